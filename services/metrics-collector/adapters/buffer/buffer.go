@@ -0,0 +1,191 @@
+package buffer
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// WriteBuffer is a core.MetricRepository that acknowledges writes as soon as
+// they are queued and stores them in the background, in batches, through the
+// wrapped repository. Reads go straight to the wrapped repository, so samples
+// still in the queue are not visible to them.
+//
+// An acknowledgement means queued, not stored: what the wrapped repository
+// rejects later, such as duplicates or samples of another type than their
+// series, is only logged, and queued samples are lost on a crash. Clients
+// that need the storage verdict, or resume a stream from its last
+// acknowledged sequence, must run without the buffer.
+type WriteBuffer struct {
+	core.MetricRepository
+	log   *slog.Logger
+	cfg   *config.WriteBuffer
+	mu    sync.Mutex
+	queue chan core.Metric
+	// closed is guarded by mu, so that no write races with closing the queue.
+	closed bool
+	wg     sync.WaitGroup
+	// giveUp is closed once the shutdown timeout has passed, so that failed
+	// flushes are no longer retried.
+	giveUp     chan struct{}
+	giveUpOnce sync.Once
+}
+
+func NewWriteBuffer(log *slog.Logger, cfgWriteBuffer *config.WriteBuffer, repo core.MetricRepository) (*WriteBuffer, error) {
+	if cfgWriteBuffer.QueueSize <= 0 || cfgWriteBuffer.Workers <= 0 || cfgWriteBuffer.BatchSize <= 0 || cfgWriteBuffer.FlushInterval <= 0 {
+		return nil, errors.New("write buffer requires a positive queue size, worker count, batch size and flush interval")
+	}
+	if cfgWriteBuffer.InitialBackoff <= 0 || cfgWriteBuffer.MaxBackoff < cfgWriteBuffer.InitialBackoff || cfgWriteBuffer.ShutdownTimeout <= 0 {
+		return nil, errors.New("write buffer requires a positive initial backoff and shutdown timeout, and a max backoff of at least the initial one")
+	}
+
+	return &WriteBuffer{
+		MetricRepository: repo,
+		log:              log,
+		cfg:              cfgWriteBuffer,
+		queue:            make(chan core.Metric, cfgWriteBuffer.QueueSize),
+		giveUp:           make(chan struct{}),
+	}, nil
+}
+
+// Start runs the flush workers. They stop once Close has drained the queue.
+func (b *WriteBuffer) Start() {
+	for range b.cfg.Workers {
+		b.wg.Add(1)
+		go b.work()
+	}
+}
+
+// Close stops accepting writes and blocks until every queued metric has been
+// stored by the wrapped repository. Once the shutdown timeout has passed,
+// batches that fail to store are dropped instead of retried.
+func (b *WriteBuffer) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	timer := time.AfterFunc(b.cfg.ShutdownTimeout, func() {
+		b.giveUpOnce.Do(func() { close(b.giveUp) })
+	})
+	defer timer.Stop()
+
+	b.wg.Wait()
+}
+
+func (b *WriteBuffer) Save(metric core.Metric) (*core.MetricIdentity, error) {
+	if err := b.enqueue([]core.Metric{metric}); err != nil {
+		return nil, err
+	}
+	return &metric.MetricIdentity, nil
+}
+
+func (b *WriteBuffer) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	if err := b.enqueue(metrics); err != nil {
+		return nil, err
+	}
+
+	results := make([]core.MetricResult, 0, len(metrics))
+	for i, metric := range metrics {
+		results = append(results, core.MetricResult{Index: i, MetricIdentity: &metric.MetricIdentity})
+	}
+	return results, nil
+}
+
+// enqueue admits either all metrics or none of them, so a batch is never
+// acknowledged partially. A batch larger than the queue could never be
+// admitted, so it is rejected as invalid rather than as a full queue.
+func (b *WriteBuffer) enqueue(metrics []core.Metric) error {
+	if len(metrics) > cap(b.queue) {
+		b.log.Warn("metric batch is larger than the write queue", slog.Int("count", len(metrics)), slog.Int("queue_size", cap(b.queue)))
+		return core.ErrMetricBatchTooLarge
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed || len(b.queue)+len(metrics) > cap(b.queue) {
+		b.log.Warn("write queue is full", slog.Int("queued", len(b.queue)), slog.Int("rejected", len(metrics)))
+		return core.ErrWriteQueueFull
+	}
+	for _, metric := range metrics {
+		b.queue <- metric
+	}
+	return nil
+}
+
+func (b *WriteBuffer) work() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]core.Metric, 0, b.cfg.BatchSize)
+	for {
+		select {
+		case metric, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, metric)
+			if len(batch) >= b.cfg.BatchSize {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush retries a batch the wrapped repository fails to store until it is
+// stored, holding up the worker meanwhile, so that a full queue pushes back on
+// writers instead of metrics being dropped.
+func (b *WriteBuffer) flush(batch []core.Metric) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := b.cfg.InitialBackoff
+	for {
+		results, err := b.MetricRepository.SaveBatch(batch)
+		if err == nil {
+			b.report(batch, results)
+			return
+		}
+
+		select {
+		case <-b.giveUp:
+			b.log.Error("failed to flush write buffer on shutdown, dropping batch", slog.Int("count", len(batch)), slog.String("error", err.Error()))
+			return
+		default:
+		}
+		b.log.Warn("failed to flush write buffer, will retry", slog.Int("count", len(batch)), slog.Duration("retry_in", backoff), slog.String("error", err.Error()))
+
+		select {
+		case <-time.After(backoff):
+		case <-b.giveUp:
+		}
+		backoff = min(2*backoff, b.cfg.MaxBackoff)
+	}
+}
+
+func (b *WriteBuffer) report(batch []core.Metric, results []core.MetricResult) {
+	rejected := 0
+	for _, result := range results {
+		if result.Err != nil {
+			rejected++
+			b.log.Warn("buffered metric rejected by storage", slog.Any("metric", batch[result.Index]), slog.String("error", result.Err.Error()))
+		}
+	}
+
+	b.log.Debug("write buffer flushed", slog.Int("count", len(batch)), slog.Int("rejected", rejected))
+}
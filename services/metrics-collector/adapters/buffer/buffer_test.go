@@ -0,0 +1,206 @@
+package buffer
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type recordingRepository struct {
+	core.MetricRepository
+	mu      sync.Mutex
+	batches [][]core.Metric
+	// release, when set, blocks every flush until it is closed.
+	release chan struct{}
+	// failures is the number of flushes to fail before storing, or -1 to
+	// fail every flush.
+	failures int
+	attempts int
+}
+
+func (r *recordingRepository) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	if r.release != nil {
+		<-r.release
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attempts++
+	if r.failures != 0 {
+		r.failures--
+		return nil, errors.New("storage unavailable")
+	}
+
+	r.batches = append(r.batches, append([]core.Metric(nil), metrics...))
+	results := make([]core.MetricResult, 0, len(metrics))
+	for i, metric := range metrics {
+		results = append(results, core.MetricResult{Index: i, MetricIdentity: &metric.MetricIdentity})
+	}
+	return results, nil
+}
+
+func (r *recordingRepository) saved() (batches, metrics int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, batch := range r.batches {
+		metrics += len(batch)
+	}
+	return len(r.batches), metrics
+}
+
+func (r *recordingRepository) flushAttempts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.attempts
+}
+
+func newTestBuffer(t *testing.T, repo core.MetricRepository, cfg config.WriteBuffer) *WriteBuffer {
+	t.Helper()
+
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = time.Second
+	}
+	writeBuffer, err := NewWriteBuffer(slog.New(slog.NewTextHandler(io.Discard, nil)), &cfg, repo)
+	if err != nil {
+		t.Fatalf("failed to create write buffer: %v", err)
+	}
+	writeBuffer.Start()
+	return writeBuffer
+}
+
+func metrics(n int) []core.Metric {
+	result := make([]core.Metric, 0, n)
+	for i := range n {
+		result = append(result, core.Metric{
+			MetricIdentity: core.MetricIdentity{Time: time.Unix(int64(i), 0), ServiceURL: "svc", MetricName: "m", PodName: "pod"},
+			MetricValue:    float64(i),
+		})
+	}
+	return result
+}
+
+func TestWriteBufferFlushesBySize(t *testing.T) {
+	repo := &recordingRepository{}
+	writeBuffer := newTestBuffer(t, repo, config.WriteBuffer{QueueSize: 100, Workers: 1, BatchSize: 5, FlushInterval: time.Hour})
+	defer writeBuffer.Close()
+
+	if _, err := writeBuffer.SaveBatch(metrics(10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if batches, saved := repo.saved(); batches == 2 && saved == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("full batches were not flushed before the flush interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriteBufferRejectsWhenFullAndDrainsOnClose(t *testing.T) {
+	repo := &recordingRepository{release: make(chan struct{})}
+	writeBuffer := newTestBuffer(t, repo, config.WriteBuffer{QueueSize: 4, Workers: 1, BatchSize: 1, FlushInterval: time.Hour})
+
+	// The worker takes one metric and blocks on it, leaving room for four.
+	if _, err := writeBuffer.Save(metrics(1)[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(writeBuffer.queue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker did not pick up the first metric")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := writeBuffer.SaveBatch(metrics(5)); !errors.Is(err, core.ErrMetricBatchTooLarge) {
+		t.Fatalf("batch larger than the queue should be rejected as invalid, got %v", err)
+	}
+	if _, err := writeBuffer.SaveBatch(metrics(3)); err != nil {
+		t.Fatalf("batch fitting the free space should be accepted, got %v", err)
+	}
+	if _, err := writeBuffer.SaveBatch(metrics(2)); !errors.Is(err, core.ErrWriteQueueFull) {
+		t.Fatalf("batch larger than the free space should be rejected, got %v", err)
+	}
+	if _, err := writeBuffer.Save(metrics(1)[0]); err != nil {
+		t.Fatalf("metric fitting the free space should be accepted, got %v", err)
+	}
+	if _, err := writeBuffer.Save(metrics(1)[0]); !errors.Is(err, core.ErrWriteQueueFull) {
+		t.Fatalf("metric should be rejected by a full queue, got %v", err)
+	}
+
+	close(repo.release)
+	writeBuffer.Close()
+
+	if _, saved := repo.saved(); saved != 5 {
+		t.Fatalf("expected all 5 accepted metrics to be stored after close, got %d", saved)
+	}
+	if _, err := writeBuffer.Save(metrics(1)[0]); !errors.Is(err, core.ErrWriteQueueFull) {
+		t.Fatalf("closed buffer should reject writes, got %v", err)
+	}
+}
+
+func TestWriteBufferRetriesFailedFlushes(t *testing.T) {
+	repo := &recordingRepository{failures: 3}
+	writeBuffer := newTestBuffer(t, repo, config.WriteBuffer{QueueSize: 100, Workers: 1, BatchSize: 5, FlushInterval: time.Hour})
+	defer writeBuffer.Close()
+
+	if _, err := writeBuffer.SaveBatch(metrics(5)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if batches, saved := repo.saved(); batches == 1 && saved == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed batch was not retried until stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if attempts := repo.flushAttempts(); attempts != 4 {
+		t.Fatalf("expected 3 failed flushes and 1 successful one, got %d attempts", attempts)
+	}
+}
+
+func TestWriteBufferGivesUpOnShutdown(t *testing.T) {
+	repo := &recordingRepository{failures: -1}
+	writeBuffer := newTestBuffer(t, repo, config.WriteBuffer{QueueSize: 100, Workers: 1, BatchSize: 5, FlushInterval: time.Hour, ShutdownTimeout: 50 * time.Millisecond})
+
+	if _, err := writeBuffer.SaveBatch(metrics(10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		writeBuffer.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close did not give up on a failing repository")
+	}
+
+	if _, saved := repo.saved(); saved != 0 {
+		t.Fatalf("expected nothing to be stored, got %d", saved)
+	}
+	if attempts := repo.flushAttempts(); attempts < 3 {
+		t.Fatalf("expected the batches to be retried before giving up, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func (db *DB) FindAnomalies(anomalyQuery core.AnomalyQuery) ([]core.Anomaly, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT m.time, s.service_url, s.metric_name, s.pod_name, s.labels, m.metric_value,
+			a.status, a.acked_by, a.reason, a.acked_at,
+			c.times, c.values
+		FROM metric m
+		JOIN series s ON s.id = m.series_id
+		LEFT JOIN anomaly_ack a ON a.time = m.time AND a.series_id = m.series_id
+		LEFT JOIN LATERAL (
+			SELECT array_agg(n.time ORDER BY n.time) AS times, array_agg(n.metric_value ORDER BY n.time) AS values
+			FROM metric n
+			WHERE n.time >= m.time - $6::interval AND n.time <= m.time + $6::interval
+				AND n.series_id = m.series_id
+		) c ON true
+		WHERE m.is_anomaly AND m.time >= $1 AND m.time <= $2
+			AND ($3::text = '' OR s.service_url = $3)
+			AND ($4::text = '' OR s.metric_name = $4)
+			AND ($5::text = '' OR s.pod_name = $5)
+		ORDER BY m.time DESC
+		LIMIT $7
+	`
+	rows, err := db.pool.Query(ctx, query,
+		anomalyQuery.Start,
+		anomalyQuery.End,
+		anomalyQuery.ServiceURL,
+		anomalyQuery.MetricName,
+		anomalyQuery.PodName,
+		pgtype.Interval{Microseconds: anomalyQuery.Context.Microseconds(), Valid: true},
+		anomalyQuery.Limit,
+	)
+	if err != nil {
+		db.log.Error("failed to query anomalies", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to query anomalies: %w", err)
+	}
+
+	anomalies, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (core.Anomaly, error) {
+		var (
+			anomaly                 core.Anomaly
+			status, ackedBy, reason pgtype.Text
+			ackedAt                 pgtype.Timestamptz
+			times                   []time.Time
+			values                  []float64
+		)
+		err := row.Scan(
+			&anomaly.Time, &anomaly.ServiceURL, &anomaly.MetricName, &anomaly.PodName, &anomaly.Labels, &anomaly.MetricValue,
+			&status, &ackedBy, &reason, &ackedAt,
+			&times, &values,
+		)
+		if err != nil {
+			return anomaly, err
+		}
+
+		anomaly.IsAnomaly = true
+		if status.Valid {
+			anomaly.Ack = &core.AnomalyAck{
+				MetricIdentity: anomaly.MetricIdentity,
+				Status:         status.String,
+				AckedBy:        ackedBy.String,
+				Reason:         reason.String,
+				AckedAt:        ackedAt.Time,
+			}
+		}
+		anomaly.Context = make([]core.Point, 0, len(times))
+		for i := range times {
+			anomaly.Context = append(anomaly.Context, core.Point{Time: times[i], Value: values[i]})
+		}
+		return anomaly, nil
+	})
+	if err != nil {
+		db.log.Error("failed to scan anomalies", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan anomalies: %w", err)
+	}
+
+	db.log.Info("anomalies fetched successfully", slog.Int("count", len(anomalies)))
+	return anomalies, nil
+}
+
+func (db *DB) SaveAnomalyAck(ack core.AnomalyAck) (*core.AnomalyAck, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	saved := ack
+	query := `
+		INSERT INTO anomaly_ack (time, series_id, status, acked_by, reason)
+		SELECT m.time, m.series_id, $6, $7, $8
+		FROM metric m
+		JOIN series s ON s.id = m.series_id
+		WHERE m.time = $1 AND s.service_url = $2 AND s.metric_name = $3 AND s.pod_name = $4 AND s.labels = $5::jsonb AND m.is_anomaly
+		ON CONFLICT (time, series_id)
+		DO UPDATE SET status = EXCLUDED.status, acked_by = EXCLUDED.acked_by, reason = EXCLUDED.reason, acked_at = now()
+		RETURNING acked_at
+	`
+	err := db.pool.
+		QueryRow(ctx, query, ack.Time, ack.ServiceURL, ack.MetricName, ack.PodName, labelsOrEmpty(ack.Labels), ack.Status, ack.AckedBy, ack.Reason).
+		Scan(&saved.AckedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			db.log.Warn("anomaly not found", slog.Any("metric_identity", ack.MetricIdentity))
+			return nil, fmt.Errorf("anomaly with identity %v: %w", ack.MetricIdentity, core.ErrAnomalyNotFound)
+		}
+		db.log.Error("failed to save anomaly acknowledgement", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to save anomaly acknowledgement: %w", err)
+	}
+
+	db.log.Info("anomaly acknowledgement saved successfully", slog.Any("metric_identity", ack.MetricIdentity))
+	return &saved, nil
+}
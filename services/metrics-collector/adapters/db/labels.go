@@ -0,0 +1,45 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var matcherOperators = map[string]string{
+	core.MatchEqual:     "=",
+	core.MatchNotEqual:  "<>",
+	core.MatchRegexp:    "~",
+	core.MatchNotRegexp: "!~",
+}
+
+// labelsOrEmpty keeps nil label sets from being stored as JSON null, which
+// would not compare equal to '{}'.
+func labelsOrEmpty(labels core.Labels) core.Labels {
+	if labels == nil {
+		return core.Labels{}
+	}
+	return labels
+}
+
+// labelMatcherConditions renders matchers as SQL conditions on the labels
+// column and appends their parameters to args.
+func labelMatcherConditions(matchers []core.LabelMatcher, args []any) (string, []any, error) {
+	var b strings.Builder
+	for _, matcher := range matchers {
+		operator, ok := matcherOperators[matcher.Type]
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported label matcher type %q", matcher.Type)
+		}
+
+		value := matcher.Value
+		if matcher.Type == core.MatchRegexp || matcher.Type == core.MatchNotRegexp {
+			value = "^(?:" + value + ")$"
+		}
+
+		args = append(args, matcher.Name, value)
+		fmt.Fprintf(&b, " AND coalesce(labels->>$%d::text, '') %s $%d::text", len(args)-1, operator, len(args))
+	}
+	return b.String(), args, nil
+}
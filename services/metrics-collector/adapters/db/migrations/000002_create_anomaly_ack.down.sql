@@ -0,0 +1,3 @@
+-- 000002_create_anomaly_ack.down.sql
+
+DROP TABLE IF EXISTS anomaly_ack;
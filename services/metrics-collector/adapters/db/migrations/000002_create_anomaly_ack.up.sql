@@ -0,0 +1,14 @@
+-- 000002_create_anomaly_ack.up.sql
+
+CREATE TABLE IF NOT EXISTS anomaly_ack (
+    time TIMESTAMPTZ NOT NULL,
+    service_url TEXT NOT NULL,
+    metric_name TEXT NOT NULL,
+    pod_name TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('acknowledged', 'dismissed')),
+    acked_by TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    acked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- Одна запись на аномальную точку, повторное подтверждение перезаписывает её
+    PRIMARY KEY (time, service_url, metric_name, pod_name)
+);
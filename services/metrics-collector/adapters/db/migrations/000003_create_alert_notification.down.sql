@@ -0,0 +1,7 @@
+-- 000003_create_alert_notification.down.sql
+
+DROP INDEX IF EXISTS idx_alert_notification_pending;
+
+DROP INDEX IF EXISTS idx_alert_notification_alert_key;
+
+DROP TABLE IF EXISTS alert_notification;
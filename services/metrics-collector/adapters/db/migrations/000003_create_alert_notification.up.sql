@@ -0,0 +1,22 @@
+-- 000003_create_alert_notification.up.sql
+
+CREATE TABLE IF NOT EXISTS alert_notification (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_url TEXT NOT NULL,
+    group_key TEXT NOT NULL,
+    alert_key TEXT NOT NULL,
+    state TEXT NOT NULL,
+    alert JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMPTZ
+);
+
+-- Поиск последнего состояния алерта для дедупликации повторов
+CREATE INDEX idx_alert_notification_alert_key ON alert_notification (webhook_url, alert_key, id DESC);
+
+-- Выборка уведомлений, ожидающих отправки
+CREATE INDEX idx_alert_notification_pending ON alert_notification (next_attempt_at) WHERE status = 'pending';
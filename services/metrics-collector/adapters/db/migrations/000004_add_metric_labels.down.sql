@@ -0,0 +1,15 @@
+-- 000004_add_metric_labels.down.sql
+
+SELECT decompress_chunk(c, if_compressed => true) FROM show_chunks('metric') c;
+
+-- Точки, различающиеся только метками, не поместятся в старый уникальный индекс
+DELETE FROM anomaly_ack WHERE labels <> '{}';
+DELETE FROM metric WHERE labels <> '{}';
+
+ALTER TABLE anomaly_ack DROP CONSTRAINT IF EXISTS anomaly_ack_pkey;
+ALTER TABLE anomaly_ack DROP COLUMN IF EXISTS labels;
+ALTER TABLE anomaly_ack ADD PRIMARY KEY (time, service_url, metric_name, pod_name);
+
+DROP INDEX IF EXISTS idx_metric_unique_composite;
+ALTER TABLE metric DROP COLUMN IF EXISTS labels;
+CREATE UNIQUE INDEX idx_metric_unique_composite ON metric (time DESC, service_url, metric_name, pod_name);
@@ -0,0 +1,16 @@
+-- 000004_add_metric_labels.up.sql
+
+-- Распаковываем сжатые чанки, иначе индекс по новому столбцу не перестроить
+SELECT decompress_chunk(c, if_compressed => true) FROM show_chunks('metric') c;
+
+-- Произвольные метки серии (регион, версия, эндпоинт и т.д.)
+ALTER TABLE metric ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+-- Метки входят в идентичность точки
+DROP INDEX IF EXISTS idx_metric_unique_composite;
+CREATE UNIQUE INDEX idx_metric_unique_composite ON metric (time DESC, service_url, metric_name, pod_name, labels);
+
+-- Подтверждения аномалий ссылаются на точку вместе с её метками
+ALTER TABLE anomaly_ack ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE anomaly_ack DROP CONSTRAINT IF EXISTS anomaly_ack_pkey;
+ALTER TABLE anomaly_ack ADD PRIMARY KEY (time, service_url, metric_name, pod_name, labels);
@@ -0,0 +1,59 @@
+-- 000005_create_series.down.sql
+
+-- Возвращаем идентичность серии в подтверждения аномалий
+ALTER TABLE anomaly_ack
+    ADD COLUMN service_url TEXT,
+    ADD COLUMN metric_name TEXT,
+    ADD COLUMN pod_name TEXT,
+    ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+
+UPDATE anomaly_ack a
+SET service_url = s.service_url, metric_name = s.metric_name, pod_name = s.pod_name, labels = s.labels
+FROM series s
+WHERE s.id = a.series_id;
+
+ALTER TABLE anomaly_ack DROP CONSTRAINT IF EXISTS anomaly_ack_pkey;
+ALTER TABLE anomaly_ack
+    DROP COLUMN series_id,
+    ALTER COLUMN service_url SET NOT NULL,
+    ALTER COLUMN metric_name SET NOT NULL,
+    ALTER COLUMN pod_name SET NOT NULL;
+ALTER TABLE anomaly_ack ADD PRIMARY KEY (time, service_url, metric_name, pod_name, labels);
+
+-- Возвращаем денормализованную таблицу метрик
+SELECT remove_retention_policy('metric', if_exists => true);
+SELECT remove_compression_policy('metric', if_exists => true);
+
+ALTER TABLE metric RENAME TO metric_new;
+
+CREATE TABLE metric (
+    time TIMESTAMPTZ NOT NULL,
+    service_url TEXT NOT NULL,
+    metric_name TEXT NOT NULL,
+    pod_name TEXT NOT NULL,
+    metric_value DOUBLE PRECISION NOT NULL,
+    is_anomaly BOOLEAN NOT NULL DEFAULT false,
+    labels JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE UNIQUE INDEX idx_metric_unique_composite ON metric (time DESC, service_url, metric_name, pod_name, labels);
+
+SELECT create_hypertable('metric', 'time', chunk_time_interval => INTERVAL '1 hour', if_not_exists => true);
+
+INSERT INTO metric (time, service_url, metric_name, pod_name, metric_value, is_anomaly, labels)
+SELECT m.time, s.service_url, s.metric_name, s.pod_name, m.metric_value, m.is_anomaly, s.labels
+FROM metric_new m
+JOIN series s ON s.id = m.series_id;
+
+DROP TABLE metric_new;
+
+ALTER TABLE metric SET (
+    timescaledb.compress,
+    timescaledb.compress_segmentby = 'service_url, metric_name',
+    timescaledb.compress_orderby = 'time DESC'
+);
+
+SELECT add_compression_policy('metric', INTERVAL '12 hours');
+SELECT add_retention_policy('metric', INTERVAL '7 days');
+
+DROP TABLE IF EXISTS series;
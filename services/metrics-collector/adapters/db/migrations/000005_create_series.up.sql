@@ -0,0 +1,72 @@
+-- 000005_create_series.up.sql
+
+-- Каталог серий: идентичность и метки хранятся один раз, точки ссылаются на id
+CREATE TABLE IF NOT EXISTS series (
+    id BIGSERIAL PRIMARY KEY,
+    service_url TEXT NOT NULL,
+    metric_name TEXT NOT NULL,
+    pod_name TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    UNIQUE (service_url, metric_name, pod_name, labels)
+);
+
+-- Поиск серий по имени метрики без указания сервиса
+CREATE INDEX idx_series_metric_name ON series (metric_name);
+
+INSERT INTO series (service_url, metric_name, pod_name, labels)
+SELECT DISTINCT service_url, metric_name, pod_name, labels FROM metric
+ON CONFLICT DO NOTHING;
+
+-- Политики переносим на новую таблицу
+SELECT remove_retention_policy('metric', if_exists => true);
+SELECT remove_compression_policy('metric', if_exists => true);
+
+ALTER TABLE metric RENAME TO metric_old;
+
+CREATE TABLE metric (
+    time TIMESTAMPTZ NOT NULL,
+    series_id BIGINT NOT NULL REFERENCES series (id),
+    metric_value DOUBLE PRECISION NOT NULL,
+    is_anomaly BOOLEAN NOT NULL DEFAULT false
+);
+
+-- Уникальный индекс для точки серии; он же обслуживает выборки по серии и диапазону времени
+CREATE UNIQUE INDEX idx_metric_series_time ON metric (series_id, time DESC);
+
+SELECT create_hypertable('metric', 'time', chunk_time_interval => INTERVAL '1 hour', if_not_exists => true);
+
+INSERT INTO metric (time, series_id, metric_value, is_anomaly)
+SELECT m.time, s.id, m.metric_value, m.is_anomaly
+FROM metric_old m
+JOIN series s
+    ON s.service_url = m.service_url AND s.metric_name = m.metric_name AND s.pod_name = m.pod_name AND s.labels = m.labels;
+
+DROP TABLE metric_old;
+
+ALTER TABLE metric SET (
+    timescaledb.compress,
+    timescaledb.compress_segmentby = 'series_id',
+    timescaledb.compress_orderby = 'time DESC'
+);
+
+SELECT add_compression_policy('metric', INTERVAL '12 hours');
+SELECT add_retention_policy('metric', INTERVAL '7 days');
+
+-- Подтверждения аномалий тоже ссылаются на серию
+ALTER TABLE anomaly_ack ADD COLUMN series_id BIGINT REFERENCES series (id);
+
+UPDATE anomaly_ack a
+SET series_id = s.id
+FROM series s
+WHERE s.service_url = a.service_url AND s.metric_name = a.metric_name AND s.pod_name = a.pod_name AND s.labels = a.labels;
+
+DELETE FROM anomaly_ack WHERE series_id IS NULL;
+
+ALTER TABLE anomaly_ack DROP CONSTRAINT IF EXISTS anomaly_ack_pkey;
+ALTER TABLE anomaly_ack
+    DROP COLUMN service_url,
+    DROP COLUMN metric_name,
+    DROP COLUMN pod_name,
+    DROP COLUMN labels,
+    ALTER COLUMN series_id SET NOT NULL;
+ALTER TABLE anomaly_ack ADD PRIMARY KEY (time, series_id);
@@ -0,0 +1,3 @@
+-- 000006_add_series_type.down.sql
+
+ALTER TABLE series DROP COLUMN IF EXISTS type;
@@ -0,0 +1,5 @@
+-- 000006_add_series_type.up.sql
+
+-- Тип метрики относится к серии; существующие серии считаем gauge
+ALTER TABLE series ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'gauge'
+    CHECK (type IN ('counter', 'gauge', 'histogram', 'summary'));
@@ -0,0 +1,4 @@
+-- 000007_move_retention_to_janitor.down.sql
+
+SELECT add_compression_policy('metric', INTERVAL '12 hours', if_not_exists => true);
+SELECT add_retention_policy('metric', INTERVAL '7 days', if_not_exists => true);
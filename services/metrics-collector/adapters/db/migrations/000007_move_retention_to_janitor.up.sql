@@ -0,0 +1,5 @@
+-- 000007_move_retention_to_janitor.up.sql
+
+-- Хранение и сжатие теперь настраиваются в конфиге по метрикам и применяются фоновым janitor
+SELECT remove_retention_policy('metric', if_exists => true);
+SELECT remove_compression_policy('metric', if_exists => true);
@@ -0,0 +1,3 @@
+-- 000008_create_metric_1m.down.sql
+
+DROP MATERIALIZED VIEW IF EXISTS metric_1m;
@@ -0,0 +1,30 @@
+-- 000008_create_metric_1m.up.sql
+
+-- Минутные агрегаты для запросов диапазона с шагом от минуты.
+-- metric_value — последнее значение в минуте, как при прореживании сырых данных,
+-- last_time — время этого значения
+CREATE MATERIALIZED VIEW IF NOT EXISTS metric_1m
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT
+    time_bucket(INTERVAL '1 minute', time) AS bucket,
+    series_id,
+    max(time) AS last_time,
+    last(metric_value, time) AS metric_value,
+    min(metric_value) AS min_value,
+    max(metric_value) AS max_value,
+    sum(metric_value) AS sum_value,
+    count(*) AS sample_count
+FROM metric
+GROUP BY bucket, series_id
+WITH NO DATA;
+
+-- Пересчитываем последние 7 дней (срок хранения сырых данных по умолчанию),
+-- чтобы исторические данные тоже попали в агрегат
+SELECT add_continuous_aggregate_policy('metric_1m',
+    start_offset => INTERVAL '7 days',
+    end_offset => INTERVAL '1 minute',
+    schedule_interval => INTERVAL '1 minute',
+    if_not_exists => true);
+
+-- Минутные агрегаты храним дольше сырых данных: 30 дней
+SELECT add_retention_policy('metric_1m', INTERVAL '30 days', if_not_exists => true);
@@ -0,0 +1,3 @@
+-- 000009_create_metric_1h.down.sql
+
+DROP MATERIALIZED VIEW IF EXISTS metric_1h;
@@ -0,0 +1,26 @@
+-- 000009_create_metric_1h.up.sql
+
+-- Часовые агрегаты для запросов диапазона с шагом от часа, столбцы как в metric_1m
+CREATE MATERIALIZED VIEW IF NOT EXISTS metric_1h
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT
+    time_bucket(INTERVAL '1 hour', time) AS bucket,
+    series_id,
+    max(time) AS last_time,
+    last(metric_value, time) AS metric_value,
+    min(metric_value) AS min_value,
+    max(metric_value) AS max_value,
+    sum(metric_value) AS sum_value,
+    count(*) AS sample_count
+FROM metric
+GROUP BY bucket, series_id
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('metric_1h',
+    start_offset => INTERVAL '7 days',
+    end_offset => INTERVAL '1 hour',
+    schedule_interval => INTERVAL '1 hour',
+    if_not_exists => true);
+
+-- Часовые агрегаты храним год
+SELECT add_retention_policy('metric_1h', INTERVAL '365 days', if_not_exists => true);
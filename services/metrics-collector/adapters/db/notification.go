@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func (db *DB) EnqueueNotification(notification core.AlertNotification) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alertJSON, err := json.Marshal(notification.Alert)
+	if err != nil {
+		db.log.Error("failed to serialize alert", slog.String("error", err.Error()))
+		return false, fmt.Errorf("failed to serialize alert: %w", err)
+	}
+
+	query := `
+		INSERT INTO alert_notification (webhook_url, group_key, alert_key, state, alert)
+		SELECT $1, $2, $3, $4, $5
+		WHERE COALESCE((
+			SELECT state FROM alert_notification
+			WHERE webhook_url = $1 AND alert_key = $3
+			ORDER BY id DESC
+			LIMIT 1
+		), '') <> $4
+	`
+	tag, err := db.pool.Exec(ctx, query, notification.WebhookURL, notification.GroupKey, notification.AlertKey, notification.Alert.State, alertJSON)
+	if err != nil {
+		db.log.Error("failed to enqueue notification", slog.String("error", err.Error()))
+		return false, fmt.Errorf("failed to enqueue notification: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		db.log.Debug("duplicate notification skipped", slog.String("alert_key", notification.AlertKey), slog.String("state", notification.Alert.State))
+		return false, nil
+	}
+
+	db.log.Info("notification enqueued successfully", slog.String("alert_key", notification.AlertKey), slog.String("state", notification.Alert.State))
+	return true, nil
+}
+
+func (db *DB) FindDueNotifications(now time.Time, limit int) ([]core.AlertNotification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, webhook_url, group_key, alert_key, alert, attempts, next_attempt_at
+		FROM alert_notification
+		WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY id
+		LIMIT $2
+	`
+	rows, err := db.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		db.log.Error("failed to query due notifications", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to query due notifications: %w", err)
+	}
+
+	notifications, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (core.AlertNotification, error) {
+		var (
+			notification core.AlertNotification
+			alertJSON    []byte
+		)
+		err := row.Scan(
+			&notification.ID, &notification.WebhookURL, &notification.GroupKey, &notification.AlertKey,
+			&alertJSON, &notification.Attempts, &notification.NextAttemptAt,
+		)
+		if err != nil {
+			return notification, err
+		}
+		return notification, json.Unmarshal(alertJSON, &notification.Alert)
+	})
+	if err != nil {
+		db.log.Error("failed to scan due notifications", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan due notifications: %w", err)
+	}
+
+	return notifications, nil
+}
+
+func (db *DB) MarkNotificationsDelivered(ids []int64, deliveredAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE alert_notification
+		SET status = 'delivered', attempts = attempts + 1, delivered_at = $2, last_error = ''
+		WHERE id = ANY($1)
+	`
+	if _, err := db.pool.Exec(ctx, query, ids, deliveredAt); err != nil {
+		db.log.Error("failed to mark notifications delivered", slog.String("error", err.Error()))
+		return fmt.Errorf("failed to mark notifications delivered: %w", err)
+	}
+
+	db.log.Info("notifications marked delivered", slog.Int("count", len(ids)))
+	return nil
+}
+
+func (db *DB) MarkNotificationsRetry(ids []int64, attempts int, nextAttemptAt time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE alert_notification
+		SET attempts = $2, next_attempt_at = $3, last_error = $4
+		WHERE id = ANY($1)
+	`
+	if _, err := db.pool.Exec(ctx, query, ids, attempts, nextAttemptAt, lastError); err != nil {
+		db.log.Error("failed to reschedule notifications", slog.String("error", err.Error()))
+		return fmt.Errorf("failed to reschedule notifications: %w", err)
+	}
+
+	db.log.Info("notifications rescheduled", slog.Int("count", len(ids)), slog.Time("next_attempt_at", nextAttemptAt))
+	return nil
+}
+
+func (db *DB) MarkNotificationsFailed(ids []int64, attempts int, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE alert_notification
+		SET status = 'failed', attempts = $2, last_error = $3
+		WHERE id = ANY($1)
+	`
+	if _, err := db.pool.Exec(ctx, query, ids, attempts, lastError); err != nil {
+		db.log.Error("failed to mark notifications failed", slog.String("error", err.Error()))
+		return fmt.Errorf("failed to mark notifications failed: %w", err)
+	}
+
+	db.log.Warn("notifications marked failed", slog.Int("count", len(ids)))
+	return nil
+}
+
+func (db *DB) DeleteNotificationsBefore(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM alert_notification n
+		WHERE n.status <> 'pending' AND n.created_at < $1
+		AND (n.state = 'resolved' OR EXISTS (
+			SELECT 1 FROM alert_notification newer
+			WHERE newer.webhook_url = n.webhook_url AND newer.alert_key = n.alert_key AND newer.id > n.id
+		))
+	`
+	tag, err := db.pool.Exec(ctx, query, before)
+	if err != nil {
+		db.log.Error("failed to delete old notifications", slog.String("error", err.Error()))
+		return 0, fmt.Errorf("failed to delete old notifications: %w", err)
+	}
+
+	db.log.Info("old notifications deleted", slog.Int64("count", tag.RowsAffected()), slog.Time("before", before))
+	return tag.RowsAffected(), nil
+}
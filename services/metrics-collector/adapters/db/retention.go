@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+)
+
+// Retention work runs in the background over whole chunks, so it gets more
+// time than the request path.
+const retentionTimeout = 5 * time.Minute
+
+// maxRollupRefresh is how far back the rollups are refreshed when no
+// retention rule is shorter, the refresh window the migrations set up.
+const maxRollupRefresh = 7 * 24 * time.Hour
+
+type rollup struct {
+	view     string
+	interval time.Duration
+}
+
+var rollups = []rollup{
+	{view: "metric_1m", interval: time.Minute},
+	{view: "metric_1h", interval: time.Hour},
+}
+
+// LimitRollupRefresh keeps the refresh window of the rollups short of the
+// shortest retention. Deleting raw rows invalidates the buckets they fall in,
+// and a refresh over those buckets would rewrite them from what is left, so
+// rollups would expire together with the raw data instead of outliving it.
+// Samples arriving later than the window are not rolled up.
+func (db *DB) LimitRollupRefresh(cfgRetention *config.Retention) error {
+	window := maxRollupRefresh
+	if cfgRetention.Enabled {
+		shortest := cfgRetention.Default
+		for _, rule := range cfgRetention.Rules {
+			shortest = min(shortest, rule.Retention)
+		}
+		// Leave an hour for bucket alignment and the time between janitor
+		// runs.
+		window = max(min(window, shortest-time.Hour), 0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		db.log.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, r := range rollups {
+		// A refresh window has to span at least two buckets past the end
+		// offset.
+		start := max(window, 3*r.interval)
+		if start > window {
+			db.log.Warn("retention is shorter than the rollup refresh window, expired raw data may erase rollups",
+				slog.String("rollup", r.view),
+				slog.Duration("refresh_window", start),
+			)
+		}
+
+		if _, err := tx.Exec(ctx, `SELECT remove_continuous_aggregate_policy($1::regclass, if_exists => true)`, r.view); err != nil {
+			db.log.Error("failed to remove rollup refresh policy", slog.String("rollup", r.view), slog.String("error", err.Error()))
+			return fmt.Errorf("failed to remove rollup refresh policy: %w", err)
+		}
+		_, err := tx.Exec(ctx, `
+			SELECT add_continuous_aggregate_policy($1::regclass,
+				start_offset => $2::interval,
+				end_offset => $3::interval,
+				schedule_interval => $3::interval)
+		`, r.view, start, r.interval)
+		if err != nil {
+			db.log.Error("failed to add rollup refresh policy", slog.String("rollup", r.view), slog.String("error", err.Error()))
+			return fmt.Errorf("failed to add rollup refresh policy: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		db.log.Error("failed to commit transaction", slog.String("error", err.Error()))
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	db.log.Info("rollup refresh window set", slog.Duration("window", window))
+
+	return nil
+}
+
+func (db *DB) MetricNames() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := db.pool.Query(ctx, `SELECT DISTINCT metric_name FROM series`)
+	if err != nil {
+		db.log.Error("failed to list metric names", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to list metric names: %w", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		db.log.Error("failed to scan metric names", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan metric names: %w", err)
+	}
+	return names, nil
+}
+
+// DeleteMetricsBefore also runs against compressed chunks when a rule keeps
+// data longer than compress_after. That is intended: compression is segmented
+// by series_id, so only the segments of the expired series are decompressed,
+// and the rows outside the rollup refresh window (see LimitRollupRefresh)
+// leave the rollups alone.
+func (db *DB) DeleteMetricsBefore(metricNames []string, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM metric
+		WHERE time < $2
+			AND series_id IN (SELECT id FROM series WHERE metric_name = ANY($1))
+	`
+	tag, err := db.pool.Exec(ctx, query, metricNames, before)
+	if err != nil {
+		db.log.Error("failed to delete expired metrics", slog.String("error", err.Error()))
+		return 0, fmt.Errorf("failed to delete expired metrics: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (db *DB) DropBefore(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+
+	var dropped int64
+	err := db.pool.
+		QueryRow(ctx, `SELECT count(*) FROM drop_chunks('metric', older_than => $1::timestamptz)`, before).
+		Scan(&dropped)
+	if err != nil {
+		db.log.Error("failed to drop expired chunks", slog.String("error", err.Error()))
+		return 0, fmt.Errorf("failed to drop expired chunks: %w", err)
+	}
+	return dropped, nil
+}
+
+func (db *DB) CompressBefore(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+
+	query := `
+		SELECT count(compress_chunk(format('%I.%I', chunk_schema, chunk_name)::regclass))
+		FROM timescaledb_information.chunks
+		WHERE hypertable_name = 'metric' AND NOT is_compressed AND range_end <= $1
+	`
+	var compressed int64
+	if err := db.pool.QueryRow(ctx, query, before).Scan(&compressed); err != nil {
+		db.log.Error("failed to compress chunks", slog.String("error", err.Error()))
+		return 0, fmt.Errorf("failed to compress chunks: %w", err)
+	}
+	return compressed, nil
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// A series not written for cachedSeriesTTL is evicted, and no more than
+// maxCachedSeries are cached at once. A miss only costs an upsert of the
+// series.
+const (
+	cachedSeriesTTL = time.Hour
+	maxCachedSeries = 1 << 20
+)
+
+type storedSeries struct {
+	id  int64
+	typ string
+}
+
+type cachedSeries struct {
+	storedSeries
+	seen time.Time
+}
+
+// SeriesCache maps series identities to their ids and types in the series
+// table so that ingestion only goes to the database for series it has not
+// seen recently. Series never change their type, so an entry is valid for as
+// long as it is cached.
+type SeriesCache struct {
+	mu        sync.Mutex
+	series    map[string]cachedSeries
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewSeriesCache() *SeriesCache {
+	return &SeriesCache{
+		series: make(map[string]cachedSeries),
+		now:    time.Now,
+	}
+}
+
+func (c *SeriesCache) Get(identity core.SeriesIdentity) (int64, string, bool) {
+	key := identity.Key()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if ok {
+		s.seen = c.now()
+		c.series[key] = s
+	}
+	return s.id, s.typ, ok
+}
+
+func (c *SeriesCache) Set(identity core.SeriesIdentity, id int64, typ string) {
+	key := identity.Key()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastSweep) >= cachedSeriesTTL {
+		c.sweep(now)
+	}
+	if _, ok := c.series[key]; !ok && len(c.series) >= maxCachedSeries {
+		return
+	}
+	c.series[key] = cachedSeries{storedSeries: storedSeries{id: id, typ: typ}, seen: now}
+}
+
+// sweep evicts the series not seen for cachedSeriesTTL. It must be called
+// with mu held.
+func (c *SeriesCache) sweep(now time.Time) {
+	for key, s := range c.series {
+		if now.Sub(s.seen) >= cachedSeriesTTL {
+			delete(c.series, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+func seriesIdentityOf(metricIdentity core.MetricIdentity) core.SeriesIdentity {
+	return core.SeriesIdentity{
+		ServiceURL: metricIdentity.ServiceURL,
+		MetricName: metricIdentity.MetricName,
+		PodName:    metricIdentity.PodName,
+		Labels:     metricIdentity.Labels,
+	}
+}
+
+// resolveSeries returns the id and the type of the series of every metric,
+// creating the series that are neither cached nor stored yet.
+func (db *DB) resolveSeries(ctx context.Context, metrics []core.Metric) ([]storedSeries, error) {
+	resolved := make([]storedSeries, len(metrics))
+	missing := make(map[string][]int)
+
+	var (
+		serviceURLs []string
+		metricNames []string
+		podNames    []string
+		labels      []core.Labels
+		types       []string
+	)
+	for i, metric := range metrics {
+		identity := seriesIdentityOf(metric.MetricIdentity)
+		if id, typ, ok := db.series.Get(identity); ok {
+			resolved[i] = storedSeries{id: id, typ: typ}
+			continue
+		}
+
+		key := identity.Key()
+		if _, ok := missing[key]; !ok {
+			serviceURLs = append(serviceURLs, identity.ServiceURL)
+			metricNames = append(metricNames, identity.MetricName)
+			podNames = append(podNames, identity.PodName)
+			labels = append(labels, labelsOrEmpty(identity.Labels))
+			types = append(types, metricTypeOrGauge(metric.Type))
+		}
+		missing[key] = append(missing[key], i)
+	}
+
+	if len(missing) == 0 {
+		return resolved, nil
+	}
+
+	// DO UPDATE instead of DO NOTHING so that RETURNING also yields series
+	// created earlier or concurrently by another writer. The update keeps the
+	// stored type: a series keeps the type it was created with.
+	query := `
+		INSERT INTO series (service_url, metric_name, pod_name, labels, type)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::jsonb[], $5::text[])
+		ON CONFLICT (service_url, metric_name, pod_name, labels)
+		DO UPDATE SET type = series.type
+		RETURNING id, service_url, metric_name, pod_name, labels, type
+	`
+	rows, err := db.pool.Query(ctx, query, serviceURLs, metricNames, podNames, labels, types)
+	if err != nil {
+		db.log.Error("failed to upsert series", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to upsert series: %w", err)
+	}
+
+	type upsertedSeries struct {
+		storedSeries
+		identity core.SeriesIdentity
+	}
+	stored, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (upsertedSeries, error) {
+		var s upsertedSeries
+		err := row.Scan(&s.id, &s.identity.ServiceURL, &s.identity.MetricName, &s.identity.PodName, &s.identity.Labels, &s.typ)
+		return s, err
+	})
+	if err != nil {
+		db.log.Error("failed to scan series", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan series: %w", err)
+	}
+
+	for _, s := range stored {
+		db.series.Set(s.identity, s.id, s.typ)
+		for _, i := range missing[s.identity.Key()] {
+			resolved[i] = s.storedSeries
+		}
+		delete(missing, s.identity.Key())
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("failed to resolve %d series", len(missing))
+	}
+
+	db.log.Debug("series resolved", slog.Int("created_or_loaded", len(stored)))
+	return resolved, nil
+}
+
+// checkSeriesType rejects metrics declaring a type other than the type of
+// their series.
+func checkSeriesType(metric core.Metric, s storedSeries) error {
+	if metricTypeOrGauge(metric.Type) != s.typ {
+		return core.ErrMetricTypeMismatch
+	}
+	return nil
+}
+
+func metricTypeOrGauge(metricType string) string {
+	if metricType == "" {
+		return core.MetricTypeGauge
+	}
+	return metricType
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func TestSeriesCacheEvictsIdleSeries(t *testing.T) {
+	cache := NewSeriesCache()
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	cpu := core.SeriesIdentity{ServiceURL: "svc", MetricName: "cpu", PodName: "pod"}
+	memory := core.SeriesIdentity{ServiceURL: "svc", MetricName: "memory", PodName: "pod"}
+	cache.Set(cpu, 1, core.MetricTypeGauge)
+	cache.Set(memory, 2, core.MetricTypeGauge)
+
+	// Reading a series keeps it cached.
+	now = now.Add(cachedSeriesTTL / 2)
+	if id, _, ok := cache.Get(cpu); !ok || id != 1 {
+		t.Fatalf("expected series 1 to be cached, got %d", id)
+	}
+
+	now = now.Add(cachedSeriesTTL / 2)
+	cache.Set(core.SeriesIdentity{ServiceURL: "svc", MetricName: "disk", PodName: "pod"}, 3, core.MetricTypeGauge)
+	if _, _, ok := cache.Get(memory); ok {
+		t.Fatal("expected the idle series to be evicted")
+	}
+	if _, _, ok := cache.Get(cpu); !ok {
+		t.Fatal("expected the series read recently to stay cached")
+	}
+}
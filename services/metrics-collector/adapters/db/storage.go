@@ -2,22 +2,35 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
 )
 
 type DB struct {
-	log  *slog.Logger
-	pool *pgxpool.Pool
+	log            *slog.Logger
+	pool           *pgxpool.Pool
+	series         *SeriesCache
+	conflictPolicy string
 }
 
-func New(log *slog.Logger, cfgDb *config.DB) (*DB, error) {
+func New(log *slog.Logger, cfgDb *config.DB, conflictPolicy string) (*DB, error) {
+	switch conflictPolicy {
+	case core.ConflictPolicyReject, core.ConflictPolicyOverwrite, core.ConflictPolicyKeepFirst:
+	default:
+		log.Error("unknown conflict policy", slog.String("conflict_policy", conflictPolicy))
+		return nil, fmt.Errorf("unknown conflict policy %q", conflictPolicy)
+	}
+
 	config, err := pgxpool.ParseConfig(cfgDb.DBConnString)
 	if err != nil {
 		log.Error("unable to parse connection string", slog.String("error", err.Error()))
@@ -36,8 +49,10 @@ func New(log *slog.Logger, cfgDb *config.DB) (*DB, error) {
 	}
 
 	return &DB{
-		log:  log,
-		pool: pool,
+		log:            log,
+		pool:           pool,
+		series:         NewSeriesCache(),
+		conflictPolicy: conflictPolicy,
 	}, nil
 }
 
@@ -45,15 +60,33 @@ func (db *DB) Save(metric core.Metric) (*core.MetricIdentity, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var metricIdentity core.MetricIdentity
+	series, err := db.resolveSeries(ctx, []core.Metric{metric})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSeriesType(metric, series[0]); err != nil {
+		db.log.Warn("metric type differs from the series type", slog.Any("metric_identity", metric.MetricIdentity), slog.String("type", metric.Type))
+		return nil, fmt.Errorf("failed to insert metric: %w", err)
+	}
+
+	metricIdentity := metric.MetricIdentity
 	query := `
-		INSERT INTO metric (time, service_url, metric_name, pod_name, metric_value) 
-		VALUES ($1, $2, $3, $4, $5) 
-		RETURNING time, service_url, metric_name, pod_name
+		INSERT INTO metric (time, series_id, metric_value, is_anomaly) 
+		VALUES ($1, $2, $3, $4) 
+		` + onConflictClause(db.conflictPolicy) + `
+		RETURNING time
 	`
-	err := db.pool.
-		QueryRow(ctx, query, metric.Time, metric.ServiceURL, metric.MetricName, metric.PodName, metric.MetricValue).
-		Scan(&metricIdentity.Time, &metricIdentity.ServiceURL, &metricIdentity.MetricName, &metricIdentity.PodName)
+	err = db.pool.
+		QueryRow(ctx, query, metric.Time, series[0].id, metric.MetricValue, metric.IsAnomaly).
+		Scan(&metricIdentity.Time)
+	if err == pgx.ErrNoRows && db.conflictPolicy == core.ConflictPolicyKeepFirst {
+		db.log.Info("metric already exists, keeping the first value", slog.Any("metric_identity", metricIdentity))
+		return &metricIdentity, nil
+	}
+	if isUniqueViolation(err) {
+		db.log.Warn("metric already exists", slog.Any("metric_identity", metricIdentity))
+		return nil, fmt.Errorf("failed to insert metric: %w", core.ErrDuplicateMetric)
+	}
 	if err != nil {
 		db.log.Error("failed to insert metric", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("failed to insert metric: %w", err)
@@ -63,19 +96,199 @@ func (db *DB) Save(metric core.Metric) (*core.MetricIdentity, error) {
 	return &metricIdentity, nil
 }
 
+func (db *DB) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	series, err := db.resolveSeries(ctx, metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	rejected := make(map[int]error)
+	ords := make([]int, 0, len(metrics))
+	rows := make([][]any, 0, len(metrics))
+	for i, metric := range metrics {
+		if err := checkSeriesType(metric, series[i]); err != nil {
+			rejected[i] = err
+			continue
+		}
+		ords = append(ords, i)
+		rows = append(rows, []any{metric.Time, series[i].id, metric.MetricValue, metric.IsAnomaly})
+	}
+	if len(rejected) > 0 {
+		db.log.Warn("metric batch contains samples of another type than their series", slog.Int("count", len(rejected)))
+	}
+	if len(rows) == 0 {
+		return batchResults(metrics, rejected, nil), nil
+	}
+
+	if db.conflictPolicy == core.ConflictPolicyReject {
+		copied, err := db.pool.CopyFrom(
+			ctx,
+			pgx.Identifier{"metric"},
+			[]string{"time", "series_id", "metric_value", "is_anomaly"},
+			pgx.CopyFromRows(rows),
+		)
+		if err == nil {
+			db.log.Info("metric batch saved successfully", slog.Int64("count", copied))
+			return batchResults(metrics, rejected, nil), nil
+		}
+		if !isUniqueViolation(err) {
+			db.log.Error("failed to copy metric batch", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to copy metric batch: %w", err)
+		}
+		// COPY is all or nothing; merge the batch to find out which samples
+		// are the duplicates and store the rest.
+		db.log.Warn("metric batch contains duplicates", slog.String("error", err.Error()))
+	}
+
+	written, err := db.mergeBatch(ctx, ords, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.conflictPolicy != core.ConflictPolicyReject {
+		return batchResults(metrics, rejected, nil), nil
+	}
+	return batchResults(metrics, rejected, written), nil
+}
+
+// batchResults reports every metric as saved, except for the rejected ones
+// and the ones missing from written, which are reported as duplicates. A nil
+// written saves all that are not rejected.
+func batchResults(metrics []core.Metric, rejected map[int]error, written map[int]bool) []core.MetricResult {
+	results := make([]core.MetricResult, 0, len(metrics))
+	for i, metric := range metrics {
+		result := core.MetricResult{Index: i}
+		switch {
+		case rejected[i] != nil:
+			result.Err = rejected[i]
+		case written == nil || written[i]:
+			metricIdentity := metric.MetricIdentity
+			result.MetricIdentity = &metricIdentity
+		default:
+			result.Err = core.ErrDuplicateMetric
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// mergeBatch copies rows into a staging table and moves them into metric with
+// the conflict policy applied, since COPY itself cannot resolve conflicts.
+// Samples repeated inside the batch are resolved by the same policy. It
+// returns the ords of the rows that were written.
+func (db *DB) mergeBatch(ctx context.Context, ords []int, rows [][]any) (map[int]bool, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		db.log.Error("failed to begin transaction", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		CREATE TEMP TABLE metric_staging (
+			ord INTEGER NOT NULL,
+			time TIMESTAMPTZ NOT NULL,
+			series_id BIGINT NOT NULL,
+			metric_value DOUBLE PRECISION NOT NULL,
+			is_anomaly BOOLEAN NOT NULL
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		db.log.Error("failed to create staging table", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to create staging table: %w", err)
+	}
+
+	stagingRows := make([][]any, 0, len(rows))
+	for i, row := range rows {
+		stagingRows = append(stagingRows, append([]any{ords[i]}, row...))
+	}
+	if _, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"metric_staging"},
+		[]string{"ord", "time", "series_id", "metric_value", "is_anomaly"},
+		pgx.CopyFromRows(stagingRows),
+	); err != nil {
+		db.log.Error("failed to copy metric batch", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to copy metric batch: %w", err)
+	}
+
+	order, onConflict := "ord", "ON CONFLICT (series_id, time) DO NOTHING"
+	if db.conflictPolicy == core.ConflictPolicyOverwrite {
+		order, onConflict = "ord DESC", onConflictClause(db.conflictPolicy)
+	}
+	query := `
+		WITH candidate AS (
+			SELECT DISTINCT ON (series_id, time) ord, time, series_id, metric_value, is_anomaly
+			FROM metric_staging
+			ORDER BY series_id, time, ` + order + `
+		), inserted AS (
+			INSERT INTO metric (time, series_id, metric_value, is_anomaly)
+			SELECT time, series_id, metric_value, is_anomaly FROM candidate
+			` + onConflict + `
+			RETURNING time, series_id
+		)
+		SELECT candidate.ord
+		FROM candidate
+		JOIN inserted ON inserted.series_id = candidate.series_id AND inserted.time = candidate.time
+	`
+	rowsWritten, err := tx.Query(ctx, query)
+	if err != nil {
+		db.log.Error("failed to merge metric batch", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to merge metric batch: %w", err)
+	}
+	writtenOrds, err := pgx.CollectRows(rowsWritten, pgx.RowTo[int32])
+	if err != nil {
+		db.log.Error("failed to merge metric batch", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to merge metric batch: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		db.log.Error("failed to commit metric batch", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to commit metric batch: %w", err)
+	}
+
+	written := make(map[int]bool, len(writtenOrds))
+	for _, ord := range writtenOrds {
+		written[int(ord)] = true
+	}
+
+	db.log.Info("metric batch saved successfully", slog.Int("count", len(written)), slog.Int("skipped", len(rows)-len(written)), slog.String("conflict_policy", db.conflictPolicy))
+	return written, nil
+}
+
+func onConflictClause(conflictPolicy string) string {
+	switch conflictPolicy {
+	case core.ConflictPolicyOverwrite:
+		return "ON CONFLICT (series_id, time) DO UPDATE SET metric_value = EXCLUDED.metric_value, is_anomaly = EXCLUDED.is_anomaly"
+	case core.ConflictPolicyKeepFirst:
+		return "ON CONFLICT (series_id, time) DO NOTHING"
+	default:
+		return ""
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
 func (db *DB) FindByMetricIdentity(metricIdentity core.MetricIdentity) (*core.Metric, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var metric core.Metric
 	query := `
-		SELECT time, service_url, metric_name, pod_name, metric_value
+		SELECT time, service_url, metric_name, pod_name, labels, type, metric_value, is_anomaly
 		FROM metric 
-		WHERE time = $1 AND service_url = $2 AND metric_name = $3 AND pod_name = $4
+		JOIN series ON series.id = metric.series_id
+		WHERE time = $1 AND service_url = $2 AND metric_name = $3 AND pod_name = $4 AND labels = $5::jsonb
 	`
 	err := db.pool.
-		QueryRow(ctx, query, metricIdentity.Time, metricIdentity.ServiceURL, metricIdentity.MetricName, metricIdentity.PodName).
-		Scan(&metric.Time, &metric.ServiceURL, &metric.MetricName, &metric.PodName, &metric.MetricValue)
+		QueryRow(ctx, query, metricIdentity.Time, metricIdentity.ServiceURL, metricIdentity.MetricName, metricIdentity.PodName, labelsOrEmpty(metricIdentity.Labels)).
+		Scan(&metric.Time, &metric.ServiceURL, &metric.MetricName, &metric.PodName, &metric.Labels, &metric.Type, &metric.MetricValue, &metric.IsAnomaly)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			db.log.Warn("metric not found", slog.Any("metric_identity", metricIdentity))
@@ -88,3 +301,211 @@ func (db *DB) FindByMetricIdentity(metricIdentity core.MetricIdentity) (*core.Me
 	db.log.Info("metric found successfully", slog.Any("metric_identity", metricIdentity))
 	return &metric, nil
 }
+
+func (db *DB) FindRange(rangeQuery core.RangeQuery) ([]core.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []any{rangeQuery.Start, rangeQuery.End, rangeQuery.ServiceURL, rangeQuery.MetricName, rangeQuery.PodName}
+	conditions, args, err := labelMatcherConditions(rangeQuery.Matchers, args)
+	if err != nil {
+		return nil, err
+	}
+
+	tier := rangeTierFor(rangeQuery.Start, rangeQuery.Step)
+	query := fmt.Sprintf(`
+		SELECT time, service_url, metric_name, pod_name, labels, type, metric_value
+		FROM %s
+		JOIN series ON series.id = metric.series_id
+		WHERE time >= $1 AND time <= $2 AND ($3::text = '' OR service_url = $3) AND metric_name = $4 AND ($5::text = '' OR pod_name = $5)%s
+		ORDER BY service_url, pod_name, labels, time
+	`, tier.source, conditions)
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		db.log.Error("failed to query metric range", slog.String("tier", tier.name), slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to query metric range: %w", err)
+	}
+
+	metrics, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (core.Metric, error) {
+		var metric core.Metric
+		err := row.Scan(&metric.Time, &metric.ServiceURL, &metric.MetricName, &metric.PodName, &metric.Labels, &metric.Type, &metric.MetricValue)
+		return metric, err
+	})
+	if err != nil {
+		db.log.Error("failed to scan metric range", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan metric range: %w", err)
+	}
+
+	db.log.Info("metric range fetched successfully", slog.String("tier", tier.name), slog.Int("count", len(metrics)))
+	return metrics, nil
+}
+
+func (db *DB) FindSeries(seriesQuery core.SeriesQuery) ([]core.SeriesIdentity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []any{seriesQuery.Start, seriesQuery.End, seriesQuery.ServiceURL, seriesQuery.MetricName, seriesQuery.PodName}
+	conditions, args, err := labelMatcherConditions(seriesQuery.Matchers, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT service_url, metric_name, pod_name, labels
+		FROM series
+		WHERE ($3::text = '' OR service_url = $3) AND ($4::text = '' OR metric_name = $4) AND ($5::text = '' OR pod_name = $5)%s
+			AND EXISTS (SELECT 1 FROM metric WHERE metric.series_id = series.id AND time >= $1 AND time <= $2)
+		ORDER BY metric_name, service_url, pod_name, labels
+	`, conditions)
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		db.log.Error("failed to query series", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to query series: %w", err)
+	}
+
+	identities, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (core.SeriesIdentity, error) {
+		var identity core.SeriesIdentity
+		err := row.Scan(&identity.ServiceURL, &identity.MetricName, &identity.PodName, &identity.Labels)
+		return identity, err
+	})
+	if err != nil {
+		db.log.Error("failed to scan series", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan series: %w", err)
+	}
+
+	db.log.Info("series fetched successfully", slog.Int("count", len(identities)))
+	return identities, nil
+}
+
+type rangeTier struct {
+	name       string
+	resolution time.Duration
+	// source yields time, series_id and metric_value under the alias metric.
+	source string
+}
+
+// rangeTiers go from the coarsest to the raw data. A rollup row carries the
+// last sample of its bucket. When the step is a multiple of the bucket width
+// and the start is aligned to it, every step holds whole buckets, so the last
+// sample of a step is the last sample of its last bucket, which is what
+// downsampling the raw data keeps. The bucket that holds the end is only
+// partly inside the range, so its samples come from the raw data.
+var rangeTiers = []rangeTier{
+	{
+		name:       "1h",
+		resolution: time.Hour,
+		source: `(
+			SELECT last_time AS time, series_id, metric_value FROM metric_1h
+			WHERE bucket >= $1 AND bucket < time_bucket(INTERVAL '1 hour', $2::timestamptz)
+			UNION ALL
+			SELECT time, series_id, metric_value FROM metric
+			WHERE time >= time_bucket(INTERVAL '1 hour', $2::timestamptz)
+		) metric`,
+	},
+	{
+		name:       "1m",
+		resolution: time.Minute,
+		source: `(
+			SELECT last_time AS time, series_id, metric_value FROM metric_1m
+			WHERE bucket >= $1 AND bucket < time_bucket(INTERVAL '1 minute', $2::timestamptz)
+			UNION ALL
+			SELECT time, series_id, metric_value FROM metric
+			WHERE time >= time_bucket(INTERVAL '1 minute', $2::timestamptz)
+		) metric`,
+	},
+	{
+		name:   "raw",
+		source: "metric",
+	},
+}
+
+// rangeTierFor picks the coarsest tier that downsamples to the same points as
+// the raw data, falling back to the raw data.
+func rangeTierFor(start time.Time, step time.Duration) rangeTier {
+	for _, tier := range rangeTiers {
+		if tier.resolution > 0 && step > 0 && step%tier.resolution == 0 && start.Sub(core.DefaultBucketOrigin)%tier.resolution == 0 {
+			return tier
+		}
+	}
+	return rangeTiers[len(rangeTiers)-1]
+}
+
+var aggregateExpressions = map[string]string{
+	core.AggregationAvg:        "avg(metric_value)",
+	core.AggregationMin:        "min(metric_value)",
+	core.AggregationMax:        "max(metric_value)",
+	core.AggregationSum:        "sum(metric_value)",
+	core.AggregationCount:      "count(*)::double precision",
+	core.AggregationFirst:      "first(metric_value, time)",
+	core.AggregationLast:       "last(metric_value, time)",
+	core.AggregationPercentile: "percentile_cont($8::double precision) WITHIN GROUP (ORDER BY metric_value)",
+}
+
+func (db *DB) Aggregate(aggregateQuery core.AggregateQuery) ([]core.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expression, ok := aggregateExpressions[aggregateQuery.Function]
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation function %q", aggregateQuery.Function)
+	}
+
+	serviceURLColumn, podNameColumn, groupBy := "$5::text", "$6::text", ""
+	switch aggregateQuery.GroupBy {
+	case core.GroupByServiceURL:
+		serviceURLColumn, groupBy = "service_url", ", service_url"
+	case core.GroupByPodName:
+		podNameColumn, groupBy = "pod_name", ", pod_name"
+	}
+
+	origin := aggregateQuery.Origin
+	if origin.IsZero() {
+		origin = core.DefaultBucketOrigin
+	}
+
+	args := []any{
+		pgtype.Interval{Microseconds: aggregateQuery.Interval.Microseconds(), Valid: true},
+		aggregateQuery.Start,
+		aggregateQuery.End,
+		aggregateQuery.MetricName,
+		aggregateQuery.ServiceURL,
+		aggregateQuery.PodName,
+		origin,
+	}
+	if aggregateQuery.Function == core.AggregationPercentile {
+		args = append(args, aggregateQuery.Percentile)
+	}
+	conditions, args, err := labelMatcherConditions(aggregateQuery.Matchers, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT time_bucket($1::interval, time, $7::timestamptz) AS bucket, %s, $4::text, %s, %s
+		FROM metric
+		JOIN series ON series.id = metric.series_id
+		WHERE time >= $2 AND time <= $3 AND metric_name = $4
+			AND ($5::text = '' OR service_url = $5) AND ($6::text = '' OR pod_name = $6)%s
+		GROUP BY bucket%s
+		ORDER BY 2, 4, bucket
+	`, serviceURLColumn, podNameColumn, expression, conditions, groupBy)
+
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		db.log.Error("failed to aggregate metrics", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to aggregate metrics: %w", err)
+	}
+
+	metrics, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (core.Metric, error) {
+		var metric core.Metric
+		err := row.Scan(&metric.Time, &metric.ServiceURL, &metric.MetricName, &metric.PodName, &metric.MetricValue)
+		return metric, err
+	})
+	if err != nil {
+		db.log.Error("failed to scan metric aggregate", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to scan metric aggregate: %w", err)
+	}
+
+	db.log.Info("metric aggregate fetched successfully", slog.Int("count", len(metrics)))
+	return metrics, nil
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRangeTierFor(t *testing.T) {
+	hour := time.Date(2024, time.May, 1, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		start time.Time
+		step  time.Duration
+		want  string
+	}{
+		{"no downsampling", hour, 0, "raw"},
+		{"step below a minute", hour, 30 * time.Second, "raw"},
+		{"whole minutes", hour, 5 * time.Minute, "1m"},
+		{"whole hours", hour, 2 * time.Hour, "1h"},
+		{"whole minutes short of an hour", hour, 90 * time.Minute, "1m"},
+		{"step not a multiple of a minute", hour, 90 * time.Second, "raw"},
+		{"start off the hour", hour.Add(time.Minute), time.Hour, "1m"},
+		{"start off the minute", hour.Add(time.Second), time.Hour, "raw"},
+	}
+	for _, c := range cases {
+		if got := rangeTierFor(c.start, c.step).name; got != c.want {
+			t.Errorf("%s: expected tier %q, got %q", c.name, c.want, got)
+		}
+	}
+}
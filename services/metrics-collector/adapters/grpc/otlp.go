@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/otlp"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// queueFullRetryDelay is the backoff suggested to OTLP exporters when the
+// write queue is full.
+const queueFullRetryDelay = time.Second
+
+// OTLPServer implements the OTLP MetricsService next to MetricsCollector.
+type OTLPServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	log      *slog.Logger
+	receiver *otlp.Receiver
+}
+
+func NewOTLPServer(log *slog.Logger, receiver *otlp.Receiver) *OTLPServer {
+	return &OTLPServer{log: log, receiver: receiver}
+}
+
+// Export answers a full write queue with ResourceExhausted carrying a
+// RetryInfo, without which OTLP exporters would not retry it.
+func (s *OTLPServer) Export(_ context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	response, err := s.receiver.Export(req)
+	if err != nil {
+		if errors.Is(err, core.ErrWriteQueueFull) {
+			return nil, s.queueFull(err)
+		}
+		if errors.Is(err, core.ErrMetricBatchTooLarge) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to save metrics")
+	}
+	return response, nil
+}
+
+func (s *OTLPServer) queueFull(err error) error {
+	st, detailsErr := status.New(codes.ResourceExhausted, err.Error()).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(queueFullRetryDelay),
+	})
+	if detailsErr != nil {
+		s.log.Error("failed to attach retry info", slog.String("error", detailsErr.Error()))
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return st.Err()
+}
@@ -3,10 +3,12 @@ package grpc
 import (
 	"context"
 	"errors"
+	"io"
 	"log/slog"
 	"time"
 
 	metricspb "github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/grpc/proto"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -16,12 +18,19 @@ import (
 
 type Server struct {
 	metricspb.UnimplementedMetricsCollectorServer
-	log     *slog.Logger
-	service *core.MetricService
+	log            *slog.Logger
+	ctx            context.Context
+	streamCfg      *config.Stream
+	service        *core.MetricService
+	anomalyService *core.AnomalyService
 }
 
-func NewServer(log *slog.Logger, service *core.MetricService) *Server {
-	return &Server{log: log, service: service}
+func NewServer(log *slog.Logger, ctx context.Context, streamCfg *config.Stream, service *core.MetricService, anomalyService *core.AnomalyService) (*Server, error) {
+	if streamCfg.BatchSize <= 0 || streamCfg.FlushInterval <= 0 {
+		return nil, errors.New("stream requires a positive batch size and flush interval")
+	}
+
+	return &Server{log: log, ctx: ctx, streamCfg: streamCfg, service: service, anomalyService: anomalyService}, nil
 }
 
 func (s *Server) Ping(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
@@ -32,11 +41,13 @@ func (s *Server) Ping(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
 func (s *Server) SendMetric(_ context.Context, req *metricspb.SendMetricRequest) (*metricspb.SendMetricResponse, error) {
 	metric := core.Metric{
 		MetricIdentity: core.MetricIdentity{
-			Time:       time.Now().UTC(),
+			Time:       core.SampleTime(optionalTime(req.Time), time.Now().UTC()),
 			ServiceURL: req.ServiceUrl,
 			MetricName: req.MetricName,
 			PodName:    req.PodName,
+			Labels:     req.Labels,
 		},
+		Type:        req.Type,
 		MetricValue: req.MetricValue,
 	}
 
@@ -46,6 +57,18 @@ func (s *Server) SendMetric(_ context.Context, req *metricspb.SendMetricRequest)
 		case errors.Is(err, core.ErrInvalidMetric):
 			s.log.Warn("metric validation failed", slog.String("error", err.Error()))
 			return nil, status.Errorf(codes.InvalidArgument, "metric validation failed")
+		case errors.Is(err, core.ErrTimestampOutOfWindow):
+			s.log.Warn("metric timestamp validation failed", slog.String("error", err.Error()))
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, core.ErrInvalidMetricType), errors.Is(err, core.ErrMetricTypeMismatch), errors.Is(err, core.ErrCounterNotMonotonic):
+			s.log.Warn("metric type validation failed", slog.String("error", err.Error()))
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, core.ErrDuplicateMetric):
+			s.log.Warn("metric already exists", slog.String("error", err.Error()))
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		case errors.Is(err, core.ErrWriteQueueFull):
+			s.log.Warn("write queue is full", slog.String("error", err.Error()))
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
 		case errors.Is(err, core.ErrSaveFailed):
 			s.log.Error("failed to save metric", slog.String("error", err.Error()))
 			return nil, status.Errorf(codes.Internal, "failed to save metric")
@@ -55,12 +78,492 @@ func (s *Server) SendMetric(_ context.Context, req *metricspb.SendMetricRequest)
 		}
 	}
 
-	response := metricspb.SendMetricResponse{
+	return toSendMetricResponse(identity), nil
+}
+
+func (s *Server) SendMetrics(_ context.Context, req *metricspb.SendMetricsRequest) (*metricspb.SendMetricsResponse, error) {
+	now := time.Now().UTC()
+	metrics := make([]core.Metric, 0, len(req.Metrics))
+	for _, m := range req.Metrics {
+		metrics = append(metrics, core.Metric{
+			MetricIdentity: core.MetricIdentity{
+				Time:       core.SampleTime(optionalTime(m.Time), now),
+				ServiceURL: m.ServiceUrl,
+				MetricName: m.MetricName,
+				PodName:    m.PodName,
+				Labels:     m.Labels,
+			},
+			Type:        m.Type,
+			MetricValue: m.MetricValue,
+		})
+	}
+
+	results, err := s.service.CreateMetrics(metrics)
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrEmptyMetricBatch), errors.Is(err, core.ErrMetricBatchTooLarge):
+			s.log.Warn("metric batch validation failed", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.InvalidArgument, "metric batch validation failed")
+		case errors.Is(err, core.ErrWriteQueueFull):
+			s.log.Warn("write queue is full", slog.String("error", err.Error()))
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, core.ErrSaveFailed):
+			s.log.Error("failed to save metric batch", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "failed to save metric batch")
+		default:
+			s.log.Error("unexpected error", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "unexpected error")
+		}
+	}
+
+	response := metricspb.SendMetricsResponse{
+		Results: make([]*metricspb.SendMetricResult, 0, len(results)),
+	}
+	for _, result := range results {
+		r := &metricspb.SendMetricResult{Index: uint32(result.Index)}
+		if result.Err != nil {
+			r.Error = result.Err.Error()
+		} else {
+			r.Accepted = true
+			r.Metric = toSendMetricResponse(result.MetricIdentity)
+		}
+		response.Results = append(response.Results, r)
+	}
+
+	return &response, nil
+}
+
+// StreamMetrics acknowledges samples once the service accepted them. With the
+// write buffer enabled that means queued: last_sequence may cover samples that
+// a crash loses before they are stored.
+func (s *Server) StreamMetrics(stream metricspb.MetricsCollector_StreamMetricsServer) error {
+	requests := make(chan *metricspb.SendMetricRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.streamCfg.FlushInterval)
+	defer ticker.Stop()
+
+	var count, lastSequence, rejected uint64
+	batch := make([]core.Metric, 0, s.streamCfg.BatchSize)
+	sequences := make([]uint64, 0, s.streamCfg.BatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		results, err := s.service.CreateMetrics(batch)
+		if errors.Is(err, core.ErrWriteQueueFull) {
+			// The client resumes from the last acknowledged sequence.
+			s.log.Warn("write queue is full, closing metric stream", slog.Uint64("last_sequence", lastSequence))
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if errors.Is(err, core.ErrMetricBatchTooLarge) {
+			s.log.Warn("stream batch does not fit the write queue, closing metric stream", slog.Int("count", len(batch)))
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err != nil {
+			s.log.Error("failed to save streamed metrics", slog.String("error", err.Error()))
+			return status.Errorf(codes.Internal, "failed to save metrics")
+		}
+
+		for _, result := range results {
+			if result.Err != nil {
+				rejected++
+				continue
+			}
+			count++
+			if seq := sequences[result.Index]; seq > lastSequence {
+				lastSequence = seq
+			}
+		}
+		batch = batch[:0]
+		sequences = sequences[:0]
+
+		if err := stream.Send(&metricspb.StreamMetricsAck{
+			Count:        count,
+			LastSequence: lastSequence,
+			Rejected:     rejected,
+		}); err != nil {
+			s.log.Warn("failed to send stream ack", slog.String("error", err.Error()))
+			return err
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case req := <-requests:
+			batch = append(batch, core.Metric{
+				MetricIdentity: core.MetricIdentity{
+					Time:       core.SampleTime(optionalTime(req.Time), time.Now().UTC()),
+					ServiceURL: req.ServiceUrl,
+					MetricName: req.MetricName,
+					PodName:    req.PodName,
+					Labels:     req.Labels,
+				},
+				Type:        req.Type,
+				MetricValue: req.MetricValue,
+			})
+			sequences = append(sequences, req.Sequence)
+			if len(batch) >= s.streamCfg.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				s.log.Info("metric stream closed by client", slog.Uint64("count", count), slog.Uint64("rejected", rejected))
+				return flush()
+			}
+			s.log.Warn("metric stream receive failed", slog.String("error", err.Error()))
+			return err
+		case <-s.ctx.Done():
+			s.log.Info("closing metric stream due to shutdown", slog.Uint64("count", count))
+			if err := flush(); err != nil {
+				return err
+			}
+			return status.Errorf(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+func (s *Server) QueryRange(_ context.Context, req *metricspb.QueryRangeRequest) (*metricspb.QueryRangeResponse, error) {
+	if req.Start == nil || req.End == nil {
+		s.log.Warn("range query without bounds")
+		return nil, status.Errorf(codes.InvalidArgument, "start and end are required")
+	}
+
+	query := core.RangeQuery{
+		ServiceURL: req.ServiceUrl,
+		MetricName: req.MetricName,
+		PodName:    req.PodName,
+		Matchers:   toLabelMatchers(req.Matchers),
+		Start:      req.Start.AsTime(),
+		End:        req.End.AsTime(),
+		Step:       req.Step.AsDuration(),
+	}
+
+	series, err := s.service.QueryRange(query)
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidRangeQuery):
+			s.log.Warn("invalid range query", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.InvalidArgument, "invalid range query")
+		case errors.Is(err, core.ErrQueryFailed):
+			s.log.Error("failed to query metrics", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "failed to query metrics")
+		default:
+			s.log.Error("unexpected error", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "unexpected error")
+		}
+	}
+
+	response := metricspb.QueryRangeResponse{
+		Series: make([]*metricspb.Series, 0, len(series)),
+	}
+	for _, ser := range series {
+		response.Series = append(response.Series, toSeries(ser))
+	}
+
+	return &response, nil
+}
+
+func (s *Server) Aggregate(_ context.Context, req *metricspb.AggregateRequest) (*metricspb.AggregateResponse, error) {
+	if req.Start == nil || req.End == nil || req.Interval == nil {
+		s.log.Warn("aggregate query without bounds or interval")
+		return nil, status.Errorf(codes.InvalidArgument, "start, end and interval are required")
+	}
+
+	query := core.AggregateQuery{
+		ServiceURL: req.ServiceUrl,
+		MetricName: req.MetricName,
+		PodName:    req.PodName,
+		Matchers:   toLabelMatchers(req.Matchers),
+		Start:      req.Start.AsTime(),
+		End:        req.End.AsTime(),
+		Interval:   req.Interval.AsDuration(),
+		Function:   req.Function,
+		Percentile: req.Percentile,
+		GroupBy:    req.GroupBy,
+	}
+
+	series, err := s.service.Aggregate(query)
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidAggregateQuery):
+			s.log.Warn("invalid aggregate query", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.InvalidArgument, "invalid aggregate query")
+		case errors.Is(err, core.ErrQueryFailed):
+			s.log.Error("failed to aggregate metrics", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "failed to aggregate metrics")
+		default:
+			s.log.Error("unexpected error", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "unexpected error")
+		}
+	}
+
+	response := metricspb.AggregateResponse{
+		Series: make([]*metricspb.Series, 0, len(series)),
+	}
+	for _, ser := range series {
+		response.Series = append(response.Series, toSeries(ser))
+	}
+
+	return &response, nil
+}
+
+func (s *Server) Rate(_ context.Context, req *metricspb.RateRequest) (*metricspb.QueryRangeResponse, error) {
+	return s.counterFunction(req, s.service.Rate)
+}
+
+func (s *Server) Increase(_ context.Context, req *metricspb.RateRequest) (*metricspb.QueryRangeResponse, error) {
+	return s.counterFunction(req, s.service.Increase)
+}
+
+func (s *Server) counterFunction(req *metricspb.RateRequest, evaluate func(core.RateQuery) ([]core.Series, error)) (*metricspb.QueryRangeResponse, error) {
+	if req.Start == nil || req.End == nil || req.Step == nil || req.Window == nil {
+		s.log.Warn("rate query without bounds, step or window")
+		return nil, status.Errorf(codes.InvalidArgument, "start, end, step and window are required")
+	}
+
+	query := core.RateQuery{
+		ServiceURL: req.ServiceUrl,
+		MetricName: req.MetricName,
+		PodName:    req.PodName,
+		Matchers:   toLabelMatchers(req.Matchers),
+		Start:      req.Start.AsTime(),
+		End:        req.End.AsTime(),
+		Step:       req.Step.AsDuration(),
+		Window:     req.Window.AsDuration(),
+	}
+
+	series, err := evaluate(query)
+	if err != nil {
+		return nil, s.functionError(err)
+	}
+
+	return toQueryRangeResponse(series), nil
+}
+
+func (s *Server) HistogramQuantile(_ context.Context, req *metricspb.HistogramQuantileRequest) (*metricspb.QueryRangeResponse, error) {
+	if req.Start == nil || req.End == nil || req.Step == nil || req.Window == nil {
+		s.log.Warn("histogram quantile query without bounds, step or window")
+		return nil, status.Errorf(codes.InvalidArgument, "start, end, step and window are required")
+	}
+
+	query := core.HistogramQuantileQuery{
+		ServiceURL: req.ServiceUrl,
+		MetricName: req.MetricName,
+		PodName:    req.PodName,
+		Matchers:   toLabelMatchers(req.Matchers),
+		Start:      req.Start.AsTime(),
+		End:        req.End.AsTime(),
+		Step:       req.Step.AsDuration(),
+		Window:     req.Window.AsDuration(),
+		Quantile:   req.Quantile,
+	}
+
+	series, err := s.service.HistogramQuantile(query)
+	if err != nil {
+		return nil, s.functionError(err)
+	}
+
+	return toQueryRangeResponse(series), nil
+}
+
+func (s *Server) functionError(err error) error {
+	switch {
+	case errors.Is(err, core.ErrInvalidRateQuery), errors.Is(err, core.ErrInvalidQuantileQuery), errors.Is(err, core.ErrQueryTypeMismatch):
+		s.log.Warn("invalid function query", slog.String("error", err.Error()))
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, core.ErrQueryFailed):
+		s.log.Error("failed to evaluate function", slog.String("error", err.Error()))
+		return status.Errorf(codes.Internal, "failed to evaluate function")
+	default:
+		s.log.Error("unexpected error", slog.String("error", err.Error()))
+		return status.Errorf(codes.Internal, "unexpected error")
+	}
+}
+
+func toQueryRangeResponse(series []core.Series) *metricspb.QueryRangeResponse {
+	response := metricspb.QueryRangeResponse{
+		Series: make([]*metricspb.Series, 0, len(series)),
+	}
+	for _, ser := range series {
+		response.Series = append(response.Series, toSeries(ser))
+	}
+	return &response
+}
+
+func (s *Server) ListAnomalies(_ context.Context, req *metricspb.ListAnomaliesRequest) (*metricspb.ListAnomaliesResponse, error) {
+	if req.Start == nil || req.End == nil {
+		s.log.Warn("anomaly query without bounds")
+		return nil, status.Errorf(codes.InvalidArgument, "start and end are required")
+	}
+
+	query := core.AnomalyQuery{
+		ServiceURL: req.ServiceUrl,
+		MetricName: req.MetricName,
+		PodName:    req.PodName,
+		Start:      req.Start.AsTime(),
+		End:        req.End.AsTime(),
+		Context:    req.Context.AsDuration(),
+		Limit:      int(req.Limit),
+	}
+
+	anomalies, err := s.anomalyService.ListAnomalies(query)
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidAnomalyQuery):
+			s.log.Warn("invalid anomaly query", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.InvalidArgument, "invalid anomaly query")
+		case errors.Is(err, core.ErrQueryFailed):
+			s.log.Error("failed to list anomalies", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "failed to list anomalies")
+		default:
+			s.log.Error("unexpected error", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "unexpected error")
+		}
+	}
+
+	response := metricspb.ListAnomaliesResponse{
+		Anomalies: make([]*metricspb.Anomaly, 0, len(anomalies)),
+	}
+	for _, anomaly := range anomalies {
+		points := make([]*metricspb.Point, 0, len(anomaly.Context))
+		for _, point := range anomaly.Context {
+			points = append(points, &metricspb.Point{Time: timestamppb.New(point.Time), Value: point.Value})
+		}
+		a := &metricspb.Anomaly{
+			Time:        timestamppb.New(anomaly.Time),
+			ServiceUrl:  anomaly.ServiceURL,
+			MetricName:  anomaly.MetricName,
+			PodName:     anomaly.PodName,
+			Labels:      anomaly.Labels,
+			MetricValue: anomaly.MetricValue,
+			Context:     points,
+		}
+		if anomaly.Ack != nil {
+			a.Ack = toAnomalyAck(anomaly.Ack)
+		}
+		response.Anomalies = append(response.Anomalies, a)
+	}
+
+	return &response, nil
+}
+
+func (s *Server) AcknowledgeAnomaly(_ context.Context, req *metricspb.AcknowledgeAnomalyRequest) (*metricspb.AnomalyAck, error) {
+	if req.Time == nil {
+		s.log.Warn("anomaly acknowledgement without time")
+		return nil, status.Errorf(codes.InvalidArgument, "time is required")
+	}
+
+	ack := core.AnomalyAck{
+		MetricIdentity: core.MetricIdentity{
+			Time:       req.Time.AsTime(),
+			ServiceURL: req.ServiceUrl,
+			MetricName: req.MetricName,
+			PodName:    req.PodName,
+			Labels:     req.Labels,
+		},
+		Status:  req.Status,
+		AckedBy: req.AckedBy,
+		Reason:  req.Reason,
+	}
+
+	saved, err := s.anomalyService.AcknowledgeAnomaly(ack)
+	if err != nil {
+		switch {
+		case errors.Is(err, core.ErrInvalidAnomalyAck):
+			s.log.Warn("invalid anomaly acknowledgement", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.InvalidArgument, "invalid anomaly acknowledgement")
+		case errors.Is(err, core.ErrAnomalyNotFound):
+			s.log.Warn("anomaly not found", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.NotFound, "anomaly not found")
+		case errors.Is(err, core.ErrAckFailed):
+			s.log.Error("failed to acknowledge anomaly", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "failed to acknowledge anomaly")
+		default:
+			s.log.Error("unexpected error", slog.String("error", err.Error()))
+			return nil, status.Errorf(codes.Internal, "unexpected error")
+		}
+	}
+
+	return toAnomalyAck(saved), nil
+}
+
+func toAnomalyAck(ack *core.AnomalyAck) *metricspb.AnomalyAck {
+	return &metricspb.AnomalyAck{
+		Status:  ack.Status,
+		AckedBy: ack.AckedBy,
+		Reason:  ack.Reason,
+		AckedAt: timestamppb.New(ack.AckedAt),
+	}
+}
+
+func toSeries(series core.Series) *metricspb.Series {
+	points := make([]*metricspb.Point, 0, len(series.Points))
+	for _, point := range series.Points {
+		points = append(points, &metricspb.Point{
+			Time:  timestamppb.New(point.Time),
+			Value: point.Value,
+		})
+	}
+	return &metricspb.Series{
+		ServiceUrl: series.ServiceURL,
+		MetricName: series.MetricName,
+		PodName:    series.PodName,
+		Labels:     series.Labels,
+		Type:       series.Type,
+		Points:     points,
+	}
+}
+
+func toLabelMatchers(matchers []*metricspb.LabelMatcher) []core.LabelMatcher {
+	result := make([]core.LabelMatcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		result = append(result, core.LabelMatcher{
+			Name:  matcher.Name,
+			Type:  matcher.Type,
+			Value: matcher.Value,
+		})
+	}
+	return result
+}
+
+func optionalTime(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+func toSendMetricResponse(identity *core.MetricIdentity) *metricspb.SendMetricResponse {
+	return &metricspb.SendMetricResponse{
 		Time:       timestamppb.New(identity.Time),
 		ServiceUrl: identity.ServiceURL,
 		MetricName: identity.MetricName,
 		PodName:    identity.PodName,
+		Labels:     identity.Labels,
 	}
-
-	return &response, nil
 }
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func (s *Storage) FindAnomalies(anomalyQuery core.AnomalyQuery) ([]core.Anomaly, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	anomalies := make([]core.Anomaly, 0)
+	for _, ser := range s.series {
+		if (anomalyQuery.ServiceURL != "" && ser.identity.ServiceURL != anomalyQuery.ServiceURL) ||
+			(anomalyQuery.MetricName != "" && ser.identity.MetricName != anomalyQuery.MetricName) ||
+			(anomalyQuery.PodName != "" && ser.identity.PodName != anomalyQuery.PodName) {
+			continue
+		}
+
+		for _, smp := range samplesBetween(ser.samples, anomalyQuery.Start, anomalyQuery.End) {
+			if !smp.isAnomaly {
+				continue
+			}
+
+			anomaly := core.Anomaly{
+				Metric: core.Metric{
+					MetricIdentity: metricIdentityOf(ser.identity, smp.time),
+					MetricValue:    smp.value,
+					IsAnomaly:      true,
+				},
+			}
+			if smp.ack != nil {
+				ack := *smp.ack
+				anomaly.Ack = &ack
+			}
+			context := samplesBetween(ser.samples, smp.time.Add(-anomalyQuery.Context), smp.time.Add(anomalyQuery.Context))
+			anomaly.Context = make([]core.Point, 0, len(context))
+			for _, point := range context {
+				anomaly.Context = append(anomaly.Context, core.Point{Time: point.time, Value: point.value})
+			}
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	slices.SortFunc(anomalies, func(a, b core.Anomaly) int {
+		return b.Time.Compare(a.Time)
+	})
+	if len(anomalies) > anomalyQuery.Limit {
+		anomalies = anomalies[:anomalyQuery.Limit]
+	}
+
+	s.log.Info("anomalies fetched successfully", slog.Int("count", len(anomalies)))
+	return anomalies, nil
+}
+
+func (s *Storage) SaveAnomalyAck(ack core.AnomalyAck) (*core.AnomalyAck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ser, smp, ok := s.find(ack.MetricIdentity)
+	if !ok || !smp.isAnomaly {
+		s.log.Warn("anomaly not found", slog.Any("metric_identity", ack.MetricIdentity))
+		return nil, fmt.Errorf("anomaly with identity %v: %w", ack.MetricIdentity, core.ErrAnomalyNotFound)
+	}
+
+	saved := ack
+	saved.MetricIdentity = metricIdentityOf(ser.identity, smp.time)
+	saved.AckedAt = time.Now().UTC()
+	stored := saved
+	smp.ack = &stored
+
+	s.log.Info("anomaly acknowledgement saved successfully", slog.Any("metric_identity", ack.MetricIdentity))
+	return &saved, nil
+}
@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	notificationPending   = "pending"
+	notificationDelivered = "delivered"
+	notificationFailed    = "failed"
+)
+
+type notification struct {
+	core.AlertNotification
+	status      string
+	lastError   string
+	createdAt   time.Time
+	deliveredAt time.Time
+}
+
+func (s *Storage) EnqueueNotification(alertNotification core.AlertNotification) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Skip repeats of the state last enqueued for the alert on this webhook.
+	for i := len(s.notifications) - 1; i >= 0; i-- {
+		last := s.notifications[i]
+		if last.WebhookURL != alertNotification.WebhookURL || last.AlertKey != alertNotification.AlertKey {
+			continue
+		}
+		if last.Alert.State == alertNotification.Alert.State {
+			s.log.Debug("duplicate notification skipped", slog.String("alert_key", alertNotification.AlertKey), slog.String("state", alertNotification.Alert.State))
+			return false, nil
+		}
+		break
+	}
+
+	alertNotification.ID = s.nextNotificationID
+	alertNotification.Attempts = 0
+	alertNotification.NextAttemptAt = time.Now().UTC()
+	s.nextNotificationID++
+	s.notifications = append(s.notifications, notification{
+		AlertNotification: alertNotification,
+		status:            notificationPending,
+		createdAt:         alertNotification.NextAttemptAt,
+	})
+
+	s.log.Info("notification enqueued successfully", slog.String("alert_key", alertNotification.AlertKey), slog.String("state", alertNotification.Alert.State))
+	return true, nil
+}
+
+func (s *Storage) FindDueNotifications(now time.Time, limit int) ([]core.AlertNotification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notifications := make([]core.AlertNotification, 0)
+	for _, n := range s.notifications {
+		if len(notifications) == limit {
+			break
+		}
+		if n.status == notificationPending && !n.NextAttemptAt.After(now) {
+			notifications = append(notifications, n.AlertNotification)
+		}
+	}
+	return notifications, nil
+}
+
+func (s *Storage) MarkNotificationsDelivered(ids []int64, deliveredAt time.Time) error {
+	s.updateNotifications(ids, func(n *notification) {
+		n.status = notificationDelivered
+		n.Attempts++
+		n.deliveredAt = deliveredAt
+		n.lastError = ""
+	})
+
+	s.log.Info("notifications marked delivered", slog.Int("count", len(ids)))
+	return nil
+}
+
+func (s *Storage) MarkNotificationsRetry(ids []int64, attempts int, nextAttemptAt time.Time, lastError string) error {
+	s.updateNotifications(ids, func(n *notification) {
+		n.Attempts = attempts
+		n.NextAttemptAt = nextAttemptAt
+		n.lastError = lastError
+	})
+
+	s.log.Info("notifications rescheduled", slog.Int("count", len(ids)), slog.Time("next_attempt_at", nextAttemptAt))
+	return nil
+}
+
+func (s *Storage) MarkNotificationsFailed(ids []int64, attempts int, lastError string) error {
+	s.updateNotifications(ids, func(n *notification) {
+		n.status = notificationFailed
+		n.Attempts = attempts
+		n.lastError = lastError
+	})
+
+	s.log.Warn("notifications marked failed", slog.Int("count", len(ids)))
+	return nil
+}
+
+func (s *Storage) updateNotifications(ids []int64, update func(n *notification)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// IDs are assigned in order, and deleting keeps the order.
+	for _, id := range ids {
+		i, ok := slices.BinarySearchFunc(s.notifications, id, func(n notification, id int64) int {
+			return cmp.Compare(n.ID, id)
+		})
+		if ok {
+			update(&s.notifications[i])
+		}
+	}
+}
+
+func (s *Storage) DeleteNotificationsBefore(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type logKey struct {
+		webhookURL string
+		alertKey   string
+	}
+	last := make(map[logKey]int64)
+	for _, n := range s.notifications {
+		last[logKey{webhookURL: n.WebhookURL, alertKey: n.AlertKey}] = n.ID
+	}
+
+	before = before.UTC()
+	kept := s.notifications[:0]
+	for _, n := range s.notifications {
+		expired := n.status != notificationPending && n.createdAt.Before(before) &&
+			(n.Alert.State == core.AlertStateResolved || last[logKey{webhookURL: n.WebhookURL, alertKey: n.AlertKey}] != n.ID)
+		if !expired {
+			kept = append(kept, n)
+		}
+	}
+	deleted := int64(len(s.notifications) - len(kept))
+	clear(s.notifications[len(kept):])
+	s.notifications = kept
+
+	s.log.Info("old notifications deleted", slog.Int64("count", deleted), slog.Time("before", before))
+	return deleted, nil
+}
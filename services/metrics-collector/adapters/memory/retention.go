@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"slices"
+	"time"
+)
+
+func (s *Storage) MetricNames() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, ser := range s.series {
+		if !seen[ser.identity.MetricName] {
+			seen[ser.identity.MetricName] = true
+			names = append(names, ser.identity.MetricName)
+		}
+	}
+	return names, nil
+}
+
+func (s *Storage) DeleteMetricsBefore(metricNames []string, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for _, ser := range s.series {
+		if slices.Contains(metricNames, ser.identity.MetricName) {
+			deleted += ser.deleteBefore(before)
+		}
+	}
+	return deleted, nil
+}
+
+// DropBefore deletes every sample older than before, there are no chunks to
+// drop in memory.
+func (s *Storage) DropBefore(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for _, ser := range s.series {
+		deleted += ser.deleteBefore(before)
+	}
+	return deleted, nil
+}
+
+func (s *Storage) CompressBefore(time.Time) (int64, error) {
+	return 0, nil
+}
+
+// deleteBefore must be called with mu held.
+func (ser *series) deleteBefore(before time.Time) int64 {
+	from, _ := slices.BinarySearchFunc(ser.samples, before, func(smp sample, t time.Time) int {
+		return smp.time.Compare(t)
+	})
+	ser.samples = slices.Delete(ser.samples, 0, from)
+	return int64(from)
+}
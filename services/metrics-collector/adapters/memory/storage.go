@@ -0,0 +1,346 @@
+package memory
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type sample struct {
+	time      time.Time
+	value     float64
+	isAnomaly bool
+	ack       *core.AnomalyAck
+}
+
+type series struct {
+	identity core.SeriesIdentity
+	typ      string
+	// samples are kept sorted by time.
+	samples []sample
+}
+
+// Storage keeps everything the database would in process memory, with the
+// same semantics as the database adapter. Nothing survives a restart, so it
+// is meant for tests and local development.
+type Storage struct {
+	log            *slog.Logger
+	conflictPolicy string
+
+	mu     sync.RWMutex
+	series map[string]*series
+
+	notifications      []notification
+	nextNotificationID int64
+}
+
+func New(log *slog.Logger, conflictPolicy string) (*Storage, error) {
+	switch conflictPolicy {
+	case core.ConflictPolicyReject, core.ConflictPolicyOverwrite, core.ConflictPolicyKeepFirst:
+	default:
+		log.Error("unknown conflict policy", slog.String("conflict_policy", conflictPolicy))
+		return nil, fmt.Errorf("unknown conflict policy %q", conflictPolicy)
+	}
+
+	return &Storage{
+		log:                log,
+		conflictPolicy:     conflictPolicy,
+		series:             make(map[string]*series),
+		nextNotificationID: 1,
+	}, nil
+}
+
+func (s *Storage) Save(metric core.Metric) (*core.MetricIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metricIdentity, err := s.insert(metric)
+	if errors.Is(err, core.ErrMetricTypeMismatch) {
+		s.log.Warn("metric type differs from the series type", slog.Any("metric_identity", metric.MetricIdentity), slog.String("type", metric.Type))
+		return nil, fmt.Errorf("failed to insert metric: %w", err)
+	}
+	if err != nil {
+		s.log.Warn("metric already exists", slog.Any("metric_identity", metric.MetricIdentity))
+		return nil, fmt.Errorf("failed to insert metric: %w", err)
+	}
+
+	s.log.Info("metric saved successfully", slog.Any("metric_identity", metricIdentity))
+	return &metricIdentity, nil
+}
+
+func (s *Storage) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]core.MetricResult, 0, len(metrics))
+	saved := 0
+	for i, metric := range metrics {
+		result := core.MetricResult{Index: i}
+		if _, err := s.insert(metric); err != nil {
+			result.Err = err
+		} else {
+			metricIdentity := metric.MetricIdentity
+			result.MetricIdentity = &metricIdentity
+			saved++
+		}
+		results = append(results, result)
+	}
+
+	s.log.Info("metric batch saved successfully", slog.Int("count", saved), slog.Int("skipped", len(metrics)-saved), slog.String("conflict_policy", s.conflictPolicy))
+	return results, nil
+}
+
+// insert must be called with mu held. Times are stored with microsecond
+// precision, like timestamptz. A series keeps the type it was created with.
+func (s *Storage) insert(metric core.Metric) (core.MetricIdentity, error) {
+	metricIdentity := metric.MetricIdentity
+	metricIdentity.Time = storedTime(metric.Time)
+
+	identity := seriesIdentityOf(metricIdentity)
+	typ := metricTypeOrGauge(metric.Type)
+	ser, ok := s.series[identity.Key()]
+	if !ok {
+		identity.Labels = maps.Clone(identity.Labels)
+		if identity.Labels == nil {
+			identity.Labels = core.Labels{}
+		}
+		ser = &series{identity: identity, typ: typ}
+		s.series[identity.Key()] = ser
+	}
+	if ser.typ != typ {
+		return metricIdentity, core.ErrMetricTypeMismatch
+	}
+
+	i, found := slices.BinarySearchFunc(ser.samples, metricIdentity.Time, func(smp sample, t time.Time) int {
+		return smp.time.Compare(t)
+	})
+	if found {
+		switch s.conflictPolicy {
+		case core.ConflictPolicyOverwrite:
+			ser.samples[i].value = metric.MetricValue
+			ser.samples[i].isAnomaly = metric.IsAnomaly
+		case core.ConflictPolicyReject:
+			return metricIdentity, core.ErrDuplicateMetric
+		}
+		return metricIdentity, nil
+	}
+
+	ser.samples = slices.Insert(ser.samples, i, sample{time: metricIdentity.Time, value: metric.MetricValue, isAnomaly: metric.IsAnomaly})
+	return metricIdentity, nil
+}
+
+func (s *Storage) FindByMetricIdentity(metricIdentity core.MetricIdentity) (*core.Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ser, smp, ok := s.find(metricIdentity)
+	if !ok {
+		s.log.Warn("metric not found", slog.Any("metric_identity", metricIdentity))
+		return nil, fmt.Errorf("metric with identity %v: %w", metricIdentity, core.ErrMetricNotFound)
+	}
+
+	s.log.Info("metric found successfully", slog.Any("metric_identity", metricIdentity))
+	return &core.Metric{
+		MetricIdentity: metricIdentityOf(ser.identity, smp.time),
+		Type:           ser.typ,
+		MetricValue:    smp.value,
+		IsAnomaly:      smp.isAnomaly,
+	}, nil
+}
+
+// find must be called with mu held.
+func (s *Storage) find(metricIdentity core.MetricIdentity) (*series, *sample, bool) {
+	ser, ok := s.series[seriesIdentityOf(metricIdentity).Key()]
+	if !ok {
+		return nil, nil, false
+	}
+
+	t := storedTime(metricIdentity.Time)
+	i, found := slices.BinarySearchFunc(ser.samples, t, func(smp sample, t time.Time) int {
+		return smp.time.Compare(t)
+	})
+	if !found {
+		return nil, nil, false
+	}
+	return ser, &ser.samples[i], true
+}
+
+func (s *Storage) FindRange(rangeQuery core.RangeQuery) ([]core.Metric, error) {
+	selector, err := core.NewLabelSelector(rangeQuery.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metrics := make([]core.Metric, 0)
+	for _, ser := range s.selectSeries(rangeQuery.ServiceURL, rangeQuery.MetricName, rangeQuery.PodName, selector) {
+		for _, smp := range samplesBetween(ser.samples, rangeQuery.Start, rangeQuery.End) {
+			metrics = append(metrics, core.Metric{
+				MetricIdentity: metricIdentityOf(ser.identity, smp.time),
+				Type:           ser.typ,
+				MetricValue:    smp.value,
+			})
+		}
+	}
+
+	s.log.Info("metric range fetched successfully", slog.Int("count", len(metrics)))
+	return metrics, nil
+}
+
+func (s *Storage) FindSeries(seriesQuery core.SeriesQuery) ([]core.SeriesIdentity, error) {
+	selector, err := core.NewLabelSelector(seriesQuery.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := make([]core.SeriesIdentity, 0)
+	for _, ser := range s.selectSeries(seriesQuery.ServiceURL, seriesQuery.MetricName, seriesQuery.PodName, selector) {
+		if len(samplesBetween(ser.samples, seriesQuery.Start, seriesQuery.End)) > 0 {
+			identities = append(identities, ser.identity)
+		}
+	}
+
+	s.log.Info("series fetched successfully", slog.Int("count", len(identities)))
+	return identities, nil
+}
+
+type aggregateGroup struct {
+	bucket     time.Time
+	serviceURL string
+	podName    string
+}
+
+func (s *Storage) Aggregate(aggregateQuery core.AggregateQuery) ([]core.Metric, error) {
+	if !core.IsAggregationFunction(aggregateQuery.Function) {
+		return nil, fmt.Errorf("unsupported aggregation function %q", aggregateQuery.Function)
+	}
+	selector, err := core.NewLabelSelector(aggregateQuery.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := aggregateQuery.Origin
+	if origin.IsZero() {
+		origin = core.DefaultBucketOrigin
+	}
+
+	s.mu.RLock()
+	groups := make(map[aggregateGroup][]core.Point)
+	for _, ser := range s.selectSeries(aggregateQuery.ServiceURL, aggregateQuery.MetricName, aggregateQuery.PodName, selector) {
+		group := aggregateGroup{serviceURL: aggregateQuery.ServiceURL, podName: aggregateQuery.PodName}
+		switch aggregateQuery.GroupBy {
+		case core.GroupByServiceURL:
+			group.serviceURL = ser.identity.ServiceURL
+		case core.GroupByPodName:
+			group.podName = ser.identity.PodName
+		}
+		for _, smp := range samplesBetween(ser.samples, aggregateQuery.Start, aggregateQuery.End) {
+			group.bucket = core.TimeBucket(smp.time, aggregateQuery.Interval, origin)
+			groups[group] = append(groups[group], core.Point{Time: smp.time, Value: smp.value})
+		}
+	}
+	s.mu.RUnlock()
+
+	metrics := make([]core.Metric, 0, len(groups))
+	for group, points := range groups {
+		value, _ := core.AggregatePoints(aggregateQuery.Function, points, aggregateQuery.Percentile)
+		metrics = append(metrics, core.Metric{
+			MetricIdentity: core.MetricIdentity{
+				Time:       group.bucket,
+				ServiceURL: group.serviceURL,
+				MetricName: aggregateQuery.MetricName,
+				PodName:    group.podName,
+			},
+			MetricValue: value,
+		})
+	}
+	slices.SortFunc(metrics, func(a, b core.Metric) int {
+		return cmp.Or(
+			cmp.Compare(a.ServiceURL, b.ServiceURL),
+			cmp.Compare(a.PodName, b.PodName),
+			a.Time.Compare(b.Time),
+		)
+	})
+
+	s.log.Info("metric aggregate fetched successfully", slog.Int("count", len(metrics)))
+	return metrics, nil
+}
+
+// selectSeries returns the matching series ordered like the database orders
+// them. Empty serviceURL, metricName and podName match any. It must be called
+// with mu held.
+func (s *Storage) selectSeries(serviceURL, metricName, podName string, selector core.LabelSelector) []*series {
+	selected := make([]*series, 0)
+	for _, ser := range s.series {
+		if (metricName != "" && ser.identity.MetricName != metricName) ||
+			(serviceURL != "" && ser.identity.ServiceURL != serviceURL) ||
+			(podName != "" && ser.identity.PodName != podName) ||
+			!selector.Matches(ser.identity.Labels) {
+			continue
+		}
+		selected = append(selected, ser)
+	}
+	slices.SortFunc(selected, func(a, b *series) int {
+		return cmp.Or(
+			cmp.Compare(a.identity.MetricName, b.identity.MetricName),
+			cmp.Compare(a.identity.ServiceURL, b.identity.ServiceURL),
+			cmp.Compare(a.identity.PodName, b.identity.PodName),
+			cmp.Compare(a.identity.Labels.String(), b.identity.Labels.String()),
+		)
+	})
+	return selected
+}
+
+// samplesBetween returns the samples in [start, end].
+func samplesBetween(samples []sample, start, end time.Time) []sample {
+	from, _ := slices.BinarySearchFunc(samples, start, func(smp sample, t time.Time) int {
+		return smp.time.Compare(t)
+	})
+	to := from
+	for to < len(samples) && !samples[to].time.After(end) {
+		to++
+	}
+	return samples[from:to]
+}
+
+func storedTime(t time.Time) time.Time {
+	return t.Round(time.Microsecond).UTC()
+}
+
+func seriesIdentityOf(metricIdentity core.MetricIdentity) core.SeriesIdentity {
+	return core.SeriesIdentity{
+		ServiceURL: metricIdentity.ServiceURL,
+		MetricName: metricIdentity.MetricName,
+		PodName:    metricIdentity.PodName,
+		Labels:     metricIdentity.Labels,
+	}
+}
+
+func metricIdentityOf(identity core.SeriesIdentity, t time.Time) core.MetricIdentity {
+	return core.MetricIdentity{
+		Time:       t,
+		ServiceURL: identity.ServiceURL,
+		MetricName: identity.MetricName,
+		PodName:    identity.PodName,
+		Labels:     identity.Labels,
+	}
+}
+
+func metricTypeOrGauge(metricType string) string {
+	if metricType == "" {
+		return core.MetricTypeGauge
+	}
+	return metricType
+}
@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func TestConflictPolicies(t *testing.T) {
+	at := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	metric := func(value float64) core.Metric {
+		return core.Metric{
+			MetricIdentity: core.MetricIdentity{Time: at, ServiceURL: "svc", MetricName: "m", PodName: "pod"},
+			MetricValue:    value,
+		}
+	}
+
+	cases := map[string]float64{
+		core.ConflictPolicyOverwrite: 3,
+		core.ConflictPolicyKeepFirst: 1,
+	}
+	for policy, want := range cases {
+		storage, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), policy)
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+
+		if _, err := storage.Save(metric(1)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		results, err := storage.SaveBatch([]core.Metric{metric(2), metric(3)})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				t.Fatalf("%s: conflicts must not be reported as errors, got %v", policy, result.Err)
+			}
+		}
+
+		stored, err := storage.FindByMetricIdentity(metric(0).MetricIdentity)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		if stored.MetricValue != want {
+			t.Fatalf("%s: expected value %v, got %v", policy, want, stored.MetricValue)
+		}
+	}
+
+	if _, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), "last_write_wins"); err == nil {
+		t.Fatal("unknown conflict policy should be rejected")
+	}
+}
+
+func TestDeleteNotificationsBeforeKeepsDedupeState(t *testing.T) {
+	storage, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), core.ConflictPolicyOverwrite)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	enqueue := func(alertKey, state string) int64 {
+		t.Helper()
+		ok, err := storage.EnqueueNotification(core.AlertNotification{
+			WebhookURL: "http://hook",
+			AlertKey:   alertKey,
+			Alert:      core.Alert{State: state},
+		})
+		if err != nil || !ok {
+			t.Fatalf("failed to enqueue %s %s: %v", alertKey, state, err)
+		}
+		return storage.nextNotificationID - 1
+	}
+	deliver := func(id int64) {
+		t.Helper()
+		if err := storage.MarkNotificationsDelivered([]int64{id}, time.Now().UTC()); err != nil {
+			t.Fatalf("failed to mark %d delivered: %v", id, err)
+		}
+	}
+
+	deliver(enqueue("resolved", core.AlertStateFiring))
+	deliver(enqueue("resolved", core.AlertStateResolved))
+	deliver(enqueue("refiring", core.AlertStateFiring))
+	deliver(enqueue("refiring", core.AlertStateResolved))
+	deliver(enqueue("refiring", core.AlertStateFiring))
+	pending := enqueue("pending", core.AlertStateFiring)
+
+	deleted, err := storage.DeleteNotificationsBefore(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted != 4 {
+		t.Fatalf("expected 4 deleted notifications, got %d", deleted)
+	}
+
+	ok, err := storage.EnqueueNotification(core.AlertNotification{
+		WebhookURL: "http://hook",
+		AlertKey:   "refiring",
+		Alert:      core.Alert{State: core.AlertStateFiring},
+	})
+	if err != nil || ok {
+		t.Fatalf("a repeat of the last kept state must be skipped, got %v, %v", ok, err)
+	}
+
+	deliver(pending)
+	due, err := storage.FindDueNotifications(time.Now().Add(time.Minute), 10)
+	if err != nil || len(due) != 0 {
+		t.Fatalf("expected no due notifications after delivery, got %+v, %v", due, err)
+	}
+}
@@ -0,0 +1,70 @@
+package otlp
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// Receiver handles OTLP metric exports for both the gRPC and the HTTP
+// transport.
+type Receiver struct {
+	log     *slog.Logger
+	cfg     *config.OTLP
+	service *core.MetricService
+}
+
+func NewReceiver(log *slog.Logger, cfgOTLP *config.OTLP, service *core.MetricService) *Receiver {
+	return &Receiver{log: log, cfg: cfgOTLP, service: service}
+}
+
+// Export saves the data points of the request. Data points that cannot be
+// translated or are rejected by the service are reported as a partial
+// success, which exporters do not retry. Samples rejected as duplicates count
+// as written so that retries of a partially saved request succeed.
+func (r *Receiver) Export(req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	t := toMetrics(r.cfg, req, time.Now().UTC())
+
+	batchSize := r.cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(t.metrics)
+	}
+
+	for start := 0; start < len(t.metrics); start += batchSize {
+		batch := t.metrics[start:min(start+batchSize, len(t.metrics))]
+
+		results, err := r.service.CreateMetrics(batch)
+		if err != nil {
+			if errors.Is(err, core.ErrWriteQueueFull) {
+				r.log.Warn("write queue is full", slog.String("error", err.Error()))
+				return nil, err
+			}
+			r.log.Error("failed to save otlp batch", slog.String("error", err.Error()))
+			return nil, err
+		}
+
+		for _, result := range results {
+			if result.Err != nil && !errors.Is(result.Err, core.ErrDuplicateMetric) {
+				t.reject(1, result.Err)
+			}
+		}
+	}
+
+	response := &colmetricspb.ExportMetricsServiceResponse{}
+	if t.rejected > 0 {
+		r.log.Warn("otlp data points rejected", slog.Int("rejected", t.rejected), slog.Int("samples", len(t.metrics)), slog.String("error", t.firstErr.Error()))
+		response.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: int64(t.rejected),
+			ErrorMessage:       t.firstErr.Error(),
+		}
+		return response, nil
+	}
+
+	r.log.Info("otlp export successfully processed", slog.Int("samples", len(t.metrics)), slog.Int("skipped", t.skipped))
+	return response, nil
+}
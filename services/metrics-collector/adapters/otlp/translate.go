@@ -0,0 +1,350 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	serviceNameAttribute      = "service.name"
+	serviceNamespaceAttribute = "service.namespace"
+)
+
+// translation collects the metrics of an export request together with the
+// data points that could not be translated.
+type translation struct {
+	metrics  []core.Metric
+	rejected int
+	skipped  int
+	firstErr error
+}
+
+func (t *translation) reject(count int, err error) {
+	t.rejected += count
+	if t.firstErr == nil {
+		t.firstErr = err
+	}
+}
+
+// toMetrics translates an export request into the series model. The service
+// URL is service.name, prefixed with service.namespace when set, and the pod
+// name the first configured pod attribute, which is the same job and instance
+// split the Prometheus OTLP translation uses. Names are sanitized so that
+// metrics stay addressable from PromQL. Histograms and summaries expand into
+// the _bucket, _sum and _count series of the exposition format.
+func toMetrics(cfg *config.OTLP, req *colmetricspb.ExportMetricsServiceRequest, now time.Time) *translation {
+	t := &translation{metrics: make([]core.Metric, 0)}
+	for _, resourceMetrics := range req.GetResourceMetrics() {
+		attributes := resourceMetrics.GetResource().GetAttributes()
+		serviceURL := attributeValue(attributes, serviceNameAttribute)
+		if namespace := attributeValue(attributes, serviceNamespaceAttribute); namespace != "" && serviceURL != "" {
+			serviceURL = namespace + "/" + serviceURL
+		}
+		podName := ""
+		for _, name := range cfg.PodAttributes {
+			if podName = attributeValue(attributes, name); podName != "" {
+				break
+			}
+		}
+
+		resourceLabels := make(core.Labels)
+		for _, name := range cfg.PromoteResourceAttributes {
+			if value := attributeValue(attributes, name); value != "" {
+				resourceLabels[sanitizeLabelName(name)] = value
+			}
+		}
+
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				if serviceURL == "" || podName == "" {
+					t.reject(dataPointCount(metric), fmt.Errorf("resource of metric %q has no service name or pod attribute", metric.GetName()))
+					continue
+				}
+				s := seriesTranslator{
+					translation:    t,
+					serviceURL:     serviceURL,
+					podName:        podName,
+					metricName:     sanitizeMetricName(metric.GetName()),
+					resourceLabels: resourceLabels,
+					now:            now,
+				}
+				s.translate(metric)
+			}
+		}
+	}
+	return t
+}
+
+type seriesTranslator struct {
+	*translation
+	serviceURL     string
+	podName        string
+	metricName     string
+	resourceLabels core.Labels
+	now            time.Time
+}
+
+func (s *seriesTranslator) translate(metric *metricspb.Metric) {
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, point := range data.Gauge.GetDataPoints() {
+			s.number(core.MetricTypeGauge, point)
+		}
+	case *metricspb.Metric_Sum:
+		sum := data.Sum
+		if sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			s.reject(len(sum.GetDataPoints()), fmt.Errorf("metric %q: only cumulative sums are supported", metric.GetName()))
+			return
+		}
+		metricType := core.MetricTypeGauge
+		if sum.GetIsMonotonic() {
+			metricType = core.MetricTypeCounter
+		}
+		for _, point := range sum.GetDataPoints() {
+			s.number(metricType, point)
+		}
+	case *metricspb.Metric_Histogram:
+		histogram := data.Histogram
+		if histogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			s.reject(len(histogram.GetDataPoints()), fmt.Errorf("metric %q: only cumulative histograms are supported", metric.GetName()))
+			return
+		}
+		for _, point := range histogram.GetDataPoints() {
+			s.histogram(point)
+		}
+	case *metricspb.Metric_Summary:
+		for _, point := range data.Summary.GetDataPoints() {
+			s.summary(point)
+		}
+	default:
+		s.reject(dataPointCount(metric), fmt.Errorf("metric %q: unsupported data type %T", metric.GetName(), data))
+	}
+}
+
+func (s *seriesTranslator) number(metricType string, point *metricspb.NumberDataPoint) {
+	if noRecordedValue(point.GetFlags()) {
+		s.skipped++
+		return
+	}
+
+	value := point.GetAsDouble()
+	if v, ok := point.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		value = float64(v.AsInt)
+	}
+	s.add(s.metricName, metricType, s.labels(point.GetAttributes()), point.GetTimeUnixNano(), value)
+}
+
+func (s *seriesTranslator) histogram(point *metricspb.HistogramDataPoint) {
+	if noRecordedValue(point.GetFlags()) {
+		s.skipped++
+		return
+	}
+
+	labels := s.labels(point.GetAttributes())
+	bounds, counts := point.GetExplicitBounds(), point.GetBucketCounts()
+	if len(counts) > 0 && len(counts) != len(bounds)+1 {
+		s.reject(1, fmt.Errorf("metric %q: %d bucket counts do not match %d explicit bounds", s.metricName, len(counts), len(bounds)))
+		return
+	}
+
+	// OTLP bucket counts are per bucket, the le buckets are cumulative.
+	var cumulative uint64
+	for i, count := range counts {
+		cumulative += count
+		le := math.Inf(1)
+		if i < len(bounds) {
+			le = bounds[i]
+		}
+		s.add(s.metricName+"_bucket", core.MetricTypeHistogram, withLabel(labels, "le", formatFloat(le)), point.GetTimeUnixNano(), float64(cumulative))
+	}
+	if point.Sum != nil {
+		s.add(s.metricName+"_sum", core.MetricTypeHistogram, labels, point.GetTimeUnixNano(), point.GetSum())
+	}
+	s.add(s.metricName+"_count", core.MetricTypeHistogram, labels, point.GetTimeUnixNano(), float64(point.GetCount()))
+}
+
+func (s *seriesTranslator) summary(point *metricspb.SummaryDataPoint) {
+	if noRecordedValue(point.GetFlags()) {
+		s.skipped++
+		return
+	}
+
+	labels := s.labels(point.GetAttributes())
+	for _, quantile := range point.GetQuantileValues() {
+		s.add(s.metricName, core.MetricTypeSummary, withLabel(labels, "quantile", formatFloat(quantile.GetQuantile())), point.GetTimeUnixNano(), quantile.GetValue())
+	}
+	s.add(s.metricName+"_sum", core.MetricTypeSummary, labels, point.GetTimeUnixNano(), point.GetSum())
+	s.add(s.metricName+"_count", core.MetricTypeSummary, labels, point.GetTimeUnixNano(), float64(point.GetCount()))
+}
+
+func (s *seriesTranslator) add(metricName, metricType string, labels core.Labels, timeUnixNano uint64, value float64) {
+	t := s.now
+	if timeUnixNano > 0 {
+		t = time.Unix(0, int64(timeUnixNano)).UTC()
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	s.metrics = append(s.metrics, core.Metric{
+		MetricIdentity: core.MetricIdentity{
+			Time:       t,
+			ServiceURL: s.serviceURL,
+			MetricName: metricName,
+			PodName:    s.podName,
+			Labels:     labels,
+		},
+		Type:        metricType,
+		MetricValue: value,
+	})
+}
+
+// labels merges the promoted resource attributes with the data point
+// attributes, which win on conflicts.
+func (s *seriesTranslator) labels(attributes []*commonpb.KeyValue) core.Labels {
+	labels := make(core.Labels, len(s.resourceLabels)+len(attributes))
+	for name, value := range s.resourceLabels {
+		labels[name] = value
+	}
+	for _, attribute := range attributes {
+		if value := anyValueString(attribute.GetValue()); value != "" {
+			labels[sanitizeLabelName(attribute.GetKey())] = value
+		}
+	}
+	return labels
+}
+
+func withLabel(labels core.Labels, name, value string) core.Labels {
+	result := make(core.Labels, len(labels)+1)
+	for n, v := range labels {
+		result[n] = v
+	}
+	result[name] = value
+	return result
+}
+
+func attributeValue(attributes []*commonpb.KeyValue, key string) string {
+	for _, attribute := range attributes {
+		if attribute.GetKey() == key {
+			return anyValueString(attribute.GetValue())
+		}
+	}
+	return ""
+}
+
+// anyValueString renders scalar values as they are and arrays and maps as
+// JSON, as the Prometheus translation does.
+func anyValueString(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return formatFloat(v.DoubleValue)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue:
+		data, err := json.Marshal(anyValueJSON(value))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	default:
+		return ""
+	}
+}
+
+func anyValueJSON(value *commonpb.AnyValue) any {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, anyValueJSON(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := make(map[string]any, len(v.KvlistValue.GetValues()))
+		for _, item := range v.KvlistValue.GetValues() {
+			values[item.GetKey()] = anyValueJSON(item.GetValue())
+		}
+		return values
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	default:
+		return anyValueString(value)
+	}
+}
+
+func sanitizeMetricName(name string) string {
+	return sanitize(name, func(r rune) bool { return r == ':' })
+}
+
+func sanitizeLabelName(name string) string {
+	return sanitize(name, func(rune) bool { return false })
+}
+
+// sanitize replaces every character that is not valid in a Prometheus name
+// with an underscore and prefixes names starting with a digit.
+func sanitize(name string, allowed func(r rune) bool) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || allowed(r) {
+			return r
+		}
+		return '_'
+	}, name)
+	if sanitized != "" && sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+}
+
+func noRecordedValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+func dataPointCount(metric *metricspb.Metric) int {
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return len(data.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		return len(data.Sum.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	default:
+		return 0
+	}
+}
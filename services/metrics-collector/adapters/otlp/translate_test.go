@@ -0,0 +1,145 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var cfg = &config.OTLP{
+	PodAttributes:             []string{"k8s.pod.name", "service.instance.id"},
+	PromoteResourceAttributes: []string{"deployment.environment"},
+}
+
+// exportJSON exercises the JSON encoding as exporters send it: lowerCamelCase
+// fields, 64-bit integers as strings and enums as numbers.
+const exportJSON = `{
+  "resourceMetrics": [{
+    "resource": {"attributes": [
+      {"key": "service.name", "value": {"stringValue": "checkout"}},
+      {"key": "service.namespace", "value": {"stringValue": "shop"}},
+      {"key": "service.instance.id", "value": {"stringValue": "checkout-1"}},
+      {"key": "deployment.environment", "value": {"stringValue": "prod"}},
+      {"key": "telemetry.sdk.language", "value": {"stringValue": "go"}}
+    ]},
+    "scopeMetrics": [{
+      "metrics": [
+        {"name": "process.cpu.utilization", "gauge": {"dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "asDouble": 0.25, "attributes": [{"key": "cpu.mode", "value": {"stringValue": "user"}}]}
+        ]}},
+        {"name": "http.server.requests", "sum": {"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "asInt": "42"}
+        ]}},
+        {"name": "queue.size", "sum": {"aggregationTemporality": 2, "dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "asInt": "-3"}
+        ]}},
+        {"name": "delta.requests", "sum": {"aggregationTemporality": 1, "isMonotonic": true, "dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "asInt": "1"}
+        ]}},
+        {"name": "http.server.duration", "histogram": {"aggregationTemporality": 2, "dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "count": "6", "sum": 2.5, "bucketCounts": ["1", "2", "3"], "explicitBounds": [0.1, 1]}
+        ]}},
+        {"name": "gc.pause", "summary": {"dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "count": "4", "sum": 0.4, "quantileValues": [{"quantile": 0.5, "value": 0.1}]}
+        ]}},
+        {"name": "stale", "gauge": {"dataPoints": [
+          {"timeUnixNano": "1735689600000000000", "asDouble": 1, "flags": 1}
+        ]}}
+      ]
+    }]
+  }]
+}`
+
+func TestToMetrics(t *testing.T) {
+	var req colmetricspb.ExportMetricsServiceRequest
+	if err := protojson.Unmarshal([]byte(exportJSON), &req); err != nil {
+		t.Fatalf("failed to unmarshal request: %v", err)
+	}
+
+	translation := toMetrics(cfg, &req, time.Now())
+	if translation.rejected != 1 || translation.firstErr == nil {
+		t.Fatalf("the delta sum should be rejected, got %d rejected (err=%v)", translation.rejected, translation.firstErr)
+	}
+	if translation.skipped != 1 {
+		t.Fatalf("the point without recorded value should be skipped, got %d", translation.skipped)
+	}
+
+	at := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	want := map[string]core.Metric{
+		`process_cpu_utilization{cpu_mode="user",deployment_environment="prod"}`: {Type: core.MetricTypeGauge, MetricValue: 0.25},
+		`http_server_requests{deployment_environment="prod"}`:                    {Type: core.MetricTypeCounter, MetricValue: 42},
+		`queue_size{deployment_environment="prod"}`:                              {Type: core.MetricTypeGauge, MetricValue: -3},
+		`http_server_duration_bucket{deployment_environment="prod",le="0.1"}`:    {Type: core.MetricTypeHistogram, MetricValue: 1},
+		`http_server_duration_bucket{deployment_environment="prod",le="1"}`:      {Type: core.MetricTypeHistogram, MetricValue: 3},
+		`http_server_duration_bucket{deployment_environment="prod",le="+Inf"}`:   {Type: core.MetricTypeHistogram, MetricValue: 6},
+		`http_server_duration_sum{deployment_environment="prod"}`:                {Type: core.MetricTypeHistogram, MetricValue: 2.5},
+		`http_server_duration_count{deployment_environment="prod"}`:              {Type: core.MetricTypeHistogram, MetricValue: 6},
+		`gc_pause{deployment_environment="prod",quantile="0.5"}`:                 {Type: core.MetricTypeSummary, MetricValue: 0.1},
+		`gc_pause_sum{deployment_environment="prod"}`:                            {Type: core.MetricTypeSummary, MetricValue: 0.4},
+		`gc_pause_count{deployment_environment="prod"}`:                          {Type: core.MetricTypeSummary, MetricValue: 4},
+	}
+	if len(translation.metrics) != len(want) {
+		t.Fatalf("expected %d metrics, got %d: %v", len(want), len(translation.metrics), translation.metrics)
+	}
+	for _, metric := range translation.metrics {
+		key := metric.MetricName + metric.Labels.String()
+		expected, ok := want[key]
+		if !ok {
+			t.Fatalf("unexpected series %s", key)
+		}
+		if metric.Type != expected.Type || metric.MetricValue != expected.MetricValue {
+			t.Fatalf("%s: expected %s %v, got %s %v", key, expected.Type, expected.MetricValue, metric.Type, metric.MetricValue)
+		}
+		if metric.ServiceURL != "shop/checkout" || metric.PodName != "checkout-1" || !metric.Time.Equal(at) {
+			t.Fatalf("%s: unexpected identity %+v", key, metric.MetricIdentity)
+		}
+	}
+}
+
+func TestToMetricsRejectsResourcesWithoutPod(t *testing.T) {
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "checkout"}}},
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "up",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+						{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1}},
+						{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1}},
+					}}},
+				}},
+			}},
+		}},
+	}
+
+	translation := toMetrics(cfg, req, time.Now())
+	if len(translation.metrics) != 0 || translation.rejected != 2 {
+		t.Fatalf("expected both points rejected, got %d metrics and %d rejected", len(translation.metrics), translation.rejected)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	cases := map[string]string{
+		"http.server.duration": "http_server_duration",
+		"ns:requests-total":    "ns:requests_total",
+		"2xx":                  "_2xx",
+	}
+	for name, want := range cases {
+		if got := sanitizeMetricName(name); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+	if got := sanitizeLabelName("k8s:pod"); got != "k8s_pod" {
+		t.Errorf("colons are not valid in label names, got %s", got)
+	}
+}
@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type AlertDTO struct {
+	Rule        string            `json:"rule"`
+	ServiceURL  string            `json:"service_url"`
+	MetricName  string            `json:"metric_name"`
+	PodName     string            `json:"pod_name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	State       string            `json:"state"`
+	Value       jsonFloat         `json:"value"`
+	ActiveAt    time.Time         `json:"active_at"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+}
+
+func NewListAlertsHandler(log *slog.Logger, service *core.AlertService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alerts := service.Alerts()
+
+		response := struct {
+			Alerts []AlertDTO `json:"alerts"`
+		}{
+			Alerts: make([]AlertDTO, 0, len(alerts)),
+		}
+		for _, alert := range alerts {
+			response.Alerts = append(response.Alerts, toAlertDTO(alert))
+		}
+
+		log.Info("alerts successfully retrieved", slog.Int("count", len(alerts)))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func toAlertDTO(alert core.Alert) AlertDTO {
+	alertDTO := AlertDTO{
+		Rule:        alert.Rule,
+		ServiceURL:  alert.Series.ServiceURL,
+		MetricName:  alert.Series.MetricName,
+		PodName:     alert.Series.PodName,
+		Labels:      alert.Labels,
+		Annotations: alert.Annotations,
+		State:       alert.State,
+		Value:       jsonFloat(alert.Value),
+		ActiveAt:    alert.ActiveAt,
+	}
+	if !alert.FiredAt.IsZero() {
+		alertDTO.FiredAt = &alert.FiredAt
+	}
+	if !alert.ResolvedAt.IsZero() {
+		alertDTO.ResolvedAt = &alert.ResolvedAt
+	}
+	return alertDTO
+}
@@ -0,0 +1,197 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type AnomalyAckDTO struct {
+	Status  string    `json:"status"`
+	AckedBy string    `json:"acked_by"`
+	Reason  string    `json:"reason"`
+	AckedAt time.Time `json:"acked_at"`
+}
+
+type AnomalyDTO struct {
+	Time        time.Time         `json:"time"`
+	ServiceURL  string            `json:"service_url"`
+	MetricName  string            `json:"metric_name"`
+	PodName     string            `json:"pod_name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MetricValue jsonFloat         `json:"metric_value"`
+	Context     []PointDTO        `json:"context"`
+	Ack         *AnomalyAckDTO    `json:"ack,omitempty"`
+}
+
+func NewListAnomaliesHandler(log *slog.Logger, service *core.AnomalyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		startStr := query.Get("start")
+		endStr := query.Get("end")
+		contextStr := query.Get("context")
+		limitStr := query.Get("limit")
+
+		start, err := time.Parse(time.RFC3339Nano, startStr)
+		if err != nil {
+			log.Warn("invalid start format", slog.String("start", startStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid start format", http.StatusBadRequest)
+			return
+		}
+
+		end, err := time.Parse(time.RFC3339Nano, endStr)
+		if err != nil {
+			log.Warn("invalid end format", slog.String("end", endStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid end format", http.StatusBadRequest)
+			return
+		}
+
+		var contextWindow time.Duration
+		if contextStr != "" {
+			contextWindow, err = time.ParseDuration(contextStr)
+			if err != nil {
+				log.Warn("invalid context format", slog.String("context", contextStr), slog.String("error", err.Error()))
+				http.Error(w, "invalid context format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var limit int
+		if limitStr != "" {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil {
+				log.Warn("invalid limit format", slog.String("limit", limitStr), slog.String("error", err.Error()))
+				http.Error(w, "invalid limit format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		anomalyQuery := core.AnomalyQuery{
+			ServiceURL: query.Get("service_url"),
+			MetricName: query.Get("metric_name"),
+			PodName:    query.Get("pod_name"),
+			Start:      start,
+			End:        end,
+			Context:    contextWindow,
+			Limit:      limit,
+		}
+
+		anomalies, err := service.ListAnomalies(anomalyQuery)
+		if err != nil {
+			switch {
+			case errors.Is(err, core.ErrInvalidAnomalyQuery):
+				log.Warn("invalid anomaly query", slog.Any("query", anomalyQuery), slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, core.ErrQueryFailed):
+				log.Error("failed to list anomalies", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			default:
+				log.Error("unexpected error", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		response := struct {
+			Anomalies []AnomalyDTO `json:"anomalies"`
+		}{
+			Anomalies: make([]AnomalyDTO, 0, len(anomalies)),
+		}
+		for _, anomaly := range anomalies {
+			points := make([]PointDTO, 0, len(anomaly.Context))
+			for _, point := range anomaly.Context {
+				points = append(points, PointDTO{Time: point.Time, Value: jsonFloat(point.Value)})
+			}
+			anomalyDTO := AnomalyDTO{
+				Time:        anomaly.Time,
+				ServiceURL:  anomaly.ServiceURL,
+				MetricName:  anomaly.MetricName,
+				PodName:     anomaly.PodName,
+				Labels:      anomaly.Labels,
+				MetricValue: jsonFloat(anomaly.MetricValue),
+				Context:     points,
+			}
+			if anomaly.Ack != nil {
+				anomalyDTO.Ack = toAnomalyAckDTO(anomaly.Ack)
+			}
+			response.Anomalies = append(response.Anomalies, anomalyDTO)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+type AcknowledgeAnomalyDTO struct {
+	Time       time.Time         `json:"time"`
+	ServiceURL string            `json:"service_url"`
+	MetricName string            `json:"metric_name"`
+	PodName    string            `json:"pod_name"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Status     string            `json:"status"`
+	AckedBy    string            `json:"acked_by"`
+	Reason     string            `json:"reason"`
+}
+
+func NewAcknowledgeAnomalyHandler(log *slog.Logger, service *core.AnomalyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ackDTO AcknowledgeAnomalyDTO
+		if err := json.NewDecoder(r.Body).Decode(&ackDTO); err != nil {
+			log.Error("failed to parse request", slog.String("error", err.Error()))
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		ack := core.AnomalyAck{
+			MetricIdentity: core.MetricIdentity{
+				Time:       ackDTO.Time,
+				ServiceURL: ackDTO.ServiceURL,
+				MetricName: ackDTO.MetricName,
+				PodName:    ackDTO.PodName,
+				Labels:     ackDTO.Labels,
+			},
+			Status:  ackDTO.Status,
+			AckedBy: ackDTO.AckedBy,
+			Reason:  ackDTO.Reason,
+		}
+
+		saved, err := service.AcknowledgeAnomaly(ack)
+		if err != nil {
+			switch {
+			case errors.Is(err, core.ErrInvalidAnomalyAck):
+				log.Warn("invalid anomaly acknowledgement", slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, core.ErrAnomalyNotFound):
+				log.Warn("anomaly not found", slog.Any("metric_identity", ack.MetricIdentity), slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, core.ErrAckFailed):
+				log.Error("failed to acknowledge anomaly", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			default:
+				log.Error("unexpected error", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(toAnomalyAckDTO(saved))
+	}
+}
+
+func toAnomalyAckDTO(ack *core.AnomalyAck) *AnomalyAckDTO {
+	return &AnomalyAckDTO{
+		Status:  ack.Status,
+		AckedBy: ack.AckedBy,
+		Reason:  ack.Reason,
+		AckedAt: ack.AckedAt,
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
@@ -27,10 +29,13 @@ func NewPingHandler(log *slog.Logger) http.HandlerFunc {
 }
 
 type MetricDTO struct {
-	ServiceURL  string  `json:"service_url"`
-	MetricName  string  `json:"metric_name"`
-	PodName     string  `json:"pod_name"`
-	MetricValue float64 `json:"metric_value"`
+	ServiceURL  string            `json:"service_url"`
+	MetricName  string            `json:"metric_name"`
+	PodName     string            `json:"pod_name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Time        *time.Time        `json:"time,omitempty"`
+	MetricValue float64           `json:"metric_value"`
 }
 
 func NewCreateMetricHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
@@ -44,20 +49,29 @@ func NewCreateMetricHandler(log *slog.Logger, service *core.MetricService) http.
 
 		metric := core.Metric{
 			MetricIdentity: core.MetricIdentity{
-				Time:       time.Now().UTC(),
+				Time:       core.SampleTime(metricDTO.Time, time.Now().UTC()),
 				ServiceURL: metricDTO.ServiceURL,
 				MetricName: metricDTO.MetricName,
 				PodName:    metricDTO.PodName,
+				Labels:     metricDTO.Labels,
 			},
+			Type:        metricDTO.Type,
 			MetricValue: metricDTO.MetricValue,
 		}
 
 		identity, err := service.CreateMetric(metric)
 		if err != nil {
 			switch {
-			case errors.Is(err, core.ErrInvalidMetric):
+			case errors.Is(err, core.ErrInvalidMetric), errors.Is(err, core.ErrTimestampOutOfWindow), errors.Is(err, core.ErrInvalidMetricType),
+				errors.Is(err, core.ErrMetricTypeMismatch), errors.Is(err, core.ErrCounterNotMonotonic):
 				log.Warn("metric validation failed", slog.String("error", err.Error()))
 				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, core.ErrDuplicateMetric):
+				log.Warn("metric already exists", slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, core.ErrWriteQueueFull):
+				log.Warn("write queue is full", slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
 			case errors.Is(err, core.ErrSaveFailed):
 				log.Error("failed to save metric", slog.String("error", err.Error()))
 				http.Error(w, "internal error", http.StatusInternalServerError)
@@ -68,29 +82,108 @@ func NewCreateMetricHandler(log *slog.Logger, service *core.MetricService) http.
 			return
 		}
 
+		response := toMetricIdentityDTO(identity)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+type MetricBatchDTO struct {
+	Metrics []MetricDTO `json:"metrics"`
+}
+
+type MetricResultDTO struct {
+	Index    int             `json:"index"`
+	Accepted bool            `json:"accepted"`
+	Error    string          `json:"error,omitempty"`
+	Metric   *MetricIdentity `json:"metric,omitempty"`
+}
+
+func NewCreateMetricsHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batchDTO MetricBatchDTO
+		if err := json.NewDecoder(r.Body).Decode(&batchDTO); err != nil {
+			log.Error("failed to parse request", slog.String("error", err.Error()))
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		metrics := make([]core.Metric, 0, len(batchDTO.Metrics))
+		for _, metricDTO := range batchDTO.Metrics {
+			metrics = append(metrics, core.Metric{
+				MetricIdentity: core.MetricIdentity{
+					Time:       core.SampleTime(metricDTO.Time, now),
+					ServiceURL: metricDTO.ServiceURL,
+					MetricName: metricDTO.MetricName,
+					PodName:    metricDTO.PodName,
+					Labels:     metricDTO.Labels,
+				},
+				Type:        metricDTO.Type,
+				MetricValue: metricDTO.MetricValue,
+			})
+		}
+
+		results, err := service.CreateMetrics(metrics)
+		if err != nil {
+			switch {
+			case errors.Is(err, core.ErrEmptyMetricBatch), errors.Is(err, core.ErrMetricBatchTooLarge):
+				log.Warn("metric batch validation failed", slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, core.ErrWriteQueueFull):
+				log.Warn("write queue is full", slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+			case errors.Is(err, core.ErrSaveFailed):
+				log.Error("failed to save metric batch", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			default:
+				log.Error("unexpected error", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+
 		response := struct {
-			Time       time.Time `json:"time"`
-			ServiceURL string    `json:"service_url"`
-			MetricName string    `json:"metric_name"`
-			PodName    string    `json:"pod_name"`
+			Results []MetricResultDTO `json:"results"`
 		}{
-			Time:       identity.Time,
-			ServiceURL: identity.ServiceURL,
-			MetricName: identity.MetricName,
-			PodName:    identity.PodName,
+			Results: make([]MetricResultDTO, 0, len(results)),
+		}
+		for _, result := range results {
+			resultDTO := MetricResultDTO{Index: result.Index}
+			if result.Err != nil {
+				resultDTO.Error = result.Err.Error()
+			} else {
+				resultDTO.Accepted = true
+				metricIdentity := toMetricIdentityDTO(result.MetricIdentity)
+				resultDTO.Metric = &metricIdentity
+			}
+			response.Results = append(response.Results, resultDTO)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(response)
 	}
 }
 
 type MetricIdentity struct {
-	Time       time.Time `json:"time"`
-	ServiceURL string    `json:"service_url"`
-	MetricName string    `json:"metric_name"`
-	PodName    string    `json:"pod_name"`
+	Time       time.Time         `json:"time"`
+	ServiceURL string            `json:"service_url"`
+	MetricName string            `json:"metric_name"`
+	PodName    string            `json:"pod_name"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+func toMetricIdentityDTO(identity *core.MetricIdentity) MetricIdentity {
+	return MetricIdentity{
+		Time:       identity.Time,
+		ServiceURL: identity.ServiceURL,
+		MetricName: identity.MetricName,
+		PodName:    identity.PodName,
+		Labels:     identity.Labels,
+	}
 }
 
 func NewGetMetricByMetricIdentityHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
@@ -109,11 +202,19 @@ func NewGetMetricByMetricIdentityHandler(log *slog.Logger, service *core.MetricS
 			return
 		}
 
+		labels, err := parseLabels(query["label"])
+		if err != nil {
+			log.Warn("invalid label format", slog.String("error", err.Error()))
+			http.Error(w, "invalid label format", http.StatusBadRequest)
+			return
+		}
+
 		metricIdentity := core.MetricIdentity{
 			Time:       parsedTime,
 			ServiceURL: serviceUrl,
 			MetricName: metricName,
 			PodName:    podName,
+			Labels:     labels,
 		}
 
 		metric, err := service.GetMetricByMetricIdentity(metricIdentity)
@@ -133,17 +234,226 @@ func NewGetMetricByMetricIdentityHandler(log *slog.Logger, service *core.MetricS
 		}
 
 		response := struct {
-			Time        time.Time `json:"time"`
-			ServiceURL  string    `json:"service_url"`
-			MetricName  string    `json:"metric_name"`
-			PodName     string    `json:"pod_name"`
-			MetricValue float64   `json:"metric_value"`
+			Time        time.Time         `json:"time"`
+			ServiceURL  string            `json:"service_url"`
+			MetricName  string            `json:"metric_name"`
+			PodName     string            `json:"pod_name"`
+			Labels      map[string]string `json:"labels,omitempty"`
+			Type        string            `json:"type"`
+			MetricValue jsonFloat         `json:"metric_value"`
+			IsAnomaly   bool              `json:"is_anomaly"`
 		}{
 			Time:        metric.Time,
 			ServiceURL:  metric.ServiceURL,
 			MetricName:  metric.MetricName,
 			PodName:     metric.PodName,
-			MetricValue: metric.MetricValue,
+			Labels:      metric.Labels,
+			Type:        metric.Type,
+			MetricValue: jsonFloat(metric.MetricValue),
+			IsAnomaly:   metric.IsAnomaly,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+type PointDTO struct {
+	Time  time.Time `json:"time"`
+	Value jsonFloat `json:"value"`
+}
+
+// jsonFloat renders NaN and infinities as the strings Prometheus uses, since
+// JSON has no numbers for them; finite values stay numbers.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+type SeriesDTO struct {
+	ServiceURL string            `json:"service_url"`
+	MetricName string            `json:"metric_name"`
+	PodName    string            `json:"pod_name"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Points     []PointDTO        `json:"points"`
+}
+
+func NewQueryRangeHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		startStr := query.Get("start")
+		endStr := query.Get("end")
+		stepStr := query.Get("step")
+
+		start, err := time.Parse(time.RFC3339Nano, startStr)
+		if err != nil {
+			log.Warn("invalid start format", slog.String("start", startStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid start format", http.StatusBadRequest)
+			return
+		}
+
+		end, err := time.Parse(time.RFC3339Nano, endStr)
+		if err != nil {
+			log.Warn("invalid end format", slog.String("end", endStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid end format", http.StatusBadRequest)
+			return
+		}
+
+		var step time.Duration
+		if stepStr != "" {
+			step, err = time.ParseDuration(stepStr)
+			if err != nil {
+				log.Warn("invalid step format", slog.String("step", stepStr), slog.String("error", err.Error()))
+				http.Error(w, "invalid step format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		matchers, err := parseLabelMatchers(query["match"])
+		if err != nil {
+			log.Warn("invalid label matcher format", slog.String("error", err.Error()))
+			http.Error(w, "invalid label matcher format", http.StatusBadRequest)
+			return
+		}
+
+		rangeQuery := core.RangeQuery{
+			ServiceURL: query.Get("service_url"),
+			MetricName: query.Get("metric_name"),
+			PodName:    query.Get("pod_name"),
+			Matchers:   matchers,
+			Start:      start,
+			End:        end,
+			Step:       step,
+		}
+
+		series, err := service.QueryRange(rangeQuery)
+		if err != nil {
+			switch {
+			case errors.Is(err, core.ErrInvalidRangeQuery):
+				log.Warn("invalid range query", slog.Any("query", rangeQuery), slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, core.ErrQueryFailed):
+				log.Error("failed to query metrics", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			default:
+				log.Error("unexpected error", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		response := struct {
+			Series []SeriesDTO `json:"series"`
+		}{
+			Series: make([]SeriesDTO, 0, len(series)),
+		}
+		for _, ser := range series {
+			response.Series = append(response.Series, toSeriesDTO(ser))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func NewAggregateHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		startStr := query.Get("start")
+		endStr := query.Get("end")
+		intervalStr := query.Get("interval")
+		percentileStr := query.Get("percentile")
+
+		start, err := time.Parse(time.RFC3339Nano, startStr)
+		if err != nil {
+			log.Warn("invalid start format", slog.String("start", startStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid start format", http.StatusBadRequest)
+			return
+		}
+
+		end, err := time.Parse(time.RFC3339Nano, endStr)
+		if err != nil {
+			log.Warn("invalid end format", slog.String("end", endStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid end format", http.StatusBadRequest)
+			return
+		}
+
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			log.Warn("invalid interval format", slog.String("interval", intervalStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid interval format", http.StatusBadRequest)
+			return
+		}
+
+		var percentile float64
+		if percentileStr != "" {
+			percentile, err = strconv.ParseFloat(percentileStr, 64)
+			if err != nil {
+				log.Warn("invalid percentile format", slog.String("percentile", percentileStr), slog.String("error", err.Error()))
+				http.Error(w, "invalid percentile format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		matchers, err := parseLabelMatchers(query["match"])
+		if err != nil {
+			log.Warn("invalid label matcher format", slog.String("error", err.Error()))
+			http.Error(w, "invalid label matcher format", http.StatusBadRequest)
+			return
+		}
+
+		aggregateQuery := core.AggregateQuery{
+			ServiceURL: query.Get("service_url"),
+			MetricName: query.Get("metric_name"),
+			PodName:    query.Get("pod_name"),
+			Matchers:   matchers,
+			Start:      start,
+			End:        end,
+			Interval:   interval,
+			Function:   query.Get("function"),
+			Percentile: percentile,
+			GroupBy:    query.Get("group_by"),
+		}
+
+		series, err := service.Aggregate(aggregateQuery)
+		if err != nil {
+			switch {
+			case errors.Is(err, core.ErrInvalidAggregateQuery):
+				log.Warn("invalid aggregate query", slog.Any("query", aggregateQuery), slog.String("error", err.Error()))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, core.ErrQueryFailed):
+				log.Error("failed to aggregate metrics", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			default:
+				log.Error("unexpected error", slog.String("error", err.Error()))
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		response := struct {
+			Series []SeriesDTO `json:"series"`
+		}{
+			Series: make([]SeriesDTO, 0, len(series)),
+		}
+		for _, ser := range series {
+			response.Series = append(response.Series, toSeriesDTO(ser))
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -151,3 +461,18 @@ func NewGetMetricByMetricIdentityHandler(log *slog.Logger, service *core.MetricS
 		_ = json.NewEncoder(w).Encode(response)
 	}
 }
+
+func toSeriesDTO(series core.Series) SeriesDTO {
+	points := make([]PointDTO, 0, len(series.Points))
+	for _, point := range series.Points {
+		points = append(points, PointDTO{Time: point.Time, Value: jsonFloat(point.Value)})
+	}
+	return SeriesDTO{
+		ServiceURL: series.ServiceURL,
+		MetricName: series.MetricName,
+		PodName:    series.PodName,
+		Labels:     series.Labels,
+		Type:       series.Type,
+		Points:     points,
+	}
+}
@@ -0,0 +1,47 @@
+package rest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var labelMatcherPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*("(?:[^"\\]|\\.)*")\s*$`)
+
+// parseLabelMatchers parses match parameters written as in PromQL selectors,
+// e.g. region="eu" or version=~"1\\..*".
+func parseLabelMatchers(values []string) ([]core.LabelMatcher, error) {
+	matchers := make([]core.LabelMatcher, 0, len(values))
+	for _, value := range values {
+		parts := labelMatcherPattern.FindStringSubmatch(value)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid label matcher %q", value)
+		}
+		matcherValue, err := strconv.Unquote(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid label matcher %q: %w", value, err)
+		}
+		matchers = append(matchers, core.LabelMatcher{Name: parts[1], Type: parts[2], Value: matcherValue})
+	}
+	return matchers, nil
+}
+
+// parseLabels parses label parameters written as name=value.
+func parseLabels(values []string) (core.Labels, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := make(core.Labels, len(values))
+	for _, value := range values {
+		name, labelValue, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q", value)
+		}
+		labels[name] = labelValue
+	}
+	return labels, nil
+}
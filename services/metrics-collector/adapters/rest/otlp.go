@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/otlp"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	maxOTLPBodySize     = 32 << 20
+	otlpProtobufContent = "application/x-protobuf"
+	otlpJSONContent     = "application/json"
+)
+
+// NewOTLPMetricsHandler accepts OTLP/HTTP metric exports encoded as protobuf
+// or JSON and answers in the encoding of the request.
+func NewOTLPMetricsHandler(log *slog.Logger, receiver *otlp.Receiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (contentType != otlpProtobufContent && contentType != otlpJSONContent) {
+			log.Warn("unsupported otlp content type", slog.String("content_type", r.Header.Get("Content-Type")))
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		req, err := decodeExportRequest(r, contentType)
+		if err != nil {
+			log.Error("failed to parse otlp request", slog.String("error", err.Error()))
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		response, err := receiver.Export(req)
+		if err != nil {
+			switch {
+			case errors.Is(err, core.ErrWriteQueueFull):
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+			case errors.Is(err, core.ErrMetricBatchTooLarge):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		var data []byte
+		if contentType == otlpJSONContent {
+			data, err = protojson.Marshal(response)
+		} else {
+			data, err = proto.Marshal(response)
+		}
+		if err != nil {
+			log.Error("failed to encode otlp response", slog.String("error", err.Error()))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+func decodeExportRequest(r *http.Request, contentType string) (*colmetricspb.ExportMetricsServiceRequest, error) {
+	var body io.Reader = r.Body
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gzip: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxOTLPBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(data) > maxOTLPBodySize {
+		return nil, fmt.Errorf("body exceeds %d bytes", maxOTLPBodySize)
+	}
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	if contentType == otlpJSONContent {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, &req)
+	} else {
+		err = proto.Unmarshal(data, &req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal export request: %w", err)
+	}
+	return &req, nil
+}
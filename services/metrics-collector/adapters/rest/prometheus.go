@@ -0,0 +1,255 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/promql"
+)
+
+// Bounds used when series and label requests leave out start or end.
+var (
+	promMinTime = time.Unix(0, 0).UTC()
+	promMaxTime = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+)
+
+type promResponse struct {
+	Status    string `json:"status"`
+	Data      any    `json:"data,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type promQueryData struct {
+	ResultType string `json:"resultType"`
+	Result     any    `json:"result"`
+}
+
+type promSeriesDTO struct {
+	Metric map[string]string `json:"metric"`
+	Value  []any             `json:"value,omitempty"`
+	Values [][]any           `json:"values,omitempty"`
+}
+
+func NewPromQueryHandler(log *slog.Logger, engine *promql.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writePromError(log, w, fmt.Errorf("%w: %v", promql.ErrInvalidQuery, err))
+			return
+		}
+
+		t, err := parsePromTime(r.Form.Get("time"), time.Now().UTC())
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+
+		result, err := engine.Query(r.Form.Get("query"), t)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		writePromData(w, toPromQueryData(result))
+	}
+}
+
+func NewPromQueryRangeHandler(log *slog.Logger, engine *promql.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writePromError(log, w, fmt.Errorf("%w: %v", promql.ErrInvalidQuery, err))
+			return
+		}
+
+		start, err := parsePromTime(r.Form.Get("start"), time.Time{})
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		end, err := parsePromTime(r.Form.Get("end"), time.Time{})
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		step, err := parsePromDuration(r.Form.Get("step"))
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		if start.IsZero() || end.IsZero() {
+			writePromError(log, w, fmt.Errorf("%w: start and end are required", promql.ErrInvalidQuery))
+			return
+		}
+
+		result, err := engine.QueryRange(r.Form.Get("query"), start, end, step)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		writePromData(w, toPromQueryData(result))
+	}
+}
+
+func NewPromSeriesHandler(log *slog.Logger, engine *promql.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, end, err := parsePromSeriesRange(r)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+
+		labelSets, err := engine.Series(r.Form["match[]"], start, end)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		data := make([]map[string]string, 0, len(labelSets))
+		for _, labels := range labelSets {
+			data = append(data, labels)
+		}
+		writePromData(w, data)
+	}
+}
+
+func NewPromLabelsHandler(log *slog.Logger, engine *promql.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, end, err := parsePromSeriesRange(r)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+
+		names, err := engine.LabelNames(r.Form["match[]"], start, end)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		writePromData(w, names)
+	}
+}
+
+func NewPromLabelValuesHandler(log *slog.Logger, engine *promql.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, end, err := parsePromSeriesRange(r)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+
+		values, err := engine.LabelValues(r.PathValue("name"), r.Form["match[]"], start, end)
+		if err != nil {
+			writePromError(log, w, err)
+			return
+		}
+		writePromData(w, values)
+	}
+}
+
+func parsePromSeriesRange(r *http.Request) (time.Time, time.Time, error) {
+	if err := r.ParseForm(); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %v", promql.ErrInvalidQuery, err)
+	}
+	start, err := parsePromTime(r.Form.Get("start"), promMinTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := parsePromTime(r.Form.Get("end"), promMaxTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end, nil
+}
+
+// parsePromTime accepts RFC 3339 and Unix timestamps in seconds with an
+// optional fraction.
+func parsePromTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMilli(int64(math.Round(seconds * 1000))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: cannot parse %q to a valid timestamp", promql.ErrInvalidQuery, value)
+}
+
+// parsePromDuration accepts seconds with an optional fraction and Prometheus
+// durations.
+func parsePromDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if d, err := promql.ParseDuration(value); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%w: cannot parse %q to a valid duration", promql.ErrInvalidQuery, value)
+}
+
+func toPromQueryData(result *promql.Result) promQueryData {
+	if result.Type == promql.ValueTypeScalar {
+		return promQueryData{ResultType: result.Type, Result: promPoint(result.Scalar)}
+	}
+
+	series := make([]promSeriesDTO, 0, len(result.Series))
+	for _, s := range result.Series {
+		seriesDTO := promSeriesDTO{Metric: s.Labels}
+		if result.Type == promql.ValueTypeVector {
+			seriesDTO.Value = promPoint(s.Points[0])
+		} else {
+			seriesDTO.Values = make([][]any, 0, len(s.Points))
+			for _, point := range s.Points {
+				seriesDTO.Values = append(seriesDTO.Values, promPoint(point))
+			}
+		}
+		series = append(series, seriesDTO)
+	}
+	return promQueryData{ResultType: result.Type, Result: series}
+}
+
+// promPoint renders a point as Prometheus does: seconds as a number and the
+// value as a string, so that NaN and infinities survive JSON.
+func promPoint(point core.Point) []any {
+	seconds := float64(point.Time.UnixMilli()) / 1000
+	switch {
+	case math.IsInf(point.Value, 1):
+		return []any{seconds, "+Inf"}
+	case math.IsInf(point.Value, -1):
+		return []any{seconds, "-Inf"}
+	case math.IsNaN(point.Value):
+		return []any{seconds, "NaN"}
+	default:
+		return []any{seconds, strconv.FormatFloat(point.Value, 'f', -1, 64)}
+	}
+}
+
+func writePromData(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(promResponse{Status: "success", Data: data})
+}
+
+func writePromError(log *slog.Logger, w http.ResponseWriter, err error) {
+	status, errorType := http.StatusInternalServerError, "internal"
+	switch {
+	case errors.Is(err, promql.ErrInvalidQuery):
+		status, errorType = http.StatusBadRequest, "bad_data"
+		log.Warn("invalid prometheus api request", slog.String("error", err.Error()))
+	case errors.Is(err, promql.ErrExecution):
+		status, errorType = http.StatusUnprocessableEntity, "execution"
+		log.Warn("failed to execute query", slog.String("error", err.Error()))
+	default:
+		log.Error("prometheus api request failed", slog.String("error", err.Error()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(promResponse{Status: "error", ErrorType: errorType, Error: err.Error()})
+}
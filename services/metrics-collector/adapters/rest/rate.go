@@ -0,0 +1,155 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func NewRateHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
+	return newCounterFunctionHandler(log, service.Rate)
+}
+
+func NewIncreaseHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
+	return newCounterFunctionHandler(log, service.Increase)
+}
+
+func newCounterFunctionHandler(log *slog.Logger, evaluate func(core.RateQuery) ([]core.Series, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		params, err := parseFunctionParams(query)
+		if err != nil {
+			log.Warn("invalid rate query parameters", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rateQuery := core.RateQuery{
+			ServiceURL: query.Get("service_url"),
+			MetricName: query.Get("metric_name"),
+			PodName:    query.Get("pod_name"),
+			Matchers:   params.matchers,
+			Start:      params.start,
+			End:        params.end,
+			Step:       params.step,
+			Window:     params.window,
+		}
+
+		series, err := evaluate(rateQuery)
+		if err != nil {
+			writeFunctionError(log, w, err)
+			return
+		}
+
+		writeSeries(w, series)
+	}
+}
+
+func NewHistogramQuantileHandler(log *slog.Logger, service *core.MetricService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		params, err := parseFunctionParams(query)
+		if err != nil {
+			log.Warn("invalid histogram quantile query parameters", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		quantileStr := query.Get("quantile")
+		quantile, err := strconv.ParseFloat(quantileStr, 64)
+		if err != nil {
+			log.Warn("invalid quantile format", slog.String("quantile", quantileStr), slog.String("error", err.Error()))
+			http.Error(w, "invalid quantile format", http.StatusBadRequest)
+			return
+		}
+
+		quantileQuery := core.HistogramQuantileQuery{
+			ServiceURL: query.Get("service_url"),
+			MetricName: query.Get("metric_name"),
+			PodName:    query.Get("pod_name"),
+			Matchers:   params.matchers,
+			Start:      params.start,
+			End:        params.end,
+			Step:       params.step,
+			Window:     params.window,
+			Quantile:   quantile,
+		}
+
+		series, err := service.HistogramQuantile(quantileQuery)
+		if err != nil {
+			writeFunctionError(log, w, err)
+			return
+		}
+
+		writeSeries(w, series)
+	}
+}
+
+type functionParams struct {
+	matchers []core.LabelMatcher
+	start    time.Time
+	end      time.Time
+	step     time.Duration
+	window   time.Duration
+}
+
+func parseFunctionParams(query url.Values) (functionParams, error) {
+	var (
+		params functionParams
+		err    error
+	)
+
+	if params.start, err = time.Parse(time.RFC3339Nano, query.Get("start")); err != nil {
+		return params, errors.New("invalid start format")
+	}
+	if params.end, err = time.Parse(time.RFC3339Nano, query.Get("end")); err != nil {
+		return params, errors.New("invalid end format")
+	}
+	if params.step, err = time.ParseDuration(query.Get("step")); err != nil {
+		return params, errors.New("invalid step format")
+	}
+	if params.window, err = time.ParseDuration(query.Get("window")); err != nil {
+		return params, errors.New("invalid window format")
+	}
+	if params.matchers, err = parseLabelMatchers(query["match"]); err != nil {
+		return params, errors.New("invalid label matcher format")
+	}
+	return params, nil
+}
+
+func writeFunctionError(log *slog.Logger, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidRateQuery), errors.Is(err, core.ErrInvalidQuantileQuery), errors.Is(err, core.ErrQueryTypeMismatch):
+		log.Warn("invalid function query", slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, core.ErrQueryFailed):
+		log.Error("failed to evaluate function", slog.String("error", err.Error()))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	default:
+		log.Error("unexpected error", slog.String("error", err.Error()))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+func writeSeries(w http.ResponseWriter, series []core.Series) {
+	response := struct {
+		Series []SeriesDTO `json:"series"`
+	}{
+		Series: make([]SeriesDTO, 0, len(series)),
+	}
+	for _, ser := range series {
+		response.Series = append(response.Series, toSeriesDTO(ser))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	prompb "github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/rest/proto"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	maxRemoteWriteBodySize = 32 << 20
+	metricNameLabel        = "__name__"
+	// staleNaN is the value Prometheus writes to mark a series as gone.
+	staleNaN = 0x7ff0000000000002
+)
+
+// NewRemoteWriteHandler accepts Prometheus remote write requests. The service
+// and pod labels become the series identity, __name__ the metric name and the
+// remaining labels are kept as they are. Only payloads that cannot be decoded
+// get a 400, since Prometheus drops the whole request then. Rejected samples
+// are logged and dropped, and duplicates count as written, so that retries of
+// a partially saved request succeed.
+func NewRemoteWriteHandler(log *slog.Logger, cfgPrometheus *config.Prometheus, cfgRemoteWrite *config.RemoteWrite, service *core.MetricService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "snappy" {
+			log.Warn("unsupported remote write encoding", slog.String("encoding", encoding))
+			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		writeRequest, err := decodeWriteRequest(r.Body)
+		if err != nil {
+			log.Error("failed to parse remote write request", slog.String("error", err.Error()))
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		metrics, skipped := toRemoteWriteMetrics(cfgPrometheus, writeRequest)
+		if len(metrics) == 0 {
+			log.Info("remote write request has no samples", slog.Int("skipped", skipped))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		batchSize := cfgRemoteWrite.BatchSize
+		if batchSize <= 0 {
+			batchSize = len(metrics)
+		}
+
+		rejected := 0
+		var firstErr error
+		for start := 0; start < len(metrics); start += batchSize {
+			batch := metrics[start:min(start+batchSize, len(metrics))]
+
+			results, err := service.CreateMetrics(batch)
+			if err != nil {
+				switch {
+				case errors.Is(err, core.ErrWriteQueueFull):
+					log.Warn("write queue is full", slog.String("error", err.Error()))
+					http.Error(w, err.Error(), http.StatusTooManyRequests)
+				case errors.Is(err, core.ErrMetricBatchTooLarge):
+					log.Warn("remote write batch is too large", slog.String("error", err.Error()))
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					log.Error("failed to save remote write batch", slog.String("error", err.Error()))
+					http.Error(w, "internal error", http.StatusInternalServerError)
+				}
+				return
+			}
+
+			for _, result := range results {
+				if result.Err != nil && !errors.Is(result.Err, core.ErrDuplicateMetric) {
+					rejected++
+					if firstErr == nil {
+						firstErr = result.Err
+					}
+				}
+			}
+		}
+
+		// Sending rejected samples again would not change the verdict, so the
+		// request succeeds without them.
+		if rejected > 0 {
+			log.Warn("remote write samples rejected", slog.Int("rejected", rejected), slog.Int("samples", len(metrics)), slog.String("error", firstErr.Error()))
+		}
+
+		log.Info("remote write request successfully processed", slog.Int("samples", len(metrics)), slog.Int("rejected", rejected), slog.Int("skipped", skipped))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func decodeWriteRequest(body io.Reader) (*prompb.WriteRequest, error) {
+	compressed, err := io.ReadAll(io.LimitReader(body, maxRemoteWriteBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(compressed) > maxRemoteWriteBodySize {
+		return nil, fmt.Errorf("body exceeds %d bytes", maxRemoteWriteBodySize)
+	}
+
+	decodedLen, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snappy: %w", err)
+	}
+	if decodedLen > maxRemoteWriteBodySize {
+		return nil, fmt.Errorf("decoded body exceeds %d bytes", maxRemoteWriteBodySize)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode snappy: %w", err)
+	}
+
+	var writeRequest prompb.WriteRequest
+	if err := proto.Unmarshal(data, &writeRequest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal write request: %w", err)
+	}
+	return &writeRequest, nil
+}
+
+// toRemoteWriteMetrics flattens the time series into metrics and reports how
+// many stale markers were skipped.
+func toRemoteWriteMetrics(cfgPrometheus *config.Prometheus, writeRequest *prompb.WriteRequest) ([]core.Metric, int) {
+	families := make(map[string]prompb.MetricMetadata_MetricType, len(writeRequest.GetMetadata()))
+	for _, metadata := range writeRequest.GetMetadata() {
+		families[metadata.GetMetricFamilyName()] = metadata.GetType()
+	}
+
+	skipped := 0
+	metrics := make([]core.Metric, 0)
+	for _, ts := range writeRequest.GetTimeseries() {
+		var serviceURL, metricName, podName string
+		labels := make(core.Labels)
+		for _, label := range ts.GetLabels() {
+			switch label.GetName() {
+			case metricNameLabel:
+				metricName = label.GetValue()
+			case cfgPrometheus.ServiceLabel:
+				serviceURL = label.GetValue()
+			case cfgPrometheus.PodLabel:
+				podName = label.GetValue()
+			default:
+				labels[label.GetName()] = label.GetValue()
+			}
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
+		metricType := remoteWriteType(metricName, labels, families)
+
+		for _, s := range ts.GetSamples() {
+			if math.Float64bits(s.GetValue()) == staleNaN {
+				skipped++
+				continue
+			}
+			metrics = append(metrics, core.Metric{
+				MetricIdentity: core.MetricIdentity{
+					Time:       time.UnixMilli(s.GetTimestamp()).UTC(),
+					ServiceURL: serviceURL,
+					MetricName: metricName,
+					PodName:    podName,
+					Labels:     labels,
+				},
+				Type:        metricType,
+				MetricValue: s.GetValue(),
+			})
+		}
+	}
+	return metrics, skipped
+}
+
+// remoteWriteType uses the metadata sent along with the samples when there is
+// any. Prometheus sends metadata in separate requests, so otherwise the type
+// is guessed from the naming conventions, the same way for every request.
+func remoteWriteType(metricName string, labels core.Labels, families map[string]prompb.MetricMetadata_MetricType) string {
+	if strings.HasSuffix(metricName, "_created") {
+		return core.MetricTypeGauge
+	}
+
+	family, ok := families[metricName]
+	for _, suffix := range []string{"_total", "_bucket", "_sum", "_count"} {
+		if ok {
+			break
+		}
+		family, ok = families[strings.TrimSuffix(metricName, suffix)]
+	}
+	if ok {
+		switch family {
+		case prompb.MetricMetadata_METRIC_TYPE_COUNTER:
+			return core.MetricTypeCounter
+		case prompb.MetricMetadata_METRIC_TYPE_HISTOGRAM:
+			return core.MetricTypeHistogram
+		case prompb.MetricMetadata_METRIC_TYPE_SUMMARY:
+			return core.MetricTypeSummary
+		default:
+			return core.MetricTypeGauge
+		}
+	}
+
+	_, hasLe := labels["le"]
+	switch {
+	case strings.HasSuffix(metricName, "_total"):
+		return core.MetricTypeCounter
+	case strings.HasSuffix(metricName, "_bucket") && hasLe:
+		return core.MetricTypeHistogram
+	default:
+		return core.MetricTypeGauge
+	}
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type RetentionPolicyDTO struct {
+	Name       string     `json:"name"`
+	MetricName string     `json:"metric_name"`
+	Action     string     `json:"action"`
+	Age        string     `json:"age"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Affected   int64      `json:"affected"`
+}
+
+func NewListRetentionPoliciesHandler(log *slog.Logger, service *core.RetentionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policies := service.Policies()
+
+		response := struct {
+			Policies []RetentionPolicyDTO `json:"policies"`
+		}{
+			Policies: make([]RetentionPolicyDTO, 0, len(policies)),
+		}
+		for _, policy := range policies {
+			response.Policies = append(response.Policies, toRetentionPolicyDTO(policy))
+		}
+
+		log.Info("retention policies successfully retrieved", slog.Int("count", len(policies)))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func toRetentionPolicyDTO(policy core.RetentionPolicy) RetentionPolicyDTO {
+	policyDTO := RetentionPolicyDTO{
+		Name:       policy.Name,
+		MetricName: policy.MetricPattern,
+		Action:     policy.Action,
+		Age:        policy.Age.String(),
+		LastError:  policy.LastError,
+		Affected:   policy.Affected,
+	}
+	if !policy.LastRun.IsZero() {
+		policyDTO.LastRun = &policy.LastRun
+	}
+	return policyDTO
+}
@@ -0,0 +1,215 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	maxScrapeBodySize = 10 << 20
+	acceptHeader      = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+
+	upMetricName             = "up"
+	scrapeDurationMetricName = "scrape_duration_seconds"
+)
+
+type Target struct {
+	ServiceURL string
+	URL        string
+	PodName    string
+	Interval   time.Duration
+	Timeout    time.Duration
+}
+
+type Manager struct {
+	log     *slog.Logger
+	targets []Target
+	service *core.MetricService
+	client  *http.Client
+}
+
+func NewManager(log *slog.Logger, cfgScrape *config.Scrape, service *core.MetricService) (*Manager, error) {
+	targets := make([]Target, 0, len(cfgScrape.Targets))
+	for _, cfgTarget := range cfgScrape.Targets {
+		target := Target{
+			ServiceURL: cfgTarget.ServiceURL,
+			URL:        cfgTarget.URL,
+			PodName:    cfgTarget.PodName,
+			Interval:   cfgTarget.Interval,
+			Timeout:    cfgTarget.Timeout,
+		}
+		if target.Interval <= 0 {
+			target.Interval = cfgScrape.DefaultInterval
+		}
+		if target.Timeout <= 0 {
+			target.Timeout = cfgScrape.DefaultTimeout
+		}
+
+		u, err := url.Parse(target.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid scrape target url %q", target.URL)
+		}
+		if target.ServiceURL == "" {
+			target.ServiceURL = u.Host + u.Path
+		}
+		if target.PodName == "" {
+			target.PodName = u.Host
+		}
+		if target.Interval <= 0 || target.Timeout <= 0 {
+			return nil, fmt.Errorf("scrape target %q requires a positive interval and timeout", target.URL)
+		}
+
+		targets = append(targets, target)
+	}
+
+	return &Manager{
+		log:     log,
+		targets: targets,
+		service: service,
+		client:  &http.Client{},
+	}, nil
+}
+
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range m.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.runTarget(ctx, target)
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *Manager) runTarget(ctx context.Context, target Target) {
+	m.log.Info("scrape loop started", slog.String("target", target.URL), slog.Duration("interval", target.Interval))
+
+	ticker := time.NewTicker(target.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.scrape(ctx, target)
+
+		select {
+		case <-ctx.Done():
+			m.log.Info("scrape loop stopped", slog.String("target", target.URL))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) scrape(ctx context.Context, target Target) {
+	start := time.Now().UTC()
+
+	metrics, err := m.fetch(ctx, target, start)
+	if ctx.Err() != nil {
+		return
+	}
+
+	up := 1.0
+	if err != nil {
+		m.log.Warn("scrape failed", slog.String("target", target.URL), slog.String("error", err.Error()))
+		up = 0
+	}
+
+	metrics = append(metrics,
+		m.sample(target, start, upMetricName, nil, core.MetricTypeGauge, up),
+		m.sample(target, start, scrapeDurationMetricName, nil, core.MetricTypeGauge, time.Since(start).Seconds()),
+	)
+
+	results, err := m.service.CreateMetrics(metrics)
+	if err != nil {
+		m.log.Error("failed to store scraped metrics", slog.String("target", target.URL), slog.String("error", err.Error()))
+		return
+	}
+
+	rejected := 0
+	for _, result := range results {
+		if result.Err != nil {
+			rejected++
+		}
+	}
+	m.log.Debug("scrape finished", slog.String("target", target.URL), slog.Int("samples", len(results)), slog.Int("rejected", rejected))
+}
+
+func (m *Manager) fetch(ctx context.Context, target Target, scrapeTime time.Time) ([]core.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, target.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch target: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("target responded with status %d", resp.StatusCode)
+	}
+
+	openMetrics := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/openmetrics-text")
+	samples, err := parse(io.LimitReader(resp.Body, maxScrapeBodySize), openMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target response: %w", err)
+	}
+
+	metrics := make([]core.Metric, 0, len(samples)+2)
+	for _, s := range samples {
+		sampleTime := scrapeTime
+		if !s.timestamp.IsZero() {
+			sampleTime = s.timestamp
+		}
+		metrics = append(metrics, m.sample(target, sampleTime, s.name, s.labels, metricType(s), s.value))
+	}
+	return metrics, nil
+}
+
+func (m *Manager) sample(target Target, scrapeTime time.Time, metricName string, labels core.Labels, metricType string, value float64) core.Metric {
+	return core.Metric{
+		MetricIdentity: core.MetricIdentity{
+			Time:       scrapeTime,
+			ServiceURL: target.ServiceURL,
+			MetricName: metricName,
+			PodName:    target.PodName,
+			Labels:     labels,
+		},
+		Type:        metricType,
+		MetricValue: value,
+	}
+}
+
+// metricType maps exposition families onto stored metric types. Creation
+// timestamps and families without counter semantics are kept as gauges.
+func metricType(s sample) string {
+	if strings.HasSuffix(s.name, "_created") {
+		return core.MetricTypeGauge
+	}
+
+	switch s.family.typ {
+	case typeCounter:
+		return core.MetricTypeCounter
+	case typeHistogram:
+		return core.MetricTypeHistogram
+	case typeSummary:
+		return core.MetricTypeSummary
+	default:
+		return core.MetricTypeGauge
+	}
+}
@@ -0,0 +1,158 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type recordingRepository struct {
+	core.MetricRepository
+	mu      sync.Mutex
+	metrics []core.Metric
+}
+
+func (r *recordingRepository) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]core.MetricResult, 0, len(metrics))
+	for i, metric := range metrics {
+		r.metrics = append(r.metrics, metric)
+		results = append(results, core.MetricResult{Index: i, MetricIdentity: &metric.MetricIdentity})
+	}
+	return results, nil
+}
+
+func (r *recordingRepository) values(metricName string) []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := make([]float64, 0)
+	for _, metric := range r.metrics {
+		if metric.MetricName == metricName {
+			values = append(values, metric.MetricValue)
+		}
+	}
+	return values
+}
+
+func newTestManager(t *testing.T, repo core.MetricRepository, targets ...config.ScrapeTarget) *Manager {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	manager, err := NewManager(log, &config.Scrape{
+		DefaultInterval: time.Hour,
+		DefaultTimeout:  100 * time.Millisecond,
+		Targets:         targets,
+	}, core.NewMetricService(log, repo, nil, core.IngestionWindow{}))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	return manager
+}
+
+func TestScrapeStoresSamplesAndUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "# HELP system_cpu_usage CPU usage")
+		fmt.Fprintln(w, "system_cpu_usage 0.42")
+		fmt.Fprintln(w, "orders_total 17")
+		fmt.Fprintln(w, `http_requests_total{method="post",code="200"} 3`)
+	}))
+	defer server.Close()
+
+	repo := &recordingRepository{}
+	manager := newTestManager(t, repo, config.ScrapeTarget{ServiceURL: "test-service-go/metrics", URL: server.URL, PodName: "pod-1"})
+	manager.scrape(context.Background(), manager.targets[0])
+
+	if values := repo.values("system_cpu_usage"); len(values) != 1 || values[0] != 0.42 {
+		t.Fatalf("unexpected system_cpu_usage values: %v", values)
+	}
+	if values := repo.values("orders_total"); len(values) != 1 || values[0] != 17 {
+		t.Fatalf("unexpected orders_total values: %v", values)
+	}
+	if values := repo.values("http_requests_total"); len(values) != 1 || values[0] != 3 {
+		t.Fatalf("unexpected http_requests_total values: %v", values)
+	}
+	if values := repo.values(upMetricName); len(values) != 1 || values[0] != 1 {
+		t.Fatalf("unexpected up values: %v", values)
+	}
+	if values := repo.values(scrapeDurationMetricName); len(values) != 1 || values[0] <= 0 {
+		t.Fatalf("unexpected scrape duration values: %v", values)
+	}
+	for _, metric := range repo.metrics {
+		if metric.ServiceURL != "test-service-go/metrics" || metric.PodName != "pod-1" {
+			t.Fatalf("unexpected series identity: %+v", metric.MetricIdentity)
+		}
+		if metric.MetricName == "http_requests_total" && (metric.Labels["method"] != "post" || metric.Labels["code"] != "200") {
+			t.Fatalf("unexpected labels: %v", metric.Labels)
+		}
+	}
+}
+
+func TestScrapeRecordsDownTargets(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	for _, target := range []config.ScrapeTarget{{URL: failing.URL}, {URL: slow.URL, Timeout: 20 * time.Millisecond}} {
+		repo := &recordingRepository{}
+		manager := newTestManager(t, repo, target)
+		manager.scrape(context.Background(), manager.targets[0])
+
+		if values := repo.values(upMetricName); len(values) != 1 || values[0] != 0 {
+			t.Fatalf("expected up=0 for %s, got %v", target.URL, values)
+		}
+	}
+}
+
+func TestRunScrapesEachTargetUntilShutdown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "system_cpu_usage 0.1")
+	}))
+	defer server.Close()
+
+	repo := &recordingRepository{}
+	manager := newTestManager(t, repo,
+		config.ScrapeTarget{URL: server.URL + "/fast", Interval: 10 * time.Millisecond},
+		config.ScrapeTarget{URL: server.URL + "/slow"},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	manager.Run(ctx)
+
+	fast, slow := 0, 0
+	for _, metric := range repo.metrics {
+		if metric.MetricName != upMetricName {
+			continue
+		}
+		switch metric.ServiceURL {
+		case manager.targets[0].ServiceURL:
+			fast++
+		case manager.targets[1].ServiceURL:
+			slow++
+		}
+	}
+	if fast < 3 || slow != 1 {
+		t.Fatalf("expected targets to follow their own intervals, got fast=%d slow=%d", fast, slow)
+	}
+}
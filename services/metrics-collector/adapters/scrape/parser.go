@@ -0,0 +1,339 @@
+package scrape
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+	typeUntyped   = "untyped"
+	typeUnknown   = "unknown"
+)
+
+type family struct {
+	name string
+	typ  string
+	help string
+	unit string
+}
+
+type sample struct {
+	family    *family
+	name      string
+	labels    core.Labels
+	value     float64
+	timestamp time.Time
+}
+
+// series is the label-preserving name of the sample, for example
+// http_requests_total{code="200",method="get"}.
+func (s sample) series() string {
+	return s.name + s.labels.String()
+}
+
+type parser struct {
+	openMetrics bool
+	families    map[string]*family
+	lineNumber  int
+}
+
+// parse reads the Prometheus text exposition format, or OpenMetrics when
+// openMetrics is set. When a series appears more than once the last sample
+// wins, since samples without timestamps share the scrape time.
+func parse(r io.Reader, openMetrics bool) ([]sample, error) {
+	p := parser{
+		openMetrics: openMetrics,
+		families:    make(map[string]*family),
+	}
+
+	samples := make([]sample, 0)
+	indexes := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		p.lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			eof, err := p.parseComment(line)
+			if err != nil {
+				return nil, err
+			}
+			if eof {
+				break
+			}
+			continue
+		}
+
+		s, err := p.parseSample(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if i, ok := indexes[s.series()]; ok {
+			samples[i] = s
+			continue
+		}
+		indexes[s.series()] = len(samples)
+		samples = append(samples, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func (p *parser) parseComment(line string) (bool, error) {
+	fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "#")), " ", 3)
+	if p.openMetrics && len(fields) == 1 && fields[0] == "EOF" {
+		return true, nil
+	}
+	if len(fields) < 2 {
+		return false, nil
+	}
+
+	keyword, name := fields[0], fields[1]
+	rest := ""
+	if len(fields) == 3 {
+		rest = strings.TrimSpace(fields[2])
+	}
+
+	switch keyword {
+	case "HELP":
+		p.family(name).help = unescapeHelp(rest)
+	case "TYPE":
+		switch rest {
+		case typeCounter, typeGauge, typeHistogram, typeSummary, typeUntyped, typeUnknown, "gaugehistogram", "stateset", "info":
+			p.family(name).typ = rest
+		default:
+			return false, p.errorf("unknown metric type %q", rest)
+		}
+	case "UNIT":
+		p.family(name).unit = rest
+	}
+	return false, nil
+}
+
+func (p *parser) parseSample(line string) (sample, error) {
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return sample{}, p.errorf("expected metric name")
+	}
+	s := sample{name: line[:nameEnd], labels: core.Labels{}}
+	if !isValidMetricName(s.name) {
+		return sample{}, p.errorf("invalid metric name %q", s.name)
+	}
+
+	rest := line[nameEnd:]
+	if strings.HasPrefix(rest, "{") {
+		labels, remaining, err := p.parseLabels(rest[1:])
+		if err != nil {
+			return sample{}, err
+		}
+		s.labels = labels
+		rest = remaining
+	}
+
+	// OpenMetrics exemplars follow the value after " # ". Label values may
+	// contain the separator too, so it is looked for past the label set.
+	if p.openMetrics {
+		if i := strings.Index(rest, " # "); i >= 0 {
+			rest = rest[:i]
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample{}, p.errorf("expected value and optional timestamp")
+	}
+
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return sample{}, p.errorf("invalid value %q", fields[0])
+	}
+	s.value = value
+
+	if len(fields) == 2 {
+		timestamp, err := p.parseTimestamp(fields[1])
+		if err != nil {
+			return sample{}, err
+		}
+		s.timestamp = timestamp
+	}
+
+	s.family = p.familyOf(s.name)
+	return s, nil
+}
+
+func (p *parser) parseLabels(input string) (core.Labels, string, error) {
+	labels := core.Labels{}
+	for {
+		input = strings.TrimLeft(input, " \t")
+		if strings.HasPrefix(input, "}") {
+			return labels, input[1:], nil
+		}
+
+		eq := strings.IndexByte(input, '=')
+		if eq <= 0 {
+			return nil, "", p.errorf("expected label name")
+		}
+		name := strings.TrimSpace(input[:eq])
+		if !isValidLabelName(name) {
+			return nil, "", p.errorf("invalid label name %q", name)
+		}
+
+		input = strings.TrimLeft(input[eq+1:], " \t")
+		if !strings.HasPrefix(input, `"`) {
+			return nil, "", p.errorf("expected quoted value for label %q", name)
+		}
+
+		value, remaining, err := unquoteLabelValue(input[1:])
+		if err != nil {
+			return nil, "", p.errorf("label %q: %s", name, err)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, "", p.errorf("duplicate label %q", name)
+		}
+		labels[name] = value
+
+		input = strings.TrimLeft(remaining, " \t")
+		switch {
+		case strings.HasPrefix(input, ","):
+			input = input[1:]
+		case strings.HasPrefix(input, "}"):
+		default:
+			return nil, "", p.errorf("expected ',' or '}' after label %q", name)
+		}
+	}
+}
+
+func (p *parser) parseTimestamp(field string) (time.Time, error) {
+	if p.openMetrics {
+		seconds, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return time.Time{}, p.errorf("invalid timestamp %q", field)
+		}
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+	}
+
+	millis, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		return time.Time{}, p.errorf("invalid timestamp %q", field)
+	}
+	return time.UnixMilli(millis).UTC(), nil
+}
+
+func (p *parser) family(name string) *family {
+	f, ok := p.families[name]
+	if !ok {
+		f = &family{name: name, typ: typeUnknown}
+		p.families[name] = f
+	}
+	return f
+}
+
+// familyOf resolves the metric family of a sample, following the suffixes
+// histograms, summaries and OpenMetrics counters add to the family name.
+func (p *parser) familyOf(name string) *family {
+	if f, ok := p.families[name]; ok {
+		return f
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total", "_created", "_gsum", "_gcount", "_info"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		if f, ok := p.families[base]; ok {
+			return f
+		}
+	}
+
+	return p.family(name)
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.lineNumber, fmt.Sprintf(format, args...))
+}
+
+func parseValue(field string) (float64, error) {
+	switch field {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(field, 64)
+}
+
+func unquoteLabelValue(input string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; c {
+		case '"':
+			return b.String(), input[i+1:], nil
+		case '\\':
+			i++
+			if i == len(input) {
+				return "", "", errors.New("unterminated escape sequence")
+			}
+			switch input[i] {
+			case '\\':
+				b.WriteByte('\\')
+			case '"':
+				b.WriteByte('"')
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				return "", "", fmt.Errorf("invalid escape sequence \\%c", input[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated label value")
+}
+
+func unescapeHelp(help string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(help)
+}
+
+func isValidMetricName(name string) bool {
+	for i, c := range name {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return name != ""
+}
+
+func isValidLabelName(name string) bool {
+	for i, c := range name {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return name != ""
+}
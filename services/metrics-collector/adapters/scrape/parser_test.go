@@ -0,0 +1,148 @@
+package scrape
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const prometheusText = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# Escaping in label values:
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+
+# TYPE process_cpu_usage gauge
+process_cpu_usage 0.25
+
+# HELP http_request_duration_seconds A histogram of the request duration.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+`
+
+const openMetricsText = `# TYPE acme_http_router_request_seconds summary
+# UNIT acme_http_router_request_seconds seconds
+acme_http_router_request_seconds_sum{path="/api/v1",method="GET"} 9036.32 1520879607.789
+acme_http_router_request_seconds_count{path="/api/v1",method="GET"} 807283.0
+# TYPE go_goroutines gauge
+go_goroutines 69
+# TYPE orders counter
+orders_total{kind="web"} 17 # {trace_id="abc"} 1.0 1520879607.7
+orders_total{kind="a # b"} 3 # {trace_id="def"} 1.0
+# EOF
+ignored_after_eof 1
+`
+
+func samplesBySeries(t *testing.T, input string, openMetrics bool) map[string]sample {
+	t.Helper()
+
+	samples, err := parse(strings.NewReader(input), openMetrics)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	bySeries := make(map[string]sample, len(samples))
+	for _, s := range samples {
+		bySeries[s.series()] = s
+	}
+	return bySeries
+}
+
+func TestParsePrometheusText(t *testing.T) {
+	samples := samplesBySeries(t, prometheusText, false)
+	if len(samples) != 11 {
+		t.Fatalf("expected 11 samples, got %d", len(samples))
+	}
+
+	counter := samples[`http_requests_total{code="200",method="post"}`]
+	if counter.value != 1027 || counter.family.typ != typeCounter || !counter.timestamp.Equal(time.UnixMilli(1395066363000)) {
+		t.Fatalf("unexpected counter sample: %+v", counter)
+	}
+	if counter.family.help != "The total number of HTTP requests." {
+		t.Fatalf("unexpected help: %q", counter.family.help)
+	}
+
+	escaped := samples[`msdos_file_access_time_seconds{error="Cannot find file:\n\"FILE.TXT\"",path="C:\\DIR\\FILE.TXT"}`]
+	if escaped.labels["path"] != `C:\DIR\FILE.TXT` || escaped.labels["error"] != "Cannot find file:\n\"FILE.TXT\"" {
+		t.Fatalf("unexpected escaped labels: %+v", escaped.labels)
+	}
+
+	if gauge := samples["process_cpu_usage"]; gauge.value != 0.25 || gauge.family.typ != typeGauge || !gauge.timestamp.IsZero() {
+		t.Fatalf("unexpected gauge sample: %+v", gauge)
+	}
+
+	bucket := samples[`http_request_duration_seconds_bucket{le="+Inf"}`]
+	if bucket.value != 144320 || bucket.family.name != "http_request_duration_seconds" || bucket.family.typ != typeHistogram {
+		t.Fatalf("unexpected histogram bucket: %+v", bucket)
+	}
+	if count := samples["http_request_duration_seconds_count"]; count.family.typ != typeHistogram {
+		t.Fatalf("histogram count should belong to the histogram family: %+v", count)
+	}
+
+	quantile := samples[`rpc_duration_seconds{quantile="0.5"}`]
+	if quantile.value != 4773 || quantile.family.typ != typeSummary {
+		t.Fatalf("unexpected summary quantile: %+v", quantile)
+	}
+	if sum := samples["rpc_duration_seconds_sum"]; sum.value != 1.7560473e+07 || sum.family.typ != typeSummary {
+		t.Fatalf("unexpected summary sum: %+v", sum)
+	}
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	samples := samplesBySeries(t, openMetricsText, true)
+	if len(samples) != 5 {
+		t.Fatalf("expected 5 samples, got %d", len(samples))
+	}
+
+	sum := samples[`acme_http_router_request_seconds_sum{method="GET",path="/api/v1"}`]
+	if sum.family.typ != typeSummary || sum.family.unit != "seconds" {
+		t.Fatalf("unexpected summary family: %+v", sum.family)
+	}
+	if want := time.Unix(1520879607, 789000000); math.Abs(float64(sum.timestamp.Sub(want))) > float64(time.Microsecond) {
+		t.Fatalf("unexpected timestamp: %v", sum.timestamp)
+	}
+
+	counter := samples[`orders_total{kind="web"}`]
+	if counter.value != 17 || counter.family.typ != typeCounter || !counter.timestamp.IsZero() {
+		t.Fatalf("exemplar should be ignored and counter resolved: %+v", counter)
+	}
+	if quoted := samples[`orders_total{kind="a # b"}`]; quoted.value != 3 {
+		t.Fatalf("a separator inside a label value must not cut the sample: %+v", quoted)
+	}
+}
+
+func TestParseSpecialValues(t *testing.T) {
+	samples := samplesBySeries(t, "a +Inf\nb -Inf\nc NaN\n", false)
+
+	if !math.IsInf(samples["a"].value, 1) || !math.IsInf(samples["b"].value, -1) || !math.IsNaN(samples["c"].value) {
+		t.Fatalf("unexpected special values: %+v", samples)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"metric",
+		"metric abc",
+		`metric{label="value} 1`,
+		`metric{label=value} 1`,
+		`metric{label="a",label="b"} 1`,
+		`metric{0label="a"} 1`,
+		"metric 1 notatimestamp",
+		"# TYPE metric nonsense",
+	} {
+		if _, err := parse(strings.NewReader(input), false); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type series struct {
+	identity core.SeriesIdentity
+	kind     string
+	updated  bool
+	// idle counts the flushes since the series was last updated.
+	idle int
+	// value is the running total of counters and the level of gauges.
+	value float64
+	// Timers keep a uniform sample of the values of the interval, of which
+	// seen were observed, and a running sum and count.
+	values []float64
+	seen   int
+	sum    float64
+	count  float64
+	// members holds the set members of the interval.
+	members map[string]struct{}
+}
+
+// aggregator folds samples into series between flushes. Counters become
+// running totals, so that they can be stored as counters, gauges keep their
+// level, timers are summarized as quantiles over the interval with a running
+// sum and count, and sets count their distinct members per interval. Only
+// series updated since the last flush are written; idle timers and sets are
+// dropped, since they carry nothing over to the next interval but the running
+// sum and count, which then start over like a restarted counter. Counters and
+// gauges are dropped once idle for IdleIntervals flushes, so that short-lived
+// senders do not pile up; a counter that comes back starts over as a reset.
+type aggregator struct {
+	cfg    *config.StatsD
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newAggregator(cfgStatsD *config.StatsD) *aggregator {
+	return &aggregator{cfg: cfgStatsD, series: make(map[string]*series)}
+}
+
+func (a *aggregator) observe(sender string, s sample) error {
+	identity := core.SeriesIdentity{ServiceURL: a.cfg.ServiceURL, MetricName: s.name, PodName: sender}
+	for name, value := range s.tags {
+		switch name {
+		case a.cfg.ServiceTag:
+			identity.ServiceURL = value
+		case a.cfg.PodTag:
+			identity.PodName = value
+		default:
+			if identity.Labels == nil {
+				identity.Labels = make(core.Labels, len(s.tags))
+			}
+			identity.Labels[name] = value
+		}
+	}
+	kind := s.kind
+	if kind == typeHistogram || kind == typeDistribution {
+		kind = typeTimer
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := identity.Key()
+	ser, ok := a.series[key]
+	if !ok {
+		ser = &series{identity: identity, kind: kind}
+		a.series[key] = ser
+	}
+	if ser.kind != kind {
+		return fmt.Errorf("metric %q is a %q, got a %q", s.name, ser.kind, s.kind)
+	}
+
+	ser.updated = true
+	switch kind {
+	case typeCounter:
+		ser.value += s.value / s.rate
+	case typeGauge:
+		if s.relative {
+			ser.value += s.value
+		} else {
+			ser.value = s.value
+		}
+	case typeTimer:
+		// Reservoir sampling keeps every value with the same probability.
+		ser.seen++
+		if len(ser.values) < a.cfg.TimerSamples {
+			ser.values = append(ser.values, s.value)
+		} else if i := rand.IntN(ser.seen); i < len(ser.values) {
+			ser.values[i] = s.value
+		}
+		ser.sum += s.value / s.rate
+		ser.count += 1 / s.rate
+	case typeSet:
+		if ser.members == nil {
+			ser.members = make(map[string]struct{})
+		}
+		ser.members[s.member] = struct{}{}
+	}
+	return nil
+}
+
+// flush returns the series updated since the last flush as metrics at t.
+func (a *aggregator) flush(t time.Time) []core.Metric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	metrics := make([]core.Metric, 0)
+	add := func(identity core.SeriesIdentity, metricName, metricType string, labels core.Labels, value float64) {
+		metrics = append(metrics, core.Metric{
+			MetricIdentity: core.MetricIdentity{
+				Time:       t,
+				ServiceURL: identity.ServiceURL,
+				MetricName: metricName,
+				PodName:    identity.PodName,
+				Labels:     labels,
+			},
+			Type:        metricType,
+			MetricValue: value,
+		})
+	}
+
+	for key, ser := range a.series {
+		if !ser.updated {
+			ser.idle++
+			if ser.kind == typeTimer || ser.kind == typeSet || ser.idle >= a.cfg.IdleIntervals {
+				delete(a.series, key)
+			}
+			continue
+		}
+		ser.updated = false
+		ser.idle = 0
+
+		identity := ser.identity
+		switch ser.kind {
+		case typeCounter:
+			add(identity, identity.MetricName, core.MetricTypeCounter, identity.Labels, ser.value)
+		case typeGauge:
+			add(identity, identity.MetricName, core.MetricTypeGauge, identity.Labels, ser.value)
+		case typeTimer:
+			slices.Sort(ser.values)
+			for _, q := range a.cfg.Quantiles {
+				labels := make(core.Labels, len(identity.Labels)+1)
+				for name, value := range identity.Labels {
+					labels[name] = value
+				}
+				labels["quantile"] = strconv.FormatFloat(q, 'f', -1, 64)
+				add(identity, identity.MetricName, core.MetricTypeSummary, labels, quantile(q, ser.values))
+			}
+			add(identity, identity.MetricName+"_sum", core.MetricTypeSummary, identity.Labels, ser.sum)
+			add(identity, identity.MetricName+"_count", core.MetricTypeSummary, identity.Labels, ser.count)
+			ser.values = ser.values[:0]
+			ser.seen = 0
+		case typeSet:
+			add(identity, identity.MetricName, core.MetricTypeGauge, identity.Labels, float64(len(ser.members)))
+			ser.members = nil
+		}
+	}
+	return metrics
+}
+
+// quantile returns the nearest-rank quantile of sorted values, as StatsD does
+// for its percentile thresholds.
+func quantile(q float64, sorted []float64) float64 {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
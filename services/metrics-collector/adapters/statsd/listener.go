@@ -0,0 +1,156 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const maxPacketSize = 65535
+
+type Listener struct {
+	log           *slog.Logger
+	flushInterval time.Duration
+	service       *core.MetricService
+	conn          *net.UDPConn
+	aggregator    *aggregator
+}
+
+// NewListener binds the UDP socket right away, so that a taken port fails at
+// startup.
+func NewListener(log *slog.Logger, cfgStatsD *config.StatsD, service *core.MetricService) (*Listener, error) {
+	if cfgStatsD.FlushInterval <= 0 {
+		return nil, fmt.Errorf("statsd flush interval must be positive, got %s", cfgStatsD.FlushInterval)
+	}
+	if cfgStatsD.TimerSamples <= 0 {
+		return nil, fmt.Errorf("statsd timer samples must be positive, got %d", cfgStatsD.TimerSamples)
+	}
+	if cfgStatsD.IdleIntervals <= 0 {
+		return nil, fmt.Errorf("statsd idle intervals must be positive, got %d", cfgStatsD.IdleIntervals)
+	}
+	for _, q := range cfgStatsD.Quantiles {
+		if q < 0 || q > 1 {
+			return nil, fmt.Errorf("statsd quantile %v is out of [0, 1]", q)
+		}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", cfgStatsD.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statsd address %q: %w", cfgStatsD.Address, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen statsd: %w", err)
+	}
+
+	return &Listener{
+		log:           log,
+		flushInterval: cfgStatsD.FlushInterval,
+		service:       service,
+		conn:          conn,
+		aggregator:    newAggregator(cfgStatsD),
+	}, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Run reads packets and flushes the aggregated series every flush interval
+// until ctx is done. It then closes the socket and flushes once more, so that
+// nothing received is lost on shutdown.
+func (l *Listener) Run(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.read()
+	}()
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := l.conn.Close(); err != nil {
+				l.log.Warn("failed to close statsd socket", slog.String("error", err.Error()))
+			}
+			<-done
+			l.flush()
+			return
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+func (l *Listener) read() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.log.Warn("failed to read statsd packet", slog.String("error", err.Error()))
+			continue
+		}
+		l.handlePacket(string(buf[:n]), addr.IP.String())
+	}
+}
+
+func (l *Listener) handlePacket(packet, sender string) {
+	invalid := 0
+	var firstErr error
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		samples, err := parseLine(line)
+		for _, s := range samples {
+			if err = l.aggregator.observe(sender, s); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			invalid++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%q: %w", line, err)
+			}
+		}
+	}
+
+	if invalid > 0 {
+		l.log.Warn("invalid statsd lines dropped", slog.String("sender", sender), slog.Int("lines", invalid), slog.String("error", firstErr.Error()))
+	}
+}
+
+func (l *Listener) flush() {
+	metrics := l.aggregator.flush(time.Now().UTC())
+	if len(metrics) == 0 {
+		return
+	}
+
+	results, err := l.service.CreateMetrics(metrics)
+	if err != nil {
+		l.log.Error("failed to store statsd metrics", slog.String("error", err.Error()))
+		return
+	}
+
+	rejected := 0
+	for _, result := range results {
+		if result.Err != nil {
+			rejected++
+		}
+	}
+	l.log.Debug("statsd flush finished", slog.Int("samples", len(results)), slog.Int("rejected", rejected))
+}
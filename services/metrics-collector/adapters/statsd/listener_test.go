@@ -0,0 +1,194 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var cfg = &config.StatsD{
+	Address:       "127.0.0.1:0",
+	FlushInterval: time.Hour,
+	ServiceTag:    "service",
+	PodTag:        "host",
+	ServiceURL:    "statsd",
+	Quantiles:     []float64{0.5, 0.9},
+	TimerSamples:  100,
+	IdleIntervals: 2,
+}
+
+func metricsByName(metrics []core.Metric) map[string]core.Metric {
+	result := make(map[string]core.Metric, len(metrics))
+	for _, metric := range metrics {
+		result[metric.MetricName+metric.Labels.String()] = metric
+	}
+	return result
+}
+
+func observeLines(t *testing.T, a *aggregator, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		samples, err := parseLine(line)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", line, err)
+		}
+		for _, s := range samples {
+			if err := a.observe("10.0.0.1", s); err != nil {
+				t.Fatalf("%q: unexpected error: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	a := newAggregator(cfg)
+	observeLines(t, a,
+		"requests:1|c|#service:checkout,host:pod-1",
+		"requests:1|c|@0.5|#service:checkout,host:pod-1",
+		"queue:10|g",
+		"queue:-3|g",
+		"latency:1:2:3:4|ms",
+		"latency:10|ms|@0.5",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s",
+	)
+
+	metrics := metricsByName(a.flush(time.Now()))
+	requests := metrics["requests"]
+	if requests.Type != core.MetricTypeCounter || requests.MetricValue != 3 || requests.ServiceURL != "checkout" || requests.PodName != "pod-1" {
+		t.Fatalf("unexpected counter %+v", requests)
+	}
+	queue := metrics["queue"]
+	if queue.MetricValue != 7 || queue.ServiceURL != "statsd" || queue.PodName != "10.0.0.1" {
+		t.Fatalf("unexpected gauge %+v", queue)
+	}
+	if median := metrics[`latency{quantile="0.5"}`]; median.Type != core.MetricTypeSummary || median.MetricValue != 3 {
+		t.Fatalf("unexpected median %+v", median)
+	}
+	if p90 := metrics[`latency{quantile="0.9"}`]; p90.MetricValue != 10 {
+		t.Fatalf("unexpected p90 %+v", p90)
+	}
+	if sum, count := metrics["latency_sum"], metrics["latency_count"]; sum.MetricValue != 30 || count.MetricValue != 6 {
+		t.Fatalf("unexpected sum %v and count %v", sum.MetricValue, count.MetricValue)
+	}
+	if users := metrics["users"]; users.MetricValue != 2 {
+		t.Fatalf("unexpected set size %+v", users)
+	}
+
+	// Counters keep counting across flushes and idle series are not written.
+	observeLines(t, a, "requests:5|c|#service:checkout,host:pod-1")
+	metrics = metricsByName(a.flush(time.Now()))
+	if len(metrics) != 1 || metrics["requests"].MetricValue != 8 {
+		t.Fatalf("expected only the running counter total, got %+v", metrics)
+	}
+
+	samples, _ := parseLine("requests:1|g|#service:checkout,host:pod-1")
+	if err := a.observe("10.0.0.1", samples[0]); err == nil {
+		t.Fatal("a type change should be rejected")
+	}
+}
+
+func TestAggregatorCapsTimersAndDropsIdleSeries(t *testing.T) {
+	a := newAggregator(cfg)
+	for i := range 1000 {
+		observeLines(t, a, fmt.Sprintf("latency:%d|ms", i), "users:alice|s", "requests:1|c")
+	}
+	key := core.SeriesIdentity{ServiceURL: "statsd", MetricName: "latency", PodName: "10.0.0.1"}.Key()
+	if got := len(a.series[key].values); got != cfg.TimerSamples {
+		t.Fatalf("expected the timer to keep %d values, got %d", cfg.TimerSamples, got)
+	}
+
+	metrics := metricsByName(a.flush(time.Now()))
+	if sum, count := metrics["latency_sum"], metrics["latency_count"]; sum.MetricValue != 999*1000/2 || count.MetricValue != 1000 {
+		t.Fatalf("sum and count must take every value, got %v and %v", sum.MetricValue, count.MetricValue)
+	}
+
+	// Nothing was observed in this interval: the timer and the set are
+	// dropped, the counter keeps its total.
+	a.flush(time.Now())
+	if len(a.series) != 1 {
+		t.Fatalf("expected only the counter to be kept, got %d series", len(a.series))
+	}
+	observeLines(t, a, "requests:1|c")
+	if metrics := metricsByName(a.flush(time.Now())); metrics["requests"].MetricValue != 1001 {
+		t.Fatalf("expected the counter total to survive, got %+v", metrics["requests"])
+	}
+
+	// Counters and gauges are dropped after IdleIntervals idle flushes, and a
+	// counter that comes back starts over.
+	observeLines(t, a, "queue:5|g")
+	a.flush(time.Now())
+	for range cfg.IdleIntervals {
+		a.flush(time.Now())
+	}
+	if len(a.series) != 0 {
+		t.Fatalf("expected idle counters and gauges to be dropped, got %d series", len(a.series))
+	}
+	observeLines(t, a, "requests:1|c")
+	if metrics := metricsByName(a.flush(time.Now())); metrics["requests"].MetricValue != 1 {
+		t.Fatalf("expected the counter to start over, got %+v", metrics["requests"])
+	}
+}
+
+func TestListenerFlushesOnShutdown(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storage, err := memory.New(log, core.ConflictPolicyReject)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	listener, err := NewListener(log, cfg, core.NewMetricService(log, storage, nil, core.IngestionWindow{}))
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listener.Run(ctx)
+	}()
+
+	conn, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial listener: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("jobs.done:4|c|#service:batch,host:worker-1\ninvalid\n")); err != nil {
+		t.Fatalf("failed to send packet: %v", err)
+	}
+
+	query := core.SeriesQuery{MetricName: "jobs_done", Start: time.Now().Add(-time.Minute), End: time.Now().Add(time.Minute)}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listener.aggregator.mu.Lock()
+		received := len(listener.aggregator.series) > 0
+		listener.aggregator.mu.Unlock()
+		if received {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("packet was not received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	series, err := storage.FindSeries(query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(series) != 1 || series[0].ServiceURL != "batch" || series[0].PodName != "worker-1" {
+		t.Fatalf("expected the counter to be flushed on shutdown, got %+v", series)
+	}
+}
@@ -0,0 +1,130 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	typeCounter = "c"
+	typeGauge   = "g"
+	typeTimer   = "ms"
+	typeSet     = "s"
+	// DogStatsD histograms and distributions aggregate like timers.
+	typeHistogram    = "h"
+	typeDistribution = "d"
+)
+
+type sample struct {
+	name string
+	kind string
+	// value holds numbers, member the raw value of sets.
+	value  float64
+	member string
+	// relative marks gauge values with an explicit sign, which adjust the
+	// gauge instead of setting it.
+	relative bool
+	rate     float64
+	tags     core.Labels
+}
+
+// parseLine parses a line of the StatsD format with the DogStatsD extensions:
+//
+//	<name>:<value>[:<value>...]|<type>[|@<sample rate>][|#<tag>[:<value>],...]
+//
+// Several values in one line share the type, the sample rate and the tags.
+// Unknown sections, such as DogStatsD container IDs and timestamps, are
+// ignored.
+func parseLine(line string) ([]sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return nil, errors.New("missing metric name")
+	}
+
+	sections := strings.Split(rest, "|")
+	if len(sections) < 2 {
+		return nil, errors.New("missing metric type")
+	}
+
+	template := sample{name: sanitizeName(name), kind: sections[1], rate: 1}
+	switch template.kind {
+	case typeCounter, typeGauge, typeTimer, typeSet, typeHistogram, typeDistribution:
+	default:
+		return nil, fmt.Errorf("unsupported metric type %q", template.kind)
+	}
+
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			rate, err := strconv.ParseFloat(section[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 || math.IsNaN(rate) {
+				return nil, fmt.Errorf("invalid sample rate %q", section[1:])
+			}
+			template.rate = rate
+		case strings.HasPrefix(section, "#"):
+			template.tags = parseTags(section[1:])
+		}
+	}
+
+	values := strings.Split(sections[0], ":")
+	samples := make([]sample, 0, len(values))
+	for _, value := range values {
+		s := template
+		if s.kind == typeSet {
+			if value == "" {
+				return nil, errors.New("empty set member")
+			}
+			s.member = value
+			samples = append(samples, s)
+			continue
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid value %q", value)
+		}
+		if s.kind == typeCounter && v < 0 {
+			return nil, fmt.Errorf("negative counter increment %q", value)
+		}
+		s.value = v
+		s.relative = s.kind == typeGauge && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-"))
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// parseTags reads DogStatsD tags. Tags without a value are skipped, since
+// labels need one.
+func parseTags(input string) core.Labels {
+	tags := make(core.Labels)
+	for _, tag := range strings.Split(input, ",") {
+		name, value, ok := strings.Cut(tag, ":")
+		if !ok || name == "" || value == "" {
+			continue
+		}
+		tags[sanitizeName(name)] = value
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// sanitizeName turns dotted StatsD names into valid label and metric names.
+func sanitizeName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
@@ -0,0 +1,56 @@
+package statsd
+
+import (
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	samples, err := parseLine("api.requests:2|c|@0.5|#env:prod,route:/orders,canary")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("expected one sample, got %d", len(samples))
+	}
+	s := samples[0]
+	if s.name != "api_requests" || s.kind != typeCounter || s.value != 2 || s.rate != 0.5 {
+		t.Fatalf("unexpected sample %+v", s)
+	}
+	if len(s.tags) != 2 || s.tags["env"] != "prod" || s.tags["route"] != "/orders" {
+		t.Fatalf("tags without value should be skipped, got %v", s.tags)
+	}
+
+	samples, err = parseLine("latency:10:20:30|ms|T1735689600|c:abc")
+	if err != nil || len(samples) != 3 || samples[2].value != 30 {
+		t.Fatalf("expected three timer values, got %+v (err=%v)", samples, err)
+	}
+
+	samples, err = parseLine("queue:-3|g")
+	if err != nil || !samples[0].relative || samples[0].value != -3 {
+		t.Fatalf("signed gauge values should be relative, got %+v (err=%v)", samples, err)
+	}
+
+	samples, err = parseLine("users:alice|s")
+	if err != nil || samples[0].member != "alice" {
+		t.Fatalf("unexpected set sample %+v (err=%v)", samples, err)
+	}
+}
+
+func TestParseLineRejectsInvalidLines(t *testing.T) {
+	for _, line := range []string{
+		"no_value",
+		":1|c",
+		"name:1",
+		"name:1|x",
+		"name:abc|c",
+		"name:-1|c",
+		"name:1|c|@0",
+		"name:1|c|@2",
+		"name:NaN|g",
+		"name:|s",
+	} {
+		if _, err := parseLine(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
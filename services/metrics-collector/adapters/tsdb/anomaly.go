@@ -0,0 +1,156 @@
+package tsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// Acknowledgements are rare and small, so they are kept in a single file that
+// is rewritten on every change.
+const acksFile = "acks.json"
+
+func (s *Storage) FindAnomalies(anomalyQuery core.AnomalyQuery) ([]core.Anomaly, error) {
+	minT, maxT := storedTime(anomalyQuery.Start).UnixMicro(), storedTime(anomalyQuery.End).UnixMicro()
+	context := anomalyQuery.Context.Microseconds()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	anomalies := make([]core.Anomaly, 0)
+	for _, entry := range s.series {
+		if (anomalyQuery.ServiceURL != "" && entry.identity.ServiceURL != anomalyQuery.ServiceURL) ||
+			(anomalyQuery.MetricName != "" && entry.identity.MetricName != anomalyQuery.MetricName) ||
+			(anomalyQuery.PodName != "" && entry.identity.PodName != anomalyQuery.PodName) {
+			continue
+		}
+
+		samples, err := s.samples(entry, minT-context, maxT+context)
+		if err != nil {
+			s.log.Error("failed to fetch anomalies", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to fetch anomalies: %w", err)
+		}
+
+		for _, smp := range samples {
+			if !smp.isAnomaly || smp.t < minT || smp.t > maxT {
+				continue
+			}
+
+			anomaly := core.Anomaly{
+				Metric: core.Metric{
+					MetricIdentity: metricIdentityOf(entry.identity, smp.t),
+					MetricValue:    smp.v,
+					IsAnomaly:      true,
+				},
+			}
+			if ack, ok := s.acks[sampleKey(entry.identity.Key(), smp.t)]; ok {
+				anomaly.Ack = &ack
+			}
+			for _, point := range samples {
+				if point.t >= smp.t-context && point.t <= smp.t+context {
+					anomaly.Context = append(anomaly.Context, core.Point{Time: time.UnixMicro(point.t).UTC(), Value: point.v})
+				}
+			}
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	slices.SortFunc(anomalies, func(a, b core.Anomaly) int {
+		return b.Time.Compare(a.Time)
+	})
+	if len(anomalies) > anomalyQuery.Limit {
+		anomalies = anomalies[:anomalyQuery.Limit]
+	}
+
+	s.log.Info("anomalies fetched successfully", slog.Int("count", len(anomalies)))
+	return anomalies, nil
+}
+
+func (s *Storage) SaveAnomalyAck(ack core.AnomalyAck) (*core.AnomalyAck, error) {
+	t := storedTime(ack.Time).UnixMicro()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var samples []sample
+	entry, ok := s.series[seriesIdentityOf(ack.MetricIdentity).Key()]
+	if ok {
+		var err error
+		if samples, err = s.samples(entry, t, t); err != nil {
+			s.log.Error("failed to find anomaly", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to find anomaly: %w", err)
+		}
+	}
+	if len(samples) == 0 || !samples[0].isAnomaly {
+		s.log.Warn("anomaly not found", slog.Any("metric_identity", ack.MetricIdentity))
+		return nil, fmt.Errorf("anomaly with identity %v: %w", ack.MetricIdentity, core.ErrAnomalyNotFound)
+	}
+
+	saved := ack
+	saved.MetricIdentity = metricIdentityOf(entry.identity, t)
+	saved.AckedAt = time.Now().UTC()
+
+	key := sampleKey(entry.identity.Key(), t)
+	previous, hadPrevious := s.acks[key]
+	s.acks[key] = saved
+	if err := s.storeAcks(); err != nil {
+		if hadPrevious {
+			s.acks[key] = previous
+		} else {
+			delete(s.acks, key)
+		}
+		s.log.Error("failed to save anomaly acknowledgement", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to save anomaly acknowledgement: %w", err)
+	}
+
+	s.log.Info("anomaly acknowledgement saved successfully", slog.Any("metric_identity", ack.MetricIdentity))
+	return &saved, nil
+}
+
+// loadAcks must be called before the storage is shared.
+func (s *Storage) loadAcks() error {
+	encoded, err := os.ReadFile(filepath.Join(s.cfg.Dir, acksFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read anomaly acknowledgements: %w", err)
+	}
+
+	var acks []core.AnomalyAck
+	if err := json.Unmarshal(encoded, &acks); err != nil {
+		return fmt.Errorf("failed to decode anomaly acknowledgements: %w", err)
+	}
+	for _, ack := range acks {
+		s.acks[sampleKey(seriesIdentityOf(ack.MetricIdentity).Key(), storedTime(ack.Time).UnixMicro())] = ack
+	}
+	return nil
+}
+
+// storeAcks must be called with mu held.
+func (s *Storage) storeAcks() error {
+	acks := make([]core.AnomalyAck, 0, len(s.acks))
+	for _, ack := range s.acks {
+		acks = append(acks, ack)
+	}
+	encoded, err := json.Marshal(acks)
+	if err != nil {
+		return fmt.Errorf("failed to encode anomaly acknowledgements: %w", err)
+	}
+
+	path := filepath.Join(s.cfg.Dir, acksFile)
+	if err := writeFileSync(path+tmpExt, encoded); err != nil {
+		return err
+	}
+	if err := os.Rename(path+tmpExt, path); err != nil {
+		return fmt.Errorf("failed to move anomaly acknowledgements into place: %w", err)
+	}
+	return syncDir(s.cfg.Dir)
+}
@@ -0,0 +1,245 @@
+package tsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// A block is an immutable directory holding the samples of many series:
+//
+//	chunks      the encoded chunks of every series, back to back
+//	index.json  the series of the block, where their chunks are and which
+//	            of their samples are anomalies
+//
+// Blocks are written to a ".tmp" directory and renamed into place once
+// complete, so a crash never leaves a partial block behind.
+const (
+	chunksFile = "chunks"
+	indexFile  = "index.json"
+	tmpExt     = ".tmp"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type blockIndex struct {
+	// Seq orders blocks that hold samples with the same time: the one with
+	// the higher Seq was written later and wins.
+	Seq     uint64        `json:"seq"`
+	MinTime int64         `json:"min_time"`
+	MaxTime int64         `json:"max_time"`
+	Series  []blockSeries `json:"series"`
+}
+
+type blockSeries struct {
+	ServiceURL string      `json:"service_url"`
+	MetricName string      `json:"metric_name"`
+	PodName    string      `json:"pod_name"`
+	Labels     core.Labels `json:"labels"`
+	Type       string      `json:"type"`
+	Chunks     []chunkMeta `json:"chunks"`
+	Anomalies  []int64     `json:"anomalies,omitempty"`
+}
+
+type chunkMeta struct {
+	MinTime int64  `json:"min_time"`
+	MaxTime int64  `json:"max_time"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+	CRC     uint32 `json:"crc"`
+}
+
+func (s *blockSeries) identity() core.SeriesIdentity {
+	return core.SeriesIdentity{
+		ServiceURL: s.ServiceURL,
+		MetricName: s.MetricName,
+		PodName:    s.PodName,
+		Labels:     s.Labels,
+	}
+}
+
+type block struct {
+	dir    string
+	index  blockIndex
+	series map[string]*blockSeries
+	chunks *os.File
+}
+
+// blockData is the content of a block about to be written, one entry per
+// series with its samples sorted by time.
+type blockData struct {
+	identity core.SeriesIdentity
+	typ      string
+	samples  []sample
+}
+
+func writeBlock(dir string, seq uint64, data []blockData) error {
+	tmp := dir + tmpExt
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to clean up block directory: %w", err)
+	}
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return fmt.Errorf("failed to create block directory: %w", err)
+	}
+
+	index := blockIndex{Seq: seq, Series: make([]blockSeries, 0, len(data))}
+	var chunks []byte
+	for i, d := range data {
+		bs := blockSeries{
+			ServiceURL: d.identity.ServiceURL,
+			MetricName: d.identity.MetricName,
+			PodName:    d.identity.PodName,
+			Labels:     d.identity.Labels,
+			Type:       d.typ,
+		}
+		for chunk := range slices.Chunk(d.samples, maxChunkSamples) {
+			encoded := encodeChunk(chunk)
+			bs.Chunks = append(bs.Chunks, chunkMeta{
+				MinTime: chunk[0].t,
+				MaxTime: chunk[len(chunk)-1].t,
+				Offset:  int64(len(chunks)),
+				Length:  int64(len(encoded)),
+				CRC:     crc32.Checksum(encoded, crcTable),
+			})
+			chunks = append(chunks, encoded...)
+			for _, smp := range chunk {
+				if smp.isAnomaly {
+					bs.Anomalies = append(bs.Anomalies, smp.t)
+				}
+			}
+		}
+
+		if i == 0 || d.samples[0].t < index.MinTime {
+			index.MinTime = d.samples[0].t
+		}
+		if i == 0 || d.samples[len(d.samples)-1].t > index.MaxTime {
+			index.MaxTime = d.samples[len(d.samples)-1].t
+		}
+		index.Series = append(index.Series, bs)
+	}
+
+	encodedIndex, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode block index: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(tmp, chunksFile), chunks); err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(tmp, indexFile), encodedIndex); err != nil {
+		return err
+	}
+	if err := syncDir(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("failed to move block into place: %w", err)
+	}
+	return syncDir(filepath.Dir(dir))
+}
+
+func openBlock(dir string) (*block, error) {
+	encodedIndex, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block index: %w", err)
+	}
+	var index blockIndex
+	if err := json.Unmarshal(encodedIndex, &index); err != nil {
+		return nil, fmt.Errorf("failed to decode block index: %w", err)
+	}
+
+	chunks, err := os.Open(filepath.Join(dir, chunksFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block chunks: %w", err)
+	}
+
+	b := &block{
+		dir:    dir,
+		index:  index,
+		series: make(map[string]*blockSeries, len(index.Series)),
+		chunks: chunks,
+	}
+	for i := range b.index.Series {
+		bs := &b.index.Series[i]
+		if bs.Labels == nil {
+			bs.Labels = core.Labels{}
+		}
+		b.series[bs.identity().Key()] = bs
+	}
+	return b, nil
+}
+
+// read returns the samples of the series with the given key in [minT, maxT],
+// sorted by time.
+func (b *block) read(key string, minT, maxT int64) ([]sample, error) {
+	bs, ok := b.series[key]
+	if !ok || b.index.MaxTime < minT || b.index.MinTime > maxT {
+		return nil, nil
+	}
+
+	var samples []sample
+	for _, meta := range bs.Chunks {
+		if meta.MaxTime < minT || meta.MinTime > maxT {
+			continue
+		}
+
+		encoded := make([]byte, meta.Length)
+		if _, err := b.chunks.ReadAt(encoded, meta.Offset); err != nil {
+			return nil, fmt.Errorf("failed to read chunk of block %s: %w", filepath.Base(b.dir), err)
+		}
+		if crc32.Checksum(encoded, crcTable) != meta.CRC {
+			return nil, fmt.Errorf("chunk of block %s: %w: checksum mismatch", filepath.Base(b.dir), errCorruptChunk)
+		}
+		chunk, err := decodeChunk(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("chunk of block %s: %w", filepath.Base(b.dir), err)
+		}
+
+		for _, smp := range chunk {
+			if smp.t < minT || smp.t > maxT {
+				continue
+			}
+			_, smp.isAnomaly = slices.BinarySearch(bs.Anomalies, smp.t)
+			samples = append(samples, smp)
+		}
+	}
+	return samples, nil
+}
+
+func (b *block) close() error {
+	return b.chunks.Close()
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync %s: %w", filepath.Base(path), err)
+	}
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package tsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// maxChunkSamples caps a chunk so that reading a narrow range does not decode
+// a whole block worth of a series.
+const maxChunkSamples = 120
+
+var errCorruptChunk = errors.New("corrupt chunk")
+
+// sample is a point with its time in microseconds since the epoch, the
+// precision the database adapter stores.
+type sample struct {
+	t         int64
+	v         float64
+	isAnomaly bool
+}
+
+// A chunk holds up to maxChunkSamples samples of a series in time order,
+// compressed as described in the Gorilla paper:
+//
+//	count      uint16, big endian
+//	t0, v0     64 bits each
+//	timestamps delta-of-delta, with the first delta taken against zero:
+//	           '0'                    dod == 0
+//	           '10'   + 14 bits       dod in [-8192, 8191]
+//	           '110'  + 17 bits       dod in [-65536, 65535]
+//	           '1110' + 20 bits       dod in [-524288, 524287]
+//	           '1111' + 64 bits       otherwise
+//	values     XOR with the previous value:
+//	           '0'                    same value
+//	           '10'   + meaningful bits within the previous leading/trailing window
+//	           '11'   + 5 bits leading zeros + 6 bits length + meaningful bits
+//
+// The anomaly flag is not part of the chunk, blocks keep it in their index.
+func encodeChunk(samples []sample) []byte {
+	w := &bitWriter{}
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(len(samples)))
+
+	var (
+		prevT, prevDelta int64
+		prevV            uint64
+		leading          = -1
+		trailing         int
+	)
+	for i, smp := range samples {
+		v := math.Float64bits(smp.v)
+		if i == 0 {
+			w.writeBits(uint64(smp.t), 64)
+			w.writeBits(v, 64)
+			prevT, prevV = smp.t, v
+			continue
+		}
+
+		delta := smp.t - prevT
+		writeDoD(w, delta-prevDelta)
+		prevT, prevDelta = smp.t, delta
+
+		xor := v ^ prevV
+		prevV = v
+		if xor == 0 {
+			w.writeBit(false)
+			continue
+		}
+		w.writeBit(true)
+
+		newLeading := min(bits.LeadingZeros64(xor), 31)
+		newTrailing := bits.TrailingZeros64(xor)
+		if leading >= 0 && newLeading >= leading && newTrailing >= trailing {
+			w.writeBit(false)
+			w.writeBits(xor>>uint(trailing), 64-leading-trailing)
+			continue
+		}
+
+		leading, trailing = newLeading, newTrailing
+		sigBits := 64 - leading - trailing
+		w.writeBit(true)
+		w.writeBits(uint64(leading), 5)
+		// 64 significant bits do not fit in 6 bits, they are written as 0.
+		w.writeBits(uint64(sigBits&63), 6)
+		w.writeBits(xor>>uint(trailing), sigBits)
+	}
+	return w.buf
+}
+
+func writeDoD(w *bitWriter, dod int64) {
+	switch {
+	case dod == 0:
+		w.writeBit(false)
+	case dod >= -(1<<13) && dod < 1<<13:
+		w.writeBits(0b10, 2)
+		w.writeBits(uint64(dod), 14)
+	case dod >= -(1<<16) && dod < 1<<16:
+		w.writeBits(0b110, 3)
+		w.writeBits(uint64(dod), 17)
+	case dod >= -(1<<19) && dod < 1<<19:
+		w.writeBits(0b1110, 4)
+		w.writeBits(uint64(dod), 20)
+	default:
+		w.writeBits(0b1111, 4)
+		w.writeBits(uint64(dod), 64)
+	}
+}
+
+func decodeChunk(data []byte) ([]sample, error) {
+	if len(data) < 2 {
+		return nil, errCorruptChunk
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	r := &bitReader{buf: data[2:]}
+
+	samples := make([]sample, 0, count)
+	var (
+		prevT, prevDelta  int64
+		prevV             uint64
+		leading, trailing int
+	)
+	for i := range count {
+		if i == 0 {
+			t, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+			prevT, prevV = int64(t), v
+			samples = append(samples, sample{t: prevT, v: math.Float64frombits(v)})
+			continue
+		}
+
+		dod, err := readDoD(r)
+		if err != nil {
+			return nil, err
+		}
+		prevDelta += dod
+		prevT += prevDelta
+
+		changed, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			newWindow, err := r.readBit()
+			if err != nil {
+				return nil, err
+			}
+			if newWindow {
+				l, err := r.readBits(5)
+				if err != nil {
+					return nil, err
+				}
+				sigBits, err := r.readBits(6)
+				if err != nil {
+					return nil, err
+				}
+				if sigBits == 0 {
+					sigBits = 64
+				}
+				if int(l)+int(sigBits) > 64 {
+					return nil, errCorruptChunk
+				}
+				leading, trailing = int(l), 64-int(l)-int(sigBits)
+			}
+			xor, err := r.readBits(64 - leading - trailing)
+			if err != nil {
+				return nil, err
+			}
+			prevV ^= xor << uint(trailing)
+		}
+
+		samples = append(samples, sample{t: prevT, v: math.Float64frombits(prevV)})
+	}
+	return samples, nil
+}
+
+func readDoD(r *bitReader) (int64, error) {
+	prefix := 0
+	for prefix < 4 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		prefix++
+	}
+
+	var size int
+	switch prefix {
+	case 0:
+		return 0, nil
+	case 1:
+		size = 14
+	case 2:
+		size = 17
+	case 3:
+		size = 20
+	default:
+		size = 64
+	}
+
+	raw, err := r.readBits(size)
+	if err != nil {
+		return 0, err
+	}
+	if size == 64 {
+		return int64(raw), nil
+	}
+	// Sign extend the two's complement value.
+	shift := uint(64 - size)
+	return int64(raw<<shift) >> shift, nil
+}
+
+type bitWriter struct {
+	buf []byte
+	// free is the number of unused low bits in the last byte of buf.
+	free uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeBits writes the n low bits of v, most significant first.
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errCorruptChunk
+	}
+	bit := r.buf[r.pos/8]&(1<<(7-uint(r.pos%8))) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for range n {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
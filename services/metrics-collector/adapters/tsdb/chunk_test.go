@@ -0,0 +1,75 @@
+package tsdb
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMicro()
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	cases := map[string][]sample{
+		"single": {{t: start, v: 42}},
+		"regular": func() []sample {
+			samples := make([]sample, 0, maxChunkSamples)
+			for i := range maxChunkSamples {
+				samples = append(samples, sample{t: start + int64(i)*15_000_000, v: float64(i % 7)})
+			}
+			return samples
+		}(),
+		"jitter and noise": func() []sample {
+			samples := make([]sample, 0, maxChunkSamples)
+			at := start
+			for range maxChunkSamples {
+				at += 15_000_000 + rng.Int64N(2_000_000) - 1_000_000
+				samples = append(samples, sample{t: at, v: rng.NormFloat64() * 1e6})
+			}
+			return samples
+		}(),
+		"special values": {
+			{t: start, v: 0},
+			{t: start + 1, v: math.Inf(1)},
+			{t: start + 2, v: math.Inf(-1)},
+			{t: start + 1_000_000_000_000, v: math.NaN()},
+			{t: start + 1_000_000_000_001, v: -0.0},
+			{t: start - start, v: math.MaxFloat64},
+			{t: math.MaxInt64, v: math.SmallestNonzeroFloat64},
+		},
+	}
+
+	for name, samples := range cases {
+		decoded, err := decodeChunk(encodeChunk(samples))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if len(decoded) != len(samples) {
+			t.Fatalf("%s: expected %d samples, got %d", name, len(samples), len(decoded))
+		}
+		for i := range samples {
+			if decoded[i].t != samples[i].t || math.Float64bits(decoded[i].v) != math.Float64bits(samples[i].v) {
+				t.Fatalf("%s: sample %d: expected %+v, got %+v", name, i, samples[i], decoded[i])
+			}
+		}
+	}
+}
+
+func TestChunkCompressesRegularSeries(t *testing.T) {
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMicro()
+	samples := make([]sample, 0, maxChunkSamples)
+	for i := range maxChunkSamples {
+		samples = append(samples, sample{t: start + int64(i)*15_000_000, v: 100 + float64(i%3)})
+	}
+
+	encoded := encodeChunk(samples)
+	// Uncompressed the samples take 16 bytes each.
+	if size := len(encoded); size > len(samples)*16/4 {
+		t.Fatalf("expected at least 4x compression, got %d bytes for %d samples", size, len(samples))
+	}
+
+	if _, err := decodeChunk(encoded[:len(encoded)/2]); err == nil {
+		t.Fatal("a truncated chunk should fail to decode")
+	}
+}
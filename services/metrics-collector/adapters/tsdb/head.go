@@ -0,0 +1,192 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/wal"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// The head log makes the in-memory head block durable until it is cut into a
+// block. It uses the record format of the write-ahead log:
+//
+//	length  uint32, big endian, of the payload
+//	crc     uint32, big endian, CRC-32C of the payload
+//	payload []core.Metric, see wal.EncodeMetrics
+//
+// Records hold only the metrics that made it into the head, so replaying them
+// in order with overwrite semantics rebuilds the head as it was.
+const (
+	headLogFile  = "head.log"
+	headerSize   = 8
+	maxRecordLen = 64 << 20
+)
+
+var errCorruptRecord = errors.New("corrupt head log record")
+
+type headLog struct {
+	path string
+	file *os.File
+	size int64
+}
+
+func openHeadLog(path string) (*headLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open head log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat head log: %w", err)
+	}
+	return &headLog{path: path, file: file, size: info.Size()}, nil
+}
+
+func (l *headLog) append(metrics []core.Metric) error {
+	payload := wal.EncodeMetrics(metrics)
+	if len(payload) > maxRecordLen {
+		return fmt.Errorf("head log record of %d bytes exceeds the limit", len(payload))
+	}
+
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+
+	if _, err := l.file.Write(record); err != nil {
+		// Drop a partially written record so the next one stays readable.
+		_ = l.file.Truncate(l.size)
+		return fmt.Errorf("failed to write head log record: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync head log: %w", err)
+	}
+	l.size += int64(len(record))
+	return nil
+}
+
+// discard drops the first n bytes of the log, the records persisted in a
+// block, and keeps the ones appended since. The rest is copied into a new
+// file that replaces the log, so that a crash leaves one of the two intact.
+func (l *headLog) discard(n int64) error {
+	if n >= l.size {
+		return l.truncate()
+	}
+
+	src, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to open head log: %w", err)
+	}
+	defer src.Close()
+	if _, err := src.Seek(n, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek head log: %w", err)
+	}
+
+	tmpPath := l.path + tmpExt
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create head log: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to copy head log: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to sync head log: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to replace head log: %w", err)
+	}
+	if err := syncDir(filepath.Dir(l.path)); err != nil {
+		dst.Close()
+		return err
+	}
+
+	// dst is the log now, appending to it continues the log.
+	l.file.Close()
+	l.file = dst
+	l.size -= n
+	return nil
+}
+
+// truncate empties the log once its content is persisted in a block.
+func (l *headLog) truncate() error {
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate head log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync head log: %w", err)
+	}
+	l.size = 0
+	return nil
+}
+
+func (l *headLog) close() error {
+	return l.file.Close()
+}
+
+// replayHeadLog calls apply for every record of the log at path. A corrupt
+// record, usually one torn by a crash, ends the replay; it returns the number
+// of bytes replayed so that the caller can cut the rest off.
+func replayHeadLog(path string, apply func([]core.Metric)) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open head log for replay: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		metrics, size, err := readRecord(reader)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		apply(metrics)
+		offset += size
+	}
+}
+
+func readRecord(reader io.Reader) ([]core.Metric, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, fmt.Errorf("%w: truncated header", errCorruptRecord)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordLen {
+		return nil, 0, fmt.Errorf("%w: record length %d", errCorruptRecord, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, fmt.Errorf("%w: truncated payload", errCorruptRecord)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+
+	metrics, err := wal.DecodeMetrics(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errCorruptRecord, err.Error())
+	}
+	return metrics, int64(headerSize + len(payload)), nil
+}
@@ -0,0 +1,106 @@
+package tsdb
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func (s *Storage) MetricNames() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, entry := range s.series {
+		if !seen[entry.identity.MetricName] {
+			seen[entry.identity.MetricName] = true
+			names = append(names, entry.identity.MetricName)
+		}
+	}
+	return names, nil
+}
+
+// DeleteMetricsBefore rewrites every block holding expired samples of the
+// metrics. Expired samples in the head are removed from memory only; should
+// the head log be replayed after a crash they come back until the next run.
+func (s *Storage) DeleteMetricsBefore(metricNames []string, before time.Time) (int64, error) {
+	beforeT := storedTime(before).UnixMicro()
+	expired := func(identity core.SeriesIdentity, smp sample) bool {
+		return smp.t < beforeT && slices.Contains(metricNames, identity.MetricName)
+	}
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	var deleted int64
+	for _, entry := range s.series {
+		if !slices.Contains(metricNames, entry.identity.MetricName) {
+			continue
+		}
+		from, _ := searchSamples(entry.head, beforeT)
+		if from > 0 {
+			entry.head = slices.Delete(entry.head, 0, from)
+			deleted += int64(from)
+		}
+	}
+
+	affected := make([]*block, 0)
+	for _, b := range s.blocks {
+		if b.index.MinTime >= beforeT {
+			continue
+		}
+		for _, bs := range b.series {
+			if slices.Contains(metricNames, bs.MetricName) && len(bs.Chunks) > 0 && bs.Chunks[0].MinTime < beforeT {
+				affected = append(affected, b)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	// Blocks are rewritten one by one so that each keeps its own Seq.
+	for _, b := range affected {
+		removed, _, err := s.rewriteBlocks([]*block{b}, func(identity core.SeriesIdentity, smp sample) bool {
+			return !expired(identity, smp)
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += removed
+	}
+
+	s.log.Debug("expired metrics deleted", slog.Int("blocks_rewritten", len(affected)), slog.Int64("samples", deleted))
+	return deleted, nil
+}
+
+// DropBefore removes the blocks that only hold samples older than before.
+func (s *Storage) DropBefore(before time.Time) (int64, error) {
+	beforeT := storedTime(before).UnixMicro()
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := make([]*block, 0)
+	for _, b := range s.blocks {
+		if b.index.MaxTime < beforeT {
+			expired = append(expired, b)
+		}
+	}
+	s.blocks = slices.DeleteFunc(s.blocks, func(b *block) bool {
+		return slices.Contains(expired, b)
+	})
+	s.removeBlocks(expired)
+	return int64(len(expired)), nil
+}
+
+// CompressBefore has nothing to do: blocks are compressed when they are cut.
+func (s *Storage) CompressBefore(time.Time) (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,951 @@
+package tsdb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const blocksDir = "blocks"
+
+type seriesEntry struct {
+	identity core.SeriesIdentity
+	typ      string
+	// head holds the samples not cut into a block yet, sorted by time.
+	head []sample
+	// inBlocks and blockMaxTime let in-order writes skip looking for
+	// conflicts in the blocks.
+	inBlocks     bool
+	blockMaxTime int64
+}
+
+// Storage is a core.MetricRepository and core.AnomalyRepository backed by
+// local files, for deployments without a database. New samples go to an
+// in-memory head, made durable by the head log, which is cut into an
+// immutable block every BlockDuration. Blocks that fall into the same
+// CompactionRange are merged in the background.
+type Storage struct {
+	log            *slog.Logger
+	cfg            *config.TSDB
+	conflictPolicy string
+
+	// compactMu serializes cutting the head and compaction, the only
+	// operations that change the set of blocks.
+	compactMu sync.Mutex
+	lastCut   time.Time
+
+	mu      sync.RWMutex
+	series  map[string]*seriesEntry
+	blocks  []*block // sorted by Seq
+	headLog *headLog
+	nextSeq uint64
+	acks    map[string]core.AnomalyAck
+	closed  bool
+}
+
+func Open(log *slog.Logger, cfgTSDB *config.TSDB, conflictPolicy string) (*Storage, error) {
+	switch conflictPolicy {
+	case core.ConflictPolicyReject, core.ConflictPolicyOverwrite, core.ConflictPolicyKeepFirst:
+	default:
+		log.Error("unknown conflict policy", slog.String("conflict_policy", conflictPolicy))
+		return nil, fmt.Errorf("unknown conflict policy %q", conflictPolicy)
+	}
+	if cfgTSDB.Dir == "" || cfgTSDB.BlockDuration <= 0 || cfgTSDB.CompactionInterval <= 0 || cfgTSDB.CompactionRange < cfgTSDB.BlockDuration {
+		return nil, errors.New("tsdb requires a directory, a positive block duration and compaction interval, and a compaction range of at least the block duration")
+	}
+	if err := os.MkdirAll(filepath.Join(cfgTSDB.Dir, blocksDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create tsdb directory: %w", err)
+	}
+
+	s := &Storage{
+		log:            log,
+		cfg:            cfgTSDB,
+		conflictPolicy: conflictPolicy,
+		lastCut:        time.Now(),
+		series:         make(map[string]*seriesEntry),
+		nextSeq:        1,
+		acks:           make(map[string]core.AnomalyAck),
+	}
+	if err := s.openBlocks(); err != nil {
+		s.closeBlocks()
+		return nil, err
+	}
+	if err := s.loadAcks(); err != nil {
+		s.closeBlocks()
+		return nil, err
+	}
+
+	headLogPath := filepath.Join(cfgTSDB.Dir, headLogFile)
+	replayed := 0
+	size, err := replayHeadLog(headLogPath, func(metrics []core.Metric) {
+		for _, metric := range metrics {
+			s.apply(metric)
+		}
+		replayed += len(metrics)
+	})
+	if err != nil {
+		log.Warn("dropping the rest of the head log", slog.Int64("offset", size), slog.String("error", err.Error()))
+		if err := os.Truncate(headLogPath, size); err != nil {
+			s.closeBlocks()
+			return nil, fmt.Errorf("failed to truncate head log: %w", err)
+		}
+	}
+	if replayed > 0 {
+		log.Info("head log replayed", slog.Int("count", replayed))
+	}
+
+	if s.headLog, err = openHeadLog(headLogPath); err != nil {
+		s.closeBlocks()
+		return nil, err
+	}
+
+	log.Info("tsdb opened", slog.String("dir", cfgTSDB.Dir), slog.Int("blocks", len(s.blocks)), slog.Int("series", len(s.series)))
+	return s, nil
+}
+
+// Run cuts the head and compacts blocks until ctx is done.
+func (s *Storage) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.CompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.maintain()
+		}
+	}
+}
+
+// Close cuts what is left in the head into a block, so that the next start
+// does not have to replay it, and releases the files.
+func (s *Storage) Close() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	cutErr := s.cut()
+	if cutErr != nil {
+		s.log.Error("failed to cut head on close, it stays in the head log", slog.String("error", cutErr.Error()))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.closeBlocks()
+	return errors.Join(cutErr, s.headLog.close())
+}
+
+func (s *Storage) Save(metric core.Metric) (*core.MetricIdentity, error) {
+	metric.Time = storedTime(metric.Time)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("tsdb is closed")
+	}
+
+	write, err := s.admit(metric, nil, nil)
+	if errors.Is(err, core.ErrDuplicateMetric) {
+		s.log.Warn("metric already exists", slog.Any("metric_identity", metric.MetricIdentity))
+		return nil, fmt.Errorf("failed to insert metric: %w", err)
+	}
+	if errors.Is(err, core.ErrMetricTypeMismatch) {
+		s.log.Warn("metric type differs from the series type", slog.Any("metric_identity", metric.MetricIdentity), slog.String("type", metric.Type))
+		return nil, fmt.Errorf("failed to insert metric: %w", err)
+	}
+	if err != nil {
+		s.log.Error("failed to look up metric", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to insert metric: %w", err)
+	}
+
+	if write {
+		if err := s.headLog.append([]core.Metric{metric}); err != nil {
+			s.log.Error("failed to insert metric", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to insert metric: %w", err)
+		}
+		s.apply(metric)
+	}
+
+	s.log.Info("metric saved successfully", slog.Any("metric_identity", metric.MetricIdentity))
+	return &metric.MetricIdentity, nil
+}
+
+func (s *Storage) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("tsdb is closed")
+	}
+
+	results := make([]core.MetricResult, 0, len(metrics))
+	written := make([]core.Metric, 0, len(metrics))
+	pending := make(map[string]bool)
+	declared := make(map[string]string)
+	saved := 0
+	for i, metric := range metrics {
+		metric.Time = storedTime(metric.Time)
+		result := core.MetricResult{Index: i}
+
+		write, err := s.admit(metric, pending, declared)
+		if err != nil && !errors.Is(err, core.ErrDuplicateMetric) && !errors.Is(err, core.ErrMetricTypeMismatch) {
+			s.log.Error("failed to look up metric", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to insert metric batch: %w", err)
+		}
+		if err != nil {
+			result.Err = err
+		} else {
+			metricIdentity := metric.MetricIdentity
+			result.MetricIdentity = &metricIdentity
+			saved++
+		}
+		if write {
+			written = append(written, metric)
+		}
+		results = append(results, result)
+	}
+
+	if len(written) > 0 {
+		if err := s.headLog.append(written); err != nil {
+			s.log.Error("failed to insert metric batch", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to insert metric batch: %w", err)
+		}
+		for _, metric := range written {
+			s.apply(metric)
+		}
+	}
+
+	s.log.Info("metric batch saved successfully", slog.Int("count", saved), slog.Int("skipped", len(metrics)-saved), slog.String("conflict_policy", s.conflictPolicy))
+	return results, nil
+}
+
+// admit decides whether metric is written under the conflict policy, given
+// what is stored and what was admitted before it in the same batch, tracked
+// in pending and, for the types of new series, in declared. A series keeps
+// the type it was created with. It must be called with mu held.
+func (s *Storage) admit(metric core.Metric, pending map[string]bool, declared map[string]string) (bool, error) {
+	identity := seriesIdentityOf(metric.MetricIdentity)
+	typ := metricTypeOrGauge(metric.Type)
+	if entry, ok := s.series[identity.Key()]; ok {
+		if entry.typ != typ {
+			return false, core.ErrMetricTypeMismatch
+		}
+	} else if declaredType, ok := declared[identity.Key()]; ok && declaredType != typ {
+		return false, core.ErrMetricTypeMismatch
+	} else if declared != nil {
+		declared[identity.Key()] = typ
+	}
+
+	t := metric.Time.UnixMicro()
+	pendingKey := sampleKey(identity.Key(), t)
+
+	exists := pending[pendingKey]
+	if !exists {
+		var err error
+		if exists, err = s.exists(identity.Key(), t); err != nil {
+			return false, err
+		}
+	}
+	if pending != nil {
+		pending[pendingKey] = true
+	}
+
+	if !exists {
+		return true, nil
+	}
+	switch s.conflictPolicy {
+	case core.ConflictPolicyOverwrite:
+		return true, nil
+	case core.ConflictPolicyReject:
+		return false, core.ErrDuplicateMetric
+	default:
+		return false, nil
+	}
+}
+
+// exists must be called with mu held.
+func (s *Storage) exists(key string, t int64) (bool, error) {
+	entry, ok := s.series[key]
+	if !ok {
+		return false, nil
+	}
+	if _, found := searchSamples(entry.head, t); found {
+		return true, nil
+	}
+	if !entry.inBlocks || t > entry.blockMaxTime {
+		return false, nil
+	}
+
+	for _, b := range s.blocks {
+		samples, err := b.read(key, t, t)
+		if err != nil {
+			return false, err
+		}
+		if len(samples) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply writes metric to the head, replacing a sample with the same time.
+// It must be called with mu held, or before the storage is shared.
+func (s *Storage) apply(metric core.Metric) {
+	entry := s.entry(seriesIdentityOf(metric.MetricIdentity), metricTypeOrGauge(metric.Type))
+
+	smp := sample{t: metric.Time.UnixMicro(), v: metric.MetricValue, isAnomaly: metric.IsAnomaly}
+	i, found := searchSamples(entry.head, smp.t)
+	if found {
+		entry.head[i] = smp
+		return
+	}
+	entry.head = slices.Insert(entry.head, i, smp)
+}
+
+// entry returns the entry of the series, creating it with typ if needed. It
+// must be called with mu held, or before the storage is shared.
+func (s *Storage) entry(identity core.SeriesIdentity, typ string) *seriesEntry {
+	key := identity.Key()
+	entry, ok := s.series[key]
+	if !ok {
+		identity.Labels = maps.Clone(identity.Labels)
+		if identity.Labels == nil {
+			identity.Labels = core.Labels{}
+		}
+		entry = &seriesEntry{identity: identity, typ: typ}
+		s.series[key] = entry
+	}
+	return entry
+}
+
+func (s *Storage) FindByMetricIdentity(metricIdentity core.MetricIdentity) (*core.Metric, error) {
+	t := storedTime(metricIdentity.Time).UnixMicro()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var samples []sample
+	entry, ok := s.series[seriesIdentityOf(metricIdentity).Key()]
+	if ok {
+		var err error
+		if samples, err = s.samples(entry, t, t); err != nil {
+			s.log.Error("failed to find metric", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to find metric: %w", err)
+		}
+	}
+	if len(samples) == 0 {
+		s.log.Warn("metric not found", slog.Any("metric_identity", metricIdentity))
+		return nil, fmt.Errorf("metric with identity %v: %w", metricIdentity, core.ErrMetricNotFound)
+	}
+
+	s.log.Info("metric found successfully", slog.Any("metric_identity", metricIdentity))
+	return &core.Metric{
+		MetricIdentity: metricIdentityOf(entry.identity, samples[0].t),
+		Type:           entry.typ,
+		MetricValue:    samples[0].v,
+		IsAnomaly:      samples[0].isAnomaly,
+	}, nil
+}
+
+func (s *Storage) FindRange(rangeQuery core.RangeQuery) ([]core.Metric, error) {
+	selector, err := core.NewLabelSelector(rangeQuery.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	minT, maxT := storedTime(rangeQuery.Start).UnixMicro(), storedTime(rangeQuery.End).UnixMicro()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metrics := make([]core.Metric, 0)
+	for _, entry := range s.selectSeries(rangeQuery.ServiceURL, rangeQuery.MetricName, rangeQuery.PodName, selector) {
+		samples, err := s.samples(entry, minT, maxT)
+		if err != nil {
+			s.log.Error("failed to fetch metric range", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to fetch metric range: %w", err)
+		}
+		for _, smp := range samples {
+			metrics = append(metrics, core.Metric{
+				MetricIdentity: metricIdentityOf(entry.identity, smp.t),
+				Type:           entry.typ,
+				MetricValue:    smp.v,
+			})
+		}
+	}
+
+	s.log.Info("metric range fetched successfully", slog.Int("count", len(metrics)))
+	return metrics, nil
+}
+
+func (s *Storage) FindSeries(seriesQuery core.SeriesQuery) ([]core.SeriesIdentity, error) {
+	selector, err := core.NewLabelSelector(seriesQuery.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	minT, maxT := storedTime(seriesQuery.Start).UnixMicro(), storedTime(seriesQuery.End).UnixMicro()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := make([]core.SeriesIdentity, 0)
+	for _, entry := range s.selectSeries(seriesQuery.ServiceURL, seriesQuery.MetricName, seriesQuery.PodName, selector) {
+		samples, err := s.samples(entry, minT, maxT)
+		if err != nil {
+			s.log.Error("failed to fetch series", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to fetch series: %w", err)
+		}
+		if len(samples) > 0 {
+			identities = append(identities, entry.identity)
+		}
+	}
+
+	s.log.Info("series fetched successfully", slog.Int("count", len(identities)))
+	return identities, nil
+}
+
+type aggregateGroup struct {
+	bucket     time.Time
+	serviceURL string
+	podName    string
+}
+
+func (s *Storage) Aggregate(aggregateQuery core.AggregateQuery) ([]core.Metric, error) {
+	if !core.IsAggregationFunction(aggregateQuery.Function) {
+		return nil, fmt.Errorf("unsupported aggregation function %q", aggregateQuery.Function)
+	}
+	selector, err := core.NewLabelSelector(aggregateQuery.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	minT, maxT := storedTime(aggregateQuery.Start).UnixMicro(), storedTime(aggregateQuery.End).UnixMicro()
+
+	origin := aggregateQuery.Origin
+	if origin.IsZero() {
+		origin = core.DefaultBucketOrigin
+	}
+
+	s.mu.RLock()
+	groups := make(map[aggregateGroup][]core.Point)
+	for _, entry := range s.selectSeries(aggregateQuery.ServiceURL, aggregateQuery.MetricName, aggregateQuery.PodName, selector) {
+		samples, err := s.samples(entry, minT, maxT)
+		if err != nil {
+			s.mu.RUnlock()
+			s.log.Error("failed to fetch metric aggregate", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to fetch metric aggregate: %w", err)
+		}
+
+		group := aggregateGroup{serviceURL: aggregateQuery.ServiceURL, podName: aggregateQuery.PodName}
+		switch aggregateQuery.GroupBy {
+		case core.GroupByServiceURL:
+			group.serviceURL = entry.identity.ServiceURL
+		case core.GroupByPodName:
+			group.podName = entry.identity.PodName
+		}
+		for _, smp := range samples {
+			t := time.UnixMicro(smp.t).UTC()
+			group.bucket = core.TimeBucket(t, aggregateQuery.Interval, origin)
+			groups[group] = append(groups[group], core.Point{Time: t, Value: smp.v})
+		}
+	}
+	s.mu.RUnlock()
+
+	metrics := make([]core.Metric, 0, len(groups))
+	for group, points := range groups {
+		value, _ := core.AggregatePoints(aggregateQuery.Function, points, aggregateQuery.Percentile)
+		metrics = append(metrics, core.Metric{
+			MetricIdentity: core.MetricIdentity{
+				Time:       group.bucket,
+				ServiceURL: group.serviceURL,
+				MetricName: aggregateQuery.MetricName,
+				PodName:    group.podName,
+			},
+			MetricValue: value,
+		})
+	}
+	slices.SortFunc(metrics, func(a, b core.Metric) int {
+		return cmp.Or(
+			cmp.Compare(a.ServiceURL, b.ServiceURL),
+			cmp.Compare(a.PodName, b.PodName),
+			a.Time.Compare(b.Time),
+		)
+	})
+
+	s.log.Info("metric aggregate fetched successfully", slog.Int("count", len(metrics)))
+	return metrics, nil
+}
+
+// samples returns the samples of the series in [minT, maxT] from the blocks
+// and the head, sorted by time. Where several hold a sample with the same
+// time, the head wins over blocks and newer blocks win over older ones. It
+// must be called with mu held.
+func (s *Storage) samples(entry *seriesEntry, minT, maxT int64) ([]sample, error) {
+	from, _ := searchSamples(entry.head, minT)
+	to, found := searchSamples(entry.head, maxT)
+	if found {
+		to++
+	}
+	head := entry.head[from:to]
+
+	if !entry.inBlocks || minT > entry.blockMaxTime {
+		return slices.Clone(head), nil
+	}
+
+	key := entry.identity.Key()
+	var merged []sample
+	for _, b := range s.blocks {
+		samples, err := b.read(key, minT, maxT)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, samples...)
+	}
+	merged = append(merged, head...)
+	return mergeSamples(merged), nil
+}
+
+// selectSeries returns the matching series ordered like the database orders
+// them. Empty serviceURL, metricName and podName match any. It must be called
+// with mu held.
+func (s *Storage) selectSeries(serviceURL, metricName, podName string, selector core.LabelSelector) []*seriesEntry {
+	selected := make([]*seriesEntry, 0)
+	for _, entry := range s.series {
+		if (metricName != "" && entry.identity.MetricName != metricName) ||
+			(serviceURL != "" && entry.identity.ServiceURL != serviceURL) ||
+			(podName != "" && entry.identity.PodName != podName) ||
+			!selector.Matches(entry.identity.Labels) {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	slices.SortFunc(selected, func(a, b *seriesEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.identity.MetricName, b.identity.MetricName),
+			cmp.Compare(a.identity.ServiceURL, b.identity.ServiceURL),
+			cmp.Compare(a.identity.PodName, b.identity.PodName),
+			cmp.Compare(a.identity.Labels.String(), b.identity.Labels.String()),
+		)
+	})
+	return selected
+}
+
+func (s *Storage) maintain() {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	if time.Since(s.lastCut) >= s.cfg.BlockDuration {
+		if err := s.cut(); err != nil {
+			s.log.Error("failed to cut head, will retry", slog.String("error", err.Error()))
+		}
+	}
+	if err := s.compact(); err != nil {
+		s.log.Error("failed to compact blocks, will retry", slog.String("error", err.Error()))
+	}
+}
+
+// cut writes the head into a new block and drops what it wrote from the
+// head. The block is written from a copy of the head without holding mu, so
+// that writes and queries go on meanwhile; samples written in the meantime
+// stay in the head. It must be called with compactMu held.
+func (s *Storage) cut() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastCut = time.Now()
+
+	data := make([]blockData, 0)
+	for _, entry := range s.series {
+		if len(entry.head) > 0 {
+			data = append(data, blockData{identity: entry.identity, typ: entry.typ, samples: slices.Clone(entry.head)})
+		}
+	}
+	seq := s.nextSeq
+	logged := s.headLog.size
+	s.mu.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+	slices.SortFunc(data, func(a, b blockData) int {
+		return cmp.Compare(a.identity.Key(), b.identity.Key())
+	})
+
+	b, err := s.writeBlock(seq, data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.removeBlocks([]*block{b})
+		return nil
+	}
+	s.nextSeq++
+	s.blocks = append(s.blocks, b)
+
+	samples := 0
+	for _, d := range data {
+		entry := s.series[d.identity.Key()]
+		entry.inBlocks = true
+		entry.blockMaxTime = max(entry.blockMaxTime, d.samples[len(d.samples)-1].t)
+		entry.head = withoutSamples(entry.head, d.samples)
+		samples += len(d.samples)
+	}
+
+	// If this fails the samples are replayed into the head after a restart
+	// and end up in the next block, where they win over the same samples in
+	// this one.
+	if err := s.headLog.discard(logged); err != nil {
+		return err
+	}
+
+	s.log.Info("head cut into block", slog.Uint64("block", seq), slog.Int("series", len(data)), slog.Int("samples", samples))
+	return nil
+}
+
+// withoutSamples returns head without the samples of written, both sorted by
+// time. A sample overwritten since it was written stays, so that it keeps
+// winning over the block.
+func withoutSamples(head, written []sample) []sample {
+	kept := head[:0]
+	for _, smp := range head {
+		i, found := searchSamples(written, smp.t)
+		if found && sameSample(written[i], smp) {
+			continue
+		}
+		kept = append(kept, smp)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return kept
+}
+
+// compact merges the blocks whose samples start in the same CompactionRange
+// aligned window, once the window is older than BlockDuration and can no
+// longer get in-order samples. It must be called with compactMu held.
+func (s *Storage) compact() error {
+	rangeMicro := s.cfg.CompactionRange.Microseconds()
+	horizon := time.Now().Add(-s.cfg.BlockDuration).UnixMicro()
+
+	s.mu.RLock()
+	windows := make(map[int64][]*block)
+	for _, b := range s.blocks {
+		window := floorDiv(b.index.MinTime, rangeMicro)
+		windows[window] = append(windows[window], b)
+	}
+	s.mu.RUnlock()
+
+	for _, window := range slices.Sorted(maps.Keys(windows)) {
+		blocks := windows[window]
+		if len(blocks) < 2 || (window+1)*rangeMicro > horizon {
+			continue
+		}
+		if err := s.compactBlocks(blocks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) compactBlocks(blocks []*block) error {
+	_, compacted, err := s.rewriteBlocks(blocks, nil)
+	if err != nil {
+		return err
+	}
+	if compacted != nil {
+		s.log.Info("blocks compacted", slog.Int("blocks", len(blocks)), slog.String("into", filepath.Base(compacted.dir)), slog.Int("series", len(compacted.index.Series)))
+	}
+	return nil
+}
+
+// rewriteBlocks replaces blocks, sorted by Seq, with a single block holding
+// the samples keep accepts, all of them if keep is nil, or with no block if
+// none is left. The new block takes the highest Seq of the ones it replaces
+// so that it keeps their precedence over other blocks. It returns how many
+// samples were left out and must be called with compactMu held.
+func (s *Storage) rewriteBlocks(blocks []*block, keep func(identity core.SeriesIdentity, smp sample) bool) (int64, *block, error) {
+	var removed int64
+	merged := make(map[string]*blockData)
+	for _, b := range blocks {
+		for i := range b.index.Series {
+			bs := &b.index.Series[i]
+			key := bs.identity().Key()
+			samples, err := b.read(key, math.MinInt64, math.MaxInt64)
+			if err != nil {
+				return 0, nil, err
+			}
+			if keep != nil {
+				kept := samples[:0]
+				for _, smp := range samples {
+					if keep(bs.identity(), smp) {
+						kept = append(kept, smp)
+					}
+				}
+				removed += int64(len(samples) - len(kept))
+				samples = kept
+			}
+
+			d, ok := merged[key]
+			if !ok {
+				d = &blockData{identity: bs.identity()}
+				merged[key] = d
+			}
+			d.typ = bs.Type
+			d.samples = append(d.samples, samples...)
+		}
+	}
+
+	data := make([]blockData, 0, len(merged))
+	for _, key := range slices.Sorted(maps.Keys(merged)) {
+		d := merged[key]
+		if len(d.samples) == 0 {
+			continue
+		}
+		d.samples = mergeSamples(d.samples)
+		data = append(data, *d)
+	}
+
+	// The block is written without holding mu: compactMu keeps the set of
+	// blocks from changing, and readers use the old blocks until the swap.
+	var rewritten *block
+	if len(data) > 0 {
+		var err error
+		if rewritten, err = s.writeBlock(blocks[len(blocks)-1].index.Seq, data); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		if rewritten != nil {
+			s.removeBlocks([]*block{rewritten})
+		}
+		return 0, nil, nil
+	}
+
+	s.blocks = slices.DeleteFunc(s.blocks, func(b *block) bool {
+		return slices.Contains(blocks, b)
+	})
+	if rewritten != nil {
+		s.blocks = append(s.blocks, rewritten)
+		slices.SortStableFunc(s.blocks, func(a, b *block) int {
+			return cmp.Compare(a.index.Seq, b.index.Seq)
+		})
+	}
+
+	// The new block is in place, so a crash from here on at worst leaves
+	// some of the old blocks next to it.
+	s.removeBlocks(blocks)
+	return removed, rewritten, nil
+}
+
+// removeBlocks deletes blocks that are no longer in use. It must be called
+// with mu held.
+func (s *Storage) removeBlocks(blocks []*block) {
+	for _, b := range blocks {
+		if err := b.close(); err != nil {
+			s.log.Warn("failed to close block", slog.String("block", filepath.Base(b.dir)), slog.String("error", err.Error()))
+		}
+		if err := os.RemoveAll(b.dir); err != nil {
+			s.log.Warn("failed to remove block", slog.String("block", filepath.Base(b.dir)), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// writeBlock writes data as a block with the given Seq into a new directory
+// and opens it. It must be called with compactMu held.
+func (s *Storage) writeBlock(seq uint64, data []blockData) (*block, error) {
+	dir, err := s.newBlockDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBlock(dir, seq, data); err != nil {
+		return nil, err
+	}
+	return openBlock(dir)
+}
+
+// newBlockDir returns the path for a new block, numbered after the newest
+// block directory.
+func (s *Storage) newBlockDir() (string, error) {
+	ids, err := s.blockIDs()
+	if err != nil {
+		return "", err
+	}
+	next := uint64(1)
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+	return s.blockDir(next), nil
+}
+
+func (s *Storage) blockDir(id uint64) string {
+	return filepath.Join(s.cfg.Dir, blocksDir, fmt.Sprintf("%020d", id))
+}
+
+// blockIDs lists the block directories, sorted, removing the leftovers of
+// blocks whose writing was interrupted.
+func (s *Storage) blockIDs() ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.cfg.Dir, blocksDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), tmpExt) {
+			if err := os.RemoveAll(filepath.Join(s.cfg.Dir, blocksDir, entry.Name())); err != nil {
+				return nil, fmt.Errorf("failed to remove unfinished block: %w", err)
+			}
+			continue
+		}
+		id, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// openBlocks must be called before the storage is shared.
+func (s *Storage) openBlocks() error {
+	ids, err := s.blockIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		b, err := openBlock(s.blockDir(id))
+		if err != nil {
+			return fmt.Errorf("failed to open block %d: %w", id, err)
+		}
+		s.blocks = append(s.blocks, b)
+	}
+	slices.SortStableFunc(s.blocks, func(a, b *block) int {
+		return cmp.Compare(a.index.Seq, b.index.Seq)
+	})
+
+	for _, b := range s.blocks {
+		s.nextSeq = max(s.nextSeq, b.index.Seq+1)
+		for i := range b.index.Series {
+			bs := &b.index.Series[i]
+			entry := s.entry(bs.identity(), bs.Type)
+			if len(bs.Chunks) > 0 {
+				entry.inBlocks = true
+				entry.blockMaxTime = max(entry.blockMaxTime, bs.Chunks[len(bs.Chunks)-1].MaxTime)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Storage) closeBlocks() {
+	for _, b := range s.blocks {
+		if err := b.close(); err != nil {
+			s.log.Warn("failed to close block", slog.String("block", filepath.Base(b.dir)), slog.String("error", err.Error()))
+		}
+	}
+	s.blocks = nil
+}
+
+// mergeSamples sorts samples by time, keeping only the last of the samples
+// that share a time.
+func mergeSamples(samples []sample) []sample {
+	slices.SortStableFunc(samples, func(a, b sample) int {
+		return cmp.Compare(a.t, b.t)
+	})
+
+	merged := samples[:0]
+	for _, smp := range samples {
+		if len(merged) > 0 && merged[len(merged)-1].t == smp.t {
+			merged[len(merged)-1] = smp
+			continue
+		}
+		merged = append(merged, smp)
+	}
+	return merged
+}
+
+// sameSample compares values by their bits, so that NaN equals itself.
+func sameSample(a, b sample) bool {
+	return a.t == b.t && math.Float64bits(a.v) == math.Float64bits(b.v) && a.isAnomaly == b.isAnomaly
+}
+
+func searchSamples(samples []sample, t int64) (int, bool) {
+	return slices.BinarySearchFunc(samples, t, func(smp sample, t int64) int {
+		return cmp.Compare(smp.t, t)
+	})
+}
+
+func sampleKey(seriesKey string, t int64) string {
+	return seriesKey + "\x00" + strconv.FormatInt(t, 10)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func storedTime(t time.Time) time.Time {
+	return t.Round(time.Microsecond).UTC()
+}
+
+func seriesIdentityOf(metricIdentity core.MetricIdentity) core.SeriesIdentity {
+	return core.SeriesIdentity{
+		ServiceURL: metricIdentity.ServiceURL,
+		MetricName: metricIdentity.MetricName,
+		PodName:    metricIdentity.PodName,
+		Labels:     metricIdentity.Labels,
+	}
+}
+
+func metricIdentityOf(identity core.SeriesIdentity, t int64) core.MetricIdentity {
+	return core.MetricIdentity{
+		Time:       time.UnixMicro(t).UTC(),
+		ServiceURL: identity.ServiceURL,
+		MetricName: identity.MetricName,
+		PodName:    identity.PodName,
+		Labels:     identity.Labels,
+	}
+}
+
+func metricTypeOrGauge(metricType string) string {
+	if metricType == "" {
+		return core.MetricTypeGauge
+	}
+	return metricType
+}
@@ -0,0 +1,368 @@
+package tsdb
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var day = time.Now().UTC().Truncate(24 * time.Hour).Add(-72 * time.Hour)
+
+func openStorage(t *testing.T, dir, conflictPolicy string) *Storage {
+	t.Helper()
+
+	storage, err := Open(slog.New(slog.NewTextHandler(io.Discard, nil)), &config.TSDB{
+		Dir:                dir,
+		BlockDuration:      2 * time.Hour,
+		CompactionInterval: time.Minute,
+		CompactionRange:    24 * time.Hour,
+	}, conflictPolicy)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	return storage
+}
+
+func metricAt(offset time.Duration, value float64) core.Metric {
+	return core.Metric{
+		MetricIdentity: core.MetricIdentity{
+			Time:       day.Add(offset),
+			ServiceURL: "svc",
+			MetricName: "cpu",
+			PodName:    "pod",
+			Labels:     core.Labels{"env": "prod"},
+		},
+		Type:        core.MetricTypeGauge,
+		MetricValue: value,
+	}
+}
+
+func cut(t *testing.T, storage *Storage) {
+	t.Helper()
+
+	storage.compactMu.Lock()
+	defer storage.compactMu.Unlock()
+	if err := storage.cut(); err != nil {
+		t.Fatalf("failed to cut head: %v", err)
+	}
+}
+
+func blockDirs(t *testing.T, dir string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(dir, blocksDir))
+	if err != nil {
+		t.Fatalf("failed to list blocks: %v", err)
+	}
+	return len(entries)
+}
+
+func TestStorageKeepsDataAcrossBlocksCompactionAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	storage := openStorage(t, dir, core.ConflictPolicyReject)
+
+	anomaly := metricAt(2*time.Minute, 90)
+	anomaly.IsAnomaly = true
+	if _, err := storage.SaveBatch([]core.Metric{metricAt(0, 10), metricAt(time.Minute, 20), anomaly}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cut(t, storage)
+
+	// Conflicts are found in blocks as well as in the head.
+	if _, err := storage.Save(metricAt(time.Minute, 21)); !errors.Is(err, core.ErrDuplicateMetric) {
+		t.Fatalf("expected ErrDuplicateMetric for a sample in a block, got %v", err)
+	}
+	if _, err := storage.Save(metricAt(3*time.Minute, 40)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cut(t, storage)
+	if got := blockDirs(t, dir); got != 2 {
+		t.Fatalf("expected 2 blocks before compaction, got %d", got)
+	}
+
+	storage.compactMu.Lock()
+	err := storage.compact()
+	storage.compactMu.Unlock()
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if got := blockDirs(t, dir); got != 1 {
+		t.Fatalf("expected blocks of the same day to be compacted into 1, got %d", got)
+	}
+
+	if _, err := storage.SaveAnomalyAck(core.AnomalyAck{MetricIdentity: anomaly.MetricIdentity, Status: "resolved", AckedBy: "oncall"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// This one only lives in the head log when the storage is reopened
+	// without being closed, as after a crash.
+	if _, err := storage.Save(metricAt(4*time.Minute, 50)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened := openStorage(t, dir, core.ConflictPolicyReject)
+	defer reopened.Close()
+
+	metrics, err := reopened.FindRange(core.RangeQuery{
+		MetricName: "cpu",
+		Matchers:   []core.LabelMatcher{{Name: "env", Type: core.MatchEqual, Value: "prod"}},
+		Start:      day,
+		End:        day.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []float64{10, 20, 90, 40, 50}
+	if len(metrics) != len(want) {
+		t.Fatalf("expected %d metrics, got %d", len(want), len(metrics))
+	}
+	for i, metric := range metrics {
+		if metric.MetricValue != want[i] || !metric.Time.Equal(day.Add(time.Duration(i)*time.Minute)) {
+			t.Fatalf("metric %d: expected %v at %v, got %v at %v", i, want[i], day.Add(time.Duration(i)*time.Minute), metric.MetricValue, metric.Time)
+		}
+	}
+
+	aggregate, err := reopened.Aggregate(core.AggregateQuery{
+		MetricName: "cpu",
+		Start:      day,
+		End:        day.Add(time.Hour),
+		Interval:   time.Hour,
+		Function:   core.AggregationMax,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(aggregate) != 1 || aggregate[0].MetricValue != 90 {
+		t.Fatalf("expected a single bucket with max 90, got %+v", aggregate)
+	}
+
+	anomalies, err := reopened.FindAnomalies(core.AnomalyQuery{Start: day, End: day.Add(time.Hour), Context: time.Minute, Limit: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].MetricValue != 90 || len(anomalies[0].Context) != 3 {
+		t.Fatalf("expected the anomaly with 3 context points, got %+v", anomalies)
+	}
+	if anomalies[0].Ack == nil || anomalies[0].Ack.AckedBy != "oncall" {
+		t.Fatalf("expected the acknowledgement to survive a restart, got %+v", anomalies[0].Ack)
+	}
+}
+
+func TestConflictPoliciesAcrossBlocks(t *testing.T) {
+	cases := map[string]float64{
+		core.ConflictPolicyOverwrite: 3,
+		core.ConflictPolicyKeepFirst: 1,
+	}
+	for policy, want := range cases {
+		dir := t.TempDir()
+		storage := openStorage(t, dir, policy)
+
+		if _, err := storage.Save(metricAt(0, 1)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		cut(t, storage)
+		if _, err := storage.Save(metricAt(0, 2)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		cut(t, storage)
+		if _, err := storage.Save(metricAt(0, 3)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+
+		stored, err := storage.FindByMetricIdentity(metricAt(0, 0).MetricIdentity)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		if stored.MetricValue != want {
+			t.Fatalf("%s: expected value %v, got %v", policy, want, stored.MetricValue)
+		}
+
+		// Closing cuts the head, compaction must keep the winning sample.
+		if err := storage.Close(); err != nil {
+			t.Fatalf("%s: failed to close: %v", policy, err)
+		}
+		reopened := openStorage(t, dir, policy)
+		reopened.compactMu.Lock()
+		err = reopened.compact()
+		reopened.compactMu.Unlock()
+		if err != nil {
+			t.Fatalf("%s: failed to compact: %v", policy, err)
+		}
+
+		stored, err = reopened.FindByMetricIdentity(metricAt(0, 0).MetricIdentity)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		}
+		if stored.MetricValue != want {
+			t.Fatalf("%s: expected value %v after compaction, got %v", policy, want, stored.MetricValue)
+		}
+		reopened.Close()
+	}
+}
+
+func TestSeriesKeepsTypeAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	storage := openStorage(t, dir, core.ConflictPolicyReject)
+
+	if _, err := storage.Save(metricAt(0, 1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	reopened := openStorage(t, dir, core.ConflictPolicyReject)
+	defer reopened.Close()
+
+	counter := metricAt(time.Minute, 2)
+	counter.Type = core.MetricTypeCounter
+	if _, err := reopened.Save(counter); !errors.Is(err, core.ErrMetricTypeMismatch) {
+		t.Fatalf("expected ErrMetricTypeMismatch, got %v", err)
+	}
+
+	// A new series takes the type of its first sample in a batch.
+	newCounter := counter
+	newCounter.MetricName = "requests_total"
+	newGauge := newCounter
+	newGauge.Time = newGauge.Time.Add(time.Minute)
+	newGauge.Type = core.MetricTypeGauge
+	results, err := reopened.SaveBatch([]core.Metric{newCounter, newGauge})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Err != nil || !errors.Is(results[1].Err, core.ErrMetricTypeMismatch) {
+		t.Fatalf("expected the second sample to be rejected, got %+v", results)
+	}
+
+	stored, err := reopened.FindByMetricIdentity(metricAt(0, 0).MetricIdentity)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Type != core.MetricTypeGauge {
+		t.Fatalf("expected the series to stay a gauge, got %q", stored.Type)
+	}
+}
+
+func TestHeadLogKeepsNonFiniteValues(t *testing.T) {
+	dir := t.TempDir()
+	storage := openStorage(t, dir, core.ConflictPolicyReject)
+
+	values := []float64{math.NaN(), math.Inf(1), math.Inf(-1)}
+	metrics := make([]core.Metric, 0, len(values))
+	for i, value := range values {
+		metrics = append(metrics, metricAt(time.Duration(i)*time.Minute, value))
+	}
+	if _, err := storage.SaveBatch(metrics); err != nil {
+		t.Fatalf("non-finite values must be saved: %v", err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	reopened := openStorage(t, dir, core.ConflictPolicyReject)
+	defer reopened.Close()
+
+	for i, metric := range metrics {
+		stored, err := reopened.FindByMetricIdentity(metric.MetricIdentity)
+		if err != nil {
+			t.Fatalf("sample %d: unexpected error: %v", i, err)
+		}
+		if math.Float64bits(stored.MetricValue) != math.Float64bits(values[i]) {
+			t.Fatalf("sample %d: expected %v after replaying the head log, got %v", i, values[i], stored.MetricValue)
+		}
+	}
+}
+
+func TestCutKeepsSamplesWrittenMeanwhile(t *testing.T) {
+	dir := t.TempDir()
+	storage := openStorage(t, dir, core.ConflictPolicyReject)
+
+	const total = 300
+	done := make(chan error, 1)
+	go func() {
+		for i := range total {
+			if _, err := storage.Save(metricAt(time.Duration(i)*time.Second, float64(i))); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for range 5 {
+		cut(t, storage)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Stop without cutting the rest of the head, as a crash would, so that it
+	// has to come back from the head log.
+	storage.mu.Lock()
+	storage.closed = true
+	storage.closeBlocks()
+	if err := storage.headLog.close(); err != nil {
+		t.Fatalf("failed to close head log: %v", err)
+	}
+	storage.mu.Unlock()
+
+	reopened := openStorage(t, dir, core.ConflictPolicyReject)
+	defer reopened.Close()
+
+	for i := range total {
+		stored, err := reopened.FindByMetricIdentity(metricAt(time.Duration(i)*time.Second, 0).MetricIdentity)
+		if err != nil {
+			t.Fatalf("sample %d: unexpected error: %v", i, err)
+		}
+		if stored.MetricValue != float64(i) {
+			t.Fatalf("sample %d: expected %d, got %v", i, i, stored.MetricValue)
+		}
+	}
+}
+
+func TestRetentionRewritesAndDropsBlocks(t *testing.T) {
+	dir := t.TempDir()
+	storage := openStorage(t, dir, core.ConflictPolicyReject)
+	defer storage.Close()
+
+	debug := metricAt(0, 1)
+	debug.MetricName = "debug_depth"
+	if _, err := storage.SaveBatch([]core.Metric{metricAt(0, 10), debug, metricAt(time.Hour, 20)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cut(t, storage)
+	if _, err := storage.Save(metricAt(48*time.Hour, 30)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cut(t, storage)
+
+	deleted, err := storage.DeleteMetricsBefore([]string{"cpu"}, day.Add(30*time.Minute))
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 deleted sample, got %d (err=%v)", deleted, err)
+	}
+	metrics, err := storage.FindRange(core.RangeQuery{MetricName: "cpu", Start: day, End: day.Add(72 * time.Hour)})
+	if err != nil || len(metrics) != 2 || metrics[0].MetricValue != 20 {
+		t.Fatalf("expected the samples after the cutoff to be kept, got %+v (err=%v)", metrics, err)
+	}
+	if _, err := storage.FindByMetricIdentity(debug.MetricIdentity); err != nil {
+		t.Fatalf("other metrics in the rewritten block must be kept, got %v", err)
+	}
+
+	dropped, err := storage.DropBefore(day.Add(24 * time.Hour))
+	if err != nil || dropped != 1 {
+		t.Fatalf("expected 1 dropped block, got %d (err=%v)", dropped, err)
+	}
+	if got := blockDirs(t, dir); got != 1 {
+		t.Fatalf("expected 1 block left, got %d", got)
+	}
+	if _, err := storage.FindByMetricIdentity(debug.MetricIdentity); !errors.Is(err, core.ErrMetricNotFound) {
+		t.Fatalf("expected the dropped sample to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// recordVersion starts a binary record payload. JSON payloads written by
+// earlier versions start with '[' or 'n' and are still decoded.
+//
+//	version  byte, recordVersion
+//	count    uvarint
+//	metrics  count times:
+//	         seconds     varint, since the epoch
+//	         nanoseconds uvarint
+//	         service url, metric name, pod name, type: uvarint length + bytes
+//	         labels      uvarint count + name, value pairs sorted by name
+//	         value       uint64, big endian, IEEE 754 bits
+//	         anomaly     byte, 0 or 1
+//
+// Values are kept as raw bits so that NaN and infinities survive, JSON has
+// no representation for them.
+const recordVersion = 1
+
+var errMalformedPayload = errors.New("malformed payload")
+
+// EncodeMetrics encodes a batch of metrics as a record payload.
+func EncodeMetrics(metrics []core.Metric) []byte {
+	buf := []byte{recordVersion}
+	buf = binary.AppendUvarint(buf, uint64(len(metrics)))
+	for _, metric := range metrics {
+		buf = binary.AppendVarint(buf, metric.Time.Unix())
+		buf = binary.AppendUvarint(buf, uint64(metric.Time.Nanosecond()))
+		buf = appendString(buf, metric.ServiceURL)
+		buf = appendString(buf, metric.MetricName)
+		buf = appendString(buf, metric.PodName)
+		buf = appendString(buf, metric.Type)
+
+		names := make([]string, 0, len(metric.Labels))
+		for name := range metric.Labels {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		buf = binary.AppendUvarint(buf, uint64(len(names)))
+		for _, name := range names {
+			buf = appendString(buf, name)
+			buf = appendString(buf, metric.Labels[name])
+		}
+
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(metric.MetricValue))
+		if metric.IsAnomaly {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	}
+	return buf
+}
+
+// DecodeMetrics decodes a record payload written by EncodeMetrics, or the
+// JSON payload of an earlier version.
+func DecodeMetrics(payload []byte) ([]core.Metric, error) {
+	if len(payload) == 0 || payload[0] != recordVersion {
+		var metrics []core.Metric
+		if err := json.Unmarshal(payload, &metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	}
+
+	d := decoder{buf: payload[1:]}
+	count := d.uvarint()
+	// Every metric takes more than a byte, a larger count is corrupt.
+	if count > uint64(len(d.buf)) {
+		return nil, errMalformedPayload
+	}
+	metrics := make([]core.Metric, 0, count)
+	for range count {
+		var metric core.Metric
+		seconds, nanoseconds := d.varint(), d.uvarint()
+		if nanoseconds >= uint64(time.Second) {
+			return nil, errMalformedPayload
+		}
+		metric.Time = time.Unix(seconds, int64(nanoseconds)).UTC()
+		metric.ServiceURL = d.string()
+		metric.MetricName = d.string()
+		metric.PodName = d.string()
+		metric.Type = d.string()
+
+		labels := d.uvarint()
+		if labels > uint64(len(d.buf)) {
+			return nil, errMalformedPayload
+		}
+		if labels > 0 {
+			metric.Labels = make(core.Labels, labels)
+			for range labels {
+				name := d.string()
+				metric.Labels[name] = d.string()
+			}
+		}
+
+		metric.MetricValue = math.Float64frombits(d.uint64())
+		metric.IsAnomaly = d.byte() == 1
+		if d.err != nil {
+			return nil, d.err
+		}
+		metrics = append(metrics, metric)
+	}
+	if d.err != nil || len(d.buf) != 0 {
+		return nil, errMalformedPayload
+	}
+	return metrics, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads a payload front to back. The first read past the end or of a
+// malformed varint sets err, and every read after it returns zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	d.err = errMalformedPayload
+	d.buf = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	length := d.uvarint()
+	if length > uint64(len(d.buf)) {
+		d.fail()
+		return ""
+	}
+	s := string(d.buf[:length])
+	d.buf = d.buf[length:]
+	return s
+}
+
+func (d *decoder) uint64() uint64 {
+	if len(d.buf) < 8 {
+		d.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if len(d.buf) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+	return v
+}
@@ -0,0 +1,453 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// A segment is a sequence of records, each one a batch of metrics written by
+// a single Save or SaveBatch call:
+//
+//	length  uint32, big endian, of the payload
+//	crc     uint32, big endian, CRC-32C of the payload
+//	payload []core.Metric, see EncodeMetrics
+const (
+	segmentExt   = ".wal"
+	headerSize   = 8
+	maxRecordLen = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt wal record")
+
+// Log is a core.MetricRepository that makes writes durable in local segment
+// files before acknowledging them and replays them to the wrapped repository
+// in the background, so ingestion survives the database being unavailable.
+// Reads go straight to the wrapped repository.
+type Log struct {
+	core.MetricRepository
+	log *slog.Logger
+	cfg *config.WAL
+
+	mu          sync.Mutex
+	active      *os.File
+	activeIndex uint64
+	activeSize  int64
+
+	// Replay position, owned by the replaying goroutine.
+	replayIndex  uint64
+	replayOffset int64
+
+	wake chan struct{}
+}
+
+func New(log *slog.Logger, cfgWAL *config.WAL, repo core.MetricRepository) (*Log, error) {
+	if cfgWAL.Dir == "" || cfgWAL.SegmentSize <= 0 || cfgWAL.ReplayBatchSize <= 0 || cfgWAL.ReplayInterval <= 0 {
+		return nil, errors.New("wal requires a directory and a positive segment size, replay batch size and replay interval")
+	}
+	if err := os.MkdirAll(cfgWAL.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+
+	l := &Log{
+		MetricRepository: repo,
+		log:              log,
+		cfg:              cfgWAL,
+		wake:             make(chan struct{}, 1),
+	}
+
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Never append to a segment left by a previous run, its tail may be torn.
+	l.activeIndex = 1
+	if len(segments) > 0 {
+		l.activeIndex = segments[len(segments)-1] + 1
+		l.replayIndex = segments[0]
+		log.Info("found wal segments to replay", slog.Int("segments", len(segments)))
+	} else {
+		l.replayIndex = l.activeIndex
+	}
+
+	if err := l.openActive(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) Save(metric core.Metric) (*core.MetricIdentity, error) {
+	if err := l.append([]core.Metric{metric}); err != nil {
+		return nil, err
+	}
+	return &metric.MetricIdentity, nil
+}
+
+func (l *Log) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	if err := l.append(metrics); err != nil {
+		return nil, err
+	}
+
+	results := make([]core.MetricResult, 0, len(metrics))
+	for i, metric := range metrics {
+		results = append(results, core.MetricResult{Index: i, MetricIdentity: &metric.MetricIdentity})
+	}
+	return results, nil
+}
+
+// Run replays the log until ctx is done. Whatever is not replayed by then
+// stays on disk and is replayed after the next start.
+func (l *Log) Run(ctx context.Context) {
+	for {
+		// After a failure new writes must not trigger retries, only the
+		// interval does.
+		wake := l.wake
+		if err := l.replay(); err != nil {
+			l.log.Warn("wal replay failed, will retry", slog.Duration("retry_in", l.cfg.ReplayInterval), slog.String("error", err.Error()))
+			wake = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(l.cfg.ReplayInterval):
+		}
+	}
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+	err := l.active.Close()
+	l.active = nil
+	return err
+}
+
+func (l *Log) append(metrics []core.Metric) error {
+	payload := EncodeMetrics(metrics)
+	if len(payload) > maxRecordLen {
+		return fmt.Errorf("wal record of %d bytes exceeds the limit", len(payload))
+	}
+
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		return errors.New("wal is closed")
+	}
+	if l.activeSize > 0 && l.activeSize+int64(len(record)) > l.cfg.SegmentSize {
+		if err := l.rotate(); err != nil {
+			l.log.Error("failed to rotate wal segment", slog.String("error", err.Error()))
+			return fmt.Errorf("failed to rotate wal segment: %w", err)
+		}
+	}
+
+	if _, err := l.active.Write(record); err != nil {
+		l.log.Error("failed to write wal record", slog.String("error", err.Error()))
+		l.discardTail()
+		return fmt.Errorf("failed to write wal record: %w", err)
+	}
+	if err := l.active.Sync(); err != nil {
+		l.log.Error("failed to sync wal segment", slog.String("error", err.Error()))
+		l.discardTail()
+		return fmt.Errorf("failed to sync wal segment: %w", err)
+	}
+	l.activeSize += int64(len(record))
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// discardTail drops whatever a failed append left after the last
+// acknowledged record, so that the record is not replayed although the write
+// was rejected, and later records do not follow a torn one. If the segment
+// cannot be truncated, a new one is started instead. It must be called with
+// mu held.
+func (l *Log) discardTail() {
+	err := l.active.Truncate(l.activeSize)
+	if err == nil {
+		err = l.active.Sync()
+	}
+	if err == nil {
+		return
+	}
+
+	l.log.Error("failed to truncate wal segment after a failed append, rotating", slog.Uint64("segment", l.activeIndex), slog.String("error", err.Error()))
+	if err := l.rotate(); err != nil {
+		l.log.Error("failed to rotate wal segment", slog.String("error", err.Error()))
+	}
+}
+
+// rotate must be called with mu held.
+func (l *Log) rotate() error {
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	l.activeIndex++
+	return l.openActive()
+}
+
+// openActive must be called with mu held, or before the log is shared.
+func (l *Log) openActive() error {
+	file, err := os.OpenFile(l.segmentPath(l.activeIndex), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	if err := l.syncDir(); err != nil {
+		file.Close()
+		return err
+	}
+
+	l.active = file
+	l.activeSize = 0
+	l.log.Debug("wal segment opened", slog.Uint64("segment", l.activeIndex))
+	return nil
+}
+
+// replay hands every record written so far to the wrapped repository, removing
+// segments once they are replayed and truncating the active one when replay
+// catches up with it.
+func (l *Log) replay() error {
+	for {
+		l.mu.Lock()
+		activeIndex, activeSize := l.activeIndex, l.activeSize
+		l.mu.Unlock()
+
+		if l.replayIndex == activeIndex {
+			if l.replayOffset < activeSize {
+				if err := l.replaySegment(activeSize); err != nil {
+					return err
+				}
+			}
+			return l.truncateActive()
+		}
+
+		if err := l.replaySegment(-1); err != nil {
+			return err
+		}
+		if err := os.Remove(l.segmentPath(l.replayIndex)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove replayed wal segment: %w", err)
+		}
+		l.log.Info("wal segment replayed", slog.Uint64("segment", l.replayIndex))
+
+		next, err := l.nextSegment(l.replayIndex, activeIndex)
+		if err != nil {
+			return err
+		}
+		l.replayIndex, l.replayOffset = next, 0
+	}
+}
+
+// replaySegment replays the current replay segment from the replay offset up
+// to limit bytes, or to its end if limit is negative. A corrupt record ends
+// the replayed range: it is logged and everything after it is dropped.
+func (l *Log) replaySegment(limit int64) error {
+	file, err := os.Open(l.segmentPath(l.replayIndex))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment for replay: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(l.replayOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek wal segment: %w", err)
+	}
+	var reader io.Reader = file
+	if limit >= 0 {
+		reader = io.LimitReader(file, limit-l.replayOffset)
+	}
+	reader = bufio.NewReader(reader)
+
+	offset := l.replayOffset
+	batch := make([]core.Metric, 0, l.cfg.ReplayBatchSize)
+	for {
+		metrics, size, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			l.log.Warn("dropping the rest of a wal segment",
+				slog.Uint64("segment", l.replayIndex),
+				slog.Int64("offset", offset),
+				slog.String("error", err.Error()))
+			if limit >= 0 {
+				offset = limit
+			}
+			break
+		}
+
+		batch = append(batch, metrics...)
+		offset += size
+		if len(batch) >= l.cfg.ReplayBatchSize {
+			if err := l.flush(batch); err != nil {
+				return err
+			}
+			l.replayOffset = offset
+			batch = batch[:0]
+		}
+	}
+
+	if err := l.flush(batch); err != nil {
+		return err
+	}
+	l.replayOffset = offset
+	return nil
+}
+
+func (l *Log) flush(batch []core.Metric) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	results, err := l.MetricRepository.SaveBatch(batch)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		// Replaying a batch twice after a crash hits duplicates; they are
+		// expected and there is nothing to retry.
+		if result.Err != nil {
+			l.log.Warn("replayed metric rejected by storage", slog.Any("metric", batch[result.Index]), slog.String("error", result.Err.Error()))
+		}
+	}
+
+	l.log.Debug("wal records replayed", slog.Int("count", len(batch)))
+	return nil
+}
+
+// truncateActive empties the active segment if replay has caught up with it.
+func (l *Log) truncateActive() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil || l.replayIndex != l.activeIndex || l.replayOffset != l.activeSize || l.activeSize == 0 {
+		return nil
+	}
+
+	if err := l.active.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate wal segment: %w", err)
+	}
+	if err := l.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal segment: %w", err)
+	}
+	l.activeSize, l.replayOffset = 0, 0
+	l.log.Debug("wal segment truncated", slog.Uint64("segment", l.activeIndex))
+	return nil
+}
+
+func readRecord(reader io.Reader) ([]core.Metric, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, fmt.Errorf("%w: truncated header", errCorruptRecord)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordLen {
+		return nil, 0, fmt.Errorf("%w: record length %d", errCorruptRecord, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, fmt.Errorf("%w: truncated payload", errCorruptRecord)
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+
+	metrics, err := DecodeMetrics(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errCorruptRecord, err.Error())
+	}
+	return metrics, int64(headerSize + len(payload)), nil
+}
+
+// segments lists the indexes of the segment files in the directory, sorted.
+func (l *Log) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(l.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wal segments: %w", err)
+	}
+
+	indexes := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		index, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+	return indexes, nil
+}
+
+// nextSegment returns the first segment after index, which is at most active.
+func (l *Log) nextSegment(index, active uint64) (uint64, error) {
+	segments, err := l.segments()
+	if err != nil {
+		return 0, err
+	}
+	for _, segment := range segments {
+		if segment > index {
+			return min(segment, active), nil
+		}
+	}
+	return active, nil
+}
+
+func (l *Log) segmentPath(index uint64) string {
+	return filepath.Join(l.cfg.Dir, fmt.Sprintf("%020d%s", index, segmentExt))
+}
+
+// syncDir makes a newly created segment file survive a crash.
+func (l *Log) syncDir() error {
+	dir, err := os.Open(l.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to open wal directory: %w", err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// database stands in for TimescaleDB and can be taken down and brought back.
+type database struct {
+	core.MetricRepository
+	mu      sync.Mutex
+	down    bool
+	metrics []core.Metric
+}
+
+func (d *database) SaveBatch(metrics []core.Metric) ([]core.MetricResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+	d.metrics = append(d.metrics, metrics...)
+
+	results := make([]core.MetricResult, 0, len(metrics))
+	for i, metric := range metrics {
+		results = append(results, core.MetricResult{Index: i, MetricIdentity: &metric.MetricIdentity})
+	}
+	return results, nil
+}
+
+func (d *database) setDown(down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down = down
+}
+
+func (d *database) values() []float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	values := make([]float64, 0, len(d.metrics))
+	for _, metric := range d.metrics {
+		values = append(values, metric.MetricValue)
+	}
+	return values
+}
+
+func newTestLog(t *testing.T, dir string, repo core.MetricRepository) *Log {
+	t.Helper()
+
+	l, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), &config.WAL{
+		Dir:             dir,
+		SegmentSize:     1024,
+		ReplayBatchSize: 10,
+		ReplayInterval:  10 * time.Millisecond,
+	}, repo)
+	if err != nil {
+		t.Fatalf("failed to open wal: %v", err)
+	}
+	return l
+}
+
+func metric(value float64) core.Metric {
+	return core.Metric{
+		MetricIdentity: core.MetricIdentity{
+			Time:       time.Unix(int64(value), 0).UTC(),
+			ServiceURL: "svc",
+			MetricName: "requests_total",
+			PodName:    "pod",
+			Labels:     core.Labels{"method": "GET"},
+		},
+		Type:        core.MetricTypeCounter,
+		MetricValue: value,
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatalf("failed to list segments: %v", err)
+	}
+	return files
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogSurvivesDatabaseOutageMidStream(t *testing.T) {
+	dir := t.TempDir()
+	db := &database{}
+	l := newTestLog(t, dir, db)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	const total = 100
+	for i := range total {
+		if i == 30 {
+			db.setDown(true)
+		}
+		if _, err := l.Save(metric(float64(i))); err != nil {
+			t.Fatalf("write %d must be acknowledged while the database is down: %v", i, err)
+		}
+	}
+
+	if len(segmentFiles(t, dir)) < 2 {
+		t.Fatal("writes during the outage should have rotated segments")
+	}
+	if len(db.values()) >= total {
+		t.Fatal("writes during the outage must not reach the database")
+	}
+
+	db.setDown(false)
+	waitFor(t, func() bool { return len(db.values()) == total }, "wal was not replayed after the database came back")
+
+	for i, value := range db.values() {
+		if value != float64(i) {
+			t.Fatalf("metric %d replayed out of order: got value %v", i, value)
+		}
+	}
+
+	waitFor(t, func() bool {
+		files := segmentFiles(t, dir)
+		if len(files) != 1 {
+			return false
+		}
+		info, err := os.Stat(files[0])
+		return err == nil && info.Size() == 0
+	}, "replayed segments should be removed and the active one truncated")
+}
+
+func TestLogReplaysAfterRestartUpToCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := &database{down: true}
+
+	l := newTestLog(t, dir, db)
+	for _, value := range []float64{1, 2, 3} {
+		if _, err := l.Save(metric(value)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close wal: %v", err)
+	}
+
+	files := segmentFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected a single segment, got %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read segment: %v", err)
+	}
+	// Flip a byte in the payload of the second record.
+	recordSize := len(data) / 3
+	data[recordSize+headerSize+5] ^= 0xff
+	if err := os.WriteFile(files[0], data, 0o644); err != nil {
+		t.Fatalf("failed to corrupt segment: %v", err)
+	}
+
+	db.setDown(false)
+	l = newTestLog(t, dir, db)
+	defer l.Close()
+
+	if err := l.replay(); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	values := db.values()
+	if len(values) != 1 || values[0] != 1 {
+		t.Fatalf("expected only the record before the corrupt one to be replayed, got %v", values)
+	}
+	if _, err := os.Stat(files[0]); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("replayed segment from the previous run should be removed")
+	}
+}
+
+func TestLogDiscardsTheTailOfAFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	db := &database{down: true}
+
+	l := newTestLog(t, dir, db)
+	if _, err := l.Save(metric(1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A short write leaves part of a record behind the acknowledged ones.
+	l.mu.Lock()
+	if _, err := l.active.Write([]byte{0, 0, 0, 42, 1, 2}); err != nil {
+		l.mu.Unlock()
+		t.Fatalf("failed to write a partial record: %v", err)
+	}
+	l.discardTail()
+	l.mu.Unlock()
+
+	if _, err := l.Save(metric(2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close wal: %v", err)
+	}
+
+	db.setDown(false)
+	l = newTestLog(t, dir, db)
+	defer l.Close()
+
+	if err := l.replay(); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if values := db.values(); len(values) != 2 || values[0] != 1 || values[1] != 2 {
+		t.Fatalf("expected both acknowledged records to be replayed, got %v", values)
+	}
+}
+
+func TestLogReplaysNonFiniteValues(t *testing.T) {
+	dir := t.TempDir()
+	db := &database{down: true}
+
+	l := newTestLog(t, dir, db)
+	values := []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1.5}
+	metrics := make([]core.Metric, 0, len(values))
+	for i, value := range values {
+		m := metric(float64(i))
+		m.MetricValue = value
+		m.Type = core.MetricTypeGauge
+		metrics = append(metrics, m)
+	}
+	if _, err := l.SaveBatch(metrics); err != nil {
+		t.Fatalf("non-finite values must be written: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close wal: %v", err)
+	}
+
+	db.setDown(false)
+	l = newTestLog(t, dir, db)
+	defer l.Close()
+
+	if err := l.replay(); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.metrics) != len(metrics) {
+		t.Fatalf("expected %d metrics to be replayed, got %d", len(metrics), len(db.metrics))
+	}
+	for i, got := range db.metrics {
+		want := metrics[i]
+		if math.Float64bits(got.MetricValue) != math.Float64bits(want.MetricValue) {
+			t.Fatalf("metric %d: expected value %v, got %v", i, want.MetricValue, got.MetricValue)
+		}
+		if !got.Time.Equal(want.Time) || got.MetricName != want.MetricName || got.Type != want.Type || got.Labels.String() != want.Labels.String() {
+			t.Fatalf("metric %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+}
+
+func TestDecodeMetricsReadsJSONRecords(t *testing.T) {
+	metrics, err := DecodeMetrics([]byte(`[{"Time":"2024-05-01T10:00:00Z","ServiceURL":"svc","MetricName":"up","PodName":"pod","Labels":null,"Type":"gauge","MetricValue":1,"IsAnomaly":false}]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(metrics) != 1 || metrics[0].MetricName != "up" || metrics[0].MetricValue != 1 {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+}
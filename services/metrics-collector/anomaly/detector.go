@@ -0,0 +1,171 @@
+package anomaly
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// Detector scores samples against a window of the latest saved samples of
+// their series. Windows of series idle for longer than the idle timeout are
+// dropped.
+type Detector struct {
+	log         *slog.Logger
+	defaults    config.AnomalyThresholds
+	metrics     map[string]config.AnomalyThresholds
+	idleTimeout time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	series    map[string]*window
+	lastSweep time.Time
+}
+
+func NewDetector(log *slog.Logger, cfgAnomaly *config.Anomaly) (*Detector, error) {
+	if err := validateThresholds("default", cfgAnomaly.Default); err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]config.AnomalyThresholds, len(cfgAnomaly.Metrics))
+	for metricName, thresholds := range cfgAnomaly.Metrics {
+		thresholds = mergeThresholds(thresholds, cfgAnomaly.Default)
+		if err := validateThresholds(metricName, thresholds); err != nil {
+			return nil, err
+		}
+		metrics[metricName] = thresholds
+	}
+	if cfgAnomaly.IdleTimeout <= 0 {
+		return nil, fmt.Errorf("anomaly idle timeout must be positive, got %s", cfgAnomaly.IdleTimeout)
+	}
+
+	return &Detector{
+		log:         log,
+		defaults:    cfgAnomaly.Default,
+		metrics:     metrics,
+		idleTimeout: cfgAnomaly.IdleTimeout,
+		now:         time.Now,
+		series:      make(map[string]*window),
+	}, nil
+}
+
+// Detect scores metric without adding it to the window of its series, which
+// Observe does once the metric is saved.
+func (d *Detector) Detect(metric core.Metric) bool {
+	thresholds := d.thresholds(metric.MetricName)
+	identity := seriesIdentityOf(metric)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, ok := d.series[identity.Key()]
+	if !ok {
+		return false
+	}
+
+	isAnomaly := false
+	if w.len() >= thresholds.MinSamples {
+		scores := w.scores(metric.MetricValue)
+		isAnomaly = scores.zScore > thresholds.ZScoreThreshold ||
+			scores.ewma > thresholds.EWMAThreshold ||
+			scores.mad > thresholds.MADThreshold
+		if isAnomaly {
+			d.log.Debug("anomaly scores exceeded thresholds",
+				slog.Any("series", identity),
+				slog.Float64("value", metric.MetricValue),
+				slog.Float64("z_score", scores.zScore),
+				slog.Float64("ewma_score", scores.ewma),
+				slog.Float64("mad_score", scores.mad),
+			)
+		}
+	}
+
+	return isAnomaly
+}
+
+func (d *Detector) Observe(metric core.Metric) {
+	thresholds := d.thresholds(metric.MetricName)
+	key := seriesIdentityOf(metric).Key()
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastSweep) >= d.idleTimeout {
+		d.sweep(now)
+	}
+
+	w, ok := d.series[key]
+	if !ok {
+		w = newWindow(thresholds.WindowSize, thresholds.EWMAAlpha)
+		d.series[key] = w
+	}
+	w.push(metric.MetricValue)
+	w.lastSeen = now
+}
+
+// sweep drops the windows of idle series. It must be called with mu held.
+func (d *Detector) sweep(now time.Time) {
+	for key, w := range d.series {
+		if now.Sub(w.lastSeen) >= d.idleTimeout {
+			delete(d.series, key)
+		}
+	}
+	d.lastSweep = now
+}
+
+func seriesIdentityOf(metric core.Metric) core.SeriesIdentity {
+	return core.SeriesIdentity{
+		ServiceURL: metric.ServiceURL,
+		MetricName: metric.MetricName,
+		PodName:    metric.PodName,
+		Labels:     metric.Labels,
+	}
+}
+
+func (d *Detector) thresholds(metricName string) config.AnomalyThresholds {
+	if thresholds, ok := d.metrics[metricName]; ok {
+		return thresholds
+	}
+	return d.defaults
+}
+
+func validateThresholds(name string, thresholds config.AnomalyThresholds) error {
+	if thresholds.WindowSize <= 0 {
+		return fmt.Errorf("anomaly thresholds %q: window_size must be positive, got %d", name, thresholds.WindowSize)
+	}
+	if thresholds.MinSamples <= 0 || thresholds.MinSamples > thresholds.WindowSize {
+		return fmt.Errorf("anomaly thresholds %q: min_samples must be between 1 and window_size, got %d", name, thresholds.MinSamples)
+	}
+	if thresholds.EWMAAlpha <= 0 || thresholds.EWMAAlpha > 1 {
+		return fmt.Errorf("anomaly thresholds %q: ewma_alpha must be in (0, 1], got %v", name, thresholds.EWMAAlpha)
+	}
+	if thresholds.ZScoreThreshold <= 0 || thresholds.EWMAThreshold <= 0 || thresholds.MADThreshold <= 0 {
+		return fmt.Errorf("anomaly thresholds %q: z_score_threshold, ewma_threshold and mad_threshold must be positive", name)
+	}
+	return nil
+}
+
+func mergeThresholds(thresholds, defaults config.AnomalyThresholds) config.AnomalyThresholds {
+	if thresholds.WindowSize <= 0 {
+		thresholds.WindowSize = defaults.WindowSize
+	}
+	if thresholds.MinSamples <= 0 {
+		thresholds.MinSamples = defaults.MinSamples
+	}
+	if thresholds.ZScoreThreshold <= 0 {
+		thresholds.ZScoreThreshold = defaults.ZScoreThreshold
+	}
+	if thresholds.EWMAAlpha <= 0 {
+		thresholds.EWMAAlpha = defaults.EWMAAlpha
+	}
+	if thresholds.EWMAThreshold <= 0 {
+		thresholds.EWMAThreshold = defaults.EWMAThreshold
+	}
+	if thresholds.MADThreshold <= 0 {
+		thresholds.MADThreshold = defaults.MADThreshold
+	}
+	return thresholds
+}
@@ -0,0 +1,160 @@
+package anomaly
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestWindowScores(t *testing.T) {
+	w := newWindow(20, 0.3)
+	for i := range 20 {
+		w.push(10 + float64(i%2)*2)
+	}
+
+	// Mean 11 and standard deviation 1; median 11 and MAD 1.
+	got := w.scores(14)
+	if !almostEqual(got.zScore, 3) {
+		t.Fatalf("expected z-score 3, got %v", got.zScore)
+	}
+	if !almostEqual(got.mad, 3*madScale) {
+		t.Fatalf("expected MAD score %v, got %v", 3*madScale, got.mad)
+	}
+
+	// More than half of the window is the median, so MAD is zero and the
+	// mean absolute deviation of 0.5 is used instead.
+	w = newWindow(4, 0.3)
+	for _, v := range []float64{10, 10, 10, 12} {
+		w.push(v)
+	}
+	if got := w.scores(11).mad; !almostEqual(got, meanADScale/0.5) {
+		t.Fatalf("expected MAD score %v, got %v", meanADScale/0.5, got)
+	}
+
+	// With alpha 0.5, 10 then 20 give an EWMA of 15 and a variance of 25.
+	w = newWindow(2, 0.5)
+	w.push(10)
+	w.push(20)
+	if got := w.scores(25).ewma; !almostEqual(got, 2) {
+		t.Fatalf("expected EWMA score 2, got %v", got)
+	}
+}
+
+func TestFlatWindowScoresNothing(t *testing.T) {
+	w := newWindow(10, 0.3)
+	for range 10 {
+		w.push(5)
+	}
+	if got := w.scores(6); got.zScore != 0 || got.ewma != 0 || got.mad != 0 {
+		t.Fatalf("a step away from a flat window must not score, got %+v", got)
+	}
+}
+
+func newTestDetector(t *testing.T, cfgAnomaly config.Anomaly) *Detector {
+	t.Helper()
+
+	if cfgAnomaly.IdleTimeout == 0 {
+		cfgAnomaly.IdleTimeout = time.Hour
+	}
+	detector, err := NewDetector(slog.New(slog.NewTextHandler(io.Discard, nil)), &cfgAnomaly)
+	if err != nil {
+		t.Fatalf("failed to create detector: %v", err)
+	}
+	return detector
+}
+
+func metricOf(name string, value float64) core.Metric {
+	return core.Metric{
+		MetricIdentity: core.MetricIdentity{ServiceURL: "svc", MetricName: name, PodName: "pod"},
+		MetricValue:    value,
+	}
+}
+
+func TestDetectorThresholds(t *testing.T) {
+	detector := newTestDetector(t, config.Anomaly{
+		Default: config.AnomalyThresholds{
+			WindowSize:      20,
+			MinSamples:      5,
+			ZScoreThreshold: 3,
+			EWMAAlpha:       0.3,
+			EWMAThreshold:   math.MaxFloat64,
+			MADThreshold:    math.MaxFloat64,
+		},
+	})
+
+	for i := range 4 {
+		detector.Observe(metricOf("cpu", 10+float64(i%2)*2))
+	}
+	if detector.Detect(metricOf("cpu", 100)) {
+		t.Fatal("no sample is an anomaly before min_samples are seen")
+	}
+
+	for i := range 16 {
+		detector.Observe(metricOf("cpu", 10+float64(i%2)*2))
+	}
+	if detector.Detect(metricOf("cpu", 13.9)) {
+		t.Fatal("a z-score below the threshold must not be an anomaly")
+	}
+	for range 2 {
+		if !detector.Detect(metricOf("cpu", 14.1)) {
+			t.Fatal("a z-score above the threshold must be an anomaly")
+		}
+	}
+	if got := detector.series[seriesIdentityOf(metricOf("cpu", 0)).Key()].len(); got != 20 {
+		t.Fatalf("detect must not add to the window, got %d samples", got)
+	}
+	if detector.Detect(metricOf("memory", 100)) {
+		t.Fatal("a series without history must not be an anomaly")
+	}
+}
+
+func TestNewDetectorValidatesThresholds(t *testing.T) {
+	defaults := config.AnomalyThresholds{WindowSize: 10, MinSamples: 5, ZScoreThreshold: 3, EWMAAlpha: 0.3, EWMAThreshold: 3, MADThreshold: 3.5}
+	negative := defaults
+	negative.MADThreshold = -1
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	invalid := map[string]config.Anomaly{
+		"window size": {Default: config.AnomalyThresholds{MinSamples: 5, EWMAAlpha: 0.3}, IdleTimeout: time.Hour},
+		"min samples": {Default: defaults, Metrics: map[string]config.AnomalyThresholds{"cpu": {WindowSize: 3}}, IdleTimeout: time.Hour},
+		"ewma alpha":  {Default: config.AnomalyThresholds{WindowSize: 10, MinSamples: 5, EWMAAlpha: 1.5}, IdleTimeout: time.Hour},
+		"idle":        {Default: defaults},
+		"threshold":   {Default: negative, IdleTimeout: time.Hour},
+	}
+	for name, cfgAnomaly := range invalid {
+		if _, err := NewDetector(logger, &cfgAnomaly); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDetectorDropsIdleSeries(t *testing.T) {
+	detector := newTestDetector(t, config.Anomaly{
+		Default:     config.AnomalyThresholds{WindowSize: 10, MinSamples: 5, ZScoreThreshold: 3, EWMAAlpha: 0.3, EWMAThreshold: 3, MADThreshold: 3.5},
+		IdleTimeout: time.Minute,
+	})
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	detector.Observe(metricOf("cpu", 1))
+	now = now.Add(30 * time.Second)
+	detector.Observe(metricOf("memory", 1))
+	now = now.Add(45 * time.Second)
+	detector.Observe(metricOf("memory", 2))
+
+	if _, ok := detector.series[seriesIdentityOf(metricOf("cpu", 0)).Key()]; ok {
+		t.Fatal("expected the idle series to be dropped")
+	}
+	if _, ok := detector.series[seriesIdentityOf(metricOf("memory", 0)).Key()]; !ok {
+		t.Fatal("expected the active series to be kept")
+	}
+}
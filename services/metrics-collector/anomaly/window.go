@@ -0,0 +1,133 @@
+package anomaly
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// madScale makes the median absolute deviation comparable to a standard
+// deviation for normally distributed data (the modified z-score).
+// meanADScale does the same for the mean absolute deviation, which is used
+// when more than half of the window holds the same value and MAD is zero.
+const (
+	madScale    = 0.6745
+	meanADScale = 0.7979
+)
+
+type window struct {
+	values   []float64
+	next     int
+	full     bool
+	lastSeen time.Time
+
+	alpha   float64
+	ewma    float64
+	ewmVar  float64
+	started bool
+}
+
+type scores struct {
+	zScore float64
+	ewma   float64
+	mad    float64
+}
+
+func newWindow(size int, alpha float64) *window {
+	return &window{
+		values: make([]float64, 0, size),
+		alpha:  alpha,
+	}
+}
+
+func (w *window) len() int {
+	return len(w.values)
+}
+
+func (w *window) push(value float64) {
+	if !w.full {
+		w.values = append(w.values, value)
+		w.full = len(w.values) == cap(w.values)
+	} else {
+		w.values[w.next] = value
+		w.next = (w.next + 1) % len(w.values)
+	}
+
+	if !w.started {
+		w.ewma, w.started = value, true
+		return
+	}
+	diff := value - w.ewma
+	incr := w.alpha * diff
+	w.ewma += incr
+	w.ewmVar = (1 - w.alpha) * (w.ewmVar + diff*incr)
+}
+
+func (w *window) scores(value float64) scores {
+	mean, std := meanStd(w.values)
+	median, mad := medianMAD(w.values)
+
+	madSpread := mad / madScale
+	if mad == 0 {
+		madSpread = meanAbsDeviation(w.values, median) / meanADScale
+	}
+
+	return scores{
+		zScore: deviation(value, mean, std),
+		ewma:   deviation(value, w.ewma, math.Sqrt(w.ewmVar)),
+		mad:    deviation(value, median, madSpread),
+	}
+}
+
+// deviation is how many spreads value lies from center. A window without
+// spread, such as a constant gauge or an idle counter, gives no scale to
+// measure a change against, so it scores nothing rather than flagging every
+// step.
+func deviation(value, center, spread float64) float64 {
+	if spread == 0 {
+		return 0
+	}
+	return math.Abs(value-center) / spread
+}
+
+func meanStd(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+func medianMAD(values []float64) (float64, float64) {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	median := medianSorted(sorted)
+
+	for i, v := range sorted {
+		sorted[i] = math.Abs(v - median)
+	}
+	slices.Sort(sorted)
+	return median, medianSorted(sorted)
+}
+
+func meanAbsDeviation(values []float64, center float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += math.Abs(v - center)
+	}
+	return sum / float64(len(values))
+}
+
+func medianSorted(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"path/filepath"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -12,12 +13,198 @@ type DB struct {
 	PoolMinConns int32  `yaml:"pool_min_conns" env:"POOL_MIN_CONNS"`
 }
 
+type Stream struct {
+	BatchSize     int           `yaml:"batch_size" env:"STREAM_BATCH_SIZE" env-default:"100"`
+	FlushInterval time.Duration `yaml:"flush_interval" env:"STREAM_FLUSH_INTERVAL" env-default:"1s"`
+}
+
+// Prometheus maps the service URL and pod name of series onto Prometheus
+// labels for the remote write receiver and the query API.
+type Prometheus struct {
+	ServiceLabel  string        `yaml:"service_label" env:"PROMETHEUS_SERVICE_LABEL" env-default:"job"`
+	PodLabel      string        `yaml:"pod_label" env:"PROMETHEUS_POD_LABEL" env-default:"instance"`
+	LookbackDelta time.Duration `yaml:"lookback_delta" env:"PROMETHEUS_LOOKBACK_DELTA" env-default:"5m"`
+}
+
+// RemoteWrite.MaxPastAge replaces the ingestion max_past_age for remote write,
+// since Prometheus resends samples as old as its own WAL after an outage. Zero
+// accepts samples of any age.
+type RemoteWrite struct {
+	BatchSize  int           `yaml:"batch_size" env:"REMOTE_WRITE_BATCH_SIZE" env-default:"1000"`
+	MaxPastAge time.Duration `yaml:"max_past_age" env:"REMOTE_WRITE_MAX_PAST_AGE" env-default:"0"`
+}
+
+// OTLP maps resource attributes onto the series identity. The pod name comes
+// from the first of PodAttributes that is set, and only the promoted resource
+// attributes become labels, next to the data point attributes.
+type OTLP struct {
+	PodAttributes             []string `yaml:"pod_attributes" env:"OTLP_POD_ATTRIBUTES" env-separator:"," env-default:"k8s.pod.name,service.instance.id,host.name"`
+	PromoteResourceAttributes []string `yaml:"promote_resource_attributes" env:"OTLP_PROMOTE_RESOURCE_ATTRIBUTES" env-separator:","`
+	BatchSize                 int      `yaml:"batch_size" env:"OTLP_BATCH_SIZE" env-default:"1000"`
+}
+
+type Ingestion struct {
+	MaxPastAge     time.Duration `yaml:"max_past_age" env:"INGESTION_MAX_PAST_AGE" env-default:"1h"`
+	MaxFutureSkew  time.Duration `yaml:"max_future_skew" env:"INGESTION_MAX_FUTURE_SKEW" env-default:"5m"`
+	ConflictPolicy string        `yaml:"conflict_policy" env:"INGESTION_CONFLICT_POLICY" env-default:"reject"`
+}
+
+// WriteBuffer acknowledges writes once they are queued, before storage has
+// seen them. Batches larger than QueueSize are rejected.
+type WriteBuffer struct {
+	Enabled       bool          `yaml:"enabled" env:"WRITE_BUFFER_ENABLED"`
+	QueueSize     int           `yaml:"queue_size" env:"WRITE_BUFFER_QUEUE_SIZE" env-default:"10000"`
+	Workers       int           `yaml:"workers" env:"WRITE_BUFFER_WORKERS" env-default:"2"`
+	BatchSize     int           `yaml:"batch_size" env:"WRITE_BUFFER_BATCH_SIZE" env-default:"500"`
+	FlushInterval time.Duration `yaml:"flush_interval" env:"WRITE_BUFFER_FLUSH_INTERVAL" env-default:"1s"`
+	// Failed flushes are retried with a backoff growing from InitialBackoff
+	// to MaxBackoff. On shutdown they are retried for up to ShutdownTimeout.
+	InitialBackoff  time.Duration `yaml:"initial_backoff" env:"WRITE_BUFFER_INITIAL_BACKOFF" env-default:"100ms"`
+	MaxBackoff      time.Duration `yaml:"max_backoff" env:"WRITE_BUFFER_MAX_BACKOFF" env-default:"10s"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"WRITE_BUFFER_SHUTDOWN_TIMEOUT" env-default:"30s"`
+}
+
+type WAL struct {
+	Enabled         bool          `yaml:"enabled" env:"WAL_ENABLED"`
+	Dir             string        `yaml:"dir" env:"WAL_DIR" env-default:"/var/lib/metrics-collector/wal"`
+	SegmentSize     int64         `yaml:"segment_size" env:"WAL_SEGMENT_SIZE" env-default:"67108864"`
+	ReplayBatchSize int           `yaml:"replay_batch_size" env:"WAL_REPLAY_BATCH_SIZE" env-default:"1000"`
+	ReplayInterval  time.Duration `yaml:"replay_interval" env:"WAL_REPLAY_INTERVAL" env-default:"1s"`
+}
+
+type TSDB struct {
+	Dir                string        `yaml:"dir" env:"TSDB_DIR" env-default:"/var/lib/metrics-collector/tsdb"`
+	BlockDuration      time.Duration `yaml:"block_duration" env:"TSDB_BLOCK_DURATION" env-default:"2h"`
+	CompactionInterval time.Duration `yaml:"compaction_interval" env:"TSDB_COMPACTION_INTERVAL" env-default:"1m"`
+	CompactionRange    time.Duration `yaml:"compaction_range" env:"TSDB_COMPACTION_RANGE" env-default:"24h"`
+}
+
+type RetentionRule struct {
+	Name       string        `yaml:"name"`
+	MetricName string        `yaml:"metric_name"`
+	Retention  time.Duration `yaml:"retention"`
+}
+
+type Retention struct {
+	Enabled       bool          `yaml:"enabled" env:"RETENTION_ENABLED" env-default:"true"`
+	Interval      time.Duration `yaml:"interval" env:"RETENTION_INTERVAL" env-default:"1h"`
+	Default       time.Duration `yaml:"default" env:"RETENTION_DEFAULT" env-default:"168h"`
+	CompressAfter time.Duration `yaml:"compress_after" env:"RETENTION_COMPRESS_AFTER" env-default:"12h"`
+	// Rules override Default by metric name. With postgres storage the
+	// shortest retention also bounds how far back the rollups are refreshed.
+	Rules []RetentionRule `yaml:"rules"`
+}
+
+type AnomalyThresholds struct {
+	WindowSize      int     `yaml:"window_size" env-default:"60"`
+	MinSamples      int     `yaml:"min_samples" env-default:"10"`
+	ZScoreThreshold float64 `yaml:"z_score_threshold" env-default:"3"`
+	EWMAAlpha       float64 `yaml:"ewma_alpha" env-default:"0.3"`
+	EWMAThreshold   float64 `yaml:"ewma_threshold" env-default:"3"`
+	MADThreshold    float64 `yaml:"mad_threshold" env-default:"3.5"`
+}
+
+type Anomaly struct {
+	Enabled bool                         `yaml:"enabled" env:"ANOMALY_ENABLED"`
+	Default AnomalyThresholds            `yaml:"default"`
+	Metrics map[string]AnomalyThresholds `yaml:"metrics"`
+	// IdleTimeout is how long the window of a series without new samples is
+	// kept.
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"ANOMALY_IDLE_TIMEOUT" env-default:"1h"`
+}
+
+type AlertRule struct {
+	Name        string            `yaml:"name"`
+	ServiceURL  string            `yaml:"service_url"`
+	MetricName  string            `yaml:"metric_name"`
+	PodName     string            `yaml:"pod_name"`
+	Function    string            `yaml:"function"`
+	Percentile  float64           `yaml:"percentile"`
+	GroupBy     string            `yaml:"group_by"`
+	Window      time.Duration     `yaml:"window"`
+	Operator    string            `yaml:"operator"`
+	Threshold   float64           `yaml:"threshold"`
+	For         time.Duration     `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type Alerting struct {
+	Enabled            bool          `yaml:"enabled" env:"ALERTING_ENABLED"`
+	EvaluationInterval time.Duration `yaml:"evaluation_interval" env:"ALERTING_EVALUATION_INTERVAL" env-default:"30s"`
+	RulesPath          string        `yaml:"rules_path" env:"ALERTING_RULES_PATH"`
+	Rules              []AlertRule   `yaml:"rules"`
+}
+
+type Notifier struct {
+	Enabled        bool          `yaml:"enabled" env:"NOTIFIER_ENABLED"`
+	Webhooks       []string      `yaml:"webhooks" env:"NOTIFIER_WEBHOOKS" env-separator:","`
+	ExternalURL    string        `yaml:"external_url" env:"NOTIFIER_EXTERNAL_URL"`
+	Timeout        time.Duration `yaml:"timeout" env-default:"5s"`
+	PollInterval   time.Duration `yaml:"poll_interval" env-default:"1s"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env-default:"1s"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"5m"`
+	MaxAttempts    int           `yaml:"max_attempts" env-default:"10"`
+	BatchSize      int           `yaml:"batch_size" env-default:"100"`
+	// Retention is how long delivered and failed notifications are kept, zero
+	// keeps them forever.
+	Retention time.Duration `yaml:"retention" env-default:"168h"`
+}
+
+type ScrapeTarget struct {
+	ServiceURL string        `yaml:"service_url"`
+	URL        string        `yaml:"url"`
+	PodName    string        `yaml:"pod_name"`
+	Interval   time.Duration `yaml:"interval"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+type Scrape struct {
+	Enabled         bool           `yaml:"enabled" env:"SCRAPE_ENABLED"`
+	DefaultInterval time.Duration  `yaml:"default_interval" env-default:"15s"`
+	DefaultTimeout  time.Duration  `yaml:"default_timeout" env-default:"5s"`
+	Targets         []ScrapeTarget `yaml:"targets"`
+}
+
+// StatsD series take the service URL and pod name from the service and pod
+// tags. Without them they fall back to ServiceURL and the sender address.
+type StatsD struct {
+	Enabled       bool          `yaml:"enabled" env:"STATSD_ENABLED"`
+	Address       string        `yaml:"address" env:"STATSD_ADDRESS" env-default:":8125"`
+	FlushInterval time.Duration `yaml:"flush_interval" env:"STATSD_FLUSH_INTERVAL" env-default:"10s"`
+	ServiceTag    string        `yaml:"service_tag" env:"STATSD_SERVICE_TAG" env-default:"service"`
+	PodTag        string        `yaml:"pod_tag" env:"STATSD_POD_TAG" env-default:"host"`
+	ServiceURL    string        `yaml:"service_url" env:"STATSD_SERVICE_URL" env-default:"statsd"`
+	Quantiles     []float64     `yaml:"quantiles" env:"STATSD_QUANTILES" env-separator:"," env-default:"0.5,0.9,0.99"`
+	// TimerSamples caps the values a timer keeps per interval for its
+	// quantiles; the sum and the count take every value.
+	TimerSamples int `yaml:"timer_samples" env:"STATSD_TIMER_SAMPLES" env-default:"1000"`
+	// IdleIntervals is how many flush intervals a counter or gauge is kept
+	// without updates. A counter updated after that starts over from zero.
+	IdleIntervals int `yaml:"idle_intervals" env:"STATSD_IDLE_INTERVALS" env-default:"6"`
+}
+
 type Config struct {
 	LogLevel    string        `yaml:"log_level" env:"LOG_LEVEL"`
 	AppAddress  string        `yaml:"app_address" env:"APP_ADDRESS"`
 	GRPCAddress string        `yaml:"grpc_address" env:"GRPC_ADDRESS"`
 	ReadTimeout time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT"`
+	Storage     string        `yaml:"storage" env:"STORAGE" env-default:"postgres"`
 	DB          DB            `yaml:"db"`
+	TSDB        TSDB          `yaml:"tsdb"`
+	Stream      Stream        `yaml:"stream"`
+	Prometheus  Prometheus    `yaml:"prometheus"`
+	RemoteWrite RemoteWrite   `yaml:"remote_write"`
+	OTLP        OTLP          `yaml:"otlp"`
+	Ingestion   Ingestion     `yaml:"ingestion"`
+	WriteBuffer WriteBuffer   `yaml:"write_buffer"`
+	WAL         WAL           `yaml:"wal"`
+	Retention   Retention     `yaml:"retention"`
+	Anomaly     Anomaly       `yaml:"anomaly"`
+	Alerting    Alerting      `yaml:"alerting"`
+	Notifier    Notifier      `yaml:"notifier"`
+	Scrape      Scrape        `yaml:"scrape"`
+	StatsD      StatsD        `yaml:"statsd"`
 }
 
 func MustLoad(configPath string) *Config {
@@ -25,5 +212,21 @@ func MustLoad(configPath string) *Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		log.Fatalf("cannot read config %q: %s", configPath, err)
 	}
+
+	if cfg.Alerting.RulesPath != "" {
+		rulesPath := cfg.Alerting.RulesPath
+		if !filepath.IsAbs(rulesPath) {
+			rulesPath = filepath.Join(filepath.Dir(configPath), rulesPath)
+		}
+
+		var rules struct {
+			Rules []AlertRule `yaml:"rules"`
+		}
+		if err := cleanenv.ReadConfig(rulesPath, &rules); err != nil {
+			log.Fatalf("cannot read alert rules %q: %s", rulesPath, err)
+		}
+		cfg.Alerting.Rules = append(cfg.Alerting.Rules, rules.Rules...)
+	}
+
 	return &cfg
 }
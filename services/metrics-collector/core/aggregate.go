@@ -0,0 +1,88 @@
+package core
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// DefaultBucketOrigin is the origin time_bucket uses when none is given.
+var DefaultBucketOrigin = time.Date(2000, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+// AggregatePoints evaluates an aggregation function over points with the same
+// semantics as the database adapter, for repositories that aggregate in
+// process. It reports false for an unknown function or no points.
+func AggregatePoints(function string, points []Point, percentile float64) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	switch function {
+	case AggregationAvg:
+		return sumPoints(points) / float64(len(points)), true
+	case AggregationMin:
+		result := math.Inf(1)
+		for _, point := range points {
+			result = min(result, point.Value)
+		}
+		return result, true
+	case AggregationMax:
+		result := math.Inf(-1)
+		for _, point := range points {
+			result = max(result, point.Value)
+		}
+		return result, true
+	case AggregationSum:
+		return sumPoints(points), true
+	case AggregationCount:
+		return float64(len(points)), true
+	case AggregationFirst:
+		return slices.MinFunc(points, func(a, b Point) int { return a.Time.Compare(b.Time) }).Value, true
+	case AggregationLast:
+		return slices.MaxFunc(points, func(a, b Point) int { return a.Time.Compare(b.Time) }).Value, true
+	case AggregationPercentile:
+		return percentileCont(points, percentile), true
+	default:
+		return 0, false
+	}
+}
+
+// IsAggregationFunction reports whether AggregatePoints supports function.
+func IsAggregationFunction(function string) bool {
+	_, ok := AggregatePoints(function, []Point{{}}, 0)
+	return ok
+}
+
+// TimeBucket returns the start of the interval-wide bucket aligned to origin
+// that t falls into, like time_bucket.
+func TimeBucket(t time.Time, interval time.Duration, origin time.Time) time.Time {
+	offset := t.Sub(origin)
+	bucket := offset / interval
+	if offset < 0 && offset%interval != 0 {
+		bucket--
+	}
+	return origin.Add(bucket * interval).UTC()
+}
+
+func sumPoints(points []Point) float64 {
+	sum := 0.0
+	for _, point := range points {
+		sum += point.Value
+	}
+	return sum
+}
+
+// percentileCont interpolates linearly between the closest ranks, like
+// percentile_cont.
+func percentileCont(points []Point, percentile float64) float64 {
+	values := make([]float64, 0, len(points))
+	for _, point := range points {
+		values = append(values, point.Value)
+	}
+	slices.Sort(values)
+
+	rank := percentile * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+type alertKey struct {
+	rule   string
+	series string
+}
+
+type seriesValue struct {
+	series SeriesIdentity
+	value  float64
+}
+
+type AlertService struct {
+	log      *slog.Logger
+	repo     MetricRepository
+	notifier AlertNotifier
+	rules    []AlertRule
+
+	mu     sync.Mutex
+	alerts map[alertKey]*Alert
+}
+
+func NewAlertService(log *slog.Logger, repo MetricRepository, notifier AlertNotifier, rules []AlertRule) (*AlertService, error) {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if err := validateAlertRule(rule); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: duplicate rule name %q", ErrInvalidAlertRule, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return &AlertService{
+		log:      log,
+		repo:     repo,
+		notifier: notifier,
+		rules:    rules,
+		alerts:   make(map[alertKey]*Alert),
+	}, nil
+}
+
+func (s *AlertService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Evaluate(now.UTC())
+		}
+	}
+}
+
+func (s *AlertService) Evaluate(now time.Time) []Alert {
+	changed := make([]Alert, 0)
+	for _, rule := range s.rules {
+		values, err := s.evaluateRule(rule, now)
+		if err != nil {
+			s.log.Error("failed to evaluate alert rule", slog.String("rule", rule.Name), slog.String("error", err.Error()))
+			continue
+		}
+		changed = append(changed, s.transition(rule, values, now)...)
+	}
+
+	notify := make([]Alert, 0, len(changed))
+	for _, alert := range changed {
+		s.log.Info("alert state changed",
+			slog.String("rule", alert.Rule),
+			slog.Any("series", alert.Series),
+			slog.String("state", alert.State),
+			slog.Float64("value", alert.Value),
+		)
+		if alert.State != AlertStatePending {
+			notify = append(notify, alert)
+		}
+	}
+
+	if s.notifier != nil && len(notify) > 0 {
+		s.notifier.Notify(notify)
+	}
+	return changed
+}
+
+func (s *AlertService) Alerts() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(s.alerts))
+	for _, alert := range s.alerts {
+		alerts = append(alerts, *alert)
+	}
+	slices.SortFunc(alerts, func(a, b Alert) int {
+		return cmp.Or(
+			cmp.Compare(a.Rule, b.Rule),
+			cmp.Compare(a.Series.ServiceURL, b.Series.ServiceURL),
+			cmp.Compare(a.Series.PodName, b.Series.PodName),
+		)
+	})
+	return alerts
+}
+
+func (s *AlertService) evaluateRule(rule AlertRule, now time.Time) (map[string]seriesValue, error) {
+	end := now.Truncate(time.Microsecond).Add(-time.Microsecond)
+	start := end.Add(-rule.Window)
+	metrics, err := s.repo.Aggregate(AggregateQuery{
+		ServiceURL: rule.ServiceURL,
+		MetricName: rule.MetricName,
+		PodName:    rule.PodName,
+		Start:      start,
+		End:        end,
+		Interval:   rule.Window,
+		Function:   rule.Function,
+		Percentile: rule.Percentile,
+		GroupBy:    rule.GroupBy,
+		Origin:     start,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]seriesValue, len(metrics))
+	for _, metric := range metrics {
+		identity := SeriesIdentity{
+			ServiceURL: metric.ServiceURL,
+			MetricName: metric.MetricName,
+			PodName:    metric.PodName,
+			Labels:     metric.Labels,
+		}
+		values[identity.Key()] = seriesValue{series: identity, value: metric.MetricValue}
+	}
+	return values, nil
+}
+
+func (s *AlertService) transition(rule AlertRule, values map[string]seriesValue, now time.Time) []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := make([]Alert, 0)
+	for series, sv := range values {
+		value := sv.value
+		if !compare(value, rule.Operator, rule.Threshold) {
+			continue
+		}
+
+		key := alertKey{rule: rule.Name, series: series}
+		alert, ok := s.alerts[key]
+		if !ok {
+			alert = &Alert{
+				Rule:        rule.Name,
+				Series:      sv.series,
+				Labels:      rule.Labels,
+				Annotations: rule.Annotations,
+				State:       AlertStatePending,
+				ActiveAt:    now,
+			}
+			s.alerts[key] = alert
+			if rule.For > 0 {
+				alert.Value = value
+				changed = append(changed, *alert)
+				continue
+			}
+		}
+
+		alert.Value = value
+		if alert.State == AlertStatePending && now.Sub(alert.ActiveAt) >= rule.For {
+			alert.State = AlertStateFiring
+			alert.FiredAt = now
+			changed = append(changed, *alert)
+		}
+	}
+
+	for key, alert := range s.alerts {
+		if key.rule != rule.Name {
+			continue
+		}
+		if sv, ok := values[key.series]; ok && compare(sv.value, rule.Operator, rule.Threshold) {
+			continue
+		}
+
+		// A resolved alert is only reported once; it fires again as a new
+		// alert.
+		if alert.State == AlertStateFiring {
+			alert.State = AlertStateResolved
+			alert.ResolvedAt = now
+			changed = append(changed, *alert)
+		}
+		delete(s.alerts, key)
+	}
+
+	return changed
+}
+
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	default:
+		return false
+	}
+}
+
+func validateAlertRule(rule AlertRule) error {
+	if rule.Name == "" || rule.MetricName == "" || rule.Window <= 0 || rule.For < 0 {
+		return fmt.Errorf("%w: rule %q requires name, metric_name and a positive window", ErrInvalidAlertRule, rule.Name)
+	}
+
+	switch rule.Operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("%w: rule %q has unsupported operator %q", ErrInvalidAlertRule, rule.Name, rule.Operator)
+	}
+
+	query := AggregateQuery{
+		MetricName: rule.MetricName,
+		Start:      time.Unix(0, 0),
+		End:        time.Unix(0, 0).Add(rule.Window),
+		Interval:   rule.Window,
+		Function:   rule.Function,
+		Percentile: rule.Percentile,
+		GroupBy:    rule.GroupBy,
+	}
+	if !isValidAggregateQuery(query) {
+		return fmt.Errorf("%w: rule %q has unsupported function or group_by", ErrInvalidAlertRule, rule.Name)
+	}
+
+	return nil
+}
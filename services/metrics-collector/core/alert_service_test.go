@@ -0,0 +1,128 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type recordingNotifier struct {
+	alerts []core.Alert
+}
+
+func (n *recordingNotifier) Notify(alerts []core.Alert) {
+	n.alerts = append(n.alerts, alerts...)
+}
+
+func newAlertService(t *testing.T, rule core.AlertRule) (*core.AlertService, *memory.Storage, *recordingNotifier) {
+	t.Helper()
+
+	storage, err := memory.New(discard, core.ConflictPolicyReject)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	notifier := &recordingNotifier{}
+	service, err := core.NewAlertService(discard, storage, notifier, []core.AlertRule{rule})
+	if err != nil {
+		t.Fatalf("failed to create alert service: %v", err)
+	}
+	return service, storage, notifier
+}
+
+func highCPURule(forDuration time.Duration) core.AlertRule {
+	return core.AlertRule{
+		Name:       "high_cpu",
+		MetricName: "system_cpu_usage",
+		Function:   core.AggregationAvg,
+		Window:     time.Minute,
+		Operator:   ">",
+		Threshold:  80,
+		For:        forDuration,
+	}
+}
+
+// evaluate saves value half a window before now and evaluates the rules at
+// now.
+func evaluate(t *testing.T, service *core.AlertService, storage *memory.Storage, now time.Time, value float64) []core.Alert {
+	t.Helper()
+
+	if _, err := storage.Save(sample(now.Add(-30*time.Second), "pod-1", value)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return service.Evaluate(now)
+}
+
+func TestAlertLifecycle(t *testing.T) {
+	service, storage, notifier := newAlertService(t, highCPURule(2*time.Minute))
+	start := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	changed := evaluate(t, service, storage, start, 90)
+	if len(changed) != 1 || changed[0].State != core.AlertStatePending || !changed[0].ActiveAt.Equal(start) {
+		t.Fatalf("expected a pending alert active at %v, got %+v", start, changed)
+	}
+
+	// The alert stays pending until the condition has held for the whole
+	// for duration.
+	if changed := evaluate(t, service, storage, start.Add(time.Minute), 95); len(changed) != 0 {
+		t.Fatalf("expected no change before the for duration, got %+v", changed)
+	}
+	if len(notifier.alerts) != 0 {
+		t.Fatalf("pending alerts must not be notified, got %+v", notifier.alerts)
+	}
+
+	changed = evaluate(t, service, storage, start.Add(2*time.Minute), 95)
+	if len(changed) != 1 || changed[0].State != core.AlertStateFiring || !changed[0].FiredAt.Equal(start.Add(2*time.Minute)) {
+		t.Fatalf("expected the alert to fire after the for duration, got %+v", changed)
+	}
+	if alerts := service.Alerts(); len(alerts) != 1 || alerts[0].State != core.AlertStateFiring {
+		t.Fatalf("expected a firing alert, got %+v", alerts)
+	}
+
+	changed = evaluate(t, service, storage, start.Add(3*time.Minute), 10)
+	if len(changed) != 1 || changed[0].State != core.AlertStateResolved || !changed[0].ResolvedAt.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("expected the alert to resolve, got %+v", changed)
+	}
+	if len(notifier.alerts) != 2 || notifier.alerts[0].State != core.AlertStateFiring || notifier.alerts[1].State != core.AlertStateResolved {
+		t.Fatalf("expected firing and resolved notifications, got %+v", notifier.alerts)
+	}
+	if alerts := service.Alerts(); len(alerts) != 0 {
+		t.Fatalf("resolved alerts must be dropped after notifying, got %+v", alerts)
+	}
+
+	// A new breach starts a new alert.
+	changed = evaluate(t, service, storage, start.Add(4*time.Minute), 90)
+	if len(changed) != 1 || changed[0].State != core.AlertStatePending || !changed[0].ActiveAt.Equal(start.Add(4*time.Minute)) {
+		t.Fatalf("expected a new pending alert, got %+v", changed)
+	}
+}
+
+func TestPendingAlertIsDroppedWithoutNotifying(t *testing.T) {
+	service, storage, notifier := newAlertService(t, highCPURule(5*time.Minute))
+	start := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	evaluate(t, service, storage, start, 90)
+	if changed := evaluate(t, service, storage, start.Add(time.Minute), 10); len(changed) != 0 {
+		t.Fatalf("a pending alert must clear without a change, got %+v", changed)
+	}
+	if alerts := service.Alerts(); len(alerts) != 0 {
+		t.Fatalf("expected no alerts, got %+v", alerts)
+	}
+	if len(notifier.alerts) != 0 {
+		t.Fatalf("expected no notifications, got %+v", notifier.alerts)
+	}
+}
+
+func TestAlertWithoutForFiresImmediately(t *testing.T) {
+	service, storage, notifier := newAlertService(t, highCPURule(0))
+	start := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	changed := evaluate(t, service, storage, start, 90)
+	if len(changed) != 1 || changed[0].State != core.AlertStateFiring || !changed[0].FiredAt.Equal(start) {
+		t.Fatalf("expected the alert to fire at once, got %+v", changed)
+	}
+	if len(notifier.alerts) != 1 {
+		t.Fatalf("expected a firing notification, got %+v", notifier.alerts)
+	}
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultAnomalyLimit = 100
+	maxAnomalyLimit     = 1000
+	maxAnomalyContext   = time.Hour
+)
+
+type AnomalyService struct {
+	log  *slog.Logger
+	repo AnomalyRepository
+}
+
+func NewAnomalyService(log *slog.Logger, repo AnomalyRepository) *AnomalyService {
+	return &AnomalyService{
+		log:  log,
+		repo: repo,
+	}
+}
+
+func (s *AnomalyService) ListAnomalies(query AnomalyQuery) ([]Anomaly, error) {
+	if !query.Start.Before(query.End) || query.Context < 0 || query.Context > maxAnomalyContext || query.Limit < 0 || query.Limit > maxAnomalyLimit {
+		s.log.Warn("invalid anomaly query", slog.Any("query", query))
+		return nil, ErrInvalidAnomalyQuery
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultAnomalyLimit
+	}
+
+	anomalies, err := s.repo.FindAnomalies(query)
+	if err != nil {
+		s.log.Error("failed to find anomalies", slog.String("error", err.Error()))
+		return nil, ErrQueryFailed
+	}
+
+	s.log.Info("anomalies successfully retrieved", slog.Any("query", query), slog.Int("count", len(anomalies)))
+	return anomalies, nil
+}
+
+func (s *AnomalyService) AcknowledgeAnomaly(ack AnomalyAck) (*AnomalyAck, error) {
+	if ack.Time.IsZero() || ack.ServiceURL == "" || ack.MetricName == "" || ack.PodName == "" || ack.AckedBy == "" || !isValidLabels(ack.Labels) ||
+		(ack.Status != AnomalyStatusAcknowledged && ack.Status != AnomalyStatusDismissed) {
+		s.log.Warn("invalid anomaly acknowledgement", slog.Any("ack", ack))
+		return nil, ErrInvalidAnomalyAck
+	}
+
+	saved, err := s.repo.SaveAnomalyAck(ack)
+	if err != nil {
+		if errors.Is(err, ErrAnomalyNotFound) {
+			s.log.Warn("anomaly to acknowledge not found", slog.Any("metric_identity", ack.MetricIdentity))
+			return nil, ErrAnomalyNotFound
+		}
+		s.log.Error("failed to save anomaly acknowledgement", slog.String("error", err.Error()))
+		return nil, ErrAckFailed
+	}
+
+	s.log.Info("anomaly successfully acknowledged", slog.Any("metric_identity", ack.MetricIdentity), slog.String("status", ack.Status))
+	return saved, nil
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// A series that receives no samples for trackedSeriesTTL is forgotten, and
+// no more than maxTrackedSeries are tracked at once. Storage keeps the type
+// of forgotten and untracked series, so they are still checked on save.
+const (
+	trackedSeriesTTL = time.Hour
+	maxTrackedSeries = 1 << 20
+)
+
+type seriesState struct {
+	typ   string
+	time  time.Time
+	value float64
+	seen  time.Time
+}
+
+// seriesTracker remembers the type of recent series and the latest sample of
+// recent counters, so that ingestion can reject type changes early and tell
+// counter resets apart.
+type seriesTracker struct {
+	mu        sync.Mutex
+	series    map[string]seriesState
+	lastSweep time.Time
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		series: make(map[string]seriesState),
+	}
+}
+
+func trackerKey(metric Metric) string {
+	return SeriesIdentity{
+		ServiceURL: metric.ServiceURL,
+		MetricName: metric.MetricName,
+		PodName:    metric.PodName,
+		Labels:     metric.Labels,
+	}.Key()
+}
+
+// check validates metric against the series it belongs to. The first sample
+// of an unknown series reserves the series type under the same lock, so that
+// concurrent writers of another type are rejected; release drops the
+// reservation if the sample is not saved. The sample itself is not recorded
+// until it is saved. check reports whether a counter dropped, which is
+// treated as a reset.
+func (t *seriesTracker) check(metric Metric, now time.Time) (bool, error) {
+	if metric.Type == MetricTypeCounter && (metric.MetricValue < 0 || math.IsNaN(metric.MetricValue) || math.IsInf(metric.MetricValue, 0)) {
+		return false, ErrCounterNotMonotonic
+	}
+
+	key := trackerKey(metric)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.series[key]
+	if !ok {
+		t.reserve(key, metric.Type, now)
+		return false, nil
+	}
+	if last.typ != metric.Type {
+		return false, ErrMetricTypeMismatch
+	}
+	// Backfilled and duplicate samples cannot be compared with the latest
+	// one; storage orders them and resolves conflicts.
+	return metric.Type == MetricTypeCounter && !last.time.IsZero() && metric.Time.After(last.time) && metric.MetricValue < last.value, nil
+}
+
+// reserve must be called with mu held.
+func (t *seriesTracker) reserve(key, typ string, now time.Time) {
+	if now.Sub(t.lastSweep) >= trackedSeriesTTL {
+		t.sweep(now)
+	}
+	if len(t.series) >= maxTrackedSeries {
+		return
+	}
+	t.series[key] = seriesState{typ: typ, seen: now}
+}
+
+// release drops the type reserved by check for a sample that was not saved,
+// unless a sample of the series has been saved meanwhile.
+func (t *seriesTracker) release(metric Metric) {
+	key := trackerKey(metric)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.series[key]; ok && last.time.IsZero() {
+		delete(t.series, key)
+	}
+}
+
+// record remembers metric once it is saved.
+func (t *seriesTracker) record(metric Metric, now time.Time) {
+	key := trackerKey(metric)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) >= trackedSeriesTTL {
+		t.sweep(now)
+	}
+
+	last, ok := t.series[key]
+	if !ok && len(t.series) >= maxTrackedSeries {
+		return
+	}
+	if ok && metric.Type == MetricTypeCounter && !metric.Time.After(last.time) {
+		last.seen = now
+		t.series[key] = last
+		return
+	}
+	t.series[key] = seriesState{typ: metric.Type, time: metric.Time, value: metric.MetricValue, seen: now}
+}
+
+// sweep forgets the series not seen for trackedSeriesTTL. It must be called
+// with mu held.
+func (t *seriesTracker) sweep(now time.Time) {
+	for key, state := range t.series {
+		if now.Sub(state.seen) >= trackedSeriesTTL {
+			delete(t.series, key)
+		}
+	}
+	t.lastSweep = now
+}
+
+func isValidMetricType(metricType string) bool {
+	switch metricType {
+	case MetricTypeCounter, MetricTypeGauge, MetricTypeHistogram, MetricTypeSummary:
+		return true
+	default:
+		return false
+	}
+}
+
+// Increase sums the growth of a counter over the points in (start, end],
+// counting a drop as a reset to zero. ok is false with fewer than two points.
+func Increase(points []Point, start, end time.Time) (float64, bool) {
+	var (
+		result float64
+		last   float64
+		count  int
+	)
+	for _, point := range points[firstPointAfter(points, start):] {
+		if point.Time.After(end) {
+			break
+		}
+		if count > 0 {
+			if point.Value >= last {
+				result += point.Value - last
+			} else {
+				result += point.Value
+			}
+		}
+		last = point.Value
+		count++
+	}
+	return result, count >= 2
+}
+
+func firstPointAfter(points []Point, t time.Time) int {
+	lo, hi := 0, len(points)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if points[mid].Time.After(t) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
@@ -3,11 +3,49 @@ package core
 import "errors"
 
 var (
-	ErrInvalidMetric = errors.New("invalid metric: no required params")
-	ErrSaveFailed    = errors.New("failed to save metric")
+	ErrInvalidMetric        = errors.New("invalid metric: no required params")
+	ErrTimestampOutOfWindow = errors.New("invalid metric: timestamp outside of the accepted window")
+	ErrDuplicateMetric      = errors.New("metric with the same identity already exists")
+	ErrWriteQueueFull       = errors.New("write queue is full, retry later")
+	ErrSaveFailed           = errors.New("failed to save metric")
+)
+
+var (
+	ErrInvalidMetricType   = errors.New("invalid metric: unsupported metric type")
+	ErrMetricTypeMismatch  = errors.New("invalid metric: type differs from the series type")
+	ErrCounterNotMonotonic = errors.New("invalid metric: counter must be a non-negative finite value")
+)
+
+var (
+	ErrEmptyMetricBatch    = errors.New("invalid metric batch: no metrics")
+	ErrMetricBatchTooLarge = errors.New("invalid metric batch: more metrics than the write queue holds")
 )
 
 var (
 	ErrInvalidMetricIdentity = errors.New("invalid metric identity")
 	ErrMetricNotFound        = errors.New("metric not found")
 )
+
+var (
+	ErrInvalidRangeQuery     = errors.New("invalid range query")
+	ErrInvalidAggregateQuery = errors.New("invalid aggregate query")
+	ErrInvalidRateQuery      = errors.New("invalid rate query")
+	ErrInvalidQuantileQuery  = errors.New("invalid histogram quantile query")
+	ErrQueryTypeMismatch     = errors.New("query function does not apply to the metric type")
+	ErrQueryFailed           = errors.New("failed to query metrics")
+)
+
+var (
+	ErrInvalidAnomalyQuery = errors.New("invalid anomaly query")
+	ErrInvalidAnomalyAck   = errors.New("invalid anomaly acknowledgement")
+	ErrAnomalyNotFound     = errors.New("anomaly not found")
+	ErrAckFailed           = errors.New("failed to acknowledge anomaly")
+)
+
+var (
+	ErrInvalidAlertRule = errors.New("invalid alert rule")
+)
+
+var (
+	ErrInvalidRetentionRule = errors.New("invalid retention rule")
+)
@@ -0,0 +1,230 @@
+package core_test
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func newMetricService(t *testing.T) (*core.MetricService, *memory.Storage) {
+	t.Helper()
+
+	storage, err := memory.New(discard, core.ConflictPolicyReject)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return core.NewMetricService(discard, storage, nil, core.IngestionWindow{}), storage
+}
+
+func sample(t time.Time, podName string, value float64) core.Metric {
+	return core.Metric{
+		MetricIdentity: core.MetricIdentity{
+			Time:       t,
+			ServiceURL: "svc/metrics",
+			MetricName: "system_cpu_usage",
+			PodName:    podName,
+			Labels:     core.Labels{"pod": podName},
+		},
+		MetricValue: value,
+	}
+}
+
+func TestCreateAndGetMetric(t *testing.T) {
+	service, _ := newMetricService(t)
+	now := time.Now().UTC()
+
+	identity, err := service.CreateMetric(sample(now, "pod-1", 0.5))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	metric, err := service.GetMetricByMetricIdentity(*identity)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if metric.MetricValue != 0.5 || metric.Type != core.MetricTypeGauge || metric.Labels["pod"] != "pod-1" {
+		t.Fatalf("unexpected metric %+v", metric)
+	}
+
+	identity.Time = identity.Time.Add(time.Second)
+	if _, err := service.GetMetricByMetricIdentity(*identity); !errors.Is(err, core.ErrMetricNotFound) {
+		t.Fatalf("expected ErrMetricNotFound, got %v", err)
+	}
+	if _, err := service.CreateMetric(sample(now, "", 0.5)); !errors.Is(err, core.ErrInvalidMetric) {
+		t.Fatalf("expected ErrInvalidMetric, got %v", err)
+	}
+}
+
+func TestCreateDuplicateMetric(t *testing.T) {
+	service, _ := newMetricService(t)
+	now := time.Now().UTC()
+
+	if _, err := service.CreateMetric(sample(now, "pod-1", 0.5)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.CreateMetric(sample(now, "pod-1", 0.7)); !errors.Is(err, core.ErrDuplicateMetric) {
+		t.Fatalf("expected ErrDuplicateMetric, got %v", err)
+	}
+
+	results, err := service.CreateMetrics([]core.Metric{
+		sample(now.Add(time.Second), "pod-1", 1),
+		sample(now.Add(time.Second), "pod-1", 2),
+		sample(now, "", 3),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Err != nil || !errors.Is(results[1].Err, core.ErrDuplicateMetric) || !errors.Is(results[2].Err, core.ErrInvalidMetric) {
+		t.Fatalf("unexpected batch results %+v", results)
+	}
+}
+
+func TestCreateMetricOutsideWindow(t *testing.T) {
+	storage, err := memory.New(discard, core.ConflictPolicyReject)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	service := core.NewMetricService(discard, storage, nil, core.IngestionWindow{MaxPastAge: time.Hour, MaxFutureSkew: time.Minute})
+
+	if _, err := service.CreateMetric(sample(time.Now().Add(-2*time.Hour), "pod-1", 1)); !errors.Is(err, core.ErrTimestampOutOfWindow) {
+		t.Fatalf("expected ErrTimestampOutOfWindow, got %v", err)
+	}
+	if _, err := service.CreateMetric(sample(time.Now().Add(-30*time.Minute), "pod-1", 1)); err != nil {
+		t.Fatalf("backfilled metric inside the window should be accepted, got %v", err)
+	}
+}
+
+func TestQueryRangeAndAggregate(t *testing.T) {
+	service, _ := newMetricService(t)
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	metrics := make([]core.Metric, 0)
+	for i := range 6 {
+		at := start.Add(time.Duration(i) * 10 * time.Second)
+		metrics = append(metrics, sample(at, "pod-1", float64(i)), sample(at, "pod-2", float64(10*i)))
+	}
+	if _, err := service.CreateMetrics(metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	series, err := service.QueryRange(core.RangeQuery{
+		ServiceURL: "svc/metrics",
+		MetricName: "system_cpu_usage",
+		Matchers:   []core.LabelMatcher{{Name: "pod", Type: core.MatchRegexp, Value: "pod-1|pod-3"}},
+		Start:      start,
+		End:        start.Add(time.Minute),
+		Step:       30 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(series) != 1 || series[0].PodName != "pod-1" {
+		t.Fatalf("expected only pod-1 to match, got %+v", series)
+	}
+	if len(series[0].Points) != 2 || series[0].Points[0].Value != 2 || series[0].Points[1].Value != 5 {
+		t.Fatalf("expected the last point of every step, got %+v", series[0].Points)
+	}
+
+	series, err = service.Aggregate(core.AggregateQuery{
+		MetricName: "system_cpu_usage",
+		Start:      start,
+		End:        start.Add(time.Minute),
+		Interval:   30 * time.Second,
+		Function:   core.AggregationAvg,
+		GroupBy:    core.GroupByPodName,
+		Origin:     start,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(series) != 2 || series[1].PodName != "pod-2" {
+		t.Fatalf("expected a series per pod, got %+v", series)
+	}
+	if points := series[1].Points; len(points) != 2 || points[0].Value != 10 || points[1].Value != 40 {
+		t.Fatalf("unexpected averages %+v", points)
+	}
+}
+
+func TestRateOfCounter(t *testing.T) {
+	service, _ := newMetricService(t)
+	start := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, value := range []float64{0, 10, 20, 5, 15} {
+		metric := sample(start.Add(time.Duration(i)*10*time.Second), "pod-1", value)
+		metric.MetricName = "requests_total"
+		metric.Type = core.MetricTypeCounter
+		if _, err := service.CreateMetric(metric); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	series, err := service.Increase(core.RateQuery{
+		MetricName: "requests_total",
+		Start:      start.Add(40 * time.Second),
+		End:        start.Add(40 * time.Second),
+		Step:       time.Second,
+		Window:     time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 0 -> 10 -> 20, reset to 5, 5 -> 15
+	if len(series) != 1 || series[0].Points[0].Value != 35 {
+		t.Fatalf("expected an increase of 35, got %+v", series)
+	}
+
+	if _, err := service.Rate(core.RateQuery{
+		MetricName: "system_cpu_usage",
+		Start:      start,
+		End:        start,
+		Step:       time.Second,
+		Window:     time.Minute,
+	}); err != nil {
+		t.Fatalf("rate over no series should be empty, got %v", err)
+	}
+}
+
+func TestAcknowledgeAnomaly(t *testing.T) {
+	service, storage := newMetricService(t)
+	anomalyService := core.NewAnomalyService(discard, storage)
+	now := time.Now().UTC()
+
+	anomalous := sample(now, "pod-1", 100)
+	anomalous.IsAnomaly = true
+	if _, err := storage.Save(anomalous); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	normal, err := service.CreateMetric(sample(now.Add(time.Second), "pod-1", 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ack := core.AnomalyAck{MetricIdentity: anomalous.MetricIdentity, Status: core.AnomalyStatusDismissed, AckedBy: "tester"}
+	if _, err := anomalyService.AcknowledgeAnomaly(ack); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ack.MetricIdentity = *normal
+	if _, err := anomalyService.AcknowledgeAnomaly(ack); !errors.Is(err, core.ErrAnomalyNotFound) {
+		t.Fatalf("expected ErrAnomalyNotFound for a normal sample, got %v", err)
+	}
+
+	anomalies, err := anomalyService.ListAnomalies(core.AnomalyQuery{
+		PodName: "pod-1",
+		Start:   now.Add(-time.Minute),
+		End:     now.Add(time.Minute),
+		Context: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Ack == nil || anomalies[0].Ack.Status != core.AnomalyStatusDismissed || len(anomalies[0].Context) != 2 {
+		t.Fatalf("unexpected anomalies %+v", anomalies)
+	}
+}
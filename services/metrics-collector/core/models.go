@@ -1,15 +1,304 @@
 package core
 
-import "time"
+import (
+	"slices"
+	"strings"
+	"time"
+)
 
 type MetricIdentity struct {
 	Time       time.Time
 	ServiceURL string
 	MetricName string
 	PodName    string
+	Labels     Labels
 }
 
+const (
+	MetricTypeCounter   = "counter"
+	MetricTypeGauge     = "gauge"
+	MetricTypeHistogram = "histogram"
+	MetricTypeSummary   = "summary"
+)
+
 type Metric struct {
 	MetricIdentity
+	Type        string
 	MetricValue float64
+	IsAnomaly   bool
+}
+
+// SampleTime returns the client-supplied sample time, or now when the client
+// left it to the server.
+func SampleTime(t *time.Time, now time.Time) time.Time {
+	if t == nil {
+		return now
+	}
+	return t.UTC()
+}
+
+// IngestionWindow bounds how far a sample time may lie from the server clock.
+// A zero duration leaves the respective side unbounded.
+type IngestionWindow struct {
+	MaxPastAge    time.Duration
+	MaxFutureSkew time.Duration
+}
+
+// Conflict policies decide what happens to a sample whose series already has
+// a sample at the same time.
+const (
+	ConflictPolicyReject    = "reject"
+	ConflictPolicyOverwrite = "overwrite"
+	ConflictPolicyKeepFirst = "keep_first"
+)
+
+type MetricResult struct {
+	Index          int
+	MetricIdentity *MetricIdentity
+	Err            error
+}
+
+type Labels map[string]string
+
+// String renders labels in the exposition format with names sorted, so that
+// equal label sets always render identically.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(l[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// LabelMatcher selects series by a label value. A missing label matches as
+// the empty string and regular expressions are fully anchored.
+type LabelMatcher struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+type SeriesIdentity struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Labels     Labels
+}
+
+// Key returns a comparable representation of the identity for use in maps.
+func (s SeriesIdentity) Key() string {
+	return strings.Join([]string{s.ServiceURL, s.MetricName, s.PodName, s.Labels.String()}, "\x00")
+}
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+type Series struct {
+	SeriesIdentity
+	Type   string
+	Points []Point
+}
+
+type RangeQuery struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Matchers   []LabelMatcher
+	Start      time.Time
+	End        time.Time
+	Step       time.Duration
+}
+
+// SeriesQuery selects the series with samples in [Start, End]. Empty
+// ServiceURL, MetricName and PodName match any.
+type SeriesQuery struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Matchers   []LabelMatcher
+	Start      time.Time
+	End        time.Time
+}
+
+// RateQuery evaluates rate or increase of counters at every step between
+// Start and End, looking back Window from each evaluation time.
+type RateQuery struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Matchers   []LabelMatcher
+	Start      time.Time
+	End        time.Time
+	Step       time.Duration
+	Window     time.Duration
+}
+
+// HistogramQuantileQuery estimates the Quantile of a histogram from the
+// increase of its MetricName_bucket series over Window.
+type HistogramQuantileQuery struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Matchers   []LabelMatcher
+	Start      time.Time
+	End        time.Time
+	Step       time.Duration
+	Window     time.Duration
+	Quantile   float64
+}
+
+const (
+	AggregationAvg        = "avg"
+	AggregationMin        = "min"
+	AggregationMax        = "max"
+	AggregationSum        = "sum"
+	AggregationCount      = "count"
+	AggregationFirst      = "first"
+	AggregationLast       = "last"
+	AggregationPercentile = "percentile"
+)
+
+const (
+	GroupByPodName    = "pod_name"
+	GroupByServiceURL = "service_url"
+)
+
+type AggregateQuery struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Matchers   []LabelMatcher
+	Start      time.Time
+	End        time.Time
+	Interval   time.Duration
+	Function   string
+	Percentile float64
+	GroupBy    string
+	Origin     time.Time
+}
+
+const (
+	AnomalyStatusAcknowledged = "acknowledged"
+	AnomalyStatusDismissed    = "dismissed"
+)
+
+type AnomalyQuery struct {
+	ServiceURL string
+	MetricName string
+	PodName    string
+	Start      time.Time
+	End        time.Time
+	Context    time.Duration
+	Limit      int
+}
+
+type AnomalyAck struct {
+	MetricIdentity
+	Status  string
+	AckedBy string
+	Reason  string
+	AckedAt time.Time
+}
+
+type Anomaly struct {
+	Metric
+	Context []Point
+	Ack     *AnomalyAck
+}
+
+const (
+	AlertStatePending  = "pending"
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+type AlertRule struct {
+	Name        string
+	ServiceURL  string
+	MetricName  string
+	PodName     string
+	Function    string
+	Percentile  float64
+	GroupBy     string
+	Window      time.Duration
+	Operator    string
+	Threshold   float64
+	For         time.Duration
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type Alert struct {
+	Rule        string
+	Series      SeriesIdentity
+	Labels      map[string]string
+	Annotations map[string]string
+	State       string
+	Value       float64
+	ActiveAt    time.Time
+	FiredAt     time.Time
+	ResolvedAt  time.Time
+}
+
+type AlertNotification struct {
+	ID            int64
+	WebhookURL    string
+	GroupKey      string
+	AlertKey      string
+	Alert         Alert
+	Attempts      int
+	NextAttemptAt time.Time
+}
+
+const (
+	RetentionActionDelete   = "delete"
+	RetentionActionDrop     = "drop"
+	RetentionActionCompress = "compress"
+)
+
+// RetentionRule keeps the metrics whose name matches MetricPattern, a glob
+// where * matches any run of characters and ? any single one, for Retention.
+type RetentionRule struct {
+	Name          string
+	MetricPattern string
+	Retention     time.Duration
+}
+
+type RetentionPolicy struct {
+	Name          string
+	MetricPattern string
+	Action        string
+	Age           time.Duration
+	LastRun       time.Time
+	LastError     string
+	Affected      int64
 }
@@ -1,6 +1,51 @@
 package core
 
+import "time"
+
 type MetricRepository interface {
 	Save(metric Metric) (*MetricIdentity, error)
+	SaveBatch(metrics []Metric) ([]MetricResult, error)
 	FindByMetricIdentity(metricIdentity MetricIdentity) (*Metric, error)
+	FindRange(query RangeQuery) ([]Metric, error)
+	Aggregate(query AggregateQuery) ([]Metric, error)
+}
+
+type SeriesRepository interface {
+	FindSeries(query SeriesQuery) ([]SeriesIdentity, error)
+}
+
+type AnomalyRepository interface {
+	FindAnomalies(query AnomalyQuery) ([]Anomaly, error)
+	SaveAnomalyAck(ack AnomalyAck) (*AnomalyAck, error)
+}
+
+type AnomalyDetector interface {
+	Detect(metric Metric) bool
+	// Observe adds a saved metric to the history Detect scores against.
+	Observe(metric Metric)
+}
+
+type AlertNotifier interface {
+	Notify(alerts []Alert)
+}
+
+type NotificationRepository interface {
+	EnqueueNotification(notification AlertNotification) (bool, error)
+	FindDueNotifications(now time.Time, limit int) ([]AlertNotification, error)
+	MarkNotificationsDelivered(ids []int64, deliveredAt time.Time) error
+	MarkNotificationsRetry(ids []int64, attempts int, nextAttemptAt time.Time, lastError string) error
+	MarkNotificationsFailed(ids []int64, attempts int, lastError string) error
+	// DeleteNotificationsBefore removes the delivered and failed notifications
+	// enqueued before the given time. The last notification of an alert that
+	// has not resolved is kept, since repeats are deduped against it.
+	DeleteNotificationsBefore(before time.Time) (int64, error)
+}
+
+// RetentionRepository removes expired data. Each method returns how many rows
+// or chunks it removed or compressed, depending on what the storage counts.
+type RetentionRepository interface {
+	MetricNames() ([]string, error)
+	DeleteMetricsBefore(metricNames []string, before time.Time) (int64, error)
+	DropBefore(before time.Time) (int64, error)
+	CompressBefore(before time.Time) (int64, error)
 }
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const bucketLabel = "le"
+
+func (s *MetricService) Rate(query RateQuery) ([]Series, error) {
+	return s.counterFunction(query, "rate", func(increase float64) float64 {
+		return increase / query.Window.Seconds()
+	})
+}
+
+func (s *MetricService) Increase(query RateQuery) ([]Series, error) {
+	return s.counterFunction(query, "increase", func(increase float64) float64 {
+		return increase
+	})
+}
+
+func (s *MetricService) counterFunction(query RateQuery, function string, value func(increase float64) float64) ([]Series, error) {
+	if query.MetricName == "" || !isValidEvaluationRange(query.Start, query.End, query.Step, query.Window) || !isValidLabelMatchers(query.Matchers) {
+		s.log.Warn("invalid rate query", slog.String("function", function), slog.Any("query", query))
+		return nil, ErrInvalidRateQuery
+	}
+
+	metrics, err := s.repo.FindRange(RangeQuery{
+		ServiceURL: query.ServiceURL,
+		MetricName: query.MetricName,
+		PodName:    query.PodName,
+		Matchers:   query.Matchers,
+		Start:      query.Start.Add(-query.Window),
+		End:        query.End,
+	})
+	if err != nil {
+		s.log.Error("failed to query counter range", slog.String("error", err.Error()))
+		return nil, ErrQueryFailed
+	}
+
+	result := make([]Series, 0)
+	for _, series := range groupSeries(metrics) {
+		if series.Type == MetricTypeGauge {
+			s.log.Warn("counter function applied to a gauge", slog.String("function", function), slog.Any("series", series.SeriesIdentity))
+			return nil, ErrQueryTypeMismatch
+		}
+
+		points := make([]Point, 0)
+		for t := query.Start; !t.After(query.End); t = t.Add(query.Step) {
+			if inc, ok := Increase(series.Points, t.Add(-query.Window), t); ok {
+				points = append(points, Point{Time: t, Value: value(inc)})
+			}
+		}
+		if len(points) > 0 {
+			result = append(result, Series{SeriesIdentity: series.SeriesIdentity, Type: MetricTypeGauge, Points: points})
+		}
+	}
+
+	s.log.Info("counter function successfully evaluated", slog.String("function", function), slog.Any("query", query), slog.Int("series", len(result)))
+	return result, nil
+}
+
+type bucket struct {
+	upperBound float64
+	points     []Point
+}
+
+func (s *MetricService) HistogramQuantile(query HistogramQuantileQuery) ([]Series, error) {
+	if query.MetricName == "" || !isValidEvaluationRange(query.Start, query.End, query.Step, query.Window) ||
+		query.Quantile < 0 || query.Quantile > 1 || !isValidLabelMatchers(query.Matchers) {
+		s.log.Warn("invalid histogram quantile query", slog.Any("query", query))
+		return nil, ErrInvalidQuantileQuery
+	}
+
+	metricName := strings.TrimSuffix(query.MetricName, "_bucket")
+	metrics, err := s.repo.FindRange(RangeQuery{
+		ServiceURL: query.ServiceURL,
+		MetricName: metricName + "_bucket",
+		PodName:    query.PodName,
+		Matchers:   query.Matchers,
+		Start:      query.Start.Add(-query.Window),
+		End:        query.End,
+	})
+	if err != nil {
+		s.log.Error("failed to query histogram buckets", slog.String("error", err.Error()))
+		return nil, ErrQueryFailed
+	}
+
+	// Buckets of one histogram share every label except le.
+	histograms := make([]SeriesIdentity, 0)
+	buckets := make(map[string][]bucket)
+	for _, series := range groupSeries(metrics) {
+		if series.Type != MetricTypeHistogram {
+			s.log.Warn("histogram quantile applied to a non-histogram", slog.Any("series", series.SeriesIdentity))
+			return nil, ErrQueryTypeMismatch
+		}
+
+		upperBound, err := strconv.ParseFloat(series.Labels[bucketLabel], 64)
+		if err != nil {
+			s.log.Warn("skipping histogram bucket without a valid upper bound", slog.Any("series", series.SeriesIdentity))
+			continue
+		}
+
+		labels := maps.Clone(series.Labels)
+		delete(labels, bucketLabel)
+		identity := SeriesIdentity{
+			ServiceURL: series.ServiceURL,
+			MetricName: metricName,
+			PodName:    series.PodName,
+			Labels:     labels,
+		}
+		key := identity.Key()
+		if _, ok := buckets[key]; !ok {
+			histograms = append(histograms, identity)
+		}
+		buckets[key] = append(buckets[key], bucket{upperBound: upperBound, points: series.Points})
+	}
+
+	result := make([]Series, 0, len(histograms))
+	for _, identity := range histograms {
+		histogram := buckets[identity.Key()]
+		slices.SortFunc(histogram, func(a, b bucket) int {
+			return cmp.Compare(a.upperBound, b.upperBound)
+		})
+
+		points := make([]Point, 0)
+		for t := query.Start; !t.After(query.End); t = t.Add(query.Step) {
+			if value, ok := bucketQuantile(query.Quantile, histogram, t.Add(-query.Window), t); ok {
+				points = append(points, Point{Time: t, Value: value})
+			}
+		}
+		if len(points) > 0 {
+			result = append(result, Series{SeriesIdentity: identity, Type: MetricTypeGauge, Points: points})
+		}
+	}
+
+	s.log.Info("histogram quantile successfully evaluated", slog.Any("query", query), slog.Int("series", len(result)))
+	return result, nil
+}
+
+// bucketQuantile interpolates the quantile linearly inside the bucket that
+// contains it, the same way Prometheus does. Buckets must be sorted by upper
+// bound and end with +Inf.
+func bucketQuantile(quantile float64, histogram []bucket, start, end time.Time) (float64, bool) {
+	if len(histogram) < 2 || !math.IsInf(histogram[len(histogram)-1].upperBound, 1) {
+		return 0, false
+	}
+
+	counts := make([]float64, len(histogram))
+	for i, b := range histogram {
+		count, ok := Increase(b.points, start, end)
+		if !ok {
+			return 0, false
+		}
+		// Buckets are cumulative; scrapes racing with observations can break
+		// that slightly, so never let a count fall below the previous one.
+		if i > 0 && count < counts[i-1] {
+			count = counts[i-1]
+		}
+		counts[i] = count
+	}
+
+	total := counts[len(counts)-1]
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := quantile * total
+	i, _ := slices.BinarySearch(counts, rank)
+	if i == len(histogram)-1 {
+		return histogram[len(histogram)-2].upperBound, true
+	}
+	if i == 0 && histogram[0].upperBound <= 0 {
+		return histogram[0].upperBound, true
+	}
+
+	lowerBound, lowerCount := 0.0, 0.0
+	if i > 0 {
+		lowerBound, lowerCount = histogram[i-1].upperBound, counts[i-1]
+	}
+	if counts[i] == lowerCount {
+		return lowerBound, true
+	}
+	upperBound := histogram[i].upperBound
+	return lowerBound + (upperBound-lowerBound)*((rank-lowerCount)/(counts[i]-lowerCount)), true
+}
+
+func isValidEvaluationRange(start, end time.Time, step, window time.Duration) bool {
+	if start.After(end) || step <= 0 || window <= 0 {
+		return false
+	}
+	return end.Sub(start)/step <= maxAggregateBuckets
+}
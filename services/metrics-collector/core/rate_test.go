@@ -0,0 +1,151 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func points(values ...float64) []Point {
+	result := make([]Point, 0, len(values))
+	for i, value := range values {
+		result = append(result, Point{Time: epoch.Add(time.Duration(i) * 10 * time.Second), Value: value})
+	}
+	return result
+}
+
+func TestIncreaseHandlesCounterResets(t *testing.T) {
+	// 10 -> 15 (+5), reset to 3 (+3), 3 -> 7 (+4)
+	inc, ok := Increase(points(10, 15, 3, 7), epoch.Add(-time.Second), epoch.Add(time.Minute))
+	if !ok || inc != 12 {
+		t.Fatalf("expected increase 12, got %v (ok=%v)", inc, ok)
+	}
+
+	// Only the points after start count, so the window holds 15, 3 and 7.
+	inc, ok = Increase(points(10, 15, 3, 7), epoch, epoch.Add(time.Minute))
+	if !ok || inc != 7 {
+		t.Fatalf("expected increase 7, got %v (ok=%v)", inc, ok)
+	}
+
+	if _, ok := Increase(points(10), epoch.Add(-time.Second), epoch.Add(time.Minute)); ok {
+		t.Fatal("a single point must not produce an increase")
+	}
+}
+
+func TestBucketQuantileInterpolates(t *testing.T) {
+	histogram := []bucket{
+		{upperBound: 0.1, points: points(0, 50)},
+		{upperBound: 0.5, points: points(0, 90)},
+		{upperBound: math.Inf(1), points: points(0, 100)},
+	}
+	start, end := epoch.Add(-time.Second), epoch.Add(time.Minute)
+
+	value, ok := bucketQuantile(0.5, histogram, start, end)
+	if !ok || math.Abs(value-0.1) > 1e-9 {
+		t.Fatalf("expected median 0.1, got %v (ok=%v)", value, ok)
+	}
+
+	value, ok = bucketQuantile(0.7, histogram, start, end)
+	if !ok || math.Abs(value-0.3) > 1e-9 {
+		t.Fatalf("expected p70 0.3, got %v (ok=%v)", value, ok)
+	}
+
+	value, ok = bucketQuantile(0.99, histogram, start, end)
+	if !ok || value != 0.5 {
+		t.Fatalf("quantile in the +Inf bucket should return the highest finite bound, got %v (ok=%v)", value, ok)
+	}
+
+	if _, ok := bucketQuantile(0.5, histogram[:2], start, end); ok {
+		t.Fatal("histogram without +Inf bucket must not produce a quantile")
+	}
+}
+
+func TestSeriesTrackerEnforcesCounterSemantics(t *testing.T) {
+	tracker := newSeriesTracker()
+	counter := func(offset time.Duration, value float64) Metric {
+		return Metric{
+			MetricIdentity: MetricIdentity{Time: epoch.Add(offset), ServiceURL: "svc", MetricName: "requests_total", PodName: "pod"},
+			Type:           MetricTypeCounter,
+			MetricValue:    value,
+		}
+	}
+
+	observe := func(metric Metric) (bool, error) {
+		reset, err := tracker.check(metric, epoch)
+		if err == nil {
+			tracker.record(metric, epoch)
+		}
+		return reset, err
+	}
+
+	if reset, err := observe(counter(0, 10)); err != nil || reset {
+		t.Fatalf("first sample: reset=%v err=%v", reset, err)
+	}
+	if reset, err := observe(counter(time.Second, 4)); err != nil || !reset {
+		t.Fatalf("drop should be accepted as a reset: reset=%v err=%v", reset, err)
+	}
+	if reset, err := observe(counter(-time.Second, 20)); err != nil || reset {
+		t.Fatalf("out-of-order sample should be accepted without a reset: reset=%v err=%v", reset, err)
+	}
+	if reset, err := observe(counter(2*time.Second, 5)); err != nil || reset {
+		t.Fatalf("out-of-order sample must not replace the latest one: reset=%v err=%v", reset, err)
+	}
+	if _, err := observe(counter(3*time.Second, -1)); !errors.Is(err, ErrCounterNotMonotonic) {
+		t.Fatalf("negative counter should be rejected, got %v", err)
+	}
+
+	gauge := counter(4*time.Second, 1)
+	gauge.Type = MetricTypeGauge
+	if _, err := observe(gauge); !errors.Is(err, ErrMetricTypeMismatch) {
+		t.Fatalf("type change should be rejected, got %v", err)
+	}
+
+	// A checked sample is not recorded until it is saved.
+	if reset, err := tracker.check(counter(5*time.Second, 1), epoch); err != nil || !reset {
+		t.Fatalf("drop should be reported as a reset: reset=%v err=%v", reset, err)
+	}
+	if reset, err := tracker.check(counter(6*time.Second, 2), epoch); err != nil || !reset {
+		t.Fatalf("unsaved sample must not become the latest one: reset=%v err=%v", reset, err)
+	}
+
+	// Idle series are forgotten.
+	tracker.record(counter(7*time.Second, 1), epoch.Add(trackedSeriesTTL))
+	tracker.record(Metric{MetricIdentity: MetricIdentity{ServiceURL: "svc", MetricName: "other", PodName: "pod"}, Type: MetricTypeGauge}, epoch.Add(2*trackedSeriesTTL))
+	if _, err := tracker.check(gauge, epoch.Add(2*trackedSeriesTTL)); err != nil {
+		t.Fatalf("expired series should be forgotten, got %v", err)
+	}
+}
+
+func TestSeriesTrackerReservesTheTypeOfNewSeries(t *testing.T) {
+	tracker := newSeriesTracker()
+	gauge := Metric{
+		MetricIdentity: MetricIdentity{Time: epoch, ServiceURL: "svc", MetricName: "queue", PodName: "pod"},
+		Type:           MetricTypeGauge,
+		MetricValue:    1,
+	}
+	counter := gauge
+	counter.Type = MetricTypeCounter
+
+	// Two writers of a new series: the first one checked reserves its type
+	// before either is saved.
+	if _, err := tracker.check(gauge, epoch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := tracker.check(counter, epoch); !errors.Is(err, ErrMetricTypeMismatch) {
+		t.Fatalf("a concurrent writer of another type should be rejected, got %v", err)
+	}
+
+	// A reservation whose sample was not saved is released.
+	tracker.release(gauge)
+	if _, err := tracker.check(counter, epoch); err != nil {
+		t.Fatalf("a released type should not be enforced, got %v", err)
+	}
+	tracker.record(counter, epoch)
+	tracker.release(counter)
+	if _, err := tracker.check(gauge, epoch); !errors.Is(err, ErrMetricTypeMismatch) {
+		t.Fatalf("release must keep a series with a saved sample, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RetentionPolicyExpired     = "expired"
+	RetentionPolicyDefault     = "default"
+	RetentionPolicyCompression = "compression"
+)
+
+type retentionTask struct {
+	policy RetentionPolicy
+	// pattern selects the metrics of a delete task. The first delete task
+	// matching a metric name owns the metric.
+	pattern *regexp.Regexp
+}
+
+// RetentionService is the janitor that enforces retention rules. Everything
+// older than the longest retention is dropped wholesale first, then each rule
+// deletes what is expired for the metrics it owns, and finally old data is
+// compressed.
+type RetentionService struct {
+	log  *slog.Logger
+	repo RetentionRepository
+
+	mu    sync.Mutex
+	tasks []retentionTask
+}
+
+func NewRetentionService(log *slog.Logger, repo RetentionRepository, rules []RetentionRule, defaultRetention, compressAfter time.Duration) (*RetentionService, error) {
+	if defaultRetention <= 0 || compressAfter < 0 {
+		return nil, fmt.Errorf("%w: default retention must be positive and compress_after not negative", ErrInvalidRetentionRule)
+	}
+
+	names := map[string]bool{
+		RetentionPolicyExpired:     true,
+		RetentionPolicyDefault:     true,
+		RetentionPolicyCompression: true,
+	}
+	maxRetention := defaultRetention
+	deletes := make([]retentionTask, 0, len(rules)+1)
+	for _, rule := range rules {
+		if rule.Name == "" || rule.MetricPattern == "" || rule.Retention <= 0 {
+			return nil, fmt.Errorf("%w: rule %q requires name, metric_name and a positive retention", ErrInvalidRetentionRule, rule.Name)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: duplicate or reserved rule name %q", ErrInvalidRetentionRule, rule.Name)
+		}
+		names[rule.Name] = true
+
+		maxRetention = max(maxRetention, rule.Retention)
+		deletes = append(deletes, retentionTask{
+			policy: RetentionPolicy{
+				Name:          rule.Name,
+				MetricPattern: rule.MetricPattern,
+				Action:        RetentionActionDelete,
+				Age:           rule.Retention,
+			},
+			pattern: compileMetricPattern(rule.MetricPattern),
+		})
+	}
+	deletes = append(deletes, retentionTask{
+		policy: RetentionPolicy{
+			Name:          RetentionPolicyDefault,
+			MetricPattern: "*",
+			Action:        RetentionActionDelete,
+			Age:           defaultRetention,
+		},
+		pattern: compileMetricPattern("*"),
+	})
+
+	tasks := make([]retentionTask, 0, len(deletes)+2)
+	tasks = append(tasks, retentionTask{
+		policy: RetentionPolicy{
+			Name:          RetentionPolicyExpired,
+			MetricPattern: "*",
+			Action:        RetentionActionDrop,
+			Age:           maxRetention,
+		},
+	})
+	tasks = append(tasks, deletes...)
+	if compressAfter > 0 {
+		tasks = append(tasks, retentionTask{
+			policy: RetentionPolicy{
+				Name:          RetentionPolicyCompression,
+				MetricPattern: "*",
+				Action:        RetentionActionCompress,
+				Age:           compressAfter,
+			},
+		})
+	}
+
+	return &RetentionService{
+		log:   log,
+		repo:  repo,
+		tasks: tasks,
+	}, nil
+}
+
+// Run applies the policies right away and then every interval until ctx is
+// done.
+func (s *RetentionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.Apply(time.Now().UTC())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Apply(now.UTC())
+		}
+	}
+}
+
+func (s *RetentionService) Apply(now time.Time) []RetentionPolicy {
+	metricNames, namesErr := s.repo.MetricNames()
+	if namesErr != nil {
+		s.log.Error("failed to list metric names for retention", slog.String("error", namesErr.Error()))
+	}
+	owned := s.assignMetrics(metricNames)
+
+	for i := range len(s.tasks) {
+		policy := s.policy(i)
+		before := now.Add(-policy.Age)
+
+		var (
+			affected int64
+			err      error
+		)
+		switch policy.Action {
+		case RetentionActionDrop:
+			affected, err = s.repo.DropBefore(before)
+		case RetentionActionCompress:
+			affected, err = s.repo.CompressBefore(before)
+		case RetentionActionDelete:
+			switch {
+			case namesErr != nil:
+				err = namesErr
+			case len(owned[i]) > 0:
+				affected, err = s.repo.DeleteMetricsBefore(owned[i], before)
+			}
+		}
+
+		s.record(i, now, affected, err)
+		if err != nil {
+			s.log.Error("failed to apply retention policy", slog.String("policy", policy.Name), slog.String("action", policy.Action), slog.String("error", err.Error()))
+			continue
+		}
+		s.log.Info("retention policy applied",
+			slog.String("policy", policy.Name),
+			slog.String("action", policy.Action),
+			slog.Time("before", before),
+			slog.Int64("affected", affected),
+		)
+	}
+
+	return s.Policies()
+}
+
+func (s *RetentionService) Policies() []RetentionPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.policies()
+}
+
+// policies must be called with mu held.
+func (s *RetentionService) policies() []RetentionPolicy {
+	policies := make([]RetentionPolicy, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		policies = append(policies, task.policy)
+	}
+	return policies
+}
+
+func (s *RetentionService) policy(i int) RetentionPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tasks[i].policy
+}
+
+func (s *RetentionService) record(i int, now time.Time, affected int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policy := &s.tasks[i].policy
+	policy.LastRun = now
+	policy.Affected = affected
+	policy.LastError = ""
+	if err != nil {
+		policy.LastError = err.Error()
+	}
+}
+
+// assignMetrics returns the metric names owned by each delete task, keyed by
+// task index.
+func (s *RetentionService) assignMetrics(metricNames []string) map[int][]string {
+	owned := make(map[int][]string)
+	for _, name := range metricNames {
+		for i, task := range s.tasks {
+			if task.pattern != nil && task.pattern.MatchString(name) {
+				owned[i] = append(owned[i], name)
+				break
+			}
+		}
+	}
+	return owned
+}
+
+// compileMetricPattern turns a glob into an anchored regular expression.
+func compileMetricPattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
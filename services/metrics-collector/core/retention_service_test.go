@@ -0,0 +1,73 @@
+package core_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+func TestRetentionServiceAppliesFirstMatchingRule(t *testing.T) {
+	storage, err := memory.New(discard, core.ConflictPolicyReject)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	ages := []time.Duration{time.Hour, 2 * 24 * time.Hour, 30 * 24 * time.Hour, 100 * 24 * time.Hour}
+	for _, name := range []string{"business_orders_total", "debug_queue_depth", "system_cpu_usage"} {
+		for _, age := range ages {
+			metric := sample(now.Add(-age), "pod-1", 1)
+			metric.MetricName = name
+			if _, err := storage.Save(metric); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	service, err := core.NewRetentionService(discard, storage, []core.RetentionRule{
+		{Name: "business", MetricPattern: "business_*", Retention: 90 * 24 * time.Hour},
+		{Name: "debug", MetricPattern: "debug_*", Retention: 24 * time.Hour},
+	}, 7*24*time.Hour, 12*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policies := service.Apply(now)
+	names := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+		if !policy.LastRun.Equal(now) || policy.LastError != "" {
+			t.Fatalf("policy %q: expected a clean run at %v, got %+v", policy.Name, now, policy)
+		}
+	}
+	if want := []string{"expired", "business", "debug", "default", "compression"}; len(names) != len(want) {
+		t.Fatalf("expected policies %v, got %v", want, names)
+	}
+	if policies[0].Action != core.RetentionActionDrop || policies[0].Age != 90*24*time.Hour {
+		t.Fatalf("expected everything past the longest retention to be dropped, got %+v", policies[0])
+	}
+
+	kept := map[string]int{
+		"business_orders_total": 3,
+		"debug_queue_depth":     1,
+		"system_cpu_usage":      2,
+	}
+	for name, want := range kept {
+		metrics, err := storage.FindRange(core.RangeQuery{MetricName: name, Start: now.Add(-365 * 24 * time.Hour), End: now})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(metrics) != want {
+			t.Fatalf("%s: expected %d samples to be kept, got %d", name, want, len(metrics))
+		}
+	}
+
+	if _, err := core.NewRetentionService(discard, storage, []core.RetentionRule{
+		{Name: "default", MetricPattern: "debug_*", Retention: time.Hour},
+	}, time.Hour, 0); !errors.Is(err, core.ErrInvalidRetentionRule) {
+		t.Fatalf("a rule must not take a reserved name, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// LabelSelector is a set of label matchers compiled for evaluation outside
+// of a database, all of which must match.
+type LabelSelector []labelPredicate
+
+type labelPredicate struct {
+	name  string
+	match func(value string) bool
+}
+
+func NewLabelSelector(matchers []LabelMatcher) (LabelSelector, error) {
+	selector := make(LabelSelector, 0, len(matchers))
+	for _, matcher := range matchers {
+		predicate := labelPredicate{name: matcher.Name}
+		value := matcher.Value
+		switch matcher.Type {
+		case MatchEqual:
+			predicate.match = func(v string) bool { return v == value }
+		case MatchNotEqual:
+			predicate.match = func(v string) bool { return v != value }
+		case MatchRegexp, MatchNotRegexp:
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid label matcher regexp %q: %w", value, err)
+			}
+			negate := matcher.Type == MatchNotRegexp
+			predicate.match = func(v string) bool { return re.MatchString(v) != negate }
+		default:
+			return nil, fmt.Errorf("unsupported label matcher type %q", matcher.Type)
+		}
+		selector = append(selector, predicate)
+	}
+	return selector, nil
+}
+
+func (s LabelSelector) Matches(labels Labels) bool {
+	for _, predicate := range s {
+		if !predicate.match(labels[predicate.name]) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,37 +1,162 @@
 package core
 
-import "log/slog"
+import (
+	"errors"
+	"log/slog"
+	"regexp"
+	"time"
+)
 
 type MetricService struct {
-	log  *slog.Logger
-	repo MetricRepository
+	log      *slog.Logger
+	repo     MetricRepository
+	detector AnomalyDetector
+	window   IngestionWindow
+	series   *seriesTracker
 }
 
-func NewMetricService(log *slog.Logger, repo MetricRepository) *MetricService {
+func NewMetricService(log *slog.Logger, repo MetricRepository, detector AnomalyDetector, window IngestionWindow) *MetricService {
 	return &MetricService{
-		log:  log,
-		repo: repo,
+		log:      log,
+		repo:     repo,
+		detector: detector,
+		window:   window,
+		series:   newSeriesTracker(),
 	}
 }
 
+// WithIngestionWindow returns a service that shares everything with s except
+// for the ingestion window, for sources that need a window of their own.
+func (s *MetricService) WithIngestionWindow(window IngestionWindow) *MetricService {
+	service := *s
+	service.window = window
+	return &service
+}
+
 func (s *MetricService) CreateMetric(metric Metric) (*MetricIdentity, error) {
-	if metric.ServiceURL == "" || metric.PodName == "" || metric.MetricName == "" {
+	if !isValidMetric(metric) {
 		s.log.Warn("validation failed for metric, missing required params", slog.Any("metric", metric))
 		return nil, ErrInvalidMetric
 	}
+	if !s.isWithinWindow(metric.Time, time.Now()) {
+		s.log.Warn("validation failed for metric, timestamp outside of the accepted window", slog.Any("metric", metric))
+		return nil, ErrTimestampOutOfWindow
+	}
+
+	metric, err := s.checkMetricType(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	metric.IsAnomaly = s.detectAnomaly(metric)
 
 	metricIdentity, err := s.repo.Save(metric)
+	if err != nil {
+		s.series.release(metric)
+	}
+	if errors.Is(err, ErrDuplicateMetric) {
+		s.log.Warn("metric already exists", slog.Any("metric_identity", metric.MetricIdentity))
+		return nil, ErrDuplicateMetric
+	}
+	if errors.Is(err, ErrWriteQueueFull) {
+		s.log.Warn("write queue is full, metric rejected", slog.Any("metric_identity", metric.MetricIdentity))
+		return nil, ErrWriteQueueFull
+	}
+	if errors.Is(err, ErrMetricTypeMismatch) {
+		s.log.Warn("metric type differs from the stored series type", slog.Any("metric", metric))
+		return nil, ErrMetricTypeMismatch
+	}
 	if err != nil {
 		s.log.Error("failed to save metric", slog.String("error", err.Error()))
 		return nil, ErrSaveFailed
 	}
 
+	// A repository that buffers writes acknowledges them once queued, so this
+	// may record a sample that storage rejects later.
+	s.series.record(metric, time.Now())
+	s.observeAnomaly(metric)
+
 	s.log.Info("metric successfully created", slog.Any("metric_identity", *metricIdentity))
 	return metricIdentity, nil
 }
 
+func (s *MetricService) CreateMetrics(metrics []Metric) ([]MetricResult, error) {
+	if len(metrics) == 0 {
+		s.log.Warn("validation failed for metric batch, no metrics")
+		return nil, ErrEmptyMetricBatch
+	}
+
+	now := time.Now()
+	results := make([]MetricResult, len(metrics))
+	valid := make([]Metric, 0, len(metrics))
+	validIndexes := make([]int, 0, len(metrics))
+	for i, metric := range metrics {
+		results[i].Index = i
+		if !isValidMetric(metric) {
+			s.log.Warn("validation failed for metric in batch, missing required params", slog.Int("index", i), slog.Any("metric", metric))
+			results[i].Err = ErrInvalidMetric
+			continue
+		}
+		if !s.isWithinWindow(metric.Time, now) {
+			s.log.Warn("validation failed for metric in batch, timestamp outside of the accepted window", slog.Int("index", i), slog.Any("metric", metric))
+			results[i].Err = ErrTimestampOutOfWindow
+			continue
+		}
+		metric, err := s.checkMetricType(metric)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		metric.IsAnomaly = s.detectAnomaly(metric)
+		valid = append(valid, metric)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(valid) == 0 {
+		s.log.Warn("metric batch has no valid metrics", slog.Int("rejected", len(metrics)))
+		return results, nil
+	}
+
+	saved, err := s.repo.SaveBatch(valid)
+	if err != nil {
+		for _, metric := range valid {
+			s.series.release(metric)
+		}
+	}
+	if errors.Is(err, ErrWriteQueueFull) {
+		s.log.Warn("write queue is full, metric batch rejected", slog.Int("count", len(valid)))
+		return nil, ErrWriteQueueFull
+	}
+	if errors.Is(err, ErrMetricBatchTooLarge) {
+		s.log.Warn("metric batch does not fit the write queue", slog.Int("count", len(valid)))
+		return nil, ErrMetricBatchTooLarge
+	}
+	if err != nil {
+		s.log.Error("failed to save metric batch", slog.String("error", err.Error()))
+		return nil, ErrSaveFailed
+	}
+
+	accepted := 0
+	now = time.Now()
+	for _, result := range saved {
+		i := validIndexes[result.Index]
+		results[i].MetricIdentity = result.MetricIdentity
+		results[i].Err = result.Err
+		if result.Err != nil {
+			s.series.release(valid[result.Index])
+			continue
+		}
+		s.series.record(valid[result.Index], now)
+		s.observeAnomaly(valid[result.Index])
+		accepted++
+	}
+
+	s.log.Info("metric batch successfully created", slog.Int("accepted", accepted), slog.Int("rejected", len(metrics)-accepted))
+	return results, nil
+}
+
 func (s *MetricService) GetMetricByMetricIdentity(metricIdentity MetricIdentity) (*Metric, error) {
-	if metricIdentity.ServiceURL == "" || metricIdentity.PodName == "" || metricIdentity.MetricName == "" {
+	if metricIdentity.ServiceURL == "" || metricIdentity.PodName == "" || metricIdentity.MetricName == "" || !isValidLabels(metricIdentity.Labels) {
 		s.log.Warn("invalid metric identity", slog.Any("metric_identity", metricIdentity))
 		return nil, ErrInvalidMetricIdentity
 	}
@@ -45,3 +170,195 @@ func (s *MetricService) GetMetricByMetricIdentity(metricIdentity MetricIdentity)
 	s.log.Info("metric successfully retrieved", slog.Any("metric_identity", metricIdentity))
 	return metric, nil
 }
+
+func (s *MetricService) QueryRange(query RangeQuery) ([]Series, error) {
+	if query.ServiceURL == "" || query.MetricName == "" || !query.Start.Before(query.End) || query.Step < 0 || !isValidLabelMatchers(query.Matchers) {
+		s.log.Warn("invalid range query", slog.Any("query", query))
+		return nil, ErrInvalidRangeQuery
+	}
+
+	metrics, err := s.repo.FindRange(query)
+	if err != nil {
+		s.log.Error("failed to query metric range", slog.String("error", err.Error()))
+		return nil, ErrQueryFailed
+	}
+
+	series := groupSeries(metrics)
+	if query.Step > 0 {
+		for i := range series {
+			series[i].Points = downsample(series[i].Points, query.Start, query.Step)
+		}
+	}
+
+	s.log.Info("metric range successfully retrieved", slog.Any("query", query), slog.Int("series", len(series)))
+	return series, nil
+}
+
+func (s *MetricService) Aggregate(query AggregateQuery) ([]Series, error) {
+	if !isValidAggregateQuery(query) {
+		s.log.Warn("invalid aggregate query", slog.Any("query", query))
+		return nil, ErrInvalidAggregateQuery
+	}
+
+	metrics, err := s.repo.Aggregate(query)
+	if err != nil {
+		s.log.Error("failed to aggregate metrics", slog.String("error", err.Error()))
+		return nil, ErrQueryFailed
+	}
+
+	series := groupSeries(metrics)
+
+	s.log.Info("metric aggregate successfully retrieved", slog.Any("query", query), slog.Int("series", len(series)))
+	return series, nil
+}
+
+const maxAggregateBuckets = 11000
+
+func isValidAggregateQuery(query AggregateQuery) bool {
+	if query.MetricName == "" || !query.Start.Before(query.End) || query.Interval <= 0 || !isValidLabelMatchers(query.Matchers) {
+		return false
+	}
+	if query.End.Sub(query.Start)/query.Interval > maxAggregateBuckets {
+		return false
+	}
+
+	switch query.Function {
+	case AggregationAvg, AggregationMin, AggregationMax, AggregationSum, AggregationCount, AggregationFirst, AggregationLast:
+	case AggregationPercentile:
+		if query.Percentile < 0 || query.Percentile > 1 {
+			return false
+		}
+	default:
+		return false
+	}
+
+	switch query.GroupBy {
+	case "", GroupByPodName, GroupByServiceURL:
+	default:
+		return false
+	}
+
+	return true
+}
+
+func groupSeries(metrics []Metric) []Series {
+	series := make([]Series, 0)
+	indexes := make(map[string]int)
+	for _, metric := range metrics {
+		identity := SeriesIdentity{
+			ServiceURL: metric.ServiceURL,
+			MetricName: metric.MetricName,
+			PodName:    metric.PodName,
+			Labels:     metric.Labels,
+		}
+		key := identity.Key()
+		i, ok := indexes[key]
+		if !ok {
+			i = len(series)
+			indexes[key] = i
+			series = append(series, Series{SeriesIdentity: identity, Type: metric.Type})
+		}
+		series[i].Points = append(series[i].Points, Point{Time: metric.Time, Value: metric.MetricValue})
+	}
+	return series
+}
+
+func downsample(points []Point, start time.Time, step time.Duration) []Point {
+	result := make([]Point, 0, len(points))
+	lastBucket := int64(-1)
+	for _, point := range points {
+		bucket := int64(point.Time.Sub(start) / step)
+		if bucket == lastBucket {
+			result[len(result)-1] = point
+			continue
+		}
+		lastBucket = bucket
+		result = append(result, point)
+	}
+	return result
+}
+
+// checkMetricType defaults untyped metrics to gauges and validates the
+// metric against the series it extends. The series tracker only records the
+// metric once it is saved.
+func (s *MetricService) checkMetricType(metric Metric) (Metric, error) {
+	if metric.Type == "" {
+		metric.Type = MetricTypeGauge
+	}
+	if !isValidMetricType(metric.Type) {
+		s.log.Warn("validation failed for metric, unsupported type", slog.Any("metric", metric))
+		return metric, ErrInvalidMetricType
+	}
+
+	reset, err := s.series.check(metric, time.Now())
+	if err != nil {
+		s.log.Warn("validation failed for metric", slog.Any("metric", metric), slog.String("error", err.Error()))
+		return metric, err
+	}
+	if reset {
+		s.log.Info("counter reset detected", slog.Any("metric_identity", metric.MetricIdentity), slog.Float64("value", metric.MetricValue))
+	}
+	return metric, nil
+}
+
+func (s *MetricService) isWithinWindow(t, now time.Time) bool {
+	if s.window.MaxPastAge > 0 && t.Before(now.Add(-s.window.MaxPastAge)) {
+		return false
+	}
+	if s.window.MaxFutureSkew > 0 && t.After(now.Add(s.window.MaxFutureSkew)) {
+		return false
+	}
+	return true
+}
+
+func (s *MetricService) detectAnomaly(metric Metric) bool {
+	if s.detector == nil {
+		return false
+	}
+
+	isAnomaly := s.detector.Detect(metric)
+	if isAnomaly {
+		s.log.Warn("anomaly detected", slog.Any("metric", metric))
+	}
+	return isAnomaly
+}
+
+// observeAnomaly adds a saved metric to the history of the detector.
+func (s *MetricService) observeAnomaly(metric Metric) {
+	if s.detector != nil {
+		s.detector.Observe(metric)
+	}
+}
+
+func isValidMetric(metric Metric) bool {
+	return metric.ServiceURL != "" && metric.PodName != "" && metric.MetricName != "" && isValidLabels(metric.Labels)
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func isValidLabels(labels Labels) bool {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidLabelMatchers(matchers []LabelMatcher) bool {
+	for _, matcher := range matchers {
+		if !labelNamePattern.MatchString(matcher.Name) {
+			return false
+		}
+		switch matcher.Type {
+		case MatchEqual, MatchNotEqual:
+		case MatchRegexp, MatchNotRegexp:
+			if _, err := regexp.Compile("^(?:" + matcher.Value + ")$"); err != nil {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
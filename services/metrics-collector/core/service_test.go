@@ -0,0 +1,31 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsWithinWindow(t *testing.T) {
+	service := &MetricService{window: IngestionWindow{MaxPastAge: time.Hour, MaxFutureSkew: time.Minute}}
+
+	cases := []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{0, true},
+		{-59 * time.Minute, true},
+		{-61 * time.Minute, false},
+		{30 * time.Second, true},
+		{2 * time.Minute, false},
+	}
+	for _, c := range cases {
+		if got := service.isWithinWindow(epoch.Add(c.offset), epoch); got != c.want {
+			t.Errorf("offset %v: expected %v, got %v", c.offset, c.want, got)
+		}
+	}
+
+	unbounded := &MetricService{}
+	if !unbounded.isWithinWindow(epoch.Add(-24*365*time.Hour), epoch) {
+		t.Error("zero window must accept any past sample")
+	}
+}
@@ -11,10 +11,21 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/buffer"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/db"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/otlp"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/rest"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/scrape"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/statsd"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/tsdb"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/wal"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/anomaly"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
 	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/notifier"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/promql"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
@@ -27,21 +38,40 @@ func main() {
 	log := mustMakeLogger(cfg.LogLevel)
 	greetings(log)
 
-	storage := mustMakeStorage(log, &cfg.DB)
-	mustMakeMigrations(log, storage, cfg.DB.DBConnString)
-
-	metricService := core.NewMetricService(log, storage)
-
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	grpcServerGracefulStop := mustStartGRPCServer(log, ctx, cfg.GRPCAddress, metricService)
-	restServerGracefulStop := mustStartRESTServer(log, ctx, cfg, metricService)
+	storage, storageStop := mustStartStorage(log, ctx, cfg)
+
+	detector := mustMakeAnomalyDetector(log, &cfg.Anomaly)
+	metricRepo, metricRepoStop := mustStartMetricRepository(log, ctx, cfg, storage)
+	metricService := core.NewMetricService(log, metricRepo, detector, makeIngestionWindow(&cfg.Ingestion))
+	anomalyService := core.NewAnomalyService(log, storage)
+	alertNotifier := makeAlertNotifier(log, &cfg.Notifier, storage)
+	alertService := mustMakeAlertService(log, storage, alertNotifier, &cfg.Alerting)
+	retentionService := mustMakeRetentionService(log, storage, &cfg.Retention)
+	queryEngine := promql.NewEngine(log, &cfg.Prometheus, storage, storage)
+	otlpReceiver := otlp.NewReceiver(log, &cfg.OTLP, metricService)
+
+	grpcServerGracefulStop := mustStartGRPCServer(log, ctx, cfg, metricService, anomalyService, otlpReceiver)
+	restServerGracefulStop := mustStartRESTServer(log, ctx, cfg, metricService, anomalyService, alertService, retentionService, queryEngine, otlpReceiver)
+	statsdListenerStop := mustStartStatsDListener(log, ctx, &cfg.StatsD, metricService)
+	alertEvaluatorStop := startAlertEvaluator(log, ctx, &cfg.Alerting, alertService)
+	alertNotifierStop := startAlertNotifier(log, ctx, alertNotifier)
+	scrapeManagerStop := mustStartScrapeManager(log, ctx, &cfg.Scrape, metricService)
+	retentionJanitorStop := startRetentionJanitor(log, ctx, &cfg.Retention, retentionService)
 
 	<-ctx.Done()
 
 	grpcServerGracefulStop()
 	restServerGracefulStop()
+	statsdListenerStop()
+	alertEvaluatorStop()
+	alertNotifierStop()
+	scrapeManagerStop()
+	retentionJanitorStop()
+	metricRepoStop()
+	storageStop()
 }
 
 func mustLoadConfig() *config.Config {
@@ -78,10 +108,89 @@ func greetings(log *slog.Logger) {
 	log.Debug("debug messages are enabled")
 }
 
-func mustMakeStorage(log *slog.Logger, cfgDb *config.DB) *db.DB {
+// storageBackend is what a storage backend has to provide for the services to run.
+type storageBackend interface {
+	core.MetricRepository
+	core.AnomalyRepository
+	core.NotificationRepository
+	core.RetentionRepository
+	core.SeriesRepository
+}
+
+// tsdbStorage keeps the notification outbox in memory, the embedded storage
+// only holds metrics and anomalies.
+type tsdbStorage struct {
+	*tsdb.Storage
+	core.NotificationRepository
+}
+
+// mustStartStorage returns the configured storage backend. The returned stop
+// func must run after everything that uses the storage stopped.
+func mustStartStorage(log *slog.Logger, ctx context.Context, cfg *config.Config) (storageBackend, func()) {
+	switch cfg.Storage {
+	case "memory":
+		return mustMakeMemoryStorage(log, cfg.Ingestion.ConflictPolicy), func() {}
+	case "postgres":
+		storage := mustMakeDBStorage(log, &cfg.DB, cfg.Ingestion.ConflictPolicy)
+		mustMakeMigrations(log, storage, cfg.DB.DBConnString)
+		mustLimitRollupRefresh(log, storage, &cfg.Retention)
+		return storage, func() {}
+	case "tsdb":
+		return mustStartTSDBStorage(log, ctx, &cfg.TSDB, cfg.Ingestion.ConflictPolicy)
+	default:
+		log.Error("unknown storage", slog.String("storage", cfg.Storage))
+		os.Exit(1)
+		return nil, nil
+	}
+}
+
+func mustMakeMemoryStorage(log *slog.Logger, conflictPolicy string) *memory.Storage {
+	storage, err := memory.New(log, conflictPolicy)
+	if err != nil {
+		log.Error("failed to initialize storage", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	log.Warn("using in-memory storage, data will not survive a restart")
+
+	return storage
+}
+
+func mustStartTSDBStorage(log *slog.Logger, ctx context.Context, cfgTSDB *config.TSDB, conflictPolicy string) (storageBackend, func()) {
+	storage, err := tsdb.Open(log, cfgTSDB, conflictPolicy)
+	if err != nil {
+		log.Error("failed to initialize storage", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	notifications, err := memory.New(log, conflictPolicy)
+	if err != nil {
+		log.Error("failed to initialize storage", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	log.Warn("pending alert notifications are kept in memory with tsdb storage and will not survive a restart")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("tsdb compaction started", slog.Duration("interval", cfgTSDB.CompactionInterval))
+		storage.Run(ctx)
+	}()
+
+	return tsdbStorage{Storage: storage, NotificationRepository: notifications}, func() {
+		log.Debug("waiting for tsdb compaction to stop")
+		<-done
+		if err := storage.Close(); err != nil {
+			log.Error("failed to close tsdb", slog.String("error", err.Error()))
+		}
+		log.Info("tsdb closed")
+	}
+}
+
+func mustMakeDBStorage(log *slog.Logger, cfgDb *config.DB, conflictPolicy string) *db.DB {
 	log.Info("connecting to the database...")
 
-	storage, err := db.New(log, cfgDb)
+	storage, err := db.New(log, cfgDb, conflictPolicy)
 	if err != nil {
 		log.Error("failed to initialize storage", slog.String("error", err.Error()))
 		os.Exit(1)
@@ -99,19 +208,301 @@ func mustMakeMigrations(log *slog.Logger, db *db.DB, connString string) {
 	}
 }
 
-func mustStartGRPCServer(log *slog.Logger, ctx context.Context, grpcAddress string, metricService *core.MetricService) func() {
-	lis, err := net.Listen("tcp", grpcAddress)
+func mustLimitRollupRefresh(log *slog.Logger, db *db.DB, cfgRetention *config.Retention) {
+	if err := db.LimitRollupRefresh(cfgRetention); err != nil {
+		log.Error("failed to set rollup refresh window", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+}
+
+func makeIngestionWindow(cfgIngestion *config.Ingestion) core.IngestionWindow {
+	return core.IngestionWindow{
+		MaxPastAge:    cfgIngestion.MaxPastAge,
+		MaxFutureSkew: cfgIngestion.MaxFutureSkew,
+	}
+}
+
+func makeRemoteWriteWindow(cfgIngestion *config.Ingestion, cfgRemoteWrite *config.RemoteWrite) core.IngestionWindow {
+	return core.IngestionWindow{
+		MaxPastAge:    cfgRemoteWrite.MaxPastAge,
+		MaxFutureSkew: cfgIngestion.MaxFutureSkew,
+	}
+}
+
+// mustStartMetricRepository puts the write buffer or the WAL in front of
+// storage for ingestion, if one of them is enabled. The returned stop func
+// must run after every writer stopped.
+func mustStartMetricRepository(log *slog.Logger, ctx context.Context, cfg *config.Config, storage core.MetricRepository) (core.MetricRepository, func()) {
+	switch {
+	case cfg.WAL.Enabled && cfg.WriteBuffer.Enabled:
+		log.Error("write buffer and wal cannot be enabled together, the wal already batches writes")
+		os.Exit(1)
+	case cfg.WAL.Enabled:
+		return mustStartWAL(log, ctx, &cfg.WAL, storage)
+	case cfg.WriteBuffer.Enabled:
+		return mustStartWriteBuffer(log, &cfg.WriteBuffer, storage)
+	}
+
+	log.Info("write buffer and wal are disabled")
+	return storage, func() {}
+}
+
+func mustStartWAL(log *slog.Logger, ctx context.Context, cfgWAL *config.WAL, storage core.MetricRepository) (core.MetricRepository, func()) {
+	writeAheadLog, err := wal.New(log, cfgWAL, storage)
+	if err != nil {
+		log.Error("failed to initialize wal", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("wal replay started", slog.String("dir", cfgWAL.Dir))
+		writeAheadLog.Run(ctx)
+	}()
+
+	return writeAheadLog, func() {
+		log.Debug("waiting for wal replay to stop")
+		<-done
+		if err := writeAheadLog.Close(); err != nil {
+			log.Error("failed to close wal", slog.String("error", err.Error()))
+		}
+		log.Info("wal closed")
+	}
+}
+
+func mustStartWriteBuffer(log *slog.Logger, cfgWriteBuffer *config.WriteBuffer, storage core.MetricRepository) (core.MetricRepository, func()) {
+	writeBuffer, err := buffer.NewWriteBuffer(log, cfgWriteBuffer, storage)
+	if err != nil {
+		log.Error("failed to initialize write buffer", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	writeBuffer.Start()
+	log.Info("write buffer started", slog.Int("queue_size", cfgWriteBuffer.QueueSize), slog.Int("workers", cfgWriteBuffer.Workers))
+
+	return writeBuffer, func() {
+		log.Debug("draining write buffer")
+		writeBuffer.Close()
+		log.Info("write buffer drained")
+	}
+}
+
+func mustMakeAnomalyDetector(log *slog.Logger, cfgAnomaly *config.Anomaly) core.AnomalyDetector {
+	if !cfgAnomaly.Enabled {
+		log.Info("anomaly detection is disabled")
+		return nil
+	}
+
+	detector, err := anomaly.NewDetector(log, cfgAnomaly)
+	if err != nil {
+		log.Error("failed to initialize anomaly detector", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	log.Info("anomaly detection is enabled", slog.Int("metric_overrides", len(cfgAnomaly.Metrics)))
+	return detector
+}
+
+func makeAlertNotifier(log *slog.Logger, cfgNotifier *config.Notifier, repo core.NotificationRepository) *notifier.Webhook {
+	if !cfgNotifier.Enabled {
+		log.Info("alert notifications are disabled")
+		return nil
+	}
+
+	log.Info("alert notifications are enabled", slog.Int("webhooks", len(cfgNotifier.Webhooks)))
+	return notifier.NewWebhook(log, cfgNotifier, repo)
+}
+
+func startAlertNotifier(log *slog.Logger, ctx context.Context, alertNotifier *notifier.Webhook) func() {
+	if alertNotifier == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("alert notifier started")
+		alertNotifier.Run(ctx)
+	}()
+
+	return func() {
+		log.Debug("waiting for alert notifier to stop")
+		<-done
+		log.Info("alert notifier stopped")
+	}
+}
+
+func mustMakeAlertService(log *slog.Logger, repo core.MetricRepository, alertNotifier *notifier.Webhook, cfgAlerting *config.Alerting) *core.AlertService {
+	rules := make([]core.AlertRule, 0, len(cfgAlerting.Rules))
+	for _, rule := range cfgAlerting.Rules {
+		rules = append(rules, core.AlertRule{
+			Name:        rule.Name,
+			ServiceURL:  rule.ServiceURL,
+			MetricName:  rule.MetricName,
+			PodName:     rule.PodName,
+			Function:    rule.Function,
+			Percentile:  rule.Percentile,
+			GroupBy:     rule.GroupBy,
+			Window:      rule.Window,
+			Operator:    rule.Operator,
+			Threshold:   rule.Threshold,
+			For:         rule.For,
+			Labels:      rule.Labels,
+			Annotations: rule.Annotations,
+		})
+	}
+
+	// A nil *notifier.Webhook must stay a nil interface, otherwise the
+	// service would try to notify through it.
+	var alertServiceNotifier core.AlertNotifier
+	if alertNotifier != nil {
+		alertServiceNotifier = alertNotifier
+	}
+
+	alertService, err := core.NewAlertService(log, repo, alertServiceNotifier, rules)
+	if err != nil {
+		log.Error("failed to load alert rules", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	log.Info("alert rules loaded", slog.Int("rules", len(rules)))
+
+	return alertService
+}
+
+func startAlertEvaluator(log *slog.Logger, ctx context.Context, cfgAlerting *config.Alerting, alertService *core.AlertService) func() {
+	if !cfgAlerting.Enabled {
+		log.Info("alerting is disabled")
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("alert evaluator started", slog.Duration("interval", cfgAlerting.EvaluationInterval))
+		alertService.Run(ctx, cfgAlerting.EvaluationInterval)
+	}()
+
+	return func() {
+		log.Debug("waiting for alert evaluator to stop")
+		<-done
+		log.Info("alert evaluator stopped")
+	}
+}
+
+func mustMakeRetentionService(log *slog.Logger, repo core.RetentionRepository, cfgRetention *config.Retention) *core.RetentionService {
+	rules := make([]core.RetentionRule, 0, len(cfgRetention.Rules))
+	for _, rule := range cfgRetention.Rules {
+		rules = append(rules, core.RetentionRule{
+			Name:          rule.Name,
+			MetricPattern: rule.MetricName,
+			Retention:     rule.Retention,
+		})
+	}
+
+	retentionService, err := core.NewRetentionService(log, repo, rules, cfgRetention.Default, cfgRetention.CompressAfter)
+	if err != nil {
+		log.Error("failed to load retention rules", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	log.Info("retention rules loaded", slog.Int("rules", len(rules)))
+
+	return retentionService
+}
+
+func startRetentionJanitor(log *slog.Logger, ctx context.Context, cfgRetention *config.Retention, retentionService *core.RetentionService) func() {
+	if !cfgRetention.Enabled {
+		log.Warn("retention is disabled, data is kept forever")
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("retention janitor started", slog.Duration("interval", cfgRetention.Interval))
+		retentionService.Run(ctx, cfgRetention.Interval)
+	}()
+
+	return func() {
+		log.Debug("waiting for retention janitor to stop")
+		<-done
+		log.Info("retention janitor stopped")
+	}
+}
+
+func mustStartScrapeManager(log *slog.Logger, ctx context.Context, cfgScrape *config.Scrape, metricService *core.MetricService) func() {
+	if !cfgScrape.Enabled {
+		log.Info("scraping is disabled")
+		return func() {}
+	}
+
+	manager, err := scrape.NewManager(log, cfgScrape, metricService)
+	if err != nil {
+		log.Error("failed to initialize scrape manager", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("scrape manager started", slog.Int("targets", len(cfgScrape.Targets)))
+		manager.Run(ctx)
+	}()
+
+	return func() {
+		log.Debug("waiting for scrape manager to stop")
+		<-done
+		log.Info("scrape manager stopped")
+	}
+}
+
+func mustStartStatsDListener(log *slog.Logger, ctx context.Context, cfgStatsD *config.StatsD, metricService *core.MetricService) func() {
+	if !cfgStatsD.Enabled {
+		log.Info("statsd listener is disabled")
+		return func() {}
+	}
+
+	listener, err := statsd.NewListener(log, cfgStatsD, metricService)
+	if err != nil {
+		log.Error("failed to initialize statsd listener", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Info("statsd listener started", slog.String("address", listener.Addr().String()))
+		listener.Run(ctx)
+	}()
+
+	return func() {
+		log.Debug("waiting for statsd listener to stop")
+		<-done
+		log.Info("statsd listener stopped")
+	}
+}
+
+func mustStartGRPCServer(log *slog.Logger, ctx context.Context, cfg *config.Config, metricService *core.MetricService, anomalyService *core.AnomalyService, otlpReceiver *otlp.Receiver) func() {
+	metricsServer, err := metricsgrpc.NewServer(log, ctx, &cfg.Stream, metricService, anomalyService)
+	if err != nil {
+		log.Error("failed to initialize gRPC server", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	lis, err := net.Listen("tcp", cfg.GRPCAddress)
 	if err != nil {
 		log.Error("failed to listen gRPC", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 
 	s := grpc.NewServer()
-	metricspb.RegisterMetricsCollectorServer(s, metricsgrpc.NewServer(log, metricService))
+	metricspb.RegisterMetricsCollectorServer(s, metricsServer)
+	colmetricspb.RegisterMetricsServiceServer(s, metricsgrpc.NewOTLPServer(log, otlpReceiver))
 	reflection.Register(s)
 
 	go func() {
-		log.Info("gRPC server started", slog.String("address", grpcAddress))
+		log.Info("gRPC server started", slog.String("address", cfg.GRPCAddress))
 		if err := s.Serve(lis); err != nil {
 			log.Error("gRPC server failed", slog.String("error", err.Error()))
 		}
@@ -124,20 +515,40 @@ func mustStartGRPCServer(log *slog.Logger, ctx context.Context, grpcAddress stri
 	}
 }
 
-func mustMakeMux(log *slog.Logger, metricService *core.MetricService) *http.ServeMux {
+func mustMakeMux(log *slog.Logger, cfg *config.Config, metricService *core.MetricService, anomalyService *core.AnomalyService, alertService *core.AlertService, retentionService *core.RetentionService, queryEngine *promql.Engine, otlpReceiver *otlp.Receiver) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /", rest.NewPingHandler(log))
 	mux.HandleFunc("GET /metric", rest.NewGetMetricByMetricIdentityHandler(log, metricService))
 	mux.HandleFunc("POST /metric", rest.NewCreateMetricHandler(log, metricService))
+	mux.HandleFunc("POST /metrics/batch", rest.NewCreateMetricsHandler(log, metricService))
+	remoteWriteService := metricService.WithIngestionWindow(makeRemoteWriteWindow(&cfg.Ingestion, &cfg.RemoteWrite))
+	mux.HandleFunc("POST /api/v1/write", rest.NewRemoteWriteHandler(log, &cfg.Prometheus, &cfg.RemoteWrite, remoteWriteService))
+	mux.HandleFunc("POST /v1/metrics", rest.NewOTLPMetricsHandler(log, otlpReceiver))
+	mux.HandleFunc("GET /metrics/range", rest.NewQueryRangeHandler(log, metricService))
+	mux.HandleFunc("GET /metrics/aggregate", rest.NewAggregateHandler(log, metricService))
+	mux.HandleFunc("GET /metrics/rate", rest.NewRateHandler(log, metricService))
+	mux.HandleFunc("GET /metrics/increase", rest.NewIncreaseHandler(log, metricService))
+	mux.HandleFunc("GET /metrics/histogram_quantile", rest.NewHistogramQuantileHandler(log, metricService))
+	mux.HandleFunc("GET /anomalies", rest.NewListAnomaliesHandler(log, anomalyService))
+	mux.HandleFunc("POST /anomalies/ack", rest.NewAcknowledgeAnomalyHandler(log, anomalyService))
+	mux.HandleFunc("GET /alerts", rest.NewListAlertsHandler(log, alertService))
+	mux.HandleFunc("GET /admin/retention", rest.NewListRetentionPoliciesHandler(log, retentionService))
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		mux.HandleFunc(method+" /api/v1/query", rest.NewPromQueryHandler(log, queryEngine))
+		mux.HandleFunc(method+" /api/v1/query_range", rest.NewPromQueryRangeHandler(log, queryEngine))
+		mux.HandleFunc(method+" /api/v1/series", rest.NewPromSeriesHandler(log, queryEngine))
+		mux.HandleFunc(method+" /api/v1/labels", rest.NewPromLabelsHandler(log, queryEngine))
+	}
+	mux.HandleFunc("GET /api/v1/label/{name}/values", rest.NewPromLabelValuesHandler(log, queryEngine))
 
 	log.Info("mux initialized with routes")
 
 	return mux
 }
 
-func mustStartRESTServer(log *slog.Logger, ctx context.Context, cfg *config.Config, metricService *core.MetricService) func() {
-	mux := mustMakeMux(log, metricService)
+func mustStartRESTServer(log *slog.Logger, ctx context.Context, cfg *config.Config, metricService *core.MetricService, anomalyService *core.AnomalyService, alertService *core.AlertService, retentionService *core.RetentionService, queryEngine *promql.Engine, otlpReceiver *otlp.Receiver) func() {
+	mux := mustMakeMux(log, cfg, metricService, anomalyService, alertService, retentionService, queryEngine, otlpReceiver)
 	server := &http.Server{
 		Addr:        cfg.AppAddress,
 		ReadTimeout: cfg.ReadTimeout,
@@ -0,0 +1,284 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+const (
+	// linkMargin is how much data around the alert the payload link shows.
+	linkMargin = 15 * time.Minute
+	// pruneInterval is how often notifications older than the retention are
+	// deleted.
+	pruneInterval = time.Hour
+)
+
+type AlertPayload struct {
+	Rule        string            `json:"rule"`
+	State       string            `json:"state"`
+	ServiceURL  string            `json:"service_url"`
+	MetricName  string            `json:"metric_name"`
+	PodName     string            `json:"pod_name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Value       float64           `json:"value"`
+	StartsAt    time.Time         `json:"starts_at"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+	Link        string            `json:"link,omitempty"`
+}
+
+type Payload struct {
+	Group  string         `json:"group"`
+	Alerts []AlertPayload `json:"alerts"`
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+type Webhook struct {
+	log    *slog.Logger
+	cfg    *config.Notifier
+	repo   core.NotificationRepository
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewWebhook(log *slog.Logger, cfgNotifier *config.Notifier, repo core.NotificationRepository) *Webhook {
+	return &Webhook{
+		log:    log,
+		cfg:    cfgNotifier,
+		repo:   repo,
+		client: &http.Client{Timeout: cfgNotifier.Timeout},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (n *Webhook) Notify(alerts []core.Alert) {
+	enqueued := 0
+	for _, webhookURL := range n.cfg.Webhooks {
+		for _, alert := range alerts {
+			notification := core.AlertNotification{
+				WebhookURL: webhookURL,
+				GroupKey:   alert.Series.ServiceURL,
+				AlertKey:   alertKey(alert),
+				Alert:      alert,
+			}
+			ok, err := n.repo.EnqueueNotification(notification)
+			if err != nil {
+				n.log.Error("failed to enqueue alert notification", slog.String("webhook", webhookURL), slog.String("error", err.Error()))
+				continue
+			}
+			if ok {
+				enqueued++
+			}
+		}
+	}
+
+	if enqueued > 0 {
+		select {
+		case n.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *Webhook) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.cfg.PollInterval)
+	defer ticker.Stop()
+
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+	n.prune()
+
+	for {
+		n.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		case <-pruneTicker.C:
+			n.prune()
+		}
+	}
+}
+
+func (n *Webhook) prune() {
+	if n.cfg.Retention <= 0 {
+		return
+	}
+
+	if _, err := n.repo.DeleteNotificationsBefore(time.Now().UTC().Add(-n.cfg.Retention)); err != nil {
+		n.log.Error("failed to delete old notifications", slog.String("error", err.Error()))
+	}
+}
+
+func (n *Webhook) deliverDue(ctx context.Context) {
+	notifications, err := n.repo.FindDueNotifications(time.Now().UTC(), n.cfg.BatchSize)
+	if err != nil {
+		n.log.Error("failed to load due notifications", slog.String("error", err.Error()))
+		return
+	}
+
+	type groupKey struct {
+		webhookURL string
+		group      string
+	}
+	order := make([]groupKey, 0)
+	groups := make(map[groupKey][]core.AlertNotification)
+	for _, notification := range notifications {
+		key := groupKey{webhookURL: notification.WebhookURL, group: notification.GroupKey}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], notification)
+	}
+
+	for _, key := range order {
+		if ctx.Err() != nil {
+			return
+		}
+		n.deliver(ctx, key.webhookURL, key.group, groups[key])
+	}
+}
+
+func (n *Webhook) deliver(ctx context.Context, webhookURL, group string, notifications []core.AlertNotification) {
+	ids := make([]int64, 0, len(notifications))
+	payload := Payload{Group: group, Alerts: make([]AlertPayload, 0, len(notifications))}
+	attempts := 0
+	for _, notification := range notifications {
+		ids = append(ids, notification.ID)
+		payload.Alerts = append(payload.Alerts, n.toAlertPayload(notification.Alert))
+		attempts = max(attempts, notification.Attempts)
+	}
+	attempts++
+
+	err := n.post(ctx, webhookURL, payload)
+	if err == nil {
+		if err := n.repo.MarkNotificationsDelivered(ids, time.Now().UTC()); err != nil {
+			n.log.Error("failed to record notification delivery", slog.String("error", err.Error()))
+			return
+		}
+		n.log.Info("alert notification delivered", slog.String("webhook", webhookURL), slog.String("group", group), slog.Int("alerts", len(ids)))
+		return
+	}
+
+	if ctx.Err() != nil {
+		n.log.Warn("alert notification interrupted by shutdown", slog.String("webhook", webhookURL), slog.String("group", group))
+		return
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || attempts >= n.cfg.MaxAttempts {
+		n.log.Error("alert notification failed permanently", slog.String("webhook", webhookURL), slog.Int("attempts", attempts), slog.String("error", err.Error()))
+		if err := n.repo.MarkNotificationsFailed(ids, attempts, err.Error()); err != nil {
+			n.log.Error("failed to record notification failure", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	nextAttemptAt := time.Now().UTC().Add(n.backoff(attempts))
+	n.log.Warn("alert notification failed, will retry", slog.String("webhook", webhookURL), slog.Int("attempts", attempts), slog.Time("next_attempt_at", nextAttemptAt), slog.String("error", err.Error()))
+	if err := n.repo.MarkNotificationsRetry(ids, attempts, nextAttemptAt, err.Error()); err != nil {
+		n.log.Error("failed to reschedule notification", slog.String("error", err.Error()))
+	}
+}
+
+func (n *Webhook) post(ctx context.Context, webhookURL string, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("failed to serialize payload: %w", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("failed to build request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return &permanentError{err: fmt.Errorf("webhook rejected notification with status %d", resp.StatusCode)}
+	default:
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+}
+
+func (n *Webhook) backoff(attempts int) time.Duration {
+	backoff := n.cfg.InitialBackoff
+	for i := 1; i < attempts && backoff < n.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, n.cfg.MaxBackoff)
+}
+
+func (n *Webhook) toAlertPayload(alert core.Alert) AlertPayload {
+	alertPayload := AlertPayload{
+		Rule:        alert.Rule,
+		State:       alert.State,
+		ServiceURL:  alert.Series.ServiceURL,
+		MetricName:  alert.Series.MetricName,
+		PodName:     alert.Series.PodName,
+		Labels:      alert.Labels,
+		Annotations: alert.Annotations,
+		Value:       alert.Value,
+		StartsAt:    alert.ActiveAt,
+	}
+
+	end := alert.ActiveAt
+	if !alert.FiredAt.IsZero() {
+		alertPayload.FiredAt = &alert.FiredAt
+		end = alert.FiredAt
+	}
+	if !alert.ResolvedAt.IsZero() {
+		alertPayload.ResolvedAt = &alert.ResolvedAt
+		end = alert.ResolvedAt
+	}
+
+	if n.cfg.ExternalURL != "" {
+		params := url.Values{}
+		params.Set("service_url", alert.Series.ServiceURL)
+		params.Set("metric_name", alert.Series.MetricName)
+		params.Set("pod_name", alert.Series.PodName)
+		params.Set("start", alert.ActiveAt.Add(-linkMargin).Format(time.RFC3339Nano))
+		params.Set("end", end.Add(linkMargin).Format(time.RFC3339Nano))
+		alertPayload.Link = strings.TrimSuffix(n.cfg.ExternalURL, "/") + "/metrics/range?" + params.Encode()
+	}
+
+	return alertPayload
+}
+
+// alertKey identifies an alert across notifications. The series labels are
+// part of it, so that alerts of one rule on series that differ only by labels
+// are deduped apart.
+func alertKey(alert core.Alert) string {
+	return strings.Join([]string{alert.Rule, alert.Series.ServiceURL, alert.Series.MetricName, alert.Series.PodName, alert.Series.Labels.String()}, "|")
+}
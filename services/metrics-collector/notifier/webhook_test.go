@@ -0,0 +1,335 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+type notificationLog struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries []*logEntry
+}
+
+type logEntry struct {
+	notification core.AlertNotification
+	status       string
+}
+
+func (l *notificationLog) EnqueueNotification(notification core.AlertNotification) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		entry := l.entries[i].notification
+		if entry.WebhookURL == notification.WebhookURL && entry.AlertKey == notification.AlertKey {
+			if entry.Alert.State == notification.Alert.State {
+				return false, nil
+			}
+			break
+		}
+	}
+
+	l.nextID++
+	notification.ID = l.nextID
+	l.entries = append(l.entries, &logEntry{notification: notification, status: "pending"})
+	return true, nil
+}
+
+func (l *notificationLog) FindDueNotifications(now time.Time, limit int) ([]core.AlertNotification, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	due := make([]core.AlertNotification, 0)
+	for _, entry := range l.entries {
+		if entry.status == "pending" && !entry.notification.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, entry.notification)
+		}
+	}
+	return due, nil
+}
+
+func (l *notificationLog) MarkNotificationsDelivered(ids []int64, _ time.Time) error {
+	l.update(ids, func(entry *logEntry) {
+		entry.status = "delivered"
+		entry.notification.Attempts++
+	})
+	return nil
+}
+
+func (l *notificationLog) MarkNotificationsRetry(ids []int64, attempts int, nextAttemptAt time.Time, _ string) error {
+	l.update(ids, func(entry *logEntry) {
+		entry.notification.Attempts = attempts
+		entry.notification.NextAttemptAt = nextAttemptAt
+	})
+	return nil
+}
+
+func (l *notificationLog) MarkNotificationsFailed(ids []int64, attempts int, _ string) error {
+	l.update(ids, func(entry *logEntry) {
+		entry.status = "failed"
+		entry.notification.Attempts = attempts
+	})
+	return nil
+}
+
+func (l *notificationLog) DeleteNotificationsBefore(time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (l *notificationLog) update(ids []int64, fn func(entry *logEntry)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range ids {
+		for _, entry := range l.entries {
+			if entry.notification.ID == id {
+				fn(entry)
+			}
+		}
+	}
+}
+
+func (l *notificationLog) statuses() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := make([]string, 0, len(l.entries))
+	for _, entry := range l.entries {
+		statuses = append(statuses, entry.status)
+	}
+	return statuses
+}
+
+func (l *notificationLog) attempts(i int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.entries[i].notification.Attempts
+}
+
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []Payload
+	failures int
+}
+
+func newWebhookServer(t *testing.T, failures int) *webhookServer {
+	s := &webhookServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var payload Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		s.payloads = append(s.payloads, payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) received() []Payload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Payload(nil), s.payloads...)
+}
+
+func newTestWebhook(repo core.NotificationRepository, webhookURL string) *Webhook {
+	return NewWebhook(slog.New(slog.NewTextHandler(io.Discard, nil)), &config.Notifier{
+		Webhooks:       []string{webhookURL},
+		ExternalURL:    "http://collector:8080",
+		Timeout:        time.Second,
+		PollInterval:   5 * time.Millisecond,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		MaxAttempts:    5,
+		BatchSize:      100,
+	}, repo)
+}
+
+func firingAlert(serviceURL, podName string) core.Alert {
+	activeAt := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	return core.Alert{
+		Rule:     "HighCPUUsage",
+		Series:   core.SeriesIdentity{ServiceURL: serviceURL, MetricName: "system_cpu_usage", PodName: podName},
+		Labels:   map[string]string{"severity": "critical"},
+		State:    core.AlertStateFiring,
+		Value:    0.95,
+		ActiveAt: activeAt,
+		FiredAt:  activeAt.Add(2 * time.Minute),
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookGroupsAlertsByServiceURL(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	repo := &notificationLog{}
+	webhook := newTestWebhook(repo, server.URL)
+
+	webhook.Notify([]core.Alert{
+		firingAlert("service-a/metrics", "pod-1"),
+		firingAlert("service-a/metrics", "pod-2"),
+		firingAlert("service-b/metrics", "pod-1"),
+	})
+	webhook.deliverDue(context.Background())
+
+	payloads := server.received()
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 grouped payloads, got %d", len(payloads))
+	}
+	if payloads[0].Group != "service-a/metrics" || len(payloads[0].Alerts) != 2 {
+		t.Fatalf("unexpected first group: %+v", payloads[0])
+	}
+	if payloads[1].Group != "service-b/metrics" || len(payloads[1].Alerts) != 1 {
+		t.Fatalf("unexpected second group: %+v", payloads[1])
+	}
+
+	alert := payloads[0].Alerts[0]
+	if alert.Rule != "HighCPUUsage" || alert.Value != 0.95 || alert.Labels["severity"] != "critical" {
+		t.Fatalf("unexpected alert payload: %+v", alert)
+	}
+	if alert.StartsAt.IsZero() || alert.Link == "" {
+		t.Fatalf("alert payload should carry start time and link: %+v", alert)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	server := newWebhookServer(t, 2)
+	repo := &notificationLog{}
+	webhook := newTestWebhook(repo, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		webhook.Run(ctx)
+	}()
+
+	webhook.Notify([]core.Alert{firingAlert("service-a/metrics", "pod-1")})
+	// The server records the payload before the delivery is marked, so wait
+	// for the status rather than the payload.
+	waitFor(t, func() bool {
+		statuses := repo.statuses()
+		return len(statuses) == 1 && statuses[0] == "delivered"
+	})
+
+	cancel()
+	<-done
+
+	if payloads := server.received(); len(payloads) != 1 {
+		t.Fatalf("expected a single delivery, got %d", len(payloads))
+	}
+	if attempts := repo.attempts(0); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWebhookGivesUpAfterMaxAttempts(t *testing.T) {
+	server := newWebhookServer(t, 100)
+	repo := &notificationLog{}
+	webhook := newTestWebhook(repo, server.URL)
+
+	webhook.Notify([]core.Alert{firingAlert("service-a/metrics", "pod-1")})
+	waitFor(t, func() bool {
+		webhook.deliverDue(context.Background())
+		return repo.statuses()[0] == "failed"
+	})
+
+	if attempts := repo.attempts(0); attempts != 5 {
+		t.Fatalf("expected 5 attempts, got %d", attempts)
+	}
+}
+
+func TestWebhookDedupesAcrossRestart(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	repo := &notificationLog{}
+
+	webhook := newTestWebhook(repo, server.URL)
+	webhook.Notify([]core.Alert{firingAlert("service-a/metrics", "pod-1")})
+	webhook.Notify([]core.Alert{firingAlert("service-a/metrics", "pod-1")})
+	webhook.deliverDue(context.Background())
+
+	restarted := newTestWebhook(repo, server.URL)
+	restarted.Notify([]core.Alert{firingAlert("service-a/metrics", "pod-1")})
+	restarted.deliverDue(context.Background())
+
+	if payloads := server.received(); len(payloads) != 1 {
+		t.Fatalf("expected a single delivery, got %d", len(payloads))
+	}
+
+	resolved := firingAlert("service-a/metrics", "pod-1")
+	resolved.State = core.AlertStateResolved
+	resolved.ResolvedAt = resolved.FiredAt.Add(time.Minute)
+	restarted.Notify([]core.Alert{resolved})
+	restarted.deliverDue(context.Background())
+
+	payloads := server.received()
+	if len(payloads) != 2 || payloads[1].Alerts[0].State != core.AlertStateResolved {
+		t.Fatalf("expected resolved notification to be delivered, got %+v", payloads)
+	}
+}
+
+func TestWebhookDedupesAlertsBySeriesLabels(t *testing.T) {
+	server := newWebhookServer(t, 0)
+	repo := &notificationLog{}
+	webhook := newTestWebhook(repo, server.URL)
+
+	get := firingAlert("service-a/metrics", "pod-1")
+	get.Series.Labels = core.Labels{"method": "GET", "code": "500"}
+	post := firingAlert("service-a/metrics", "pod-1")
+	post.Series.Labels = core.Labels{"method": "POST", "code": "500"}
+	reordered := firingAlert("service-a/metrics", "pod-1")
+	reordered.Series.Labels = core.Labels{"code": "500", "method": "GET"}
+
+	webhook.Notify([]core.Alert{get, post, reordered})
+	webhook.deliverDue(context.Background())
+
+	payloads := server.received()
+	if len(payloads) != 1 || len(payloads[0].Alerts) != 2 {
+		t.Fatalf("expected both series to be notified once, got %+v", payloads)
+	}
+}
+
+func TestWebhookKeepsPendingOnShutdown(t *testing.T) {
+	repo := &notificationLog{}
+	webhook := newTestWebhook(repo, "http://127.0.0.1:0")
+
+	webhook.Notify([]core.Alert{firingAlert("service-a/metrics", "pod-1")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	webhook.deliverDue(ctx)
+
+	if statuses := repo.statuses(); len(statuses) != 1 || statuses[0] != "pending" {
+		t.Fatalf("expected notification to stay pending, got %v", statuses)
+	}
+}
@@ -0,0 +1,64 @@
+package promql
+
+import (
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// MetricNameLabel holds the metric name in label sets and selectors.
+const MetricNameLabel = "__name__"
+
+type Expr interface {
+	expr()
+}
+
+type NumberLiteral struct {
+	Value float64
+}
+
+// VectorSelector selects series by matchers, the metric name included as a
+// matcher on MetricNameLabel.
+type VectorSelector struct {
+	Matchers []core.LabelMatcher
+}
+
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+}
+
+type Call struct {
+	Func string
+	Arg  *MatrixSelector
+}
+
+type AggregateExpr struct {
+	Op       string
+	Expr     Expr
+	Grouping []string
+	Without  bool
+}
+
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+type UnaryExpr struct {
+	Expr Expr
+}
+
+type ParenExpr struct {
+	Expr Expr
+}
+
+func (*NumberLiteral) expr()  {}
+func (*VectorSelector) expr() {}
+func (*MatrixSelector) expr() {}
+func (*Call) expr()           {}
+func (*AggregateExpr) expr()  {}
+func (*BinaryExpr) expr()     {}
+func (*UnaryExpr) expr()      {}
+func (*ParenExpr) expr()      {}
@@ -0,0 +1,702 @@
+package promql
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+// maxSteps matches the resolution limit of Prometheus.
+const maxSteps = 11000
+
+var ErrExecution = errors.New("query execution failed")
+
+const (
+	ValueTypeScalar = "scalar"
+	ValueTypeVector = "vector"
+	ValueTypeMatrix = "matrix"
+)
+
+type Series struct {
+	Labels core.Labels
+	Points []core.Point
+}
+
+// Result holds Scalar for scalar results and Series otherwise. Every series
+// of a vector has exactly one point.
+type Result struct {
+	Type   string
+	Scalar core.Point
+	Series []Series
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Engine evaluates queries against the stored samples. Series are exposed
+// with their labels plus the metric name, service URL and pod name under
+// MetricNameLabel and the configured service and pod labels.
+type Engine struct {
+	log     *slog.Logger
+	cfg     *config.Prometheus
+	metrics core.MetricRepository
+	series  core.SeriesRepository
+}
+
+func NewEngine(log *slog.Logger, cfgPrometheus *config.Prometheus, metrics core.MetricRepository, series core.SeriesRepository) *Engine {
+	return &Engine{
+		log:     log,
+		cfg:     cfgPrometheus,
+		metrics: metrics,
+		series:  series,
+	}
+}
+
+// Query evaluates an instant query at t.
+func (e *Engine) Query(query string, t time.Time) (*Result, error) {
+	expr, err := Parse(query)
+	if err != nil {
+		e.log.Warn("invalid query", slog.String("query", query), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	if matrix, ok := expr.(*MatrixSelector); ok {
+		series, err := e.selectSeries(matrix.Vector, t.Add(-matrix.Range), t)
+		if err != nil {
+			return nil, err
+		}
+		result := &Result{Type: ValueTypeMatrix, Series: make([]Series, 0, len(series))}
+		for _, s := range series {
+			if points := window(s.Points, t.Add(-matrix.Range), t); len(points) > 0 {
+				result.Series = append(result.Series, Series{Labels: s.Labels, Points: points})
+			}
+		}
+		e.log.Info("query successfully evaluated", slog.String("query", query), slog.Int("series", len(result.Series)))
+		return result, nil
+	}
+
+	ev := &evaluator{engine: e, start: t, step: time.Second, steps: 1}
+	value, err := ev.eval(expr)
+	if err != nil {
+		e.log.Warn("failed to evaluate query", slog.String("query", query), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	result := &Result{Type: ValueTypeVector, Series: make([]Series, 0)}
+	switch value := value.(type) {
+	case scalarValue:
+		result.Type = ValueTypeScalar
+		result.Scalar = core.Point{Time: t, Value: value[0]}
+	case vectorValue:
+		for _, s := range value {
+			if s.ok[0] {
+				result.Series = append(result.Series, Series{Labels: s.labels, Points: []core.Point{{Time: t, Value: s.values[0]}}})
+			}
+		}
+	}
+
+	e.log.Info("query successfully evaluated", slog.String("query", query), slog.Int("series", len(result.Series)))
+	return result, nil
+}
+
+// QueryRange evaluates a query at every step from start to end. Scalars come
+// back as a single series without labels.
+func (e *Engine) QueryRange(query string, start, end time.Time, step time.Duration) (*Result, error) {
+	if step <= 0 || end.Before(start) {
+		return nil, fmt.Errorf("%w: end must not be before start and step must be positive", ErrInvalidQuery)
+	}
+	if end.Sub(start)/step >= maxSteps {
+		return nil, fmt.Errorf("%w: exceeded maximum resolution of %d points per series, try increasing the step", ErrInvalidQuery, maxSteps)
+	}
+
+	expr, err := Parse(query)
+	if err != nil {
+		e.log.Warn("invalid query", slog.String("query", query), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if _, ok := expr.(*MatrixSelector); ok {
+		return nil, fmt.Errorf("%w: range query must return a scalar or an instant vector, got a range vector", ErrInvalidQuery)
+	}
+
+	ev := &evaluator{engine: e, start: start, step: step, steps: int(end.Sub(start)/step) + 1}
+	value, err := ev.eval(expr)
+	if err != nil {
+		e.log.Warn("failed to evaluate query", slog.String("query", query), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	result := &Result{Type: ValueTypeMatrix, Series: make([]Series, 0)}
+	switch value := value.(type) {
+	case scalarValue:
+		points := make([]core.Point, 0, ev.steps)
+		for i, v := range value {
+			points = append(points, core.Point{Time: ev.at(i), Value: v})
+		}
+		result.Series = append(result.Series, Series{Labels: core.Labels{}, Points: points})
+	case vectorValue:
+		for _, s := range value {
+			points := make([]core.Point, 0)
+			for i, v := range s.values {
+				if s.ok[i] {
+					points = append(points, core.Point{Time: ev.at(i), Value: v})
+				}
+			}
+			if len(points) > 0 {
+				result.Series = append(result.Series, Series{Labels: s.labels, Points: points})
+			}
+		}
+	}
+
+	e.log.Info("range query successfully evaluated", slog.String("query", query), slog.Int("series", len(result.Series)))
+	return result, nil
+}
+
+// Series returns the label sets of the series matching any of the selectors
+// with samples between start and end.
+func (e *Engine) Series(matches []string, start, end time.Time) ([]core.Labels, error) {
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: no match[] parameter provided", ErrInvalidQuery)
+	}
+	return e.labelSets(matches, start, end)
+}
+
+// LabelNames returns the sorted label names of the matching series, or of all
+// series when there are no selectors.
+func (e *Engine) LabelNames(matches []string, start, end time.Time) ([]string, error) {
+	labelSets, err := e.labelSets(matches, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, labels := range labelSets {
+		for name, value := range labels {
+			if value != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (e *Engine) LabelValues(name string, matches []string, start, end time.Time) ([]string, error) {
+	if !labelNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid label name %q", ErrInvalidQuery, name)
+	}
+	labelSets, err := e.labelSets(matches, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0)
+	for _, labels := range labelSets {
+		if value := labels[name]; value != "" && !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	slices.Sort(values)
+	return values, nil
+}
+
+func (e *Engine) labelSets(matches []string, start, end time.Time) ([]core.Labels, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: end must not be before start", ErrInvalidQuery)
+	}
+
+	selectors := make([]*VectorSelector, 0, len(matches))
+	for _, match := range matches {
+		selector, err := ParseSelector(match)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	if len(selectors) == 0 {
+		// A selector without matchers selects every series.
+		selectors = append(selectors, &VectorSelector{})
+	}
+
+	seen := make(map[string]bool)
+	labelSets := make([]core.Labels, 0)
+	for _, selector := range selectors {
+		identities, err := e.findSeries(selector, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, identity := range identities {
+			labels := e.labelsOf(identity)
+			if key := labels.String(); !seen[key] {
+				seen[key] = true
+				labelSets = append(labelSets, labels)
+			}
+		}
+	}
+
+	e.log.Info("series successfully listed", slog.Int("series", len(labelSets)))
+	return labelSets, nil
+}
+
+// seriesQuery pushes the equality matchers on the metric name, service and pod
+// and every matcher on other labels down to storage. The returned selector
+// holds all matchers and must still be applied to the series labels.
+func (e *Engine) seriesQuery(selector *VectorSelector, start, end time.Time) (core.SeriesQuery, core.LabelSelector, error) {
+	query := core.SeriesQuery{Start: start, End: end}
+	for _, matcher := range selector.Matchers {
+		switch matcher.Name {
+		case MetricNameLabel:
+			if matcher.Type == core.MatchEqual {
+				query.MetricName = matcher.Value
+			}
+		case e.cfg.ServiceLabel:
+			if matcher.Type == core.MatchEqual {
+				query.ServiceURL = matcher.Value
+			}
+		case e.cfg.PodLabel:
+			if matcher.Type == core.MatchEqual {
+				query.PodName = matcher.Value
+			}
+		default:
+			query.Matchers = append(query.Matchers, matcher)
+		}
+	}
+
+	labelSelector, err := core.NewLabelSelector(selector.Matchers)
+	if err != nil {
+		return query, nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return query, labelSelector, nil
+}
+
+func (e *Engine) findSeries(selector *VectorSelector, start, end time.Time) ([]core.SeriesIdentity, error) {
+	query, labelSelector, err := e.seriesQuery(selector, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := e.series.FindSeries(query)
+	if err != nil {
+		e.log.Error("failed to find series", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%w: %v", core.ErrQueryFailed, err)
+	}
+	return slices.DeleteFunc(identities, func(identity core.SeriesIdentity) bool {
+		return !labelSelector.Matches(e.labelsOf(identity))
+	}), nil
+}
+
+// selectSeries returns the samples of the matching series in [start, end].
+// Selectors without a metric name are resolved to metric names first, since
+// ranges are fetched per metric.
+func (e *Engine) selectSeries(selector *VectorSelector, start, end time.Time) ([]Series, error) {
+	query, labelSelector, err := e.seriesQuery(selector, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	metricNames := []string{query.MetricName}
+	if query.MetricName == "" {
+		identities, err := e.findSeries(selector, start, end)
+		if err != nil {
+			return nil, err
+		}
+		metricNames = make([]string, 0)
+		for _, identity := range identities {
+			if !slices.Contains(metricNames, identity.MetricName) {
+				metricNames = append(metricNames, identity.MetricName)
+			}
+		}
+	}
+
+	series := make([]Series, 0)
+	for _, metricName := range metricNames {
+		metrics, err := e.metrics.FindRange(core.RangeQuery{
+			ServiceURL: query.ServiceURL,
+			MetricName: metricName,
+			PodName:    query.PodName,
+			Matchers:   query.Matchers,
+			Start:      start,
+			End:        end,
+		})
+		if err != nil {
+			e.log.Error("failed to query metric range", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%w: %v", core.ErrQueryFailed, err)
+		}
+
+		indexes := make(map[string]int)
+		for _, metric := range metrics {
+			identity := core.SeriesIdentity{
+				ServiceURL: metric.ServiceURL,
+				MetricName: metric.MetricName,
+				PodName:    metric.PodName,
+				Labels:     metric.Labels,
+			}
+			key := identity.Key()
+			i, ok := indexes[key]
+			if !ok {
+				labels := e.labelsOf(identity)
+				if !labelSelector.Matches(labels) {
+					indexes[key] = -1
+					continue
+				}
+				i = len(series)
+				indexes[key] = i
+				series = append(series, Series{Labels: labels})
+			}
+			if i >= 0 {
+				series[i].Points = append(series[i].Points, core.Point{Time: metric.Time, Value: metric.MetricValue})
+			}
+		}
+	}
+	return series, nil
+}
+
+func (e *Engine) labelsOf(identity core.SeriesIdentity) core.Labels {
+	labels := make(core.Labels, len(identity.Labels)+3)
+	for name, value := range identity.Labels {
+		labels[name] = value
+	}
+	labels[MetricNameLabel] = identity.MetricName
+	labels[e.cfg.ServiceLabel] = identity.ServiceURL
+	labels[e.cfg.PodLabel] = identity.PodName
+	return labels
+}
+
+type scalarValue []float64
+
+// vectorValue is an instant vector evaluated at every step. A series has a
+// value at step i only where ok[i] is set.
+type vectorValue []stepSeries
+
+type stepSeries struct {
+	labels core.Labels
+	values []float64
+	ok     []bool
+}
+
+type evaluator struct {
+	engine *Engine
+	start  time.Time
+	step   time.Duration
+	steps  int
+}
+
+func (ev *evaluator) at(i int) time.Time {
+	return ev.start.Add(time.Duration(i) * ev.step)
+}
+
+func (ev *evaluator) newSeries(labels core.Labels) stepSeries {
+	return stepSeries{
+		labels: labels,
+		values: make([]float64, ev.steps),
+		ok:     make([]bool, ev.steps),
+	}
+}
+
+func (ev *evaluator) eval(expr Expr) (any, error) {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		value := make(scalarValue, ev.steps)
+		for i := range value {
+			value[i] = expr.Value
+		}
+		return value, nil
+	case *ParenExpr:
+		return ev.eval(expr.Expr)
+	case *UnaryExpr:
+		value, err := ev.eval(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return ev.binary("*", scalarValue(slices.Repeat([]float64{-1}, ev.steps)), value)
+	case *VectorSelector:
+		return ev.selector(expr)
+	case *Call:
+		return ev.call(expr)
+	case *AggregateExpr:
+		value, err := ev.eval(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		vector, ok := value.(vectorValue)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s expects an instant vector, got a scalar", ErrInvalidQuery, expr.Op)
+		}
+		return ev.aggregate(expr, vector), nil
+	case *BinaryExpr:
+		lhs, err := ev.eval(expr.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := ev.eval(expr.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return ev.binary(expr.Op, lhs, rhs)
+	default:
+		return nil, fmt.Errorf("%w: unexpected range vector", ErrInvalidQuery)
+	}
+}
+
+// selector takes at every step the latest sample no older than the lookback
+// delta.
+func (ev *evaluator) selector(selector *VectorSelector) (vectorValue, error) {
+	lookback := ev.engine.cfg.LookbackDelta
+	series, err := ev.engine.selectSeries(selector, ev.start.Add(-lookback), ev.at(ev.steps-1))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(vectorValue, 0, len(series))
+	for _, s := range series {
+		stepped := ev.newSeries(s.Labels)
+		for i := range ev.steps {
+			t := ev.at(i)
+			points := window(s.Points, t.Add(-lookback), t)
+			if len(points) > 0 {
+				stepped.values[i], stepped.ok[i] = points[len(points)-1].Value, true
+			}
+		}
+		result = append(result, stepped)
+	}
+	return result, nil
+}
+
+type rangeFunction func(points []core.Point, start, end time.Time) (float64, bool)
+
+// rangeFunctions evaluate over the samples in (t-range, t]. rate and increase
+// do not extrapolate to the window bounds, the same as the metrics rate API.
+var rangeFunctions = map[string]rangeFunction{
+	"rate": func(points []core.Point, start, end time.Time) (float64, bool) {
+		inc, ok := core.Increase(points, start, end)
+		return inc / end.Sub(start).Seconds(), ok
+	},
+	"increase": func(points []core.Point, start, end time.Time) (float64, bool) {
+		return core.Increase(points, start, end)
+	},
+	"avg_over_time":   overTime(core.AggregationAvg),
+	"min_over_time":   overTime(core.AggregationMin),
+	"max_over_time":   overTime(core.AggregationMax),
+	"sum_over_time":   overTime(core.AggregationSum),
+	"count_over_time": overTime(core.AggregationCount),
+	"last_over_time":  overTime(core.AggregationLast),
+}
+
+func overTime(function string) rangeFunction {
+	return func(points []core.Point, _, _ time.Time) (float64, bool) {
+		return core.AggregatePoints(function, points, 0)
+	}
+}
+
+func (ev *evaluator) call(call *Call) (vectorValue, error) {
+	rng := call.Arg.Range
+	series, err := ev.engine.selectSeries(call.Arg.Vector, ev.start.Add(-rng), ev.at(ev.steps-1))
+	if err != nil {
+		return nil, err
+	}
+
+	function := rangeFunctions[call.Func]
+	result := make(vectorValue, 0, len(series))
+	for _, s := range series {
+		stepped := ev.newSeries(withoutMetricName(s.Labels))
+		for i := range ev.steps {
+			t := ev.at(i)
+			if points := window(s.Points, t.Add(-rng), t); len(points) > 0 {
+				stepped.values[i], stepped.ok[i] = function(points, t.Add(-rng), t)
+			}
+		}
+		result = append(result, stepped)
+	}
+	return result, nil
+}
+
+func (ev *evaluator) aggregate(aggregate *AggregateExpr, vector vectorValue) vectorValue {
+	groups := make(map[string]int)
+	members := make([][]stepSeries, 0)
+	result := make(vectorValue, 0)
+	for _, s := range vector {
+		labels := groupLabels(s.labels, aggregate)
+		key := labels.String()
+		i, ok := groups[key]
+		if !ok {
+			i = len(result)
+			groups[key] = i
+			result = append(result, ev.newSeries(labels))
+			members = append(members, nil)
+		}
+		members[i] = append(members[i], s)
+	}
+
+	for g := range result {
+		for i := range ev.steps {
+			points := make([]core.Point, 0, len(members[g]))
+			for _, s := range members[g] {
+				if s.ok[i] {
+					points = append(points, core.Point{Value: s.values[i]})
+				}
+			}
+			if len(points) > 0 {
+				result[g].values[i], result[g].ok[i] = core.AggregatePoints(aggregate.Op, points, 0)
+			}
+		}
+	}
+	return result
+}
+
+func groupLabels(labels core.Labels, aggregate *AggregateExpr) core.Labels {
+	grouped := make(core.Labels)
+	if aggregate.Without {
+		for name, value := range labels {
+			if name != MetricNameLabel && !slices.Contains(aggregate.Grouping, name) {
+				grouped[name] = value
+			}
+		}
+		return grouped
+	}
+	for _, name := range aggregate.Grouping {
+		if value := labels[name]; value != "" {
+			grouped[name] = value
+		}
+	}
+	return grouped
+}
+
+// binary applies arithmetic between scalars and vectors. Vectors match one to
+// one on all labels but the metric name, which the result drops.
+func (ev *evaluator) binary(op string, lhs, rhs any) (any, error) {
+	switch lhs := lhs.(type) {
+	case scalarValue:
+		switch rhs := rhs.(type) {
+		case scalarValue:
+			result := make(scalarValue, ev.steps)
+			for i := range result {
+				result[i] = arithmetic(op, lhs[i], rhs[i])
+			}
+			return result, nil
+		case vectorValue:
+			result := make(vectorValue, 0, len(rhs))
+			for _, s := range rhs {
+				stepped := ev.newSeries(withoutMetricName(s.labels))
+				for i := range ev.steps {
+					if s.ok[i] {
+						stepped.values[i], stepped.ok[i] = arithmetic(op, lhs[i], s.values[i]), true
+					}
+				}
+				result = append(result, stepped)
+			}
+			return result, nil
+		}
+	case vectorValue:
+		switch rhs := rhs.(type) {
+		case scalarValue:
+			result := make(vectorValue, 0, len(lhs))
+			for _, s := range lhs {
+				stepped := ev.newSeries(withoutMetricName(s.labels))
+				for i := range ev.steps {
+					if s.ok[i] {
+						stepped.values[i], stepped.ok[i] = arithmetic(op, s.values[i], rhs[i]), true
+					}
+				}
+				result = append(result, stepped)
+			}
+			return result, nil
+		case vectorValue:
+			return ev.vectorBinary(op, lhs, rhs)
+		}
+	}
+	return nil, fmt.Errorf("%w: unsupported operands for %q", ErrInvalidQuery, op)
+}
+
+func (ev *evaluator) vectorBinary(op string, lhs, rhs vectorValue) (vectorValue, error) {
+	signatures := func(vector vectorValue) (map[string]int, error) {
+		indexes := make(map[string]int, len(vector))
+		for i, s := range vector {
+			key := withoutMetricName(s.labels).String()
+			if _, ok := indexes[key]; ok {
+				return nil, fmt.Errorf("%w: many-to-many matching not allowed, matching labels must be unique on each side", ErrExecution)
+			}
+			indexes[key] = i
+		}
+		return indexes, nil
+	}
+	if _, err := signatures(lhs); err != nil {
+		return nil, err
+	}
+	rhsIndexes, err := signatures(rhs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(vectorValue, 0)
+	for _, l := range lhs {
+		labels := withoutMetricName(l.labels)
+		j, ok := rhsIndexes[labels.String()]
+		if !ok {
+			continue
+		}
+		r := rhs[j]
+		stepped := ev.newSeries(labels)
+		for i := range ev.steps {
+			if l.ok[i] && r.ok[i] {
+				stepped.values[i], stepped.ok[i] = arithmetic(op, l.values[i], r.values[i]), true
+			}
+		}
+		result = append(result, stepped)
+	}
+	slices.SortFunc(result, func(a, b stepSeries) int {
+		return cmp.Compare(a.labels.String(), b.labels.String())
+	})
+	return result, nil
+}
+
+func arithmetic(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return math.Mod(a, b)
+	case "^":
+		return math.Pow(a, b)
+	default:
+		return math.NaN()
+	}
+}
+
+func withoutMetricName(labels core.Labels) core.Labels {
+	result := make(core.Labels, len(labels))
+	for name, value := range labels {
+		if name != MetricNameLabel {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// window returns the points in (start, end] of points sorted by time.
+func window(points []core.Point, start, end time.Time) []core.Point {
+	from, _ := slices.BinarySearchFunc(points, start, func(point core.Point, t time.Time) int {
+		if point.Time.After(t) {
+			return 1
+		}
+		return -1
+	})
+	to, _ := slices.BinarySearchFunc(points, end, func(point core.Point, t time.Time) int {
+		if point.Time.After(t) {
+			return 1
+		}
+		return -1
+	})
+	return points[from:to]
+}
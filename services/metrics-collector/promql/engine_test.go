@@ -0,0 +1,159 @@
+package promql
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/config"
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestEngine stores a requests_total counter growing by 10 every 10 seconds
+// for two pods of the api service and one pod of the web service.
+func newTestEngine(t *testing.T) (*Engine, *memory.Storage) {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storage, err := memory.New(log, core.ConflictPolicyOverwrite)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	for _, target := range []struct{ service, pod string }{{"api", "a"}, {"api", "b"}, {"web", "c"}} {
+		for i := range 7 {
+			_, err := storage.Save(core.Metric{
+				MetricIdentity: core.MetricIdentity{
+					Time:       epoch.Add(time.Duration(i) * 10 * time.Second),
+					ServiceURL: target.service,
+					MetricName: "requests_total",
+					PodName:    target.pod,
+					Labels:     core.Labels{"code": "200"},
+				},
+				Type:        core.MetricTypeCounter,
+				MetricValue: float64(i * 10),
+			})
+			if err != nil {
+				t.Fatalf("failed to save metric: %v", err)
+			}
+		}
+	}
+
+	cfg := &config.Prometheus{ServiceLabel: "job", PodLabel: "instance", LookbackDelta: 5 * time.Minute}
+	return NewEngine(log, cfg, storage, storage), storage
+}
+
+func TestQuerySelectorAndAggregation(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	at := epoch.Add(time.Minute)
+
+	result, err := engine.Query(`requests_total{job="api"}`, at)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Type != ValueTypeVector || len(result.Series) != 2 {
+		t.Fatalf("expected two api series, got %+v", result)
+	}
+	for _, s := range result.Series {
+		if s.Labels[MetricNameLabel] != "requests_total" || s.Labels["code"] != "200" || s.Points[0].Value != 60 {
+			t.Fatalf("unexpected series %+v", s)
+		}
+	}
+
+	result, err = engine.Query(`sum by (job) (rate(requests_total[1m])) * 60`, at)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]float64{"api": 100, "web": 50}
+	if len(result.Series) != len(want) {
+		t.Fatalf("expected %d groups, got %+v", len(want), result.Series)
+	}
+	for _, s := range result.Series {
+		if len(s.Labels) != 1 || s.Points[0].Value != want[s.Labels["job"]] {
+			t.Fatalf("unexpected group %+v", s)
+		}
+	}
+
+	result, err = engine.Query(`2 ^ 3 - 1`, at)
+	if err != nil || result.Type != ValueTypeScalar || result.Scalar.Value != 7 {
+		t.Fatalf("expected scalar 7, got %+v (err=%v)", result, err)
+	}
+}
+
+func TestQueryRangeAppliesLookback(t *testing.T) {
+	engine, _ := newTestEngine(t)
+
+	result, err := engine.QueryRange(`requests_total{instance="c"}`, epoch, epoch.Add(10*time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Type != ValueTypeMatrix || len(result.Series) != 1 {
+		t.Fatalf("expected a single series, got %+v", result)
+	}
+	// The last sample at 1m is still visible at 5m but stale at 10m.
+	points := result.Series[0].Points
+	if len(points) != 2 || points[0].Value != 0 || points[1].Value != 60 {
+		t.Fatalf("unexpected points %+v", points)
+	}
+
+	if _, err := engine.QueryRange(`requests_total`, epoch, epoch.Add(time.Hour), time.Millisecond); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("too many steps should be rejected, got %v", err)
+	}
+}
+
+func TestVectorMatchingRejectsManyToMany(t *testing.T) {
+	engine, storage := newTestEngine(t)
+	at := epoch.Add(time.Minute)
+
+	result, err := engine.Query(`requests_total / requests_total`, at)
+	if err != nil || len(result.Series) != 3 {
+		t.Fatalf("expected one-to-one matching of three series, got %+v (err=%v)", result, err)
+	}
+	for _, s := range result.Series {
+		if _, ok := s.Labels[MetricNameLabel]; ok || s.Points[0].Value != 1 {
+			t.Fatalf("unexpected series %+v", s)
+		}
+	}
+
+	// Two metric names with equal labels collide once the name is dropped.
+	_, err = storage.Save(core.Metric{
+		MetricIdentity: core.MetricIdentity{Time: at, ServiceURL: "web", MetricName: "errors_total", PodName: "c", Labels: core.Labels{"code": "200"}},
+		Type:           core.MetricTypeCounter,
+		MetricValue:    1,
+	})
+	if err != nil {
+		t.Fatalf("failed to save metric: %v", err)
+	}
+	_, err = engine.Query(`{__name__=~"requests_total|errors_total"} + requests_total`, at)
+	if !errors.Is(err, ErrExecution) {
+		t.Fatalf("expected ErrExecution, got %v", err)
+	}
+}
+
+func TestSeriesAndLabels(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	start, end := epoch, epoch.Add(time.Hour)
+
+	series, err := engine.Series([]string{`{job="web"}`}, start, end)
+	if err != nil || len(series) != 1 || series[0]["instance"] != "c" {
+		t.Fatalf("expected the web series, got %v (err=%v)", series, err)
+	}
+
+	names, err := engine.LabelNames(nil, start, end)
+	if err != nil || len(names) != 4 || names[0] != MetricNameLabel {
+		t.Fatalf("unexpected label names %v (err=%v)", names, err)
+	}
+
+	values, err := engine.LabelValues("instance", []string{`requests_total{job="api"}`}, start, end)
+	if err != nil || len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Fatalf("unexpected label values %v (err=%v)", values, err)
+	}
+
+	if _, err := engine.Series(nil, start, end); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("series without match[] should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package promql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of input"
+	}
+	return strconv.Quote(t.text)
+}
+
+// punctuation is ordered so that two-character operators are tried first.
+var punctuation = []string{"!=", "=~", "!~", "(", ")", "{", "}", "[", "]", ",", "=", "+", "-", "*", "/", "%", "^"}
+
+func lex(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	for pos := 0; pos < len(input); {
+		c := input[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c == '#':
+			for pos < len(input) && input[pos] != '\n' {
+				pos++
+			}
+		case isIdentStart(c):
+			end := pos + 1
+			for end < len(input) && isIdentChar(input[end]) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[pos:end], pos: pos})
+			pos = end
+		case isDigit(c) || (c == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			tok, err := lexNumber(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			pos += len(tok.text)
+		case c == '"' || c == '\'' || c == '`':
+			tok, end, err := lexString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			pos = end
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(input[pos:], p) {
+					tokens = append(tokens, token{kind: tokenPunct, text: p, pos: pos})
+					pos += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				r, _ := utf8.DecodeRuneInString(input[pos:])
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, pos)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+// lexNumber reads a float literal, or a duration when an integer is followed
+// by a unit.
+func lexNumber(input string, pos int) (token, error) {
+	end := pos
+	for end < len(input) && isDigit(input[end]) {
+		end++
+	}
+	if end < len(input) && isDurationUnit(input[end]) {
+		for end < len(input) && (isDigit(input[end]) || isDurationUnit(input[end])) {
+			end++
+		}
+		text := input[pos:end]
+		if _, err := ParseDuration(text); err != nil || (end < len(input) && isIdentChar(input[end])) {
+			return token{}, fmt.Errorf("invalid duration %q at position %d", text, pos)
+		}
+		return token{kind: tokenDuration, text: text, pos: pos}, nil
+	}
+
+	if end < len(input) && input[end] == '.' {
+		end++
+		for end < len(input) && isDigit(input[end]) {
+			end++
+		}
+	}
+	if end < len(input) && (input[end] == 'e' || input[end] == 'E') {
+		exp := end + 1
+		if exp < len(input) && (input[exp] == '+' || input[exp] == '-') {
+			exp++
+		}
+		if exp < len(input) && isDigit(input[exp]) {
+			end = exp
+			for end < len(input) && isDigit(input[end]) {
+				end++
+			}
+		}
+	}
+	return token{kind: tokenNumber, text: input[pos:end], pos: pos}, nil
+}
+
+func lexString(input string, pos int) (token, int, error) {
+	quote := input[pos]
+	end := pos + 1
+	for end < len(input) && input[end] != quote {
+		if input[end] == '\\' && quote != '`' {
+			end++
+		}
+		end++
+	}
+	if end >= len(input) {
+		return token{}, 0, fmt.Errorf("unterminated string at position %d", pos)
+	}
+
+	raw := input[pos+1 : end]
+	if quote == '`' {
+		return token{kind: tokenString, text: raw, pos: pos}, end + 1, nil
+	}
+
+	var b strings.Builder
+	for len(raw) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(raw, quote)
+		if err != nil {
+			return token{}, 0, fmt.Errorf("invalid escape in string at position %d", pos)
+		}
+		if multibyte || r >= utf8.RuneSelf {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte(byte(r))
+		}
+		raw = tail
+	}
+	return token{kind: tokenString, text: b.String(), pos: pos}, end + 1, nil
+}
+
+var durationUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"y", 365 * 24 * time.Hour},
+}
+
+// ParseDuration parses Prometheus durations such as 1h30m, which unlike Go
+// durations allow days, weeks and years.
+func ParseDuration(text string) (time.Duration, error) {
+	if text == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var total time.Duration
+	for text != "" {
+		end := 0
+		for end < len(text) && isDigit(text[end]) {
+			end++
+		}
+		if end == 0 {
+			return 0, fmt.Errorf("invalid duration")
+		}
+		n, err := strconv.ParseInt(text[:end], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		text = text[end:]
+
+		matched := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(text, u.suffix) {
+				total += time.Duration(n) * u.unit
+				text = text[len(u.suffix):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, fmt.Errorf("invalid duration unit")
+		}
+	}
+	return total, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDurationUnit(c byte) bool {
+	return strings.IndexByte("smhdwy", c) >= 0
+}
@@ -0,0 +1,372 @@
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mclyashko/monitoring-system/services/metrics-collector/core"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+var aggregateOps = []string{
+	core.AggregationSum,
+	core.AggregationAvg,
+	core.AggregationMin,
+	core.AggregationMax,
+	core.AggregationCount,
+}
+
+// binaryPrecedence lists the arithmetic operators from the loosest binding.
+var binaryPrecedence = map[string]int{
+	"+": 1, "-": 1,
+	"*": 2, "/": 2, "%": 2,
+	"^": 3,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a query of the supported PromQL subset: selectors with label
+// matchers, range selectors as function arguments, the rate, increase and
+// *_over_time functions, sum, avg, min, max and count aggregations with by or
+// without, and arithmetic between scalars and vectors.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	p := parser{tokens: tokens}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("%w: unexpected %s at position %d", ErrInvalidQuery, tok, tok.pos)
+	}
+	return expr, nil
+}
+
+// ParseSelector parses a single vector selector, as used by match[]
+// parameters.
+func ParseSelector(input string) (*VectorSelector, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	selector, ok := expr.(*VectorSelector)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q is not a series selector", ErrInvalidQuery, input)
+	}
+	return selector, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokenPunct && tok.text == text
+}
+
+func (p *parser) expectPunct(text string) error {
+	if tok := p.next(); tok.kind != tokenPunct || tok.text != text {
+		return fmt.Errorf("expected %q, got %s at position %d", text, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr(minPrecedence int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		precedence, ok := binaryPrecedence[tok.text]
+		if tok.kind != tokenPunct || !ok || precedence < minPrecedence {
+			return lhs, nil
+		}
+		p.next()
+
+		// ^ is right associative, the others bind to the left.
+		next := precedence + 1
+		if tok.text == "^" {
+			next = precedence
+		}
+		rhs, err := p.parseExpr(next)
+		if err != nil {
+			return nil, err
+		}
+		expr := &BinaryExpr{Op: tok.text, LHS: lhs, RHS: rhs}
+		if err := checkOperands(expr); err != nil {
+			return nil, err
+		}
+		lhs = expr
+	}
+}
+
+// parseUnary lets ^ bind tighter than a sign, so -2^2 is -4.
+func (p *parser) parseUnary() (Expr, error) {
+	if p.isPunct("-") || p.isPunct("+") {
+		sign := p.next()
+		expr, err := p.parseExpr(binaryPrecedence["^"])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := expr.(*MatrixSelector); ok {
+			return nil, fmt.Errorf("unary %q does not apply to a range vector", sign.text)
+		}
+		if sign.text == "+" {
+			return expr, nil
+		}
+		if number, ok := expr.(*NumberLiteral); ok {
+			return &NumberLiteral{Value: -number.Value}, nil
+		}
+		return &UnaryExpr{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenNumber:
+		p.next()
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return &NumberLiteral{Value: value}, nil
+	case tokenPunct:
+		switch tok.text {
+		case "(":
+			p.next()
+			expr, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return &ParenExpr{Expr: expr}, nil
+		case "{":
+			return p.parseSelector("")
+		}
+	case tokenIdent:
+		switch {
+		case strings.EqualFold(tok.text, "inf"):
+			p.next()
+			return &NumberLiteral{Value: math.Inf(1)}, nil
+		case strings.EqualFold(tok.text, "nan"):
+			p.next()
+			return &NumberLiteral{Value: math.NaN()}, nil
+		case slices.Contains(aggregateOps, tok.text) && p.isAggregateStart():
+			return p.parseAggregate()
+		case p.tokens[p.pos+1].kind == tokenPunct && p.tokens[p.pos+1].text == "(":
+			return p.parseCall()
+		default:
+			p.next()
+			return p.parseSelector(tok.text)
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+func (p *parser) isAggregateStart() bool {
+	next := p.tokens[p.pos+1]
+	return (next.kind == tokenPunct && next.text == "(") ||
+		(next.kind == tokenIdent && (next.text == "by" || next.text == "without"))
+}
+
+func (p *parser) parseSelector(metricName string) (Expr, error) {
+	selector := &VectorSelector{}
+	if metricName != "" {
+		selector.Matchers = append(selector.Matchers, core.LabelMatcher{Name: MetricNameLabel, Type: core.MatchEqual, Value: metricName})
+	}
+
+	if p.isPunct("{") {
+		p.next()
+		for !p.isPunct("}") {
+			matcher, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			selector.Matchers = append(selector.Matchers, matcher)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expectPunct("}"); err != nil {
+			return nil, err
+		}
+	}
+
+	if !slices.ContainsFunc(selector.Matchers, func(matcher core.LabelMatcher) bool {
+		return !matchesEmpty(matcher)
+	}) {
+		return nil, fmt.Errorf("vector selector must contain at least one matcher that does not match the empty string")
+	}
+
+	if !p.isPunct("[") {
+		return selector, nil
+	}
+	p.next()
+	tok := p.next()
+	if tok.kind != tokenDuration {
+		return nil, fmt.Errorf("expected a duration in range selector, got %s at position %d", tok, tok.pos)
+	}
+	rng, _ := ParseDuration(tok.text)
+	if rng <= 0 {
+		return nil, fmt.Errorf("range must be positive at position %d", tok.pos)
+	}
+	if err := p.expectPunct("]"); err != nil {
+		return nil, err
+	}
+	return &MatrixSelector{Vector: selector, Range: rng}, nil
+}
+
+func (p *parser) parseMatcher() (core.LabelMatcher, error) {
+	name := p.next()
+	if name.kind != tokenIdent {
+		return core.LabelMatcher{}, fmt.Errorf("expected a label name, got %s at position %d", name, name.pos)
+	}
+
+	op := p.next()
+	if op.kind != tokenPunct || !slices.Contains([]string{core.MatchEqual, core.MatchNotEqual, core.MatchRegexp, core.MatchNotRegexp}, op.text) {
+		return core.LabelMatcher{}, fmt.Errorf("expected a matcher operator, got %s at position %d", op, op.pos)
+	}
+
+	value := p.next()
+	if value.kind != tokenString {
+		return core.LabelMatcher{}, fmt.Errorf("expected a label value string, got %s at position %d", value, value.pos)
+	}
+
+	matcher := core.LabelMatcher{Name: name.text, Type: op.text, Value: value.text}
+	if _, err := core.NewLabelSelector([]core.LabelMatcher{matcher}); err != nil {
+		return core.LabelMatcher{}, err
+	}
+	return matcher, nil
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	name := p.next()
+	if _, ok := rangeFunctions[name.text]; !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	arg, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	matrix, ok := arg.(*MatrixSelector)
+	if !ok {
+		return nil, fmt.Errorf("function %q expects a range vector argument", name.text)
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return &Call{Func: name.text, Arg: matrix}, nil
+}
+
+func (p *parser) parseAggregate() (Expr, error) {
+	aggregate := &AggregateExpr{Op: p.next().text}
+
+	grouped := false
+	if p.peek().kind == tokenIdent {
+		if err := p.parseGrouping(aggregate); err != nil {
+			return nil, err
+		}
+		grouped = true
+	}
+
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if err := checkInstant(expr, aggregate.Op); err != nil {
+		return nil, err
+	}
+	aggregate.Expr = expr
+
+	if !grouped && p.peek().kind == tokenIdent && (p.peek().text == "by" || p.peek().text == "without") {
+		if err := p.parseGrouping(aggregate); err != nil {
+			return nil, err
+		}
+	}
+	return aggregate, nil
+}
+
+func (p *parser) parseGrouping(aggregate *AggregateExpr) error {
+	keyword := p.next()
+	switch keyword.text {
+	case "by":
+	case "without":
+		aggregate.Without = true
+	default:
+		return fmt.Errorf("expected by or without, got %s at position %d", keyword, keyword.pos)
+	}
+
+	if err := p.expectPunct("("); err != nil {
+		return err
+	}
+	aggregate.Grouping = make([]string, 0)
+	for !p.isPunct(")") {
+		label := p.next()
+		if label.kind != tokenIdent {
+			return fmt.Errorf("expected a label name, got %s at position %d", label, label.pos)
+		}
+		aggregate.Grouping = append(aggregate.Grouping, label.text)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	return p.expectPunct(")")
+}
+
+func checkOperands(expr *BinaryExpr) error {
+	if err := checkInstant(expr.LHS, expr.Op); err != nil {
+		return err
+	}
+	return checkInstant(expr.RHS, expr.Op)
+}
+
+func checkInstant(expr Expr, operation string) error {
+	if _, ok := expr.(*MatrixSelector); ok {
+		return fmt.Errorf("%q expects an instant vector or a scalar, got a range vector", operation)
+	}
+	return nil
+}
+
+func matchesEmpty(matcher core.LabelMatcher) bool {
+	selector, err := core.NewLabelSelector([]core.LabelMatcher{matcher})
+	return err == nil && selector.Matches(core.Labels{})
+}
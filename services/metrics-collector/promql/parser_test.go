@@ -0,0 +1,97 @@
+package promql
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePrecedence(t *testing.T) {
+	expr, err := Parse(`1 + 2 * 3 ^ 2 ^ 0.5`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum, ok := expr.(*BinaryExpr)
+	if !ok || sum.Op != "+" {
+		t.Fatalf("expected + at the root, got %#v", expr)
+	}
+	product, ok := sum.RHS.(*BinaryExpr)
+	if !ok || product.Op != "*" {
+		t.Fatalf("expected * under +, got %#v", sum.RHS)
+	}
+	power, ok := product.RHS.(*BinaryExpr)
+	if !ok || power.Op != "^" {
+		t.Fatalf("expected ^ under *, got %#v", product.RHS)
+	}
+	if _, ok := power.RHS.(*BinaryExpr); !ok {
+		t.Fatalf("^ should be right associative, got %#v", power.RHS)
+	}
+
+	expr, err = Parse(`-2 ^ 2`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := expr.(*UnaryExpr); !ok {
+		t.Fatalf("^ should bind tighter than unary minus, got %#v", expr)
+	}
+}
+
+func TestParseAggregateAndCall(t *testing.T) {
+	for _, query := range []string{
+		`sum by (job) (rate(http_requests_total{code=~"5.."}[5m]))`,
+		`sum(rate(http_requests_total{code=~"5.."}[5m])) by (job)`,
+	} {
+		expr, err := Parse(query)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", query, err)
+		}
+		aggregate, ok := expr.(*AggregateExpr)
+		if !ok || aggregate.Op != "sum" || aggregate.Without || len(aggregate.Grouping) != 1 || aggregate.Grouping[0] != "job" {
+			t.Fatalf("%s: unexpected aggregate %#v", query, expr)
+		}
+		call, ok := aggregate.Expr.(*Call)
+		if !ok || call.Func != "rate" || call.Arg.Range != 5*time.Minute {
+			t.Fatalf("%s: unexpected call %#v", query, aggregate.Expr)
+		}
+		if len(call.Arg.Vector.Matchers) != 2 {
+			t.Fatalf("%s: expected name and code matchers, got %v", query, call.Arg.Vector.Matchers)
+		}
+	}
+}
+
+func TestParseRejectsInvalidQueries(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`{}`,
+		`{job=~".*"}`,
+		`up{job="a"`,
+		`rate(up)`,
+		`sum(up[5m])`,
+		`up[5m] + 1`,
+		`histogram_quantile(0.9, up)`,
+		`up{job=~"("}`,
+		`up[5x]`,
+	} {
+		if _, err := Parse(query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: expected ErrInvalidQuery, got %v", query, err)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"500ms": 500 * time.Millisecond,
+		"1h30m": 90 * time.Minute,
+		"2d":    48 * time.Hour,
+		"1w":    7 * 24 * time.Hour,
+	}
+	for text, want := range cases {
+		got, err := ParseDuration(text)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %v, got %v (err=%v)", text, want, got, err)
+		}
+	}
+	if _, err := ParseDuration("5"); err == nil {
+		t.Error("a duration without unit should be rejected")
+	}
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/mclyashko/monitoring-system/services/test-service-go/core"
+)
+
+// Storage keeps orders in process memory with the same semantics as the
+// database adapter. Nothing survives a restart, so it is meant for tests and
+// local development.
+type Storage struct {
+	log    *slog.Logger
+	mu     sync.RWMutex
+	orders map[int]core.Order
+	nextID int
+}
+
+func New(log *slog.Logger) *Storage {
+	return &Storage{
+		log:    log,
+		orders: make(map[int]core.Order),
+		nextID: 1,
+	}
+}
+
+func (s *Storage) Save(order core.Order) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order.ID = s.nextID
+	s.nextID++
+	s.orders[order.ID] = order
+
+	s.log.Info("order saved successfully", slog.Int("order_id", order.ID))
+	return order.ID, nil
+}
+
+func (s *Storage) FindByID(orderID int) (*core.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		s.log.Warn("order not found", slog.Int("order_id", orderID))
+		return nil, fmt.Errorf("order with id %d not found", orderID)
+	}
+
+	s.log.Info("order found successfully", slog.Int("order_id", orderID))
+	return &order, nil
+}
@@ -16,6 +16,7 @@ type Config struct {
 	LogLevel    string        `yaml:"log_level" env:"LOG_LEVEL"`
 	AppAddress  string        `yaml:"app_address" env:"APP_ADDRESS"`
 	ReadTimeout time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT"`
+	Storage     string        `yaml:"storage" env:"STORAGE" env-default:"postgres"`
 	DB          DB            `yaml:"db"`
 }
 
@@ -0,0 +1,66 @@
+package core_test
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/mclyashko/monitoring-system/services/test-service-go/adapters/memory"
+	"github.com/mclyashko/monitoring-system/services/test-service-go/core"
+)
+
+func newOrderService() *core.OrderService {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return core.NewOrderService(log, memory.New(log))
+}
+
+func TestCreateAndGetOrder(t *testing.T) {
+	service := newOrderService()
+
+	first, err := service.CreateOrder(core.Order{ProductID: 1, Quantity: 2, UserID: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := service.CreateOrder(core.Order{ProductID: 4, Quantity: 5, UserID: 6})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first == second {
+		t.Fatalf("orders should get distinct ids, both got %d", first)
+	}
+
+	order, err := service.GetOrderByID(first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := core.Order{ID: first, ProductID: 1, Quantity: 2, UserID: 3}
+	if *order != want {
+		t.Fatalf("expected %+v, got %+v", want, *order)
+	}
+}
+
+func TestCreateInvalidOrder(t *testing.T) {
+	service := newOrderService()
+
+	for _, order := range []core.Order{
+		{ProductID: 0, Quantity: 1, UserID: 1},
+		{ProductID: 1, Quantity: -1, UserID: 1},
+		{ProductID: 1, Quantity: 1, UserID: 0},
+	} {
+		if _, err := service.CreateOrder(order); !errors.Is(err, core.ErrInvalidOrder) {
+			t.Fatalf("expected ErrInvalidOrder for %+v, got %v", order, err)
+		}
+	}
+}
+
+func TestGetOrderNotFound(t *testing.T) {
+	service := newOrderService()
+
+	if _, err := service.GetOrderByID(42); !errors.Is(err, core.ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if _, err := service.GetOrderByID(0); !errors.Is(err, core.ErrInvalidOrderID) {
+		t.Fatalf("expected ErrInvalidOrderID, got %v", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os/signal"
 
 	"github.com/mclyashko/monitoring-system/services/test-service-go/adapters/db"
+	"github.com/mclyashko/monitoring-system/services/test-service-go/adapters/memory"
 	"github.com/mclyashko/monitoring-system/services/test-service-go/adapters/rest"
 	"github.com/mclyashko/monitoring-system/services/test-service-go/config"
 	"github.com/mclyashko/monitoring-system/services/test-service-go/core"
@@ -22,9 +23,7 @@ func main() {
 
 	greetings(log)
 
-	storage := mustMakeStorage(log, &cfg.DB)
-
-	mustMakeMigrations(log, storage, cfg.DB.DBConnString)
+	storage := mustMakeStorage(log, cfg)
 
 	mux := mustMakeMux(log, storage)
 
@@ -87,7 +86,23 @@ func greetings(log *slog.Logger) {
 	log.Debug("debug messages are enabled")
 }
 
-func mustMakeStorage(log *slog.Logger, cfgDb *config.DB) *db.DB {
+func mustMakeStorage(log *slog.Logger, cfg *config.Config) core.OrderRepository {
+	switch cfg.Storage {
+	case "memory":
+		log.Warn("using in-memory storage, data will not survive a restart")
+		return memory.New(log)
+	case "postgres":
+		storage := mustMakeDBStorage(log, &cfg.DB)
+		mustMakeMigrations(log, storage, cfg.DB.DBConnString)
+		return storage
+	default:
+		log.Error("unknown storage", slog.String("storage", cfg.Storage))
+		os.Exit(1)
+		return nil
+	}
+}
+
+func mustMakeDBStorage(log *slog.Logger, cfgDb *config.DB) *db.DB {
 	log.Info("connecting to the database...")
 
 	storage, err := db.New(log, cfgDb)
@@ -2,16 +2,25 @@ package metrics_collector_grpc_api_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 	"time"
 
 	metricspb "github.com/mclyashko/monitoring-system/tests/test-service-go/metrics-collector/proto"
 	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	otlpmetricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const grpcAddress = "localhost:81"
@@ -56,6 +65,36 @@ func TestSendMetric(t *testing.T) {
 	require.Equal(t, req.PodName, resp.PodName)
 }
 
+func TestSendMetricWithTimestamp(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sampleTime := time.Now().UTC().Add(-15 * time.Minute).Truncate(time.Millisecond)
+	req := &metricspb.SendMetricRequest{
+		ServiceUrl:  "test-service-go/metrics",
+		MetricName:  "system_cpu_usage",
+		PodName:     fmt.Sprintf("test-pod-backfill-%d", time.Now().UnixNano()),
+		MetricValue: 0.5,
+		Time:        timestamppb.New(sampleTime),
+	}
+
+	resp, err := c.SendMetric(ctx, req)
+	require.NoError(t, err)
+	require.True(t, sampleTime.Equal(resp.Time.AsTime()), "client timestamp should be kept")
+
+	_, err = c.SendMetric(ctx, req)
+	require.Equal(t, codes.AlreadyExists, status.Code(err), "duplicate metric should be rejected")
+
+	req.Time = timestamppb.New(time.Now().Add(-48 * time.Hour))
+	_, err = c.SendMetric(ctx, req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "metric older than the acceptance window should be rejected")
+}
+
 func TestSendInvalidMetric(t *testing.T) {
 	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	require.NoError(t, err)
@@ -79,3 +118,356 @@ func TestSendInvalidMetric(t *testing.T) {
 	require.True(t, ok, "expected gRPC status error")
 	require.Equal(t, codes.InvalidArgument, st.Code())
 }
+
+func TestSendMetrics(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := &metricspb.SendMetricsRequest{
+		Metrics: []*metricspb.SendMetricRequest{
+			{ServiceUrl: "test-service-go/metrics", MetricName: "system_cpu_usage", PodName: "test-pod-batch-1", MetricValue: 0.1},
+			{ServiceUrl: "test-service-go/metrics", MetricName: "", PodName: "test-pod-batch-2", MetricValue: 0.2},
+			{ServiceUrl: "test-service-go/metrics", MetricName: "system_cpu_usage", PodName: "test-pod-batch-3", MetricValue: 0.3},
+		},
+	}
+
+	resp, err := c.SendMetrics(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, resp.Results, len(req.Metrics))
+
+	require.True(t, resp.Results[0].Accepted)
+	require.Equal(t, req.Metrics[0].PodName, resp.Results[0].Metric.PodName)
+	require.False(t, resp.Results[1].Accepted)
+	require.NotEmpty(t, resp.Results[1].Error)
+	require.True(t, resp.Results[2].Accepted)
+	require.Equal(t, uint32(2), resp.Results[2].Index)
+}
+
+func TestSendEmptyMetrics(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = c.SendMetrics(ctx, &metricspb.SendMetricsRequest{})
+	require.Error(t, err)
+
+	st, ok := status.FromError(err)
+	require.True(t, ok, "expected gRPC status error")
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+func TestStreamMetrics(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := c.StreamMetrics(ctx)
+	require.NoError(t, err)
+
+	reqs := []*metricspb.SendMetricRequest{
+		{ServiceUrl: "test-service-go/metrics", MetricName: "system_cpu_usage", PodName: "test-pod-stream-1", MetricValue: 0.1, Sequence: 1},
+		{ServiceUrl: "test-service-go/metrics", MetricName: "system_cpu_usage", PodName: "", MetricValue: 0.2, Sequence: 2},
+		{ServiceUrl: "test-service-go/metrics", MetricName: "system_cpu_usage", PodName: "test-pod-stream-3", MetricValue: 0.3, Sequence: 3},
+	}
+	for _, req := range reqs {
+		require.NoError(t, stream.Send(req))
+	}
+	require.NoError(t, stream.CloseSend())
+
+	var last *metricspb.StreamMetricsAck
+	for {
+		ack, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		last = ack
+	}
+
+	require.NotNil(t, last, "expected at least one ack")
+	require.Equal(t, uint64(2), last.Count)
+	require.Equal(t, uint64(1), last.Rejected)
+	require.Equal(t, uint64(3), last.LastSequence)
+}
+
+func TestQueryRange(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	podName := fmt.Sprintf("test-pod-range-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+	for _, value := range []float64{1, 2} {
+		_, err := c.SendMetric(ctx, &metricspb.SendMetricRequest{
+			ServiceUrl:  "test-service-go/metrics",
+			MetricName:  "system_cpu_usage",
+			PodName:     podName,
+			MetricValue: value,
+		})
+		require.NoError(t, err)
+	}
+
+	resp, err := c.QueryRange(ctx, &metricspb.QueryRangeRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "system_cpu_usage",
+		PodName:    podName,
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(time.Now().UTC().Add(time.Second)),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, 1)
+	require.Len(t, resp.Series[0].Points, 2)
+	require.Equal(t, 2.0, resp.Series[0].Points[1].Value)
+
+	resp, err = c.QueryRange(ctx, &metricspb.QueryRangeRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "system_cpu_usage",
+		PodName:    podName,
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(time.Now().UTC().Add(time.Second)),
+		Step:       durationpb.New(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, 1)
+	require.Len(t, resp.Series[0].Points, 1)
+}
+
+func TestQueryRangeInvalid(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = c.QueryRange(ctx, &metricspb.QueryRangeRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "system_cpu_usage",
+	})
+	require.Error(t, err)
+
+	st, ok := status.FromError(err)
+	require.True(t, ok, "expected gRPC status error")
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+func TestQueryRangeWithLabelMatchers(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	podName := fmt.Sprintf("test-pod-labels-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+	for i, region := range []string{"eu", "us"} {
+		resp, err := c.SendMetric(ctx, &metricspb.SendMetricRequest{
+			ServiceUrl:  "test-service-go/metrics",
+			MetricName:  "http_requests",
+			PodName:     podName,
+			MetricValue: float64(i),
+			Labels:      map[string]string{"region": region},
+		})
+		require.NoError(t, err)
+		require.Equal(t, region, resp.Labels["region"])
+	}
+
+	resp, err := c.QueryRange(ctx, &metricspb.QueryRangeRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "http_requests",
+		PodName:    podName,
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(time.Now().UTC().Add(time.Second)),
+		Matchers:   []*metricspb.LabelMatcher{{Name: "region", Type: "=~", Value: "u.*"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, 1)
+	require.Equal(t, "us", resp.Series[0].Labels["region"])
+
+	_, err = c.QueryRange(ctx, &metricspb.QueryRangeRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "http_requests",
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(time.Now().UTC().Add(time.Second)),
+		Matchers:   []*metricspb.LabelMatcher{{Name: "region", Type: "~~", Value: "eu"}},
+	})
+	require.Error(t, err)
+
+	st, ok := status.FromError(err)
+	require.True(t, ok, "expected gRPC status error")
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+func TestRate(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	podName := fmt.Sprintf("test-pod-rate-%d", time.Now().UnixNano())
+	for _, value := range []float64{1, 7} {
+		_, err := c.SendMetric(ctx, &metricspb.SendMetricRequest{
+			ServiceUrl:  "test-service-go/metrics",
+			MetricName:  "orders_total",
+			PodName:     podName,
+			MetricValue: value,
+			Type:        "counter",
+		})
+		require.NoError(t, err)
+	}
+
+	_, err = c.SendMetric(ctx, &metricspb.SendMetricRequest{
+		ServiceUrl:  "test-service-go/metrics",
+		MetricName:  "orders_total",
+		PodName:     podName,
+		MetricValue: 8,
+		Type:        "gauge",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "type change should be rejected")
+
+	now := time.Now().UTC()
+	resp, err := c.Increase(ctx, &metricspb.RateRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "orders_total",
+		PodName:    podName,
+		Start:      timestamppb.New(now),
+		End:        timestamppb.New(now),
+		Step:       durationpb.New(time.Second),
+		Window:     durationpb.New(time.Minute),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, 1)
+	require.Len(t, resp.Series[0].Points, 1)
+	require.Equal(t, 6.0, resp.Series[0].Points[0].Value)
+
+	_, err = c.Rate(ctx, &metricspb.RateRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "orders_total",
+		Start:      timestamppb.New(now),
+		End:        timestamppb.New(now),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "rate without step and window should be rejected")
+}
+
+func TestAggregate(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := metricspb.NewMetricsCollectorClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	podName := fmt.Sprintf("test-pod-aggregate-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+	for _, value := range []float64{1, 5} {
+		_, err := c.SendMetric(ctx, &metricspb.SendMetricRequest{
+			ServiceUrl:  "test-service-go/metrics",
+			MetricName:  "system_cpu_usage",
+			PodName:     podName,
+			MetricValue: value,
+		})
+		require.NoError(t, err)
+	}
+
+	resp, err := c.Aggregate(ctx, &metricspb.AggregateRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "system_cpu_usage",
+		PodName:    podName,
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(time.Now().UTC().Add(time.Second)),
+		Interval:   durationpb.New(time.Hour),
+		Function:   "max",
+		GroupBy:    "pod_name",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, 1)
+	require.Equal(t, podName, resp.Series[0].PodName)
+
+	var maxValue float64
+	for _, point := range resp.Series[0].Points {
+		maxValue = max(maxValue, point.Value)
+	}
+	require.Equal(t, 5.0, maxValue)
+}
+
+func TestOTLPExport(t *testing.T) {
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	podName := fmt.Sprintf("test-pod-otlp-grpc-%d", time.Now().UnixNano())
+	start := time.Now().UTC()
+	stringValue := func(value string) *commonpb.AnyValue {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+	}
+
+	resp, err := colmetricspb.NewMetricsServiceClient(conn).Export(ctx, &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*otlpmetricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: stringValue("test-service-go/metrics")},
+				{Key: "service.instance.id", Value: stringValue(podName)},
+			}},
+			ScopeMetrics: []*otlpmetricspb.ScopeMetrics{{
+				Metrics: []*otlpmetricspb.Metric{
+					{
+						Name: "system.cpu.usage",
+						Data: &otlpmetricspb.Metric_Gauge{Gauge: &otlpmetricspb.Gauge{DataPoints: []*otlpmetricspb.NumberDataPoint{
+							{TimeUnixNano: uint64(time.Now().UnixNano()), Value: &otlpmetricspb.NumberDataPoint_AsDouble{AsDouble: 0.75}},
+						}}},
+					},
+					{
+						Name: "delta.requests",
+						Data: &otlpmetricspb.Metric_Sum{Sum: &otlpmetricspb.Sum{
+							AggregationTemporality: otlpmetricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+							IsMonotonic:            true,
+							DataPoints: []*otlpmetricspb.NumberDataPoint{
+								{TimeUnixNano: uint64(time.Now().UnixNano()), Value: &otlpmetricspb.NumberDataPoint_AsInt{AsInt: 1}},
+							},
+						}},
+					},
+				},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp.PartialSuccess, "the delta sum should be reported as rejected")
+	require.Equal(t, int64(1), resp.PartialSuccess.RejectedDataPoints)
+
+	queryResp, err := metricspb.NewMetricsCollectorClient(conn).QueryRange(ctx, &metricspb.QueryRangeRequest{
+		ServiceUrl: "test-service-go/metrics",
+		MetricName: "system_cpu_usage",
+		PodName:    podName,
+		Start:      timestamppb.New(start.Add(-time.Second)),
+		End:        timestamppb.New(time.Now().UTC().Add(time.Second)),
+	})
+	require.NoError(t, err)
+	require.Len(t, queryResp.Series, 1)
+	require.Equal(t, 0.75, queryResp.Series[0].Points[0].Value)
+}
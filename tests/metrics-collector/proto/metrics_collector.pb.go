@@ -9,6 +9,7 @@ package proto
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
@@ -29,6 +30,10 @@ type SendMetricRequest struct {
 	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
 	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
 	MetricValue   float64                `protobuf:"fixed64,4,opt,name=metric_value,json=metricValue,proto3" json:"metric_value,omitempty"`
+	Sequence      uint64                 `protobuf:"varint,5,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,6,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Type          string                 `protobuf:"bytes,7,opt,name=type,proto3" json:"type,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=time,proto3" json:"time,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -91,31 +96,1215 @@ func (x *SendMetricRequest) GetMetricValue() float64 {
 	return 0
 }
 
+func (x *SendMetricRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *SendMetricRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *SendMetricRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *SendMetricRequest) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
 type SendMetricResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
 	ServiceUrl    string                 `protobuf:"bytes,2,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
 	MetricName    string                 `protobuf:"bytes,3,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
 	PodName       string                 `protobuf:"bytes,4,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMetricResponse) Reset() {
+	*x = SendMetricResponse{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMetricResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMetricResponse) ProtoMessage() {}
+
+func (x *SendMetricResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMetricResponse.ProtoReflect.Descriptor instead.
+func (*SendMetricResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SendMetricResponse) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *SendMetricResponse) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *SendMetricResponse) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *SendMetricResponse) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *SendMetricResponse) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type SendMetricsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*SendMetricRequest   `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMetricsRequest) Reset() {
+	*x = SendMetricsRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMetricsRequest) ProtoMessage() {}
+
+func (x *SendMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMetricsRequest.ProtoReflect.Descriptor instead.
+func (*SendMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SendMetricsRequest) GetMetrics() []*SendMetricRequest {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+type SendMetricResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         uint32                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Accepted      bool                   `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	Metric        *SendMetricResponse    `protobuf:"bytes,4,opt,name=metric,proto3" json:"metric,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMetricResult) Reset() {
+	*x = SendMetricResult{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMetricResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMetricResult) ProtoMessage() {}
+
+func (x *SendMetricResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMetricResult.ProtoReflect.Descriptor instead.
+func (*SendMetricResult) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SendMetricResult) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *SendMetricResult) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *SendMetricResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *SendMetricResult) GetMetric() *SendMetricResponse {
+	if x != nil {
+		return x.Metric
+	}
+	return nil
+}
+
+type SendMetricsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*SendMetricResult    `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMetricsResponse) Reset() {
+	*x = SendMetricsResponse{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMetricsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMetricsResponse) ProtoMessage() {}
+
+func (x *SendMetricsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMetricsResponse.ProtoReflect.Descriptor instead.
+func (*SendMetricsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SendMetricsResponse) GetResults() []*SendMetricResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type StreamMetricsAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Count         uint64                 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	LastSequence  uint64                 `protobuf:"varint,2,opt,name=last_sequence,json=lastSequence,proto3" json:"last_sequence,omitempty"`
+	Rejected      uint64                 `protobuf:"varint,3,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamMetricsAck) Reset() {
+	*x = StreamMetricsAck{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamMetricsAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamMetricsAck) ProtoMessage() {}
+
+func (x *StreamMetricsAck) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamMetricsAck.ProtoReflect.Descriptor instead.
+func (*StreamMetricsAck) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StreamMetricsAck) GetCount() uint64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *StreamMetricsAck) GetLastSequence() uint64 {
+	if x != nil {
+		return x.LastSequence
+	}
+	return 0
+}
+
+func (x *StreamMetricsAck) GetRejected() uint64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+type LabelMatcher struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Value         string                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelMatcher) Reset() {
+	*x = LabelMatcher{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelMatcher) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelMatcher) ProtoMessage() {}
+
+func (x *LabelMatcher) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelMatcher.ProtoReflect.Descriptor instead.
+func (*LabelMatcher) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *LabelMatcher) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *LabelMatcher) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *LabelMatcher) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type QueryRangeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceUrl    string                 `protobuf:"bytes,1,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=end,proto3" json:"end,omitempty"`
+	Step          *durationpb.Duration   `protobuf:"bytes,6,opt,name=step,proto3" json:"step,omitempty"`
+	Matchers      []*LabelMatcher        `protobuf:"bytes,7,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRangeRequest) Reset() {
+	*x = QueryRangeRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRangeRequest) ProtoMessage() {}
+
+func (x *QueryRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRangeRequest.ProtoReflect.Descriptor instead.
+func (*QueryRangeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *QueryRangeRequest) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *QueryRangeRequest) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *QueryRangeRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *QueryRangeRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetStep() *durationpb.Duration {
+	if x != nil {
+		return x.Step
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+type Point struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Value         float64                `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Point) Reset() {
+	*x = Point{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Point) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Point) ProtoMessage() {}
+
+func (x *Point) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Point.ProtoReflect.Descriptor instead.
+func (*Point) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Point) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Point) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type Series struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceUrl    string                 `protobuf:"bytes,1,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Points        []*Point               `protobuf:"bytes,4,rep,name=points,proto3" json:"points,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Type          string                 `protobuf:"bytes,6,opt,name=type,proto3" json:"type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Series) Reset() {
+	*x = Series{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Series) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Series) ProtoMessage() {}
+
+func (x *Series) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Series.ProtoReflect.Descriptor instead.
+func (*Series) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Series) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *Series) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *Series) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *Series) GetPoints() []*Point {
+	if x != nil {
+		return x.Points
+	}
+	return nil
+}
+
+func (x *Series) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Series) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+type QueryRangeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Series        []*Series              `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRangeResponse) Reset() {
+	*x = QueryRangeResponse{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRangeResponse) ProtoMessage() {}
+
+func (x *QueryRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRangeResponse.ProtoReflect.Descriptor instead.
+func (*QueryRangeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *QueryRangeResponse) GetSeries() []*Series {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+type AggregateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceUrl    string                 `protobuf:"bytes,1,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=end,proto3" json:"end,omitempty"`
+	Interval      *durationpb.Duration   `protobuf:"bytes,6,opt,name=interval,proto3" json:"interval,omitempty"`
+	Function      string                 `protobuf:"bytes,7,opt,name=function,proto3" json:"function,omitempty"`
+	Percentile    float64                `protobuf:"fixed64,8,opt,name=percentile,proto3" json:"percentile,omitempty"`
+	GroupBy       string                 `protobuf:"bytes,9,opt,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`
+	Matchers      []*LabelMatcher        `protobuf:"bytes,10,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AggregateRequest) Reset() {
+	*x = AggregateRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AggregateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AggregateRequest) ProtoMessage() {}
+
+func (x *AggregateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AggregateRequest.ProtoReflect.Descriptor instead.
+func (*AggregateRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AggregateRequest) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *AggregateRequest) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *AggregateRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *AggregateRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *AggregateRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *AggregateRequest) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+func (x *AggregateRequest) GetFunction() string {
+	if x != nil {
+		return x.Function
+	}
+	return ""
+}
+
+func (x *AggregateRequest) GetPercentile() float64 {
+	if x != nil {
+		return x.Percentile
+	}
+	return 0
+}
+
+func (x *AggregateRequest) GetGroupBy() string {
+	if x != nil {
+		return x.GroupBy
+	}
+	return ""
+}
+
+func (x *AggregateRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+type AggregateResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Series        []*Series              `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AggregateResponse) Reset() {
+	*x = AggregateResponse{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AggregateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AggregateResponse) ProtoMessage() {}
+
+func (x *AggregateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AggregateResponse.ProtoReflect.Descriptor instead.
+func (*AggregateResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *AggregateResponse) GetSeries() []*Series {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+type RateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceUrl    string                 `protobuf:"bytes,1,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Matchers      []*LabelMatcher        `protobuf:"bytes,4,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=end,proto3" json:"end,omitempty"`
+	Step          *durationpb.Duration   `protobuf:"bytes,7,opt,name=step,proto3" json:"step,omitempty"`
+	Window        *durationpb.Duration   `protobuf:"bytes,8,opt,name=window,proto3" json:"window,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RateRequest) Reset() {
+	*x = RateRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RateRequest) ProtoMessage() {}
+
+func (x *RateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RateRequest.ProtoReflect.Descriptor instead.
+func (*RateRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RateRequest) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *RateRequest) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *RateRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *RateRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+func (x *RateRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *RateRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *RateRequest) GetStep() *durationpb.Duration {
+	if x != nil {
+		return x.Step
+	}
+	return nil
+}
+
+func (x *RateRequest) GetWindow() *durationpb.Duration {
+	if x != nil {
+		return x.Window
+	}
+	return nil
+}
+
+type HistogramQuantileRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceUrl    string                 `protobuf:"bytes,1,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Matchers      []*LabelMatcher        `protobuf:"bytes,4,rep,name=matchers,proto3" json:"matchers,omitempty"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=end,proto3" json:"end,omitempty"`
+	Step          *durationpb.Duration   `protobuf:"bytes,7,opt,name=step,proto3" json:"step,omitempty"`
+	Window        *durationpb.Duration   `protobuf:"bytes,8,opt,name=window,proto3" json:"window,omitempty"`
+	Quantile      float64                `protobuf:"fixed64,9,opt,name=quantile,proto3" json:"quantile,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistogramQuantileRequest) Reset() {
+	*x = HistogramQuantileRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistogramQuantileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistogramQuantileRequest) ProtoMessage() {}
+
+func (x *HistogramQuantileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistogramQuantileRequest.ProtoReflect.Descriptor instead.
+func (*HistogramQuantileRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *HistogramQuantileRequest) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *HistogramQuantileRequest) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *HistogramQuantileRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *HistogramQuantileRequest) GetMatchers() []*LabelMatcher {
+	if x != nil {
+		return x.Matchers
+	}
+	return nil
+}
+
+func (x *HistogramQuantileRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *HistogramQuantileRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *HistogramQuantileRequest) GetStep() *durationpb.Duration {
+	if x != nil {
+		return x.Step
+	}
+	return nil
+}
+
+func (x *HistogramQuantileRequest) GetWindow() *durationpb.Duration {
+	if x != nil {
+		return x.Window
+	}
+	return nil
+}
+
+func (x *HistogramQuantileRequest) GetQuantile() float64 {
+	if x != nil {
+		return x.Quantile
+	}
+	return 0
+}
+
+type ListAnomaliesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceUrl    string                 `protobuf:"bytes,1,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,2,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,3,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=end,proto3" json:"end,omitempty"`
+	Context       *durationpb.Duration   `protobuf:"bytes,6,opt,name=context,proto3" json:"context,omitempty"`
+	Limit         uint32                 `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAnomaliesRequest) Reset() {
+	*x = ListAnomaliesRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAnomaliesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAnomaliesRequest) ProtoMessage() {}
+
+func (x *ListAnomaliesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAnomaliesRequest.ProtoReflect.Descriptor instead.
+func (*ListAnomaliesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListAnomaliesRequest) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *ListAnomaliesRequest) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *ListAnomaliesRequest) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *ListAnomaliesRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ListAnomaliesRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *ListAnomaliesRequest) GetContext() *durationpb.Duration {
+	if x != nil {
+		return x.Context
+	}
+	return nil
+}
+
+func (x *ListAnomaliesRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type AnomalyAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	AckedBy       string                 `protobuf:"bytes,2,opt,name=acked_by,json=ackedBy,proto3" json:"acked_by,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	AckedAt       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=acked_at,json=ackedAt,proto3" json:"acked_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnomalyAck) Reset() {
+	*x = AnomalyAck{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnomalyAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnomalyAck) ProtoMessage() {}
+
+func (x *AnomalyAck) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnomalyAck.ProtoReflect.Descriptor instead.
+func (*AnomalyAck) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *AnomalyAck) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *AnomalyAck) GetAckedBy() string {
+	if x != nil {
+		return x.AckedBy
+	}
+	return ""
+}
+
+func (x *AnomalyAck) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *AnomalyAck) GetAckedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AckedAt
+	}
+	return nil
+}
+
+type Anomaly struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	ServiceUrl    string                 `protobuf:"bytes,2,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,3,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,4,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	MetricValue   float64                `protobuf:"fixed64,5,opt,name=metric_value,json=metricValue,proto3" json:"metric_value,omitempty"`
+	Context       []*Point               `protobuf:"bytes,6,rep,name=context,proto3" json:"context,omitempty"`
+	Ack           *AnomalyAck            `protobuf:"bytes,7,opt,name=ack,proto3" json:"ack,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SendMetricResponse) Reset() {
-	*x = SendMetricResponse{}
-	mi := &file_proto_metrics_collector_proto_msgTypes[1]
+func (x *Anomaly) Reset() {
+	*x = Anomaly{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SendMetricResponse) String() string {
+func (x *Anomaly) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SendMetricResponse) ProtoMessage() {}
+func (*Anomaly) ProtoMessage() {}
 
-func (x *SendMetricResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_metrics_collector_proto_msgTypes[1]
+func (x *Anomaly) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -126,62 +1315,386 @@ func (x *SendMetricResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SendMetricResponse.ProtoReflect.Descriptor instead.
-func (*SendMetricResponse) Descriptor() ([]byte, []int) {
-	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use Anomaly.ProtoReflect.Descriptor instead.
+func (*Anomaly) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{17}
 }
 
-func (x *SendMetricResponse) GetTime() *timestamppb.Timestamp {
+func (x *Anomaly) GetTime() *timestamppb.Timestamp {
 	if x != nil {
 		return x.Time
 	}
 	return nil
 }
 
-func (x *SendMetricResponse) GetServiceUrl() string {
+func (x *Anomaly) GetServiceUrl() string {
 	if x != nil {
 		return x.ServiceUrl
 	}
 	return ""
 }
 
-func (x *SendMetricResponse) GetMetricName() string {
+func (x *Anomaly) GetMetricName() string {
 	if x != nil {
 		return x.MetricName
 	}
 	return ""
 }
 
-func (x *SendMetricResponse) GetPodName() string {
+func (x *Anomaly) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *Anomaly) GetMetricValue() float64 {
+	if x != nil {
+		return x.MetricValue
+	}
+	return 0
+}
+
+func (x *Anomaly) GetContext() []*Point {
+	if x != nil {
+		return x.Context
+	}
+	return nil
+}
+
+func (x *Anomaly) GetAck() *AnomalyAck {
+	if x != nil {
+		return x.Ack
+	}
+	return nil
+}
+
+func (x *Anomaly) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type ListAnomaliesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Anomalies     []*Anomaly             `protobuf:"bytes,1,rep,name=anomalies,proto3" json:"anomalies,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAnomaliesResponse) Reset() {
+	*x = ListAnomaliesResponse{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAnomaliesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAnomaliesResponse) ProtoMessage() {}
+
+func (x *ListAnomaliesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAnomaliesResponse.ProtoReflect.Descriptor instead.
+func (*ListAnomaliesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ListAnomaliesResponse) GetAnomalies() []*Anomaly {
+	if x != nil {
+		return x.Anomalies
+	}
+	return nil
+}
+
+type AcknowledgeAnomalyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	ServiceUrl    string                 `protobuf:"bytes,2,opt,name=service_url,json=serviceUrl,proto3" json:"service_url,omitempty"`
+	MetricName    string                 `protobuf:"bytes,3,opt,name=metric_name,json=metricName,proto3" json:"metric_name,omitempty"`
+	PodName       string                 `protobuf:"bytes,4,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Status        string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	AckedBy       string                 `protobuf:"bytes,6,opt,name=acked_by,json=ackedBy,proto3" json:"acked_by,omitempty"`
+	Reason        string                 `protobuf:"bytes,7,opt,name=reason,proto3" json:"reason,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AcknowledgeAnomalyRequest) Reset() {
+	*x = AcknowledgeAnomalyRequest{}
+	mi := &file_proto_metrics_collector_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AcknowledgeAnomalyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AcknowledgeAnomalyRequest) ProtoMessage() {}
+
+func (x *AcknowledgeAnomalyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_collector_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AcknowledgeAnomalyRequest.ProtoReflect.Descriptor instead.
+func (*AcknowledgeAnomalyRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_collector_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *AcknowledgeAnomalyRequest) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *AcknowledgeAnomalyRequest) GetServiceUrl() string {
+	if x != nil {
+		return x.ServiceUrl
+	}
+	return ""
+}
+
+func (x *AcknowledgeAnomalyRequest) GetMetricName() string {
+	if x != nil {
+		return x.MetricName
+	}
+	return ""
+}
+
+func (x *AcknowledgeAnomalyRequest) GetPodName() string {
 	if x != nil {
 		return x.PodName
 	}
 	return ""
 }
 
+func (x *AcknowledgeAnomalyRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *AcknowledgeAnomalyRequest) GetAckedBy() string {
+	if x != nil {
+		return x.AckedBy
+	}
+	return ""
+}
+
+func (x *AcknowledgeAnomalyRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *AcknowledgeAnomalyRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
 var File_proto_metrics_collector_proto protoreflect.FileDescriptor
 
 const file_proto_metrics_collector_proto_rawDesc = "" +
 	"\n" +
-	"\x1dproto/metrics_collector.proto\x12\x05proto\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x93\x01\n" +
+	"\x1dproto/metrics_collector.proto\x12\x05proto\x1a\x1egoogle/protobuf/duration.proto\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xec\x02\n" +
 	"\x11SendMetricRequest\x12\x1f\n" +
 	"\vservice_url\x18\x01 \x01(\tR\n" +
 	"serviceUrl\x12\x1f\n" +
 	"\vmetric_name\x18\x02 \x01(\tR\n" +
 	"metricName\x12\x19\n" +
 	"\bpod_name\x18\x03 \x01(\tR\apodName\x12!\n" +
-	"\fmetric_value\x18\x04 \x01(\x01R\vmetricValue\"\xa1\x01\n" +
+	"\fmetric_value\x18\x04 \x01(\x01R\vmetricValue\x12\x1a\n" +
+	"\bsequence\x18\x05 \x01(\x04R\bsequence\x12<\n" +
+	"\x06labels\x18\x06 \x03(\v2$.proto.SendMetricRequest.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04type\x18\a \x01(\tR\x04type\x12.\n" +
+	"\x04time\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x9b\x02\n" +
 	"\x12SendMetricResponse\x12.\n" +
 	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
 	"\vservice_url\x18\x02 \x01(\tR\n" +
 	"serviceUrl\x12\x1f\n" +
 	"\vmetric_name\x18\x03 \x01(\tR\n" +
 	"metricName\x12\x19\n" +
-	"\bpod_name\x18\x04 \x01(\tR\apodName2\x91\x01\n" +
+	"\bpod_name\x18\x04 \x01(\tR\apodName\x12=\n" +
+	"\x06labels\x18\x05 \x03(\v2%.proto.SendMetricResponse.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"H\n" +
+	"\x12SendMetricsRequest\x122\n" +
+	"\ametrics\x18\x01 \x03(\v2\x18.proto.SendMetricRequestR\ametrics\"\x8d\x01\n" +
+	"\x10SendMetricResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\rR\x05index\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\bR\baccepted\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x121\n" +
+	"\x06metric\x18\x04 \x01(\v2\x19.proto.SendMetricResponseR\x06metric\"H\n" +
+	"\x13SendMetricsResponse\x121\n" +
+	"\aresults\x18\x01 \x03(\v2\x17.proto.SendMetricResultR\aresults\"i\n" +
+	"\x10StreamMetricsAck\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x04R\x05count\x12#\n" +
+	"\rlast_sequence\x18\x02 \x01(\x04R\flastSequence\x12\x1a\n" +
+	"\brejected\x18\x03 \x01(\x04R\brejected\"L\n" +
+	"\fLabelMatcher\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\tR\x05value\"\xb0\x02\n" +
+	"\x11QueryRangeRequest\x12\x1f\n" +
+	"\vservice_url\x18\x01 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x02 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x03 \x01(\tR\apodName\x120\n" +
+	"\x05start\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x12-\n" +
+	"\x04step\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\x04step\x12/\n" +
+	"\bmatchers\x18\a \x03(\v2\x13.proto.LabelMatcherR\bmatchers\"M\n" +
+	"\x05Point\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\"\x8d\x02\n" +
+	"\x06Series\x12\x1f\n" +
+	"\vservice_url\x18\x01 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x02 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x03 \x01(\tR\apodName\x12$\n" +
+	"\x06points\x18\x04 \x03(\v2\f.proto.PointR\x06points\x121\n" +
+	"\x06labels\x18\x05 \x03(\v2\x19.proto.Series.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04type\x18\x06 \x01(\tR\x04type\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\";\n" +
+	"\x12QueryRangeResponse\x12%\n" +
+	"\x06series\x18\x01 \x03(\v2\r.proto.SeriesR\x06series\"\x8e\x03\n" +
+	"\x10AggregateRequest\x12\x1f\n" +
+	"\vservice_url\x18\x01 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x02 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x03 \x01(\tR\apodName\x120\n" +
+	"\x05start\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x125\n" +
+	"\binterval\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\binterval\x12\x1a\n" +
+	"\bfunction\x18\a \x01(\tR\bfunction\x12\x1e\n" +
+	"\n" +
+	"percentile\x18\b \x01(\x01R\n" +
+	"percentile\x12\x19\n" +
+	"\bgroup_by\x18\t \x01(\tR\agroupBy\x12/\n" +
+	"\bmatchers\x18\n" +
+	" \x03(\v2\x13.proto.LabelMatcherR\bmatchers\":\n" +
+	"\x11AggregateResponse\x12%\n" +
+	"\x06series\x18\x01 \x03(\v2\r.proto.SeriesR\x06series\"\xdd\x02\n" +
+	"\vRateRequest\x12\x1f\n" +
+	"\vservice_url\x18\x01 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x02 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x03 \x01(\tR\apodName\x12/\n" +
+	"\bmatchers\x18\x04 \x03(\v2\x13.proto.LabelMatcherR\bmatchers\x120\n" +
+	"\x05start\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x12-\n" +
+	"\x04step\x18\a \x01(\v2\x19.google.protobuf.DurationR\x04step\x121\n" +
+	"\x06window\x18\b \x01(\v2\x19.google.protobuf.DurationR\x06window\"\x86\x03\n" +
+	"\x18HistogramQuantileRequest\x12\x1f\n" +
+	"\vservice_url\x18\x01 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x02 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x03 \x01(\tR\apodName\x12/\n" +
+	"\bmatchers\x18\x04 \x03(\v2\x13.proto.LabelMatcherR\bmatchers\x120\n" +
+	"\x05start\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x12-\n" +
+	"\x04step\x18\a \x01(\v2\x19.google.protobuf.DurationR\x04step\x121\n" +
+	"\x06window\x18\b \x01(\v2\x19.google.protobuf.DurationR\x06window\x12\x1a\n" +
+	"\bquantile\x18\t \x01(\x01R\bquantile\"\x9e\x02\n" +
+	"\x14ListAnomaliesRequest\x12\x1f\n" +
+	"\vservice_url\x18\x01 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x02 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x03 \x01(\tR\apodName\x120\n" +
+	"\x05start\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x123\n" +
+	"\acontext\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\acontext\x12\x14\n" +
+	"\x05limit\x18\a \x01(\rR\x05limit\"\x8e\x01\n" +
+	"\n" +
+	"AnomalyAck\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x19\n" +
+	"\backed_by\x18\x02 \x01(\tR\aackedBy\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\x125\n" +
+	"\backed_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\aackedAt\"\xf5\x02\n" +
+	"\aAnomaly\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
+	"\vservice_url\x18\x02 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x03 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x04 \x01(\tR\apodName\x12!\n" +
+	"\fmetric_value\x18\x05 \x01(\x01R\vmetricValue\x12&\n" +
+	"\acontext\x18\x06 \x03(\v2\f.proto.PointR\acontext\x12#\n" +
+	"\x03ack\x18\a \x01(\v2\x11.proto.AnomalyAckR\x03ack\x122\n" +
+	"\x06labels\x18\b \x03(\v2\x1a.proto.Anomaly.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"E\n" +
+	"\x15ListAnomaliesResponse\x12,\n" +
+	"\tanomalies\x18\x01 \x03(\v2\x0e.proto.AnomalyR\tanomalies\"\xf4\x02\n" +
+	"\x19AcknowledgeAnomalyRequest\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
+	"\vservice_url\x18\x02 \x01(\tR\n" +
+	"serviceUrl\x12\x1f\n" +
+	"\vmetric_name\x18\x03 \x01(\tR\n" +
+	"metricName\x12\x19\n" +
+	"\bpod_name\x18\x04 \x01(\tR\apodName\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x19\n" +
+	"\backed_by\x18\x06 \x01(\tR\aackedBy\x12\x16\n" +
+	"\x06reason\x18\a \x01(\tR\x06reason\x12D\n" +
+	"\x06labels\x18\b \x03(\v2,.proto.AcknowledgeAnomalyRequest.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\x8e\x06\n" +
 	"\x10MetricsCollector\x128\n" +
 	"\x04Ping\x12\x16.google.protobuf.Empty\x1a\x16.google.protobuf.Empty\"\x00\x12C\n" +
 	"\n" +
-	"SendMetric\x12\x18.proto.SendMetricRequest\x1a\x19.proto.SendMetricResponse\"\x00B\x15Z\x13adapters/grpc/protob\x06proto3"
+	"SendMetric\x12\x18.proto.SendMetricRequest\x1a\x19.proto.SendMetricResponse\"\x00\x12F\n" +
+	"\vSendMetrics\x12\x19.proto.SendMetricsRequest\x1a\x1a.proto.SendMetricsResponse\"\x00\x12H\n" +
+	"\rStreamMetrics\x12\x18.proto.SendMetricRequest\x1a\x17.proto.StreamMetricsAck\"\x00(\x010\x01\x12C\n" +
+	"\n" +
+	"QueryRange\x12\x18.proto.QueryRangeRequest\x1a\x19.proto.QueryRangeResponse\"\x00\x12@\n" +
+	"\tAggregate\x12\x17.proto.AggregateRequest\x1a\x18.proto.AggregateResponse\"\x00\x127\n" +
+	"\x04Rate\x12\x12.proto.RateRequest\x1a\x19.proto.QueryRangeResponse\"\x00\x12;\n" +
+	"\bIncrease\x12\x12.proto.RateRequest\x1a\x19.proto.QueryRangeResponse\"\x00\x12Q\n" +
+	"\x11HistogramQuantile\x12\x1f.proto.HistogramQuantileRequest\x1a\x19.proto.QueryRangeResponse\"\x00\x12L\n" +
+	"\rListAnomalies\x12\x1b.proto.ListAnomaliesRequest\x1a\x1c.proto.ListAnomaliesResponse\"\x00\x12K\n" +
+	"\x12AcknowledgeAnomaly\x12 .proto.AcknowledgeAnomalyRequest\x1a\x11.proto.AnomalyAck\"\x00B\x15Z\x13adapters/grpc/protob\x06proto3"
 
 var (
 	file_proto_metrics_collector_proto_rawDescOnce sync.Once
@@ -195,24 +1708,106 @@ func file_proto_metrics_collector_proto_rawDescGZIP() []byte {
 	return file_proto_metrics_collector_proto_rawDescData
 }
 
-var file_proto_metrics_collector_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_metrics_collector_proto_msgTypes = make([]protoimpl.MessageInfo, 25)
 var file_proto_metrics_collector_proto_goTypes = []any{
-	(*SendMetricRequest)(nil),     // 0: proto.SendMetricRequest
-	(*SendMetricResponse)(nil),    // 1: proto.SendMetricResponse
-	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
-	(*emptypb.Empty)(nil),         // 3: google.protobuf.Empty
+	(*SendMetricRequest)(nil),         // 0: proto.SendMetricRequest
+	(*SendMetricResponse)(nil),        // 1: proto.SendMetricResponse
+	(*SendMetricsRequest)(nil),        // 2: proto.SendMetricsRequest
+	(*SendMetricResult)(nil),          // 3: proto.SendMetricResult
+	(*SendMetricsResponse)(nil),       // 4: proto.SendMetricsResponse
+	(*StreamMetricsAck)(nil),          // 5: proto.StreamMetricsAck
+	(*LabelMatcher)(nil),              // 6: proto.LabelMatcher
+	(*QueryRangeRequest)(nil),         // 7: proto.QueryRangeRequest
+	(*Point)(nil),                     // 8: proto.Point
+	(*Series)(nil),                    // 9: proto.Series
+	(*QueryRangeResponse)(nil),        // 10: proto.QueryRangeResponse
+	(*AggregateRequest)(nil),          // 11: proto.AggregateRequest
+	(*AggregateResponse)(nil),         // 12: proto.AggregateResponse
+	(*RateRequest)(nil),               // 13: proto.RateRequest
+	(*HistogramQuantileRequest)(nil),  // 14: proto.HistogramQuantileRequest
+	(*ListAnomaliesRequest)(nil),      // 15: proto.ListAnomaliesRequest
+	(*AnomalyAck)(nil),                // 16: proto.AnomalyAck
+	(*Anomaly)(nil),                   // 17: proto.Anomaly
+	(*ListAnomaliesResponse)(nil),     // 18: proto.ListAnomaliesResponse
+	(*AcknowledgeAnomalyRequest)(nil), // 19: proto.AcknowledgeAnomalyRequest
+	nil,                               // 20: proto.SendMetricRequest.LabelsEntry
+	nil,                               // 21: proto.SendMetricResponse.LabelsEntry
+	nil,                               // 22: proto.Series.LabelsEntry
+	nil,                               // 23: proto.Anomaly.LabelsEntry
+	nil,                               // 24: proto.AcknowledgeAnomalyRequest.LabelsEntry
+	(*timestamppb.Timestamp)(nil),     // 25: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),       // 26: google.protobuf.Duration
+	(*emptypb.Empty)(nil),             // 27: google.protobuf.Empty
 }
 var file_proto_metrics_collector_proto_depIdxs = []int32{
-	2, // 0: proto.SendMetricResponse.time:type_name -> google.protobuf.Timestamp
-	3, // 1: proto.MetricsCollector.Ping:input_type -> google.protobuf.Empty
-	0, // 2: proto.MetricsCollector.SendMetric:input_type -> proto.SendMetricRequest
-	3, // 3: proto.MetricsCollector.Ping:output_type -> google.protobuf.Empty
-	1, // 4: proto.MetricsCollector.SendMetric:output_type -> proto.SendMetricResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	20, // 0: proto.SendMetricRequest.labels:type_name -> proto.SendMetricRequest.LabelsEntry
+	25, // 1: proto.SendMetricRequest.time:type_name -> google.protobuf.Timestamp
+	25, // 2: proto.SendMetricResponse.time:type_name -> google.protobuf.Timestamp
+	21, // 3: proto.SendMetricResponse.labels:type_name -> proto.SendMetricResponse.LabelsEntry
+	0,  // 4: proto.SendMetricsRequest.metrics:type_name -> proto.SendMetricRequest
+	1,  // 5: proto.SendMetricResult.metric:type_name -> proto.SendMetricResponse
+	3,  // 6: proto.SendMetricsResponse.results:type_name -> proto.SendMetricResult
+	25, // 7: proto.QueryRangeRequest.start:type_name -> google.protobuf.Timestamp
+	25, // 8: proto.QueryRangeRequest.end:type_name -> google.protobuf.Timestamp
+	26, // 9: proto.QueryRangeRequest.step:type_name -> google.protobuf.Duration
+	6,  // 10: proto.QueryRangeRequest.matchers:type_name -> proto.LabelMatcher
+	25, // 11: proto.Point.time:type_name -> google.protobuf.Timestamp
+	8,  // 12: proto.Series.points:type_name -> proto.Point
+	22, // 13: proto.Series.labels:type_name -> proto.Series.LabelsEntry
+	9,  // 14: proto.QueryRangeResponse.series:type_name -> proto.Series
+	25, // 15: proto.AggregateRequest.start:type_name -> google.protobuf.Timestamp
+	25, // 16: proto.AggregateRequest.end:type_name -> google.protobuf.Timestamp
+	26, // 17: proto.AggregateRequest.interval:type_name -> google.protobuf.Duration
+	6,  // 18: proto.AggregateRequest.matchers:type_name -> proto.LabelMatcher
+	9,  // 19: proto.AggregateResponse.series:type_name -> proto.Series
+	6,  // 20: proto.RateRequest.matchers:type_name -> proto.LabelMatcher
+	25, // 21: proto.RateRequest.start:type_name -> google.protobuf.Timestamp
+	25, // 22: proto.RateRequest.end:type_name -> google.protobuf.Timestamp
+	26, // 23: proto.RateRequest.step:type_name -> google.protobuf.Duration
+	26, // 24: proto.RateRequest.window:type_name -> google.protobuf.Duration
+	6,  // 25: proto.HistogramQuantileRequest.matchers:type_name -> proto.LabelMatcher
+	25, // 26: proto.HistogramQuantileRequest.start:type_name -> google.protobuf.Timestamp
+	25, // 27: proto.HistogramQuantileRequest.end:type_name -> google.protobuf.Timestamp
+	26, // 28: proto.HistogramQuantileRequest.step:type_name -> google.protobuf.Duration
+	26, // 29: proto.HistogramQuantileRequest.window:type_name -> google.protobuf.Duration
+	25, // 30: proto.ListAnomaliesRequest.start:type_name -> google.protobuf.Timestamp
+	25, // 31: proto.ListAnomaliesRequest.end:type_name -> google.protobuf.Timestamp
+	26, // 32: proto.ListAnomaliesRequest.context:type_name -> google.protobuf.Duration
+	25, // 33: proto.AnomalyAck.acked_at:type_name -> google.protobuf.Timestamp
+	25, // 34: proto.Anomaly.time:type_name -> google.protobuf.Timestamp
+	8,  // 35: proto.Anomaly.context:type_name -> proto.Point
+	16, // 36: proto.Anomaly.ack:type_name -> proto.AnomalyAck
+	23, // 37: proto.Anomaly.labels:type_name -> proto.Anomaly.LabelsEntry
+	17, // 38: proto.ListAnomaliesResponse.anomalies:type_name -> proto.Anomaly
+	25, // 39: proto.AcknowledgeAnomalyRequest.time:type_name -> google.protobuf.Timestamp
+	24, // 40: proto.AcknowledgeAnomalyRequest.labels:type_name -> proto.AcknowledgeAnomalyRequest.LabelsEntry
+	27, // 41: proto.MetricsCollector.Ping:input_type -> google.protobuf.Empty
+	0,  // 42: proto.MetricsCollector.SendMetric:input_type -> proto.SendMetricRequest
+	2,  // 43: proto.MetricsCollector.SendMetrics:input_type -> proto.SendMetricsRequest
+	0,  // 44: proto.MetricsCollector.StreamMetrics:input_type -> proto.SendMetricRequest
+	7,  // 45: proto.MetricsCollector.QueryRange:input_type -> proto.QueryRangeRequest
+	11, // 46: proto.MetricsCollector.Aggregate:input_type -> proto.AggregateRequest
+	13, // 47: proto.MetricsCollector.Rate:input_type -> proto.RateRequest
+	13, // 48: proto.MetricsCollector.Increase:input_type -> proto.RateRequest
+	14, // 49: proto.MetricsCollector.HistogramQuantile:input_type -> proto.HistogramQuantileRequest
+	15, // 50: proto.MetricsCollector.ListAnomalies:input_type -> proto.ListAnomaliesRequest
+	19, // 51: proto.MetricsCollector.AcknowledgeAnomaly:input_type -> proto.AcknowledgeAnomalyRequest
+	27, // 52: proto.MetricsCollector.Ping:output_type -> google.protobuf.Empty
+	1,  // 53: proto.MetricsCollector.SendMetric:output_type -> proto.SendMetricResponse
+	4,  // 54: proto.MetricsCollector.SendMetrics:output_type -> proto.SendMetricsResponse
+	5,  // 55: proto.MetricsCollector.StreamMetrics:output_type -> proto.StreamMetricsAck
+	10, // 56: proto.MetricsCollector.QueryRange:output_type -> proto.QueryRangeResponse
+	12, // 57: proto.MetricsCollector.Aggregate:output_type -> proto.AggregateResponse
+	10, // 58: proto.MetricsCollector.Rate:output_type -> proto.QueryRangeResponse
+	10, // 59: proto.MetricsCollector.Increase:output_type -> proto.QueryRangeResponse
+	10, // 60: proto.MetricsCollector.HistogramQuantile:output_type -> proto.QueryRangeResponse
+	18, // 61: proto.MetricsCollector.ListAnomalies:output_type -> proto.ListAnomaliesResponse
+	16, // 62: proto.MetricsCollector.AcknowledgeAnomaly:output_type -> proto.AnomalyAck
+	52, // [52:63] is the sub-list for method output_type
+	41, // [41:52] is the sub-list for method input_type
+	41, // [41:41] is the sub-list for extension type_name
+	41, // [41:41] is the sub-list for extension extendee
+	0,  // [0:41] is the sub-list for field type_name
 }
 
 func init() { file_proto_metrics_collector_proto_init() }
@@ -226,7 +1821,7 @@ func file_proto_metrics_collector_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_metrics_collector_proto_rawDesc), len(file_proto_metrics_collector_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   25,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
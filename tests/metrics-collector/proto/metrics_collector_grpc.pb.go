@@ -20,8 +20,17 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	MetricsCollector_Ping_FullMethodName       = "/proto.MetricsCollector/Ping"
-	MetricsCollector_SendMetric_FullMethodName = "/proto.MetricsCollector/SendMetric"
+	MetricsCollector_Ping_FullMethodName               = "/proto.MetricsCollector/Ping"
+	MetricsCollector_SendMetric_FullMethodName         = "/proto.MetricsCollector/SendMetric"
+	MetricsCollector_SendMetrics_FullMethodName        = "/proto.MetricsCollector/SendMetrics"
+	MetricsCollector_StreamMetrics_FullMethodName      = "/proto.MetricsCollector/StreamMetrics"
+	MetricsCollector_QueryRange_FullMethodName         = "/proto.MetricsCollector/QueryRange"
+	MetricsCollector_Aggregate_FullMethodName          = "/proto.MetricsCollector/Aggregate"
+	MetricsCollector_Rate_FullMethodName               = "/proto.MetricsCollector/Rate"
+	MetricsCollector_Increase_FullMethodName           = "/proto.MetricsCollector/Increase"
+	MetricsCollector_HistogramQuantile_FullMethodName  = "/proto.MetricsCollector/HistogramQuantile"
+	MetricsCollector_ListAnomalies_FullMethodName      = "/proto.MetricsCollector/ListAnomalies"
+	MetricsCollector_AcknowledgeAnomaly_FullMethodName = "/proto.MetricsCollector/AcknowledgeAnomaly"
 )
 
 // MetricsCollectorClient is the client API for MetricsCollector service.
@@ -30,6 +39,15 @@ const (
 type MetricsCollectorClient interface {
 	Ping(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	SendMetric(ctx context.Context, in *SendMetricRequest, opts ...grpc.CallOption) (*SendMetricResponse, error)
+	SendMetrics(ctx context.Context, in *SendMetricsRequest, opts ...grpc.CallOption) (*SendMetricsResponse, error)
+	StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SendMetricRequest, StreamMetricsAck], error)
+	QueryRange(ctx context.Context, in *QueryRangeRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error)
+	Aggregate(ctx context.Context, in *AggregateRequest, opts ...grpc.CallOption) (*AggregateResponse, error)
+	Rate(ctx context.Context, in *RateRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error)
+	Increase(ctx context.Context, in *RateRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error)
+	HistogramQuantile(ctx context.Context, in *HistogramQuantileRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error)
+	ListAnomalies(ctx context.Context, in *ListAnomaliesRequest, opts ...grpc.CallOption) (*ListAnomaliesResponse, error)
+	AcknowledgeAnomaly(ctx context.Context, in *AcknowledgeAnomalyRequest, opts ...grpc.CallOption) (*AnomalyAck, error)
 }
 
 type metricsCollectorClient struct {
@@ -60,12 +78,114 @@ func (c *metricsCollectorClient) SendMetric(ctx context.Context, in *SendMetricR
 	return out, nil
 }
 
+func (c *metricsCollectorClient) SendMetrics(ctx context.Context, in *SendMetricsRequest, opts ...grpc.CallOption) (*SendMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendMetricsResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_SendMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SendMetricRequest, StreamMetricsAck], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MetricsCollector_ServiceDesc.Streams[0], MetricsCollector_StreamMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SendMetricRequest, StreamMetricsAck]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricsCollector_StreamMetricsClient = grpc.BidiStreamingClient[SendMetricRequest, StreamMetricsAck]
+
+func (c *metricsCollectorClient) QueryRange(ctx context.Context, in *QueryRangeRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryRangeResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_QueryRange_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) Aggregate(ctx context.Context, in *AggregateRequest, opts ...grpc.CallOption) (*AggregateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AggregateResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_Aggregate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) Rate(ctx context.Context, in *RateRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryRangeResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_Rate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) Increase(ctx context.Context, in *RateRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryRangeResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_Increase_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) HistogramQuantile(ctx context.Context, in *HistogramQuantileRequest, opts ...grpc.CallOption) (*QueryRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryRangeResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_HistogramQuantile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) ListAnomalies(ctx context.Context, in *ListAnomaliesRequest, opts ...grpc.CallOption) (*ListAnomaliesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAnomaliesResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_ListAnomalies_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorClient) AcknowledgeAnomaly(ctx context.Context, in *AcknowledgeAnomalyRequest, opts ...grpc.CallOption) (*AnomalyAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AnomalyAck)
+	err := c.cc.Invoke(ctx, MetricsCollector_AcknowledgeAnomaly_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsCollectorServer is the server API for MetricsCollector service.
 // All implementations must embed UnimplementedMetricsCollectorServer
 // for forward compatibility.
 type MetricsCollectorServer interface {
 	Ping(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
 	SendMetric(context.Context, *SendMetricRequest) (*SendMetricResponse, error)
+	SendMetrics(context.Context, *SendMetricsRequest) (*SendMetricsResponse, error)
+	StreamMetrics(grpc.BidiStreamingServer[SendMetricRequest, StreamMetricsAck]) error
+	QueryRange(context.Context, *QueryRangeRequest) (*QueryRangeResponse, error)
+	Aggregate(context.Context, *AggregateRequest) (*AggregateResponse, error)
+	Rate(context.Context, *RateRequest) (*QueryRangeResponse, error)
+	Increase(context.Context, *RateRequest) (*QueryRangeResponse, error)
+	HistogramQuantile(context.Context, *HistogramQuantileRequest) (*QueryRangeResponse, error)
+	ListAnomalies(context.Context, *ListAnomaliesRequest) (*ListAnomaliesResponse, error)
+	AcknowledgeAnomaly(context.Context, *AcknowledgeAnomalyRequest) (*AnomalyAck, error)
 	mustEmbedUnimplementedMetricsCollectorServer()
 }
 
@@ -82,6 +202,33 @@ func (UnimplementedMetricsCollectorServer) Ping(context.Context, *emptypb.Empty)
 func (UnimplementedMetricsCollectorServer) SendMetric(context.Context, *SendMetricRequest) (*SendMetricResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendMetric not implemented")
 }
+func (UnimplementedMetricsCollectorServer) SendMetrics(context.Context, *SendMetricsRequest) (*SendMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMetrics not implemented")
+}
+func (UnimplementedMetricsCollectorServer) StreamMetrics(grpc.BidiStreamingServer[SendMetricRequest, StreamMetricsAck]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamMetrics not implemented")
+}
+func (UnimplementedMetricsCollectorServer) QueryRange(context.Context, *QueryRangeRequest) (*QueryRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryRange not implemented")
+}
+func (UnimplementedMetricsCollectorServer) Aggregate(context.Context, *AggregateRequest) (*AggregateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Aggregate not implemented")
+}
+func (UnimplementedMetricsCollectorServer) Rate(context.Context, *RateRequest) (*QueryRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rate not implemented")
+}
+func (UnimplementedMetricsCollectorServer) Increase(context.Context, *RateRequest) (*QueryRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Increase not implemented")
+}
+func (UnimplementedMetricsCollectorServer) HistogramQuantile(context.Context, *HistogramQuantileRequest) (*QueryRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HistogramQuantile not implemented")
+}
+func (UnimplementedMetricsCollectorServer) ListAnomalies(context.Context, *ListAnomaliesRequest) (*ListAnomaliesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAnomalies not implemented")
+}
+func (UnimplementedMetricsCollectorServer) AcknowledgeAnomaly(context.Context, *AcknowledgeAnomalyRequest) (*AnomalyAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcknowledgeAnomaly not implemented")
+}
 func (UnimplementedMetricsCollectorServer) mustEmbedUnimplementedMetricsCollectorServer() {}
 func (UnimplementedMetricsCollectorServer) testEmbeddedByValue()                          {}
 
@@ -139,6 +286,157 @@ func _MetricsCollector_SendMetric_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsCollector_SendMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).SendMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_SendMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).SendMetrics(ctx, req.(*SendMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_StreamMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsCollectorServer).StreamMetrics(&grpc.GenericServerStream[SendMetricRequest, StreamMetricsAck]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricsCollector_StreamMetricsServer = grpc.BidiStreamingServer[SendMetricRequest, StreamMetricsAck]
+
+func _MetricsCollector_QueryRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).QueryRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_QueryRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).QueryRange(ctx, req.(*QueryRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_Aggregate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AggregateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).Aggregate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_Aggregate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).Aggregate(ctx, req.(*AggregateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_Rate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).Rate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_Rate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).Rate(ctx, req.(*RateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_Increase_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).Increase(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_Increase_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).Increase(ctx, req.(*RateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_HistogramQuantile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HistogramQuantileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).HistogramQuantile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_HistogramQuantile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).HistogramQuantile(ctx, req.(*HistogramQuantileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_ListAnomalies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAnomaliesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).ListAnomalies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_ListAnomalies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).ListAnomalies(ctx, req.(*ListAnomaliesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollector_AcknowledgeAnomaly_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AcknowledgeAnomalyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).AcknowledgeAnomaly(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_AcknowledgeAnomaly_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).AcknowledgeAnomaly(ctx, req.(*AcknowledgeAnomalyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetricsCollector_ServiceDesc is the grpc.ServiceDesc for MetricsCollector service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -154,7 +452,46 @@ var MetricsCollector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendMetric",
 			Handler:    _MetricsCollector_SendMetric_Handler,
 		},
+		{
+			MethodName: "SendMetrics",
+			Handler:    _MetricsCollector_SendMetrics_Handler,
+		},
+		{
+			MethodName: "QueryRange",
+			Handler:    _MetricsCollector_QueryRange_Handler,
+		},
+		{
+			MethodName: "Aggregate",
+			Handler:    _MetricsCollector_Aggregate_Handler,
+		},
+		{
+			MethodName: "Rate",
+			Handler:    _MetricsCollector_Rate_Handler,
+		},
+		{
+			MethodName: "Increase",
+			Handler:    _MetricsCollector_Increase_Handler,
+		},
+		{
+			MethodName: "HistogramQuantile",
+			Handler:    _MetricsCollector_HistogramQuantile_Handler,
+		},
+		{
+			MethodName: "ListAnomalies",
+			Handler:    _MetricsCollector_ListAnomalies_Handler,
+		},
+		{
+			MethodName: "AcknowledgeAnomaly",
+			Handler:    _MetricsCollector_AcknowledgeAnomaly_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrics",
+			Handler:       _MetricsCollector_StreamMetrics_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/metrics_collector.proto",
 }
@@ -9,7 +9,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	prompb "github.com/mclyashko/monitoring-system/tests/test-service-go/metrics-collector/proto"
 )
 
 const address = "http://localhost:8081"
@@ -37,6 +41,7 @@ type GetMetricResponse struct {
 	MetricName  string    `json:"metric_name"`
 	PodName     string    `json:"pod_name"`
 	MetricValue float64   `json:"metric_value"`
+	IsAnomaly   bool      `json:"is_anomaly"`
 }
 
 func TestCreateAndGetMetricByMetricIdentity(t *testing.T) {
@@ -60,6 +65,418 @@ func TestCreateAndGetMetricByMetricIdentity(t *testing.T) {
 	require.Equal(t, metricToCreate.MetricValue, respMetric.MetricValue, "unexpected metric value change")
 }
 
+func TestCreateAnomalousMetric(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-anomaly-%d", time.Now().UnixNano())
+
+	respIdentity := createAnomaly(t, podName)
+
+	code, respMetric := getMetricByMetricIdentity(t, respIdentity.Time, respIdentity.ServiceURL, respIdentity.MetricName, respIdentity.PodName)
+	require.Equal(t, http.StatusOK, code, "unexpected status code when getting metric")
+	require.True(t, respMetric.IsAnomaly, "outlier should be flagged as anomaly")
+}
+
+type ListAnomaliesResponse struct {
+	Anomalies []struct {
+		Time        time.Time `json:"time"`
+		PodName     string    `json:"pod_name"`
+		MetricValue float64   `json:"metric_value"`
+		Context     []struct {
+			Time  time.Time `json:"time"`
+			Value float64   `json:"value"`
+		} `json:"context"`
+		Ack *struct {
+			Status  string `json:"status"`
+			AckedBy string `json:"acked_by"`
+			Reason  string `json:"reason"`
+		} `json:"ack"`
+	} `json:"anomalies"`
+}
+
+func TestListAndAcknowledgeAnomalies(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-anomaly-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+
+	respIdentity := createAnomaly(t, podName)
+
+	code, resp := listAnomalies(t, podName, start, time.Now().UTC().Add(time.Second))
+	require.Equal(t, http.StatusOK, code, "unexpected status code when listing anomalies")
+	require.Len(t, resp.Anomalies, 1, "unexpected number of anomalies")
+	require.Equal(t, 100.0, resp.Anomalies[0].MetricValue, "unexpected anomaly value")
+	require.Greater(t, len(resp.Anomalies[0].Context), 1, "anomaly should come with surrounding points")
+	require.Nil(t, resp.Anomalies[0].Ack, "new anomaly should not be acknowledged")
+
+	code = acknowledgeAnomaly(t, respIdentity, "dismissed", "tester", "load test spike")
+	require.Equal(t, http.StatusOK, code, "unexpected status code when dismissing anomaly")
+
+	code, resp = listAnomalies(t, podName, start, time.Now().UTC().Add(time.Second))
+	require.Equal(t, http.StatusOK, code, "unexpected status code when listing anomalies")
+	require.Len(t, resp.Anomalies, 1, "unexpected number of anomalies")
+	require.NotNil(t, resp.Anomalies[0].Ack, "anomaly should be acknowledged")
+	require.Equal(t, "dismissed", resp.Anomalies[0].Ack.Status, "unexpected ack status")
+	require.Equal(t, "tester", resp.Anomalies[0].Ack.AckedBy, "unexpected ack author")
+	require.Equal(t, "load test spike", resp.Anomalies[0].Ack.Reason, "unexpected ack reason")
+}
+
+func TestAcknowledgeAnomalyNotFound(t *testing.T) {
+	identity := CreateMetricResponse{
+		Time:       time.Now().UTC(),
+		ServiceURL: "no-service/metrics",
+		MetricName: "some_metric",
+		PodName:    "no-pod",
+	}
+
+	code := acknowledgeAnomaly(t, identity, "acknowledged", "tester", "")
+	require.Equal(t, http.StatusNotFound, code, "unexpected status code when acknowledging unexisting anomaly")
+}
+
+func TestListAlerts(t *testing.T) {
+	resp, err := client.Get(address + "/alerts")
+	require.NoError(t, err, "failed to send request to list alerts")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unexpected status code when listing alerts")
+
+	var response struct {
+		Alerts []struct {
+			Rule  string `json:"rule"`
+			State string `json:"state"`
+		} `json:"alerts"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response), "failed to decode alerts")
+	require.NotNil(t, response.Alerts, "alerts should be a list")
+	for _, alert := range response.Alerts {
+		require.Contains(t, []string{"pending", "firing", "resolved"}, alert.State, "unexpected alert state")
+	}
+}
+
+func TestListRetentionPolicies(t *testing.T) {
+	resp, err := client.Get(address + "/admin/retention")
+	require.NoError(t, err, "failed to send request to list retention policies")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unexpected status code when listing retention policies")
+
+	var response struct {
+		Policies []struct {
+			Name    string     `json:"name"`
+			Action  string     `json:"action"`
+			Age     string     `json:"age"`
+			LastRun *time.Time `json:"last_run"`
+		} `json:"policies"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response), "failed to decode retention policies")
+	require.NotEmpty(t, response.Policies, "the default policies should always be listed")
+
+	names := make([]string, 0, len(response.Policies))
+	for _, policy := range response.Policies {
+		names = append(names, policy.Name)
+		require.Contains(t, []string{"drop", "delete", "compress"}, policy.Action, "unexpected retention action")
+		require.NotEmpty(t, policy.Age, "policy age should be set")
+	}
+	require.Contains(t, names, "expired", "expired chunks policy should be listed")
+	require.Contains(t, names, "default", "default retention policy should be listed")
+}
+
+func TestRemoteWrite(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-remote-write-%d", time.Now().UnixNano())
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	writeRequest := &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			{
+				Labels: []*prompb.Label{
+					{Name: "__name__", Value: "system_cpu_usage"},
+					{Name: "job", Value: "test-service-go/metrics"},
+					{Name: "instance", Value: podName},
+				},
+				Samples: []*prompb.Sample{
+					{Value: 0.25, Timestamp: now.Add(-time.Minute).UnixMilli()},
+					{Value: 0.5, Timestamp: now.UnixMilli()},
+				},
+			},
+		},
+	}
+	code := remoteWrite(t, writeRequest)
+	require.Equal(t, http.StatusNoContent, code, "unexpected status code for remote write")
+
+	code, respMetric := getMetricByMetricIdentity(t, now.Add(-time.Minute), "test-service-go/metrics", "system_cpu_usage", podName)
+	require.Equal(t, http.StatusOK, code, "unexpected status code when getting remote written metric")
+	require.Equal(t, 0.25, respMetric.MetricValue, "unexpected metric value change")
+
+	// Retries of samples that were already written are accepted.
+	code = remoteWrite(t, writeRequest)
+	require.Equal(t, http.StatusNoContent, code, "unexpected status code for a retried remote write")
+
+	// Samples older than the ingestion window are accepted, since Prometheus
+	// resends them after an outage.
+	old := now.Add(-3 * time.Hour)
+	writeRequest.Timeseries[0].Samples = []*prompb.Sample{{Value: 0.75, Timestamp: old.UnixMilli()}}
+	code = remoteWrite(t, writeRequest)
+	require.Equal(t, http.StatusNoContent, code, "unexpected status code for an old sample")
+
+	code, respMetric = getMetricByMetricIdentity(t, old, "test-service-go/metrics", "system_cpu_usage", podName)
+	require.Equal(t, http.StatusOK, code, "old remote written sample should be stored")
+	require.Equal(t, 0.75, respMetric.MetricValue, "unexpected metric value change")
+
+	// Rejected samples are dropped without failing the request, which
+	// Prometheus would not retry anyway.
+	writeRequest.Timeseries[0].Labels = writeRequest.Timeseries[0].Labels[:2]
+	code = remoteWrite(t, writeRequest)
+	require.Equal(t, http.StatusNoContent, code, "samples without an instance label should be dropped")
+}
+
+func TestRemoteWriteInvalidPayload(t *testing.T) {
+	resp, err := client.Post(address+"/api/v1/write", "application/x-protobuf", bytes.NewReader([]byte("not snappy")))
+	require.NoError(t, err, "failed to send remote write request")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "unexpected status code for invalid payload")
+}
+
+func remoteWrite(t *testing.T, writeRequest *prompb.WriteRequest) (code int) {
+	data, err := proto.Marshal(writeRequest)
+	require.NoError(t, err, "failed to marshal write request")
+
+	req, err := http.NewRequest(http.MethodPost, address+"/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	require.NoError(t, err, "failed to build remote write request")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err, "failed to send remote write request")
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestOTLPHTTPExport(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-otlp-http-%d", time.Now().UnixNano())
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	body := fmt.Sprintf(`{"resourceMetrics": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "test-service-go/metrics"}},
+			{"key": "service.instance.id", "value": {"stringValue": %q}}
+		]},
+		"scopeMetrics": [{"metrics": [
+			{"name": "http.server.requests", "sum": {"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": [
+				{"timeUnixNano": "%d", "asInt": "7", "attributes": [{"key": "http.route", "value": {"stringValue": "/orders"}}]}
+			]}}
+		]}]
+	}]}`, podName, now.UnixNano())
+
+	resp, err := client.Post(address+"/v1/metrics", "application/json", bytes.NewReader([]byte(body)))
+	require.NoError(t, err, "failed to send otlp request")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "unexpected status code for otlp export")
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"), "the response should be json as the request")
+
+	code, response := promAPI(t, http.MethodGet, "/api/v1/query", url.Values{
+		"query": {fmt.Sprintf(`http_server_requests{instance=%q}`, podName)},
+		"time":  {now.Format(time.RFC3339Nano)},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for instant query")
+	var data PromQueryData
+	require.NoError(t, json.Unmarshal(response.Data, &data), "failed to decode query result")
+	require.Len(t, data.Result, 1, "unexpected number of series")
+	require.Equal(t, "/orders", data.Result[0].Metric["http_route"], "attributes should become labels")
+	require.Equal(t, "7", data.Result[0].Value[1], "unexpected value")
+
+	resp, err = client.Post(address+"/v1/metrics", "text/plain", bytes.NewReader([]byte(body)))
+	require.NoError(t, err, "failed to send otlp request")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode, "unexpected status code for unsupported content type")
+}
+
+type PromResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+type PromQueryData struct {
+	ResultType string `json:"resultType"`
+	Result     []struct {
+		Metric map[string]string `json:"metric"`
+		Value  []interface{}     `json:"value"`
+		Values [][]interface{}   `json:"values"`
+	} `json:"result"`
+}
+
+func TestPromQuery(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-promql-%d", time.Now().UnixNano())
+	for _, value := range []float64{1, 3} {
+		code, _ := createMetric(t, "test-service-go/metrics", "system_cpu_usage", podName, value)
+		require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+	}
+
+	code, response := promAPI(t, http.MethodGet, "/api/v1/query", url.Values{
+		"query": {fmt.Sprintf(`system_cpu_usage{instance=%q}`, podName)},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for instant query")
+	require.Equal(t, "success", response.Status, "unexpected response status")
+
+	var data PromQueryData
+	require.NoError(t, json.Unmarshal(response.Data, &data), "failed to decode query result")
+	require.Equal(t, "vector", data.ResultType, "unexpected result type")
+	require.Len(t, data.Result, 1, "unexpected number of series")
+	require.Equal(t, "system_cpu_usage", data.Result[0].Metric["__name__"], "unexpected metric name")
+	require.Equal(t, "test-service-go/metrics", data.Result[0].Metric["job"], "service should be exposed as job")
+	require.Equal(t, "3", data.Result[0].Value[1], "the latest sample should be returned")
+
+	// POST with a form body is accepted as well.
+	code, response = promAPI(t, http.MethodPost, "/api/v1/query", url.Values{
+		"query": {fmt.Sprintf(`max_over_time(system_cpu_usage{instance=%q}[5m]) * 2`, podName)},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for instant query")
+	var maxData PromQueryData
+	require.NoError(t, json.Unmarshal(response.Data, &maxData), "failed to decode query result")
+	require.Len(t, maxData.Result, 1, "unexpected number of series")
+	require.NotContains(t, maxData.Result[0].Metric, "__name__", "functions should drop the metric name")
+	require.Equal(t, "6", maxData.Result[0].Value[1], "unexpected value")
+}
+
+func TestPromQueryRange(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-promql-range-%d", time.Now().UnixNano())
+	code, _ := createMetric(t, "test-service-go/metrics", "system_cpu_usage", podName, 0.5)
+	require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+
+	now := time.Now().UTC()
+	code, response := promAPI(t, http.MethodGet, "/api/v1/query_range", url.Values{
+		"query": {fmt.Sprintf(`system_cpu_usage{instance=%q}`, podName)},
+		"start": {fmt.Sprintf("%d", now.Add(-time.Minute).Unix())},
+		"end":   {now.Add(time.Minute).Format(time.RFC3339Nano)},
+		"step":  {"30s"},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for range query")
+
+	var data PromQueryData
+	require.NoError(t, json.Unmarshal(response.Data, &data), "failed to decode query result")
+	require.Equal(t, "matrix", data.ResultType, "unexpected result type")
+	require.Len(t, data.Result, 1, "unexpected number of series")
+	require.NotEmpty(t, data.Result[0].Values, "the sample should be visible at later steps")
+	for _, value := range data.Result[0].Values {
+		require.Equal(t, "0.5", value[1], "unexpected value")
+	}
+}
+
+func TestPromQueryInvalid(t *testing.T) {
+	for _, query := range []string{"", "sum(", "rate(system_cpu_usage)", `{job=~".*"}`} {
+		code, response := promAPI(t, http.MethodGet, "/api/v1/query", url.Values{"query": {query}})
+		require.Equal(t, http.StatusBadRequest, code, "unexpected status code for query %q", query)
+		require.Equal(t, "error", response.Status, "unexpected response status")
+		require.Equal(t, "bad_data", response.ErrorType, "unexpected error type")
+	}
+
+	code, _ := promAPI(t, http.MethodGet, "/api/v1/query_range", url.Values{
+		"query": {"system_cpu_usage"},
+		"start": {"0"},
+		"end":   {"100000"},
+		"step":  {"1ms"},
+	})
+	require.Equal(t, http.StatusBadRequest, code, "too many points per series should be rejected")
+}
+
+func TestPromSeriesAndLabels(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-promql-series-%d", time.Now().UnixNano())
+	code, _ := createMetric(t, "test-service-go/metrics", "system_cpu_usage", podName, 1)
+	require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+
+	match := fmt.Sprintf(`{instance=%q}`, podName)
+	code, response := promAPI(t, http.MethodGet, "/api/v1/series", url.Values{"match[]": {match}})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for series")
+	var series []map[string]string
+	require.NoError(t, json.Unmarshal(response.Data, &series), "failed to decode series")
+	require.Len(t, series, 1, "unexpected number of series")
+	require.Equal(t, "system_cpu_usage", series[0]["__name__"], "unexpected metric name")
+
+	code, response = promAPI(t, http.MethodGet, "/api/v1/labels", url.Values{"match[]": {match}})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for labels")
+	var names []string
+	require.NoError(t, json.Unmarshal(response.Data, &names), "failed to decode label names")
+	require.Equal(t, []string{"__name__", "instance", "job"}, names, "unexpected label names")
+
+	code, response = promAPI(t, http.MethodGet, "/api/v1/label/instance/values", url.Values{"match[]": {match}})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for label values")
+	var values []string
+	require.NoError(t, json.Unmarshal(response.Data, &values), "failed to decode label values")
+	require.Equal(t, []string{podName}, values, "unexpected label values")
+
+	code, _ = promAPI(t, http.MethodGet, "/api/v1/series", url.Values{})
+	require.Equal(t, http.StatusBadRequest, code, "series without match[] should be rejected")
+}
+
+func promAPI(t *testing.T, method, path string, params url.Values) (code int, response PromResponse) {
+	var resp *http.Response
+	var err error
+	if method == http.MethodPost {
+		resp, err = client.PostForm(address+path, params)
+	} else {
+		resp, err = client.Get(address + path + "?" + params.Encode())
+	}
+	require.NoError(t, err, "failed to send prometheus api request")
+	defer resp.Body.Close()
+
+	code = resp.StatusCode
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+
+	return code, response
+}
+
+func createAnomaly(t *testing.T, podName string) CreateMetricResponse {
+	for i := 0; i < 20; i++ {
+		code, respIdentity := createMetric(t, "test-service-go/metrics", "test_anomaly_metric", podName, 1+0.1*float64(i%2))
+		require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+
+		_, respMetric := getMetricByMetricIdentity(t, respIdentity.Time, respIdentity.ServiceURL, respIdentity.MetricName, respIdentity.PodName)
+		require.False(t, respMetric.IsAnomaly, "regular metric should not be flagged")
+	}
+
+	code, respIdentity := createMetric(t, "test-service-go/metrics", "test_anomaly_metric", podName, 100)
+	require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+
+	return respIdentity
+}
+
+func listAnomalies(t *testing.T, podName string, start, end time.Time) (code int, response ListAnomaliesResponse) {
+	params := url.Values{}
+	params.Set("pod_name", podName)
+	params.Set("start", start.Format(time.RFC3339Nano))
+	params.Set("end", end.Format(time.RFC3339Nano))
+	params.Set("context", "1m")
+
+	resp, err := client.Get(address + "/anomalies?" + params.Encode())
+	require.NoError(t, err, "failed to send request to list anomalies")
+	defer resp.Body.Close()
+
+	code = resp.StatusCode
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+
+	return code, response
+}
+
+func acknowledgeAnomaly(t *testing.T, identity CreateMetricResponse, status, ackedBy, reason string) (code int) {
+	ack := map[string]interface{}{
+		"time":        identity.Time,
+		"service_url": identity.ServiceURL,
+		"metric_name": identity.MetricName,
+		"pod_name":    identity.PodName,
+		"status":      status,
+		"acked_by":    ackedBy,
+		"reason":      reason,
+	}
+	ackJSON, err := json.Marshal(ack)
+	require.NoError(t, err, "failed to serialize anomaly acknowledgement")
+
+	resp, err := client.Post(address+"/anomalies/ack", "application/json", bytes.NewReader(ackJSON))
+	require.NoError(t, err, "failed to send request to acknowledge anomaly")
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
 func TestGetMetricInvalidMetricIdentity(t *testing.T) {
 	code, _ := getMetricByMetricIdentity(t, time.Now(), "", "system_cpu_usage", "test-pod")
 
@@ -72,6 +489,411 @@ func TestGetMetricNotFound(t *testing.T) {
 	require.Equal(t, code, http.StatusNotFound, "unexpected status code when getting metric by unexisting identity")
 }
 
+type CreateMetricsResponse struct {
+	Results []struct {
+		Index    int                   `json:"index"`
+		Accepted bool                  `json:"accepted"`
+		Error    string                `json:"error"`
+		Metric   *CreateMetricResponse `json:"metric"`
+	} `json:"results"`
+}
+
+func TestCreateMetricsBatch(t *testing.T) {
+	metrics := []map[string]interface{}{
+		{"service_url": "test-service-go/metrics", "metric_name": "system_cpu_usage", "pod_name": "test-pod-batch-1", "metric_value": 0.1},
+		{"service_url": "test-service-go/metrics", "metric_name": "system_cpu_usage", "pod_name": "", "metric_value": 0.2},
+	}
+
+	code, resp := createMetrics(t, metrics)
+	require.Equal(t, http.StatusOK, code, "unexpected status code when creating metric batch")
+	require.Len(t, resp.Results, len(metrics), "unexpected number of results")
+
+	require.True(t, resp.Results[0].Accepted, "valid metric should be accepted")
+	require.NotNil(t, resp.Results[0].Metric)
+
+	code, respMetric := getMetricByMetricIdentity(t, resp.Results[0].Metric.Time, resp.Results[0].Metric.ServiceURL, resp.Results[0].Metric.MetricName, resp.Results[0].Metric.PodName)
+	require.Equal(t, http.StatusOK, code, "unexpected status code when getting batched metric")
+	require.Equal(t, 0.1, respMetric.MetricValue, "unexpected metric value change")
+
+	require.False(t, resp.Results[1].Accepted, "invalid metric should be rejected")
+	require.NotEmpty(t, resp.Results[1].Error, "rejected metric should have a reason")
+}
+
+func TestCreateMetricsWithTimestamps(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-backfill-%d", time.Now().UnixNano())
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	metric := func(offset time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"service_url":  "test-service-go/metrics",
+			"metric_name":  "system_cpu_usage",
+			"pod_name":     podName,
+			"time":         now.Add(offset).Format(time.RFC3339Nano),
+			"metric_value": 0.5,
+		}
+	}
+
+	code, resp := createMetrics(t, []map[string]interface{}{
+		metric(-10 * time.Minute),
+		metric(-20 * time.Minute),
+		metric(-2 * time.Hour),
+		metric(time.Hour),
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code when creating metric batch")
+	require.Len(t, resp.Results, 4, "unexpected number of results")
+
+	require.True(t, resp.Results[0].Accepted, "backfilled metric should be accepted")
+	require.True(t, resp.Results[1].Accepted, "out-of-order metric should be accepted")
+	require.True(t, now.Add(-10*time.Minute).Equal(resp.Results[0].Metric.Time), "client timestamp should be kept")
+	require.False(t, resp.Results[2].Accepted, "metric older than the acceptance window should be rejected")
+	require.False(t, resp.Results[3].Accepted, "metric too far in the future should be rejected")
+
+	code, respMetric := getMetricByMetricIdentity(t, now.Add(-20*time.Minute), "test-service-go/metrics", "system_cpu_usage", podName)
+	require.Equal(t, http.StatusOK, code, "unexpected status code when getting backfilled metric")
+	require.Equal(t, 0.5, respMetric.MetricValue, "unexpected metric value change")
+}
+
+func TestCreateDuplicateMetric(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-duplicate-%d", time.Now().UnixNano())
+	metric := map[string]interface{}{
+		"service_url":  "test-service-go/metrics",
+		"metric_name":  "system_cpu_usage",
+		"pod_name":     podName,
+		"time":         time.Now().UTC().Add(-time.Minute).Format(time.RFC3339Nano),
+		"metric_value": 0.5,
+	}
+
+	metricJSON, err := json.Marshal(metric)
+	require.NoError(t, err, "failed to serialize metric")
+
+	for _, expected := range []int{http.StatusCreated, http.StatusConflict} {
+		resp, err := client.Post(address+"/metric", "application/json", bytes.NewReader(metricJSON))
+		require.NoError(t, err, "failed to send request to create metric")
+		resp.Body.Close()
+		require.Equal(t, expected, resp.StatusCode, "unexpected status code when creating metric")
+	}
+
+	code, resp := createMetrics(t, []map[string]interface{}{metric})
+	require.Equal(t, http.StatusOK, code, "unexpected status code when creating metric batch")
+	require.False(t, resp.Results[0].Accepted, "duplicate metric should be rejected")
+	require.NotEmpty(t, resp.Results[0].Error, "duplicate metric should have a reason")
+}
+
+func TestCreateEmptyMetricsBatch(t *testing.T) {
+	code, _ := createMetrics(t, []map[string]interface{}{})
+
+	require.Equal(t, http.StatusBadRequest, code, "unexpected status code for empty metric batch")
+}
+
+type QueryRangeResponse struct {
+	Series []struct {
+		ServiceURL string            `json:"service_url"`
+		MetricName string            `json:"metric_name"`
+		PodName    string            `json:"pod_name"`
+		Labels     map[string]string `json:"labels"`
+		Points     []struct {
+			Time  time.Time `json:"time"`
+			Value float64   `json:"value"`
+		} `json:"points"`
+	} `json:"series"`
+}
+
+func TestQueryRange(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-range-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+
+	for _, value := range []float64{1, 2, 3} {
+		code, _ := createMetric(t, "test-service-go/metrics", "system_cpu_usage", podName, value)
+		require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+	}
+
+	code, resp := queryRange(t, "test-service-go/metrics", "system_cpu_usage", podName, start, time.Now().UTC().Add(time.Second), "")
+	require.Equal(t, http.StatusOK, code, "unexpected status code when querying range")
+	require.Len(t, resp.Series, 1, "unexpected number of series")
+	require.Equal(t, podName, resp.Series[0].PodName, "unexpected pod name")
+	require.Len(t, resp.Series[0].Points, 3, "unexpected number of points")
+	for i := 1; i < len(resp.Series[0].Points); i++ {
+		require.True(t, resp.Series[0].Points[i-1].Time.Before(resp.Series[0].Points[i].Time), "points should be ordered by time")
+	}
+	require.Equal(t, 3.0, resp.Series[0].Points[2].Value, "unexpected last point value")
+
+	code, resp = queryRange(t, "test-service-go/metrics", "system_cpu_usage", podName, start, time.Now().UTC().Add(time.Second), "1h")
+	require.Equal(t, http.StatusOK, code, "unexpected status code when querying range with step")
+	require.Len(t, resp.Series, 1, "unexpected number of series")
+	require.Len(t, resp.Series[0].Points, 1, "step should collapse points into a single bucket")
+}
+
+func TestQueryRangeInvalid(t *testing.T) {
+	now := time.Now().UTC()
+
+	code, _ := queryRange(t, "", "system_cpu_usage", "", now.Add(-time.Minute), now, "")
+	require.Equal(t, http.StatusBadRequest, code, "unexpected status code for missing service url")
+
+	code, _ = queryRange(t, "test-service-go/metrics", "system_cpu_usage", "", now, now.Add(-time.Minute), "")
+	require.Equal(t, http.StatusBadRequest, code, "unexpected status code for inverted range")
+}
+
+func TestQueryRangeWithLabelMatchers(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-labels-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+
+	code, resp := createMetrics(t, []map[string]interface{}{
+		{"service_url": "test-service-go/metrics", "metric_name": "http_requests", "pod_name": podName, "labels": map[string]string{"region": "eu", "version": "1.2.0"}, "metric_value": 1},
+		{"service_url": "test-service-go/metrics", "metric_name": "http_requests", "pod_name": podName, "labels": map[string]string{"region": "us", "version": "2.0.0"}, "metric_value": 2},
+		{"service_url": "test-service-go/metrics", "metric_name": "http_requests", "pod_name": podName, "labels": map[string]string{"bad-name": "x"}, "metric_value": 3},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code when creating labelled metrics")
+	require.True(t, resp.Results[0].Accepted, "labelled metric should be accepted")
+	require.True(t, resp.Results[1].Accepted, "labelled metric should be accepted")
+	require.False(t, resp.Results[2].Accepted, "metric with invalid label name should be rejected")
+
+	end := time.Now().UTC().Add(time.Second)
+	for _, tc := range []struct {
+		match  string
+		region string
+	}{
+		{match: `region="eu"`, region: "eu"},
+		{match: `region!="eu"`, region: "us"},
+		{match: `version=~"2\\..*"`, region: "us"},
+		{match: `version!~"2\\..*"`, region: "eu"},
+	} {
+		params := url.Values{}
+		params.Set("service_url", "test-service-go/metrics")
+		params.Set("metric_name", "http_requests")
+		params.Set("pod_name", podName)
+		params.Set("start", start.Format(time.RFC3339Nano))
+		params.Set("end", end.Format(time.RFC3339Nano))
+		params.Add("match", tc.match)
+
+		code, resp := getQueryRange(t, params)
+		require.Equal(t, http.StatusOK, code, "unexpected status code for matcher %s", tc.match)
+		require.Len(t, resp.Series, 1, "unexpected number of series for matcher %s", tc.match)
+		require.Equal(t, tc.region, resp.Series[0].Labels["region"], "unexpected series for matcher %s", tc.match)
+	}
+
+	params := url.Values{}
+	params.Set("service_url", "test-service-go/metrics")
+	params.Set("metric_name", "http_requests")
+	params.Set("start", start.Format(time.RFC3339Nano))
+	params.Set("end", end.Format(time.RFC3339Nano))
+	params.Add("match", `version=~"("`)
+	code, _ = getQueryRange(t, params)
+	require.Equal(t, http.StatusBadRequest, code, "unexpected status code for invalid regex")
+}
+
+func TestAggregate(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-aggregate-%d", time.Now().UnixNano())
+	start := time.Now().UTC().Add(-time.Second)
+
+	for _, value := range []float64{1, 2, 3} {
+		code, _ := createMetric(t, "test-service-go/metrics", "system_cpu_usage", podName, value)
+		require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+	}
+	end := time.Now().UTC().Add(time.Second)
+
+	code, resp := aggregate(t, url.Values{
+		"service_url": {"test-service-go/metrics"},
+		"metric_name": {"system_cpu_usage"},
+		"pod_name":    {podName},
+		"start":       {start.Format(time.RFC3339Nano)},
+		"end":         {end.Format(time.RFC3339Nano)},
+		"interval":    {"1h"},
+		"function":    {"count"},
+		"group_by":    {"pod_name"},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code when aggregating")
+	require.Len(t, resp.Series, 1, "unexpected number of series")
+	require.Equal(t, podName, resp.Series[0].PodName, "unexpected pod name")
+	var count float64
+	for _, point := range resp.Series[0].Points {
+		count += point.Value
+	}
+	require.Equal(t, 3.0, count, "unexpected sample count")
+
+	code, resp = aggregate(t, url.Values{
+		"service_url": {"test-service-go/metrics"},
+		"metric_name": {"system_cpu_usage"},
+		"pod_name":    {podName},
+		"start":       {start.Format(time.RFC3339Nano)},
+		"end":         {end.Format(time.RFC3339Nano)},
+		"interval":    {"1h"},
+		"function":    {"percentile"},
+		"percentile":  {"1"},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code when aggregating percentile")
+	require.Len(t, resp.Series, 1, "unexpected number of series")
+	require.NotEmpty(t, resp.Series[0].Points, "expected aggregated points")
+}
+
+func TestAggregateInvalid(t *testing.T) {
+	now := time.Now().UTC()
+
+	code, _ := aggregate(t, url.Values{
+		"metric_name": {"system_cpu_usage"},
+		"start":       {now.Add(-time.Hour).Format(time.RFC3339Nano)},
+		"end":         {now.Format(time.RFC3339Nano)},
+		"interval":    {"1m"},
+		"function":    {"median"},
+	})
+	require.Equal(t, http.StatusBadRequest, code, "unexpected status code for unknown function")
+}
+
+func TestCounterRateAndIncrease(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-counter-%d", time.Now().UnixNano())
+
+	for _, value := range []float64{10, 15, 3} {
+		code, resp := createMetrics(t, []map[string]interface{}{
+			{"service_url": "test-service-go/metrics", "metric_name": "orders_total", "pod_name": podName, "type": "counter", "metric_value": value},
+		})
+		require.Equal(t, http.StatusOK, code, "unexpected status code when creating counter")
+		require.True(t, resp.Results[0].Accepted, "counter sample should be accepted, reset included")
+	}
+
+	code, resp := createMetrics(t, []map[string]interface{}{
+		{"service_url": "test-service-go/metrics", "metric_name": "orders_total", "pod_name": podName, "type": "counter", "metric_value": -1},
+		{"service_url": "test-service-go/metrics", "metric_name": "orders_total", "pod_name": podName, "type": "gauge", "metric_value": 1},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code when creating invalid counters")
+	require.False(t, resp.Results[0].Accepted, "negative counter should be rejected")
+	require.False(t, resp.Results[1].Accepted, "type change should be rejected")
+
+	params := url.Values{
+		"service_url": {"test-service-go/metrics"},
+		"metric_name": {"orders_total"},
+		"pod_name":    {podName},
+		"start":       {time.Now().UTC().Format(time.RFC3339Nano)},
+		"end":         {time.Now().UTC().Format(time.RFC3339Nano)},
+		"step":        {"1s"},
+		"window":      {"1m"},
+	}
+
+	code, increaseResp := queryFunction(t, "/metrics/increase", params)
+	require.Equal(t, http.StatusOK, code, "unexpected status code for increase")
+	require.Len(t, increaseResp.Series, 1, "unexpected number of series")
+	require.Len(t, increaseResp.Series[0].Points, 1, "unexpected number of points")
+	require.Equal(t, 8.0, increaseResp.Series[0].Points[0].Value, "increase should count the reset")
+
+	code, rateResp := queryFunction(t, "/metrics/rate", params)
+	require.Equal(t, http.StatusOK, code, "unexpected status code for rate")
+	require.Len(t, rateResp.Series, 1, "unexpected number of series")
+	require.InDelta(t, 8.0/60, rateResp.Series[0].Points[0].Value, 1e-9, "unexpected rate")
+}
+
+func TestRateOnGaugeRejected(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-gauge-rate-%d", time.Now().UnixNano())
+	for _, value := range []float64{1, 2} {
+		code, _ := createMetric(t, "test-service-go/metrics", "system_cpu_usage", podName, value)
+		require.Equal(t, http.StatusCreated, code, "unexpected status code when creating metric")
+	}
+
+	now := time.Now().UTC()
+	code, _ := queryFunction(t, "/metrics/rate", url.Values{
+		"service_url": {"test-service-go/metrics"},
+		"metric_name": {"system_cpu_usage"},
+		"pod_name":    {podName},
+		"start":       {now.Format(time.RFC3339Nano)},
+		"end":         {now.Format(time.RFC3339Nano)},
+		"step":        {"1s"},
+		"window":      {"1m"},
+	})
+	require.Equal(t, http.StatusBadRequest, code, "rate on a gauge should be rejected")
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	podName := fmt.Sprintf("test-pod-histogram-%d", time.Now().UnixNano())
+
+	for _, counts := range [][3]float64{{0, 0, 0}, {50, 90, 100}} {
+		metrics := make([]map[string]interface{}, 0, len(counts))
+		for i, le := range []string{"0.1", "0.5", "+Inf"} {
+			metrics = append(metrics, map[string]interface{}{
+				"service_url":  "test-service-go/metrics",
+				"metric_name":  "request_duration_seconds_bucket",
+				"pod_name":     podName,
+				"labels":       map[string]string{"le": le},
+				"type":         "histogram",
+				"metric_value": counts[i],
+			})
+		}
+		code, _ := createMetrics(t, metrics)
+		require.Equal(t, http.StatusOK, code, "unexpected status code when creating histogram buckets")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	now := time.Now().UTC()
+	code, resp := queryFunction(t, "/metrics/histogram_quantile", url.Values{
+		"service_url": {"test-service-go/metrics"},
+		"metric_name": {"request_duration_seconds"},
+		"pod_name":    {podName},
+		"start":       {now.Format(time.RFC3339Nano)},
+		"end":         {now.Format(time.RFC3339Nano)},
+		"step":        {"1s"},
+		"window":      {"1m"},
+		"quantile":    {"0.7"},
+	})
+	require.Equal(t, http.StatusOK, code, "unexpected status code for histogram quantile")
+	require.Len(t, resp.Series, 1, "unexpected number of series")
+	require.Equal(t, "request_duration_seconds", resp.Series[0].MetricName, "unexpected metric name")
+	require.InDelta(t, 0.3, resp.Series[0].Points[0].Value, 1e-9, "unexpected quantile")
+}
+
+func queryFunction(t *testing.T, path string, params url.Values) (code int, response QueryRangeResponse) {
+	resp, err := client.Get(address + path + "?" + params.Encode())
+	require.NoError(t, err, "failed to send request to evaluate function")
+	defer resp.Body.Close()
+
+	code = resp.StatusCode
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+
+	return code, response
+}
+
+func aggregate(t *testing.T, params url.Values) (code int, response QueryRangeResponse) {
+	resp, err := client.Get(address + "/metrics/aggregate?" + params.Encode())
+	require.NoError(t, err, "failed to send request to aggregate metrics")
+	defer resp.Body.Close()
+
+	code = resp.StatusCode
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+
+	return code, response
+}
+
+func queryRange(t *testing.T, serviceURL, metricName, podName string, start, end time.Time, step string) (code int, response QueryRangeResponse) {
+	params := url.Values{}
+	params.Set("service_url", serviceURL)
+	params.Set("metric_name", metricName)
+	params.Set("pod_name", podName)
+	params.Set("start", start.Format(time.RFC3339Nano))
+	params.Set("end", end.Format(time.RFC3339Nano))
+	params.Set("step", step)
+
+	return getQueryRange(t, params)
+}
+
+func getQueryRange(t *testing.T, params url.Values) (code int, response QueryRangeResponse) {
+	resp, err := client.Get(address + "/metrics/range?" + params.Encode())
+	require.NoError(t, err, "failed to send request to query range")
+	defer resp.Body.Close()
+
+	code = resp.StatusCode
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+
+	return code, response
+}
+
+func createMetrics(t *testing.T, metrics []map[string]interface{}) (code int, response CreateMetricsResponse) {
+	batchJSON, err := json.Marshal(map[string]interface{}{"metrics": metrics})
+	require.NoError(t, err, "failed to serialize metric batch")
+
+	resp, err := client.Post(address+"/metrics/batch", "application/json", bytes.NewReader(batchJSON))
+	require.NoError(t, err, "failed to send request to create metric batch")
+	defer resp.Body.Close()
+
+	code = resp.StatusCode
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+
+	return code, response
+}
+
 func createMetric(t *testing.T, serviceURL, metricName, podName string, metricValue float64) (code int, response CreateMetricResponse) {
 	metric := map[string]interface{}{
 		"service_url":  serviceURL,